@@ -0,0 +1,452 @@
+package analytics
+
+import (
+	"slices"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+
+	"finhub-backend/currency"
+	"finhub-backend/models"
+)
+
+// Filters narrow the deals an analysis covers. From and To bound the date
+// each analysis is keyed on (documented per function); To is exclusive.
+type Filters struct {
+	From       *time.Time
+	To         *time.Time
+	PipelineID string
+	OwnerID    string
+	Source     string
+}
+
+// Durations summarises a set of durations in days
+type Durations struct {
+	Count      int     `json:"count"`
+	AvgDays    float64 `json:"avgDays"`
+	MedianDays float64 `json:"medianDays"`
+	MinDays    float64 `json:"minDays"`
+	MaxDays    float64 `json:"maxDays"`
+}
+
+// StageDuration is the time deals spent in one stage
+type StageDuration struct {
+	StageID    string `json:"stageId"`
+	StageName  string `json:"stageName"`
+	StageOrder int    `json:"stageOrder"`
+	PipelineID string `json:"pipelineId"`
+	Durations
+}
+
+// StageConversion counts the deals that entered a stage and how many of
+// them went on to a later stage of the pipeline
+type StageConversion struct {
+	StageID        string  `json:"stageId"`
+	StageName      string  `json:"stageName"`
+	StageOrder     int     `json:"stageOrder"`
+	PipelineID     string  `json:"pipelineId"`
+	Entered        int     `json:"entered"`
+	Advanced       int     `json:"advanced"`
+	Won            int     `json:"won"`
+	ConversionRate float64 `json:"conversionRate"`
+	WinRate        float64 `json:"winRate"`
+}
+
+// Transition counts the moves between two stages
+type Transition struct {
+	FromStageID string `json:"fromStageId"`
+	ToStageID   string `json:"toStageId"`
+	Count       int    `json:"count"`
+}
+
+// WinRate summarises closed deals for one owner or source
+type WinRate struct {
+	Key        *string `json:"key"`
+	Name       string  `json:"name"`
+	Currency   string  `json:"currency"`
+	Won        int     `json:"won"`
+	Lost       int     `json:"lost"`
+	WonAmount  float64 `json:"wonAmount"`
+	LostAmount float64 `json:"lostAmount"`
+	WinRate    float64 `json:"winRate"`
+	// Currencies of deals left out of the amounts for lack of a rate
+	UnconvertedCurrencies []string `json:"unconvertedCurrencies,omitempty"`
+}
+
+// SalesCycle is the time from creation to close of won deals
+type SalesCycle struct {
+	Overall Durations           `json:"overall"`
+	ByOwner []SalesCycleByOwner `json:"byOwner"`
+}
+
+type SalesCycleByOwner struct {
+	OwnerID   *string `json:"ownerId"`
+	OwnerName string  `json:"ownerName"`
+	Durations
+}
+
+// dealsQuery selects the tenant's live deals matching the filters. Deals are
+// attributed to the source of the lead they were converted from, the
+// earliest when several leads converted into the same deal.
+func dealsQuery(db *gorm.DB, tenantID string, f Filters) *gorm.DB {
+	query := db.Table("deals").
+		Joins("JOIN stages ON deals.stage_id = stages.id").
+		Joins(`LEFT JOIN LATERAL (
+			SELECT source FROM leads
+			WHERE leads.converted_to_deal_id = deals.id AND leads.is_deleted = ?
+			ORDER BY leads.converted_at ASC, leads.id ASC
+			LIMIT 1
+		) leads ON true`, false).
+		Where("deals.tenant_id = ? AND deals.is_deleted = ?", tenantID, false)
+	if f.PipelineID != "" {
+		query = query.Where("deals.pipeline_id = ?", f.PipelineID)
+	}
+	if f.OwnerID != "" {
+		query = query.Where("deals.assigned_user_id = ?", f.OwnerID)
+	}
+	if f.Source != "" {
+		query = query.Where("leads.source = ?", f.Source)
+	}
+	return query
+}
+
+// stageMove is a DealStageHistory row that changed the deal's stage
+type stageMove struct {
+	DealID      string
+	FromStageID *string
+	ToStageID   string
+	MovedAt     time.Time
+}
+
+// loadStageMoves returns the stage changes of the matching deals ordered by
+// deal and time. History rows that only changed amounts or dates are dropped.
+func loadStageMoves(db *gorm.DB, tenantID string, f Filters) ([]stageMove, error) {
+	var rows []stageMove
+	if err := db.Table("deal_stage_histories").
+		Select("deal_id, from_stage_id, to_stage_id, moved_at").
+		Where("deal_id IN (?)", dealsQuery(db, tenantID, f).Select("deals.id")).
+		Order("deal_id ASC, moved_at ASC").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	moves := rows[:0]
+	current := map[string]string{}
+	for _, row := range rows {
+		if current[row.DealID] == row.ToStageID {
+			continue
+		}
+		current[row.DealID] = row.ToStageID
+		moves = append(moves, row)
+	}
+	return moves, nil
+}
+
+// StageDurations returns the average, median, shortest and longest time
+// deals spent in each stage. A stay is counted when the deal left the stage
+// between From and To; deals still in a stage are not counted.
+func StageDurations(db *gorm.DB, tenantID string, f Filters) ([]StageDuration, error) {
+	moves, err := loadStageMoves(db, tenantID, f)
+	if err != nil {
+		return nil, err
+	}
+
+	days := map[string][]float64{}
+	for i := 1; i < len(moves); i++ {
+		prev, move := moves[i-1], moves[i]
+		if prev.DealID != move.DealID || !inRange(move.MovedAt, f) {
+			continue
+		}
+		days[prev.ToStageID] = append(days[prev.ToStageID], move.MovedAt.Sub(prev.MovedAt).Hours()/24)
+	}
+
+	stages, err := loadStages(db, tenantID, f, keys(days))
+	if err != nil {
+		return nil, err
+	}
+
+	result := []StageDuration{}
+	for _, stage := range stages {
+		if len(days[stage.ID]) == 0 && (stage.IsClosedWon || stage.IsClosedLost) {
+			continue
+		}
+		result = append(result, StageDuration{
+			StageID:    stage.ID,
+			StageName:  stage.Name,
+			StageOrder: stage.Order,
+			PipelineID: stage.PipelineID,
+			Durations:  summarise(days[stage.ID]),
+		})
+	}
+	return result, nil
+}
+
+// StageConversions returns, for deals created between From and To, how many
+// entered each stage, how many of those went on to a later stage and how
+// many were won, together with the counts of every stage-to-stage move.
+func StageConversions(db *gorm.DB, tenantID string, f Filters) ([]StageConversion, []Transition, error) {
+	moves, err := loadStageMoves(db, tenantID, f)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Deal creation is the first history row of each deal
+	cohort := map[string]bool{}
+	entered := map[string][]string{}
+	transitions := map[[2]string]int{}
+	for i, move := range moves {
+		if i == 0 || moves[i-1].DealID != move.DealID {
+			cohort[move.DealID] = inRange(move.MovedAt, f)
+		} else if cohort[move.DealID] {
+			transitions[[2]string{moves[i-1].ToStageID, move.ToStageID}]++
+		}
+		if cohort[move.DealID] {
+			entered[move.DealID] = append(entered[move.DealID], move.ToStageID)
+		}
+	}
+
+	stageIDs := map[string]bool{}
+	for _, stages := range entered {
+		for _, id := range stages {
+			stageIDs[id] = true
+		}
+	}
+	stages, err := loadStages(db, tenantID, f, keys(stageIDs))
+	if err != nil {
+		return nil, nil, err
+	}
+	byID := map[string]models.Stage{}
+	for _, stage := range stages {
+		byID[stage.ID] = stage
+	}
+
+	counts := map[string]*StageConversion{}
+	for _, stage := range stages {
+		counts[stage.ID] = &StageConversion{
+			StageID:    stage.ID,
+			StageName:  stage.Name,
+			StageOrder: stage.Order,
+			PipelineID: stage.PipelineID,
+		}
+	}
+	for _, path := range entered {
+		seen := map[string]bool{}
+		won := byID[path[len(path)-1]].IsClosedWon
+		for i, id := range path {
+			if seen[id] || counts[id] == nil {
+				continue
+			}
+			seen[id] = true
+			counts[id].Entered++
+			if won {
+				counts[id].Won++
+			}
+			for _, later := range path[i+1:] {
+				next, ok := byID[later]
+				if ok && next.PipelineID == byID[id].PipelineID && next.Order > byID[id].Order && !next.IsClosedLost {
+					counts[id].Advanced++
+					break
+				}
+			}
+		}
+	}
+
+	conversions := []StageConversion{}
+	for _, stage := range stages {
+		conversion := counts[stage.ID]
+		if conversion.Entered > 0 {
+			conversion.ConversionRate = float64(conversion.Advanced) / float64(conversion.Entered)
+			conversion.WinRate = float64(conversion.Won) / float64(conversion.Entered)
+		}
+		conversions = append(conversions, *conversion)
+	}
+
+	transitionList := []Transition{}
+	for key, count := range transitions {
+		transitionList = append(transitionList, Transition{FromStageID: key[0], ToStageID: key[1], Count: count})
+	}
+	sort.Slice(transitionList, func(i, j int) bool { return transitionList[i].Count > transitionList[j].Count })
+
+	return conversions, transitionList, nil
+}
+
+// WinRates returns won and lost counts and amounts of deals closed between
+// From and To, grouped by owner or by lead source (groupBy "owner" or
+// "source"). Amounts are converted into the reporting currency at the rate
+// on each deal's close date.
+func WinRates(db *gorm.DB, tenantID string, f Filters, groupBy string) ([]WinRate, error) {
+	converter, err := currency.NewConverter(db, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, name := "deals.assigned_user_id", "TRIM(CONCAT(users.first_name, ' ', users.last_name))"
+	if groupBy == "source" {
+		key, name = "leads.source", "COALESCE(leads.source, '')"
+	}
+
+	var rows []struct {
+		GroupKey   *string
+		Name       string
+		Currency   string
+		CloseDate  time.Time
+		Won        int
+		Lost       int
+		WonAmount  float64
+		LostAmount float64
+	}
+	query := dealsQuery(db, tenantID, f).
+		Joins("LEFT JOIN users ON deals.assigned_user_id = users.id").
+		Select(key + ` as group_key, ` + name + ` as name, deals.currency,
+			COALESCE(deals.actual_close_date, CURRENT_DATE)::date as close_date,
+			COUNT(*) FILTER (WHERE stages.is_closed_won) as won,
+			COUNT(*) FILTER (WHERE stages.is_closed_lost) as lost,
+			COALESCE(SUM(deals.amount) FILTER (WHERE stages.is_closed_won), 0) as won_amount,
+			COALESCE(SUM(deals.amount) FILTER (WHERE stages.is_closed_lost), 0) as lost_amount`).
+		Where("(stages.is_closed_won OR stages.is_closed_lost)").
+		Group("1, 2, 3, 4").
+		Order("2")
+	query = whereDateRange(query, "deals.actual_close_date", f)
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	rates := []WinRate{}
+	index := map[string]int{}
+	for _, row := range rows {
+		groupKey := ""
+		if row.GroupKey != nil {
+			groupKey = *row.GroupKey
+		}
+		i, ok := index[groupKey]
+		if !ok {
+			i = len(rates)
+			index[groupKey] = i
+			rates = append(rates, WinRate{Key: row.GroupKey, Name: row.Name, Currency: converter.Target})
+		}
+		rates[i].Won += row.Won
+		rates[i].Lost += row.Lost
+		wonAmount, wonOK := converter.Convert(row.WonAmount, row.Currency, row.CloseDate)
+		lostAmount, lostOK := converter.Convert(row.LostAmount, row.Currency, row.CloseDate)
+		if !wonOK || !lostOK {
+			if !slices.Contains(rates[i].UnconvertedCurrencies, row.Currency) {
+				rates[i].UnconvertedCurrencies = append(rates[i].UnconvertedCurrencies, row.Currency)
+				sort.Strings(rates[i].UnconvertedCurrencies)
+			}
+			continue
+		}
+		rates[i].WonAmount += wonAmount
+		rates[i].LostAmount += lostAmount
+	}
+	for i := range rates {
+		if total := rates[i].Won + rates[i].Lost; total > 0 {
+			rates[i].WinRate = float64(rates[i].Won) / float64(total)
+		}
+	}
+	return rates, nil
+}
+
+// SalesCycleLength measures the days from creation to close of deals won
+// between From and To, overall and per owner
+func SalesCycleLength(db *gorm.DB, tenantID string, f Filters) (*SalesCycle, error) {
+	var rows []struct {
+		OwnerID   *string
+		OwnerName string
+		Days      float64
+	}
+	query := dealsQuery(db, tenantID, f).
+		Joins("LEFT JOIN users ON deals.assigned_user_id = users.id").
+		Select(`deals.assigned_user_id as owner_id,
+			TRIM(CONCAT(users.first_name, ' ', users.last_name)) as owner_name,
+			EXTRACT(EPOCH FROM (deals.actual_close_date - deals.created_at)) / 86400 as days`).
+		Where("stages.is_closed_won AND deals.actual_close_date IS NOT NULL")
+	query = whereDateRange(query, "deals.actual_close_date", f)
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	var all []float64
+	byOwner := map[string][]float64{}
+	owners := map[string]SalesCycleByOwner{}
+	for _, row := range rows {
+		all = append(all, row.Days)
+		key := ""
+		if row.OwnerID != nil {
+			key = *row.OwnerID
+		}
+		byOwner[key] = append(byOwner[key], row.Days)
+		owners[key] = SalesCycleByOwner{OwnerID: row.OwnerID, OwnerName: row.OwnerName}
+	}
+
+	result := &SalesCycle{Overall: summarise(all), ByOwner: []SalesCycleByOwner{}}
+	for key, owner := range owners {
+		owner.Durations = summarise(byOwner[key])
+		result.ByOwner = append(result.ByOwner, owner)
+	}
+	sort.Slice(result.ByOwner, func(i, j int) bool { return result.ByOwner[i].OwnerName < result.ByOwner[j].OwnerName })
+	return result, nil
+}
+
+// loadStages returns the stages of the filtered pipeline, or of the given
+// stage IDs when no pipeline is selected, in pipeline order
+func loadStages(db *gorm.DB, tenantID string, f Filters, stageIDs []string) ([]models.Stage, error) {
+	query := db.Where("tenant_id = ?", tenantID)
+	if f.PipelineID != "" {
+		query = query.Where("pipeline_id = ? AND (is_deleted = ? OR id IN ?)", f.PipelineID, false, append(stageIDs, ""))
+	} else {
+		query = query.Where("id IN ?", append(stageIDs, ""))
+	}
+
+	var stages []models.Stage
+	if err := query.Order("pipeline_id ASC, \"order\" ASC").Find(&stages).Error; err != nil {
+		return nil, err
+	}
+	return stages, nil
+}
+
+func whereDateRange(query *gorm.DB, column string, f Filters) *gorm.DB {
+	if f.From != nil {
+		query = query.Where(column+" >= ?", *f.From)
+	}
+	if f.To != nil {
+		query = query.Where(column+" < ?", *f.To)
+	}
+	return query
+}
+
+func inRange(t time.Time, f Filters) bool {
+	return (f.From == nil || !t.Before(*f.From)) && (f.To == nil || t.Before(*f.To))
+}
+
+func summarise(days []float64) Durations {
+	if len(days) == 0 {
+		return Durations{}
+	}
+	sorted := append([]float64(nil), days...)
+	sort.Float64s(sorted)
+
+	total := 0.0
+	for _, d := range sorted {
+		total += d
+	}
+	median := sorted[len(sorted)/2]
+	if len(sorted)%2 == 0 {
+		median = (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+	}
+	return Durations{
+		Count:      len(sorted),
+		AvgDays:    total / float64(len(sorted)),
+		MedianDays: median,
+		MinDays:    sorted[0],
+		MaxDays:    sorted[len(sorted)-1],
+	}
+}
+
+func keys[V any](m map[string]V) []string {
+	result := make([]string, 0, len(m))
+	for key := range m {
+		result = append(result, key)
+	}
+	return result
+}
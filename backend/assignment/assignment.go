@@ -0,0 +1,318 @@
+// Package assignment routes new leads to users with tenant assignment rules
+package assignment
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"finhub-backend/models"
+	"finhub-backend/territories"
+)
+
+// Assignment methods
+const (
+	MethodUser       = "user"
+	MethodRoundRobin = "round_robin"
+	MethodTerritory  = "territory"
+)
+
+// Events that run the assignment rules
+const (
+	TriggerCreate  = "create"
+	TriggerImport  = "import"
+	TriggerWebForm = "web_form"
+)
+
+// Decision outcomes
+const (
+	OutcomeAssigned    = "assigned"
+	OutcomeNoMatch     = "no_match"
+	OutcomeUnavailable = "unavailable"
+)
+
+// Condition operators
+const (
+	OperatorIn       = "in"
+	OperatorNotIn    = "not_in"
+	OperatorContains = "contains"
+)
+
+// fields are the lead and company attributes conditions can match. Company
+// location comes from the company's primary address.
+var fields = map[string]func(s *subject) string{
+	"source":             func(s *subject) string { return deref(s.lead.Source) },
+	"campaign":           func(s *subject) string { return deref(s.lead.Campaign) },
+	"title":              func(s *subject) string { return deref(s.lead.Title) },
+	"statusId":           func(s *subject) string { return deref(s.lead.StatusID) },
+	"temperatureId":      func(s *subject) string { return deref(s.lead.TemperatureID) },
+	"company.name":       func(s *subject) string { return s.company.Name },
+	"company.industryId": func(s *subject) string { return deref(s.company.IndustryID) },
+	"company.sizeId":     func(s *subject) string { return deref(s.company.SizeID) },
+	"company.country":    func(s *subject) string { return deref(s.address.Country) },
+	"company.state":      func(s *subject) string { return deref(s.address.State) },
+	"company.city":       func(s *subject) string { return deref(s.address.City) },
+	"company.postalCode": func(s *subject) string { return deref(s.address.PostalCode) },
+}
+
+// ValidField reports whether conditions can match on a field
+func ValidField(field string) bool {
+	_, ok := fields[field]
+	return ok
+}
+
+// subject is a lead with the company values its conditions see
+type subject struct {
+	lead    *models.Lead
+	company models.Company
+	address models.Address
+}
+
+// AssignLead runs the tenant's active assignment rules, in order, for a lead
+// without an assigned user. The first matching rule that has an available
+// user assigns the lead; rules whose users are all inactive or at capacity
+// are passed over. The decision is logged and returned; nil is returned for
+// leads that already have a user.
+func AssignLead(db *gorm.DB, lead *models.Lead, trigger string) (*models.AssignmentLog, error) {
+	if lead.AssignedUserID != nil {
+		return nil, nil
+	}
+
+	var rules []models.AssignmentRule
+	if err := db.Where("tenant_id = ? AND is_active = ?", lead.TenantID, true).
+		Order(`"order" ASC, created_at ASC`).
+		Find(&rules).Error; err != nil {
+		return nil, err
+	}
+
+	s := subject{lead: lead}
+	if lead.CompanyID != nil {
+		if err := db.First(&s.company, "id = ?", *lead.CompanyID).Error; err != nil && err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+		if err := db.Where("entity_type = ? AND entity_id = ?", "company", *lead.CompanyID).
+			Order("is_primary DESC, created_at ASC").
+			Limit(1).
+			Find(&s.address).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	entry := models.AssignmentLog{
+		LeadID:   lead.ID,
+		Trigger:  trigger,
+		Outcome:  OutcomeNoMatch,
+		TenantID: lead.TenantID,
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var notes []string
+		for i := range rules {
+			rule := &rules[i]
+			if !matches(rule.Conditions, &s) {
+				continue
+			}
+			userID, reason, err := pick(tx, rule, lead)
+			if err != nil {
+				return err
+			}
+			if userID == nil {
+				entry.Outcome = OutcomeUnavailable
+				entry.RuleID = &rule.ID
+				notes = append(notes, fmt.Sprintf("%s: %s", rule.Name, reason))
+				continue
+			}
+
+			if err := tx.Model(lead).Update("assigned_user_id", *userID).Error; err != nil {
+				return err
+			}
+			lead.AssignedUserID = userID
+			entry.Outcome = OutcomeAssigned
+			entry.RuleID = &rule.ID
+			entry.UserID = userID
+			notes = append(notes, fmt.Sprintf("%s: assigned by %s", rule.Name, rule.Method))
+			break
+		}
+		if len(notes) > 0 {
+			detail := strings.Join(notes, "; ")
+			entry.Detail = &detail
+		}
+		return tx.Create(&entry).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// matches reports whether a lead meets all conditions
+func matches(conditions []models.AssignmentCondition, s *subject) bool {
+	for _, condition := range conditions {
+		field, ok := fields[condition.Field]
+		if !ok {
+			return false
+		}
+		value := strings.ToLower(field(s))
+		found := false
+		for _, v := range condition.Values {
+			v = strings.ToLower(v)
+			if condition.Operator == OperatorContains {
+				found = value != "" && v != "" && strings.Contains(value, v)
+			} else {
+				found = value == v
+			}
+			if found {
+				break
+			}
+		}
+		if found == (condition.Operator == OperatorNotIn) {
+			return false
+		}
+	}
+	return true
+}
+
+// pick chooses the user a matching rule assigns to, or returns why no user
+// is available
+func pick(tx *gorm.DB, rule *models.AssignmentRule, lead *models.Lead) (*string, string, error) {
+	tenantID := lead.TenantID
+	switch rule.Method {
+	case MethodUser:
+		if rule.UserID == nil {
+			return nil, "no user set", nil
+		}
+		return available(tx, *rule.UserID, tenantID, rule.MaxOpenLeads)
+	case MethodTerritory:
+		if rule.TerritoryID == nil {
+			// Route to the owner of the company's own territory
+			if lead.CompanyID == nil {
+				return nil, "lead has no company", nil
+			}
+			territory, err := territories.Primary(tx, *lead.CompanyID)
+			if err != nil {
+				return nil, "", err
+			}
+			if territory == nil {
+				return nil, "company is in no territory with an owner", nil
+			}
+			return available(tx, *territory.OwnerID, tenantID, rule.MaxOpenLeads)
+		}
+		var territory models.Territory
+		if err := tx.Select("id", "owner_id").
+			Where("id = ? AND tenant_id = ?", *rule.TerritoryID, tenantID).
+			First(&territory).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, "territory not found", nil
+			}
+			return nil, "", err
+		}
+		if territory.OwnerID == nil {
+			return nil, "territory has no owner", nil
+		}
+		return available(tx, *territory.OwnerID, tenantID, rule.MaxOpenLeads)
+	case MethodRoundRobin:
+		if rule.GroupID == nil {
+			return nil, "no group set", nil
+		}
+		return roundRobin(tx, rule, tenantID)
+	}
+	return nil, "unknown method " + rule.Method, nil
+}
+
+// roundRobin picks the available group member furthest behind their
+// weighted share of assignments. The group row is locked so concurrent
+// assignments see each other's counts.
+func roundRobin(tx *gorm.DB, rule *models.AssignmentRule, tenantID string) (*string, string, error) {
+	var group models.UserGroup
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND tenant_id = ?", *rule.GroupID, tenantID).
+		First(&group).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, "group not found", nil
+		}
+		return nil, "", err
+	}
+	if !group.IsActive {
+		return nil, "group is inactive", nil
+	}
+
+	var members []models.UserGroupMember
+	if err := tx.Where("group_id = ? AND weight > 0", group.ID).
+		Order("created_at ASC, id ASC").
+		Find(&members).Error; err != nil {
+		return nil, "", err
+	}
+
+	var chosen *models.UserGroupMember
+	for i := range members {
+		member := &members[i]
+		capacity := rule.MaxOpenLeads
+		if member.MaxOpenLeads != nil {
+			capacity = member.MaxOpenLeads
+		}
+		userID, _, err := available(tx, member.UserID, tenantID, capacity)
+		if err != nil {
+			return nil, "", err
+		}
+		if userID == nil {
+			continue
+		}
+		// Compare (count+1)/weight without floating point
+		if chosen == nil || (member.AssignedCount+1)*chosen.Weight < (chosen.AssignedCount+1)*member.Weight {
+			chosen = member
+		}
+	}
+	if chosen == nil {
+		return nil, "no group member is active and under capacity", nil
+	}
+
+	if err := tx.Model(chosen).UpdateColumn("assigned_count", gorm.Expr("assigned_count + 1")).Error; err != nil {
+		return nil, "", err
+	}
+	return &chosen.UserID, "", nil
+}
+
+// available returns the user when they are active and have fewer than
+// capacity open leads, and otherwise why not
+func available(tx *gorm.DB, userID, tenantID string, capacity *int) (*string, string, error) {
+	var user models.User
+	if err := tx.Select("id", "is_active").
+		Where("id = ? AND tenant_id = ?", userID, tenantID).
+		First(&user).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, "user not found", nil
+		}
+		return nil, "", err
+	}
+	if !user.IsActive {
+		return nil, "user is inactive", nil
+	}
+	if capacity != nil {
+		open, err := OpenLeads(tx, userID)
+		if err != nil {
+			return nil, "", err
+		}
+		if open >= int64(*capacity) {
+			return nil, fmt.Sprintf("user has %d open leads (capacity %d)", open, *capacity), nil
+		}
+	}
+	return &user.ID, "", nil
+}
+
+// OpenLeads counts a user's assigned leads that are neither converted nor
+// deleted
+func OpenLeads(db *gorm.DB, userID string) (int64, error) {
+	var count int64
+	err := db.Model(&models.Lead{}).
+		Where("assigned_user_id = ? AND converted_at IS NULL AND is_deleted = ?", userID, false).
+		Count(&count).Error
+	return count, err
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
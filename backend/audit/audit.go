@@ -0,0 +1,481 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+
+	"finhub-backend/models"
+)
+
+// Actions recorded in ActivityLog.Action
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// ActionKey can be set on a statement (db.Set(audit.ActionKey, "...")) to
+// override the action recorded for the changes it makes
+const ActionKey = "audit:action"
+
+const (
+	snapshotKey = "audit:snapshot"
+	skipKey     = "audit:skip"
+)
+
+// Context keys read from the statement context. They match the keys that
+// the auth and request info middleware store on the gin context, so passing
+// the gin context to db.WithContext is enough to attribute a change.
+const (
+	UserIDKey    = "user_id"
+	IPAddressKey = "ip_address"
+	UserAgentKey = "user_agent"
+)
+
+// fields that change on every write and carry no audit value
+var ignoredColumns = map[string]bool{
+	"tenant_id":  true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+// Register installs the audit callbacks on db. Every create, update and
+// delete of a tenant-owned model writes one ActivityLog row per changed field
+// in the same transaction as the change itself.
+func Register(db *gorm.DB) error {
+	if err := db.Callback().Create().After("gorm:create").Register("audit:after_create", afterCreate); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().Before("gorm:update").Register("audit:before_update", beforeUpdate); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After("gorm:update").Register("audit:after_update", afterUpdate); err != nil {
+		return err
+	}
+	if err := db.Callback().Delete().Before("gorm:delete").Register("audit:before_delete", beforeDelete); err != nil {
+		return err
+	}
+	return db.Callback().Delete().After("gorm:delete").Register("audit:after_delete", afterDelete)
+}
+
+// Skip returns a session whose writes are not audited
+func Skip(db *gorm.DB) *gorm.DB {
+	return db.Set(skipKey, true)
+}
+
+// EntityType returns the ActivityLog entity type for a model schema,
+// e.g. "deal" for models.Deal
+func EntityType(s *schema.Schema) string {
+	return schema.NamingStrategy{}.ColumnName("", s.Name)
+}
+
+// EncodeValue converts a field value into the string form stored in
+// ActivityLog.OldValue/NewValue. Nil pointers are returned as nil.
+func EncodeValue(value interface{}) *string {
+	rv := reflect.ValueOf(value)
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return nil
+	}
+
+	var s string
+	switch v := rv.Interface().(type) {
+	case time.Time:
+		s = v.UTC().Format(time.RFC3339Nano)
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		switch rv.Kind() {
+		case reflect.String:
+			s = rv.String()
+		case reflect.Bool:
+			s = strconv.FormatBool(rv.Bool())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			s = strconv.FormatInt(rv.Int(), 10)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			s = strconv.FormatUint(rv.Uint(), 10)
+		case reflect.Float32, reflect.Float64:
+			s = strconv.FormatFloat(rv.Float(), 'f', -1, 64)
+		default:
+			b, err := json.Marshal(rv.Interface())
+			if err != nil {
+				s = fmt.Sprintf("%v", rv.Interface())
+			} else {
+				s = string(b)
+			}
+		}
+	}
+	return &s
+}
+
+// DecodeValue is the inverse of EncodeValue: it parses a stored value into a
+// value assignable to the given schema field
+func DecodeValue(field *schema.Field, value *string) (interface{}, error) {
+	fieldType := field.FieldType
+	isPtr := fieldType.Kind() == reflect.Ptr
+	if isPtr {
+		fieldType = fieldType.Elem()
+	}
+
+	if value == nil {
+		if isPtr || fieldType.Kind() == reflect.Interface || fieldType.Kind() == reflect.Slice {
+			return reflect.Zero(field.FieldType).Interface(), nil
+		}
+		return nil, fmt.Errorf("field %s cannot be null", field.Name)
+	}
+
+	target := reflect.New(fieldType).Elem()
+	s := *value
+
+	switch {
+	case fieldType == reflect.TypeOf(time.Time{}):
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, err
+		}
+		target.Set(reflect.ValueOf(t))
+	case fieldType.Kind() == reflect.String:
+		target.SetString(s)
+	case fieldType.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, err
+		}
+		target.SetBool(b)
+	case fieldType.Kind() >= reflect.Int && fieldType.Kind() <= reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		target.SetInt(i)
+	case fieldType.Kind() >= reflect.Uint && fieldType.Kind() <= reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		target.SetUint(u)
+	case fieldType.Kind() == reflect.Float32 || fieldType.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		target.SetFloat(f)
+	default:
+		if err := json.Unmarshal([]byte(s), target.Addr().Interface()); err != nil {
+			return nil, err
+		}
+	}
+
+	if isPtr {
+		return target.Addr().Interface(), nil
+	}
+	return target.Interface(), nil
+}
+
+// AuditedFields returns the fields of a schema whose changes are recorded
+func AuditedFields(s *schema.Schema) []*schema.Field {
+	var fields []*schema.Field
+	for _, field := range s.Fields {
+		if field.DBName == "" || field.PrimaryKey || ignoredColumns[field.DBName] {
+			continue
+		}
+		// never record secrets such as password hashes
+		if field.Tag.Get("json") == "-" {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// isAudited reports whether changes made by the statement should be logged
+func isAudited(db *gorm.DB) bool {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return false
+	}
+	if skip, ok := db.Get(skipKey); ok && skip == true {
+		return false
+	}
+	s := db.Statement.Schema
+	if s.ModelType == reflect.TypeOf(models.ActivityLog{}) {
+		return false
+	}
+	if s.LookUpField("TenantID") == nil || s.PrioritizedPrimaryField == nil {
+		return false
+	}
+	return true
+}
+
+func afterCreate(db *gorm.DB) {
+	if !isAudited(db) {
+		return
+	}
+
+	stmt := db.Statement
+	var logs []models.ActivityLog
+	eachRecord(stmt.ReflectValue, func(record reflect.Value) {
+		base, ok := baseEntry(db, record, actionFor(db, ActionCreate))
+		if !ok {
+			return
+		}
+		for _, field := range AuditedFields(stmt.Schema) {
+			value, isZero := field.ValueOf(stmt.Context, record)
+			if isZero {
+				continue
+			}
+			entry := base
+			entry.FieldName = stringPtr(field.DBName)
+			entry.NewValue = EncodeValue(value)
+			logs = append(logs, entry)
+		}
+	})
+
+	writeLogs(db, logs)
+}
+
+func beforeUpdate(db *gorm.DB) {
+	if !isAudited(db) {
+		return
+	}
+	records, err := loadAffected(db)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	db.Set(snapshotKey, records)
+}
+
+func afterUpdate(db *gorm.DB) {
+	if !isAudited(db) {
+		return
+	}
+	value, ok := db.Get(snapshotKey)
+	if !ok {
+		return
+	}
+	before := value.(reflect.Value)
+	if before.Len() == 0 {
+		return
+	}
+
+	stmt := db.Statement
+	pk := stmt.Schema.PrioritizedPrimaryField
+	ids := make([]interface{}, 0, before.Len())
+	for i := 0; i < before.Len(); i++ {
+		id, _ := pk.ValueOf(stmt.Context, before.Index(i))
+		ids = append(ids, id)
+	}
+
+	after := reflect.New(reflect.SliceOf(stmt.Schema.ModelType))
+	if err := newSession(db).Table(stmt.Table).Where(pk.DBName+" IN ?", ids).Find(after.Interface()).Error; err != nil {
+		db.AddError(err)
+		return
+	}
+	afterByID := map[string]reflect.Value{}
+	for i := 0; i < after.Elem().Len(); i++ {
+		record := after.Elem().Index(i)
+		id, _ := pk.ValueOf(stmt.Context, record)
+		afterByID[fmt.Sprint(id)] = record
+	}
+
+	var logs []models.ActivityLog
+	for i := 0; i < before.Len(); i++ {
+		old := before.Index(i)
+		id, _ := pk.ValueOf(stmt.Context, old)
+		current, ok := afterByID[fmt.Sprint(id)]
+		if !ok {
+			continue
+		}
+		base, ok := baseEntry(db, current, actionFor(db, ActionUpdate))
+		if !ok {
+			continue
+		}
+		for _, field := range AuditedFields(stmt.Schema) {
+			oldValue, _ := field.ValueOf(stmt.Context, old)
+			newValue, _ := field.ValueOf(stmt.Context, current)
+			oldStr, newStr := EncodeValue(oldValue), EncodeValue(newValue)
+			if equalValues(oldStr, newStr) {
+				continue
+			}
+			entry := base
+			entry.FieldName = stringPtr(field.DBName)
+			entry.OldValue = oldStr
+			entry.NewValue = newStr
+			logs = append(logs, entry)
+		}
+	}
+
+	writeLogs(db, logs)
+}
+
+func beforeDelete(db *gorm.DB) {
+	if !isAudited(db) {
+		return
+	}
+	records, err := loadAffected(db)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	db.Set(snapshotKey, records)
+}
+
+func afterDelete(db *gorm.DB) {
+	if !isAudited(db) {
+		return
+	}
+	value, ok := db.Get(snapshotKey)
+	if !ok {
+		return
+	}
+	before := value.(reflect.Value)
+
+	var logs []models.ActivityLog
+	for i := 0; i < before.Len(); i++ {
+		base, ok := baseEntry(db, before.Index(i), actionFor(db, ActionDelete))
+		if !ok {
+			continue
+		}
+		logs = append(logs, base)
+	}
+
+	writeLogs(db, logs)
+}
+
+// loadAffected fetches the current state of the rows a statement is about to
+// change, either by the primary keys of the records passed to it or by its
+// WHERE clause for batch operations
+func loadAffected(db *gorm.DB) (reflect.Value, error) {
+	stmt := db.Statement
+	pk := stmt.Schema.PrioritizedPrimaryField
+	records := reflect.New(reflect.SliceOf(stmt.Schema.ModelType))
+
+	var ids []interface{}
+	eachRecord(stmt.ReflectValue, func(record reflect.Value) {
+		if id, isZero := pk.ValueOf(stmt.Context, record); !isZero {
+			ids = append(ids, id)
+		}
+	})
+
+	query := newSession(db).Table(stmt.Table)
+	if len(ids) > 0 {
+		query = query.Where(pk.DBName+" IN ?", ids)
+	}
+	if where, ok := stmt.Clauses["WHERE"]; ok {
+		if w, ok := where.Expression.(clause.Where); ok && len(w.Exprs) > 0 {
+			query = query.Clauses(clause.Where{Exprs: w.Exprs})
+		}
+	} else if len(ids) == 0 {
+		// gorm refuses global updates and deletes, nothing to snapshot
+		return records.Elem(), nil
+	}
+
+	if err := query.Find(records.Interface()).Error; err != nil {
+		return records.Elem(), err
+	}
+	return records.Elem(), nil
+}
+
+func baseEntry(db *gorm.DB, record reflect.Value, action string) (models.ActivityLog, bool) {
+	stmt := db.Statement
+	id, isZero := stmt.Schema.PrioritizedPrimaryField.ValueOf(stmt.Context, record)
+	if isZero {
+		return models.ActivityLog{}, false
+	}
+	tenantID, _ := stmt.Schema.LookUpField("TenantID").ValueOf(stmt.Context, record)
+	tenant := EncodeValue(tenantID)
+	if tenant == nil || *tenant == "" {
+		return models.ActivityLog{}, false
+	}
+
+	return models.ActivityLog{
+		EntityType: EntityType(stmt.Schema),
+		EntityID:   fmt.Sprint(id),
+		Action:     action,
+		UserID:     contextString(stmt.Context, UserIDKey),
+		IPAddress:  contextString(stmt.Context, IPAddressKey),
+		UserAgent:  contextString(stmt.Context, UserAgentKey),
+		TenantID:   *tenant,
+	}, true
+}
+
+func writeLogs(db *gorm.DB, logs []models.ActivityLog) {
+	if len(logs) == 0 {
+		return
+	}
+	now := time.Now()
+	for i := range logs {
+		logs[i].CreatedAt = now
+	}
+	if err := newSession(db).Create(&logs).Error; err != nil {
+		db.AddError(err)
+	}
+}
+
+// newSession returns a fresh statement bound to the same connection (and
+// therefore the same transaction) and context as db
+func newSession(db *gorm.DB) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true})
+}
+
+func actionFor(db *gorm.DB, fallback string) string {
+	if action, ok := db.Get(ActionKey); ok {
+		if s, ok := action.(string); ok && s != "" {
+			return s
+		}
+	}
+	return fallback
+}
+
+func eachRecord(value reflect.Value, fn func(record reflect.Value)) {
+	value = reflect.Indirect(value)
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			fn(reflect.Indirect(value.Index(i)))
+		}
+	case reflect.Struct:
+		fn(value)
+	}
+}
+
+func contextString(ctx context.Context, key string) *string {
+	if ctx == nil {
+		return nil
+	}
+	value := ctx.Value(key)
+	if value == nil {
+		return nil
+	}
+	s := fmt.Sprint(value)
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func equalValues(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
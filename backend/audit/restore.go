@@ -0,0 +1,301 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+
+	"finhub-backend/models"
+)
+
+// ActionRestore is recorded for changes made by reverting a record from its history
+const ActionRestore = "restore"
+
+// ErrNotRestorable is returned for entity types that cannot be restored
+var ErrNotRestorable = errors.New("entity type cannot be restored")
+
+// ErrDidNotExist is returned when restoring a record to a time before it was created
+var ErrDidNotExist = errors.New("record did not exist at the requested time")
+
+// ErrReadOnly is returned when restoring a record that may no longer change,
+// such as a converted lead
+var ErrReadOnly = errors.New("record is read-only")
+
+// readOnly reports whether a loaded record may no longer change
+func readOnly(record interface{}) bool {
+	lead, ok := record.(*models.Lead)
+	return ok && lead.ConvertedAt != nil
+}
+
+// restorable lists the models that can be restored from the activity log,
+// keyed by their entity type
+var restorable = map[string]func() interface{}{
+	"company":              func() interface{} { return &models.Company{} },
+	"contact":              func() interface{} { return &models.Contact{} },
+	"lead":                 func() interface{} { return &models.Lead{} },
+	"deal":                 func() interface{} { return &models.Deal{} },
+	"deal_line_item":       func() interface{} { return &models.DealLineItem{} },
+	"product":              func() interface{} { return &models.Product{} },
+	"contract":             func() interface{} { return &models.Contract{} },
+	"task":                 func() interface{} { return &models.Task{} },
+	"communication":        func() interface{} { return &models.Communication{} },
+	"pipeline":             func() interface{} { return &models.Pipeline{} },
+	"stage":                func() interface{} { return &models.Stage{} },
+	"phone_number":         func() interface{} { return &models.PhoneNumber{} },
+	"email_address":        func() interface{} { return &models.EmailAddress{} },
+	"address":              func() interface{} { return &models.Address{} },
+	"social_media_account": func() interface{} { return &models.SocialMediaAccount{} },
+	"territory":            func() interface{} { return &models.Territory{} },
+}
+
+// NewRecord returns an empty model for a restorable entity type
+func NewRecord(entityType string) (interface{}, error) {
+	newRecord, ok := restorable[entityType]
+	if !ok {
+		return nil, ErrNotRestorable
+	}
+	return newRecord(), nil
+}
+
+// FieldChange describes a single field changed by a restore
+type FieldChange struct {
+	Field    string  `json:"field"`
+	OldValue *string `json:"oldValue"`
+	NewValue *string `json:"newValue"`
+}
+
+// RestoreResult describes what a restore changed on one record
+type RestoreResult struct {
+	EntityType string        `json:"entityType"`
+	EntityID   string        `json:"entityId"`
+	Changes    []FieldChange `json:"changes"`
+	Skipped    []string      `json:"skipped,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// RestoreTo reverts record (a loaded, tenant-owned model) to its state at the
+// given time by replaying its activity log backwards. When fields is not
+// empty only those columns are reverted. Entries moved to the record by a
+// merge are ignored. The revert is written through db and is therefore
+// audited like any other change. Read-only records give ErrReadOnly.
+func RestoreTo(db *gorm.DB, record interface{}, at time.Time, fields []string) (*RestoreResult, error) {
+	if readOnly(record) {
+		return nil, ErrReadOnly
+	}
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(record); err != nil {
+		return nil, err
+	}
+	s := stmt.Schema
+	reflectValue := reflect.Indirect(reflect.ValueOf(record))
+
+	current := map[string]*string{}
+	for _, field := range AuditedFields(s) {
+		value, _ := field.ValueOf(db.Statement.Context, reflectValue)
+		current[field.DBName] = EncodeValue(value)
+	}
+	id, _ := s.PrioritizedPrimaryField.ValueOf(db.Statement.Context, reflectValue)
+	tenantID, _ := s.LookUpField("TenantID").ValueOf(db.Statement.Context, reflectValue)
+
+	var logs []models.ActivityLog
+	if err := db.Session(&gorm.Session{NewDB: true}).
+		Where("tenant_id = ? AND entity_type = ? AND entity_id = ? AND created_at > ?", tenantID, EntityType(s), fmt.Sprint(id), at).
+		Where("merged_from_id IS NULL").
+		Order("created_at DESC").
+		Find(&logs).Error; err != nil {
+		return nil, err
+	}
+
+	target := map[string]*string{}
+	for column, value := range current {
+		target[column] = value
+	}
+	for _, entry := range logs {
+		if entry.Action == ActionCreate {
+			return nil, ErrDidNotExist
+		}
+		if entry.FieldName == nil {
+			continue
+		}
+		if _, ok := target[*entry.FieldName]; ok {
+			target[*entry.FieldName] = entry.OldValue
+		}
+	}
+
+	only := map[string]bool{}
+	for _, field := range fields {
+		if _, ok := current[field]; !ok {
+			return nil, fmt.Errorf("unknown field: %s", field)
+		}
+		only[field] = true
+	}
+
+	result := &RestoreResult{EntityType: EntityType(s), EntityID: fmt.Sprint(id), Changes: []FieldChange{}}
+	updates := map[string]interface{}{}
+	for column, value := range target {
+		if len(only) > 0 && !only[column] {
+			continue
+		}
+		if equalValues(value, current[column]) {
+			continue
+		}
+		decoded, err := DecodeValue(s.LookUpField(column), value)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", column, err)
+		}
+		updates[column] = decoded
+		result.Changes = append(result.Changes, FieldChange{Field: column, OldValue: current[column], NewValue: value})
+	}
+	sort.Slice(result.Changes, func(i, j int) bool { return result.Changes[i].Field < result.Changes[j].Field })
+
+	if len(updates) == 0 {
+		return result, nil
+	}
+	if err := db.Set(ActionKey, ActionRestore).Model(record).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// UndoUserChanges reverts every change a user made to the tenant's records
+// between from and to. Fields that someone else has changed since are left
+// alone and reported as skipped, as are hard deletes which cannot be undone.
+// Records the user created in the window are soft deleted where supported;
+// read-only records are left alone and reported with an error.
+func UndoUserChanges(db *gorm.DB, tenantID, userID string, from, to time.Time) ([]RestoreResult, error) {
+	var logs []models.ActivityLog
+	if err := db.Session(&gorm.Session{NewDB: true}).
+		Where("tenant_id = ? AND user_id = ? AND created_at >= ? AND created_at <= ?", tenantID, userID, from, to).
+		Where("merged_from_id IS NULL").
+		Order("created_at ASC").
+		Find(&logs).Error; err != nil {
+		return nil, err
+	}
+
+	type entityKey struct{ entityType, entityID string }
+	var order []entityKey
+	byEntity := map[entityKey][]models.ActivityLog{}
+	for _, entry := range logs {
+		key := entityKey{entry.EntityType, entry.EntityID}
+		if _, ok := byEntity[key]; !ok {
+			order = append(order, key)
+		}
+		byEntity[key] = append(byEntity[key], entry)
+	}
+
+	results := []RestoreResult{}
+	for _, key := range order {
+		result, err := undoEntity(db, tenantID, userID, to, key.entityType, key.entityID, byEntity[key])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, *result)
+	}
+	return results, nil
+}
+
+func undoEntity(db *gorm.DB, tenantID, userID string, to time.Time, entityType, entityID string, logs []models.ActivityLog) (*RestoreResult, error) {
+	result := &RestoreResult{EntityType: entityType, EntityID: entityID, Changes: []FieldChange{}}
+
+	record, err := NewRecord(entityType)
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	if err := db.Session(&gorm.Session{NewDB: true}).
+		Where("id = ? AND tenant_id = ?", entityID, tenantID).
+		First(record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			result.Error = "record no longer exists"
+			return result, nil
+		}
+		return nil, err
+	}
+	if readOnly(record) {
+		result.Error = ErrReadOnly.Error()
+		return result, nil
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(record); err != nil {
+		return nil, err
+	}
+	s := stmt.Schema
+	reflectValue := reflect.Indirect(reflect.ValueOf(record))
+
+	// the value each field had before the user's first change in the window
+	original := map[string]*string{}
+	var fieldOrder []string
+	created := false
+	for _, entry := range logs {
+		if entry.Action == ActionCreate {
+			created = true
+			continue
+		}
+		if entry.FieldName == nil {
+			result.Skipped = append(result.Skipped, entry.Action)
+			continue
+		}
+		if _, ok := original[*entry.FieldName]; !ok {
+			original[*entry.FieldName] = entry.OldValue
+			fieldOrder = append(fieldOrder, *entry.FieldName)
+		}
+	}
+
+	// fields changed by anyone after the window must not be overwritten
+	var later []models.ActivityLog
+	if err := db.Session(&gorm.Session{NewDB: true}).
+		Where("tenant_id = ? AND entity_type = ? AND entity_id = ? AND created_at > ?", tenantID, entityType, entityID, to).
+		Where("merged_from_id IS NULL").
+		Find(&later).Error; err != nil {
+		return nil, err
+	}
+	changedLater := map[string]bool{}
+	for _, entry := range later {
+		if entry.FieldName != nil && (entry.UserID == nil || *entry.UserID != userID) {
+			changedLater[*entry.FieldName] = true
+		}
+	}
+
+	updates := map[string]interface{}{}
+	if created {
+		if field := s.LookUpField("is_deleted"); field != nil && !changedLater["is_deleted"] {
+			updates["is_deleted"] = true
+			value, _ := field.ValueOf(db.Statement.Context, reflectValue)
+			result.Changes = append(result.Changes, FieldChange{Field: "is_deleted", OldValue: EncodeValue(value), NewValue: stringPtr("true")})
+		} else {
+			result.Skipped = append(result.Skipped, ActionCreate)
+		}
+	} else {
+		for _, column := range fieldOrder {
+			field := s.LookUpField(column)
+			if field == nil || changedLater[column] {
+				result.Skipped = append(result.Skipped, column)
+				continue
+			}
+			value, _ := field.ValueOf(db.Statement.Context, reflectValue)
+			currentValue := EncodeValue(value)
+			if equalValues(currentValue, original[column]) {
+				continue
+			}
+			decoded, err := DecodeValue(field, original[column])
+			if err != nil {
+				result.Skipped = append(result.Skipped, column)
+				continue
+			}
+			updates[column] = decoded
+			result.Changes = append(result.Changes, FieldChange{Field: column, OldValue: currentValue, NewValue: original[column]})
+		}
+	}
+
+	if len(updates) > 0 {
+		if err := db.Set(ActionKey, ActionRestore).Model(record).Updates(updates).Error; err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -4,9 +4,13 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"sort"
 	"strconv"
 
+	"finhub-backend/assignment"
 	"finhub-backend/config"
+	"finhub-backend/domains"
+	"finhub-backend/duplicates"
 	"finhub-backend/models"
 
 	"gorm.io/gorm"
@@ -82,6 +86,27 @@ func createEmailAddress(db *gorm.DB, email string, entityID string, entityType s
 	return &emailAddress
 }
 
+// duplicateOf runs the tenant's duplicate rules for a record about to be
+// imported. It returns the ID of the existing record a block rule matched,
+// and logs the matches of warn rules.
+func duplicateOf(db *gorm.DB, record duplicates.Record, label string) string {
+	result, err := duplicates.Find(db, record)
+	if err != nil {
+		log.Println("Failed to check duplicates for", label+":", err)
+		return ""
+	}
+	for _, match := range result.Matches {
+		if match.Action == duplicates.ActionBlock {
+			log.Printf("Skipping %s, a duplicate of %s %s (score %.2f)", label, match.EntityType, match.Name, match.Score)
+			return match.EntityID
+		}
+	}
+	for _, match := range result.Matches {
+		log.Printf("Possible duplicate: %s and %s %s (score %.2f)", label, match.EntityType, match.Name, match.Score)
+	}
+	return ""
+}
+
 func main() {
 	// Parse command line arguments
 	contactPath := flag.String("contact", "", "Path to the contact CSV file to import")
@@ -126,10 +151,6 @@ func main() {
 		log.Fatal("Failed to load deal histories:", err)
 	}
 
-	if len(dealHistoryData) > 0 {
-		fmt.Println("Deal histories are not supported yet")
-	}
-
 	// Load configuration
 	cfg := config.Load()
 
@@ -208,6 +229,19 @@ func main() {
 			log.Fatal("Failed to convert company size to int:", err)
 		}
 
+		if id := duplicateOf(db, duplicates.Record{
+			EntityType: duplicates.EntityCompany,
+			TenantID:   tenant.ID,
+			Name:       company.CompanyName,
+			Website:    company.Website,
+			Phones:     []string{company.Phone},
+		}, "company "+company.CompanyName); id != "" {
+			var existing models.Company
+			db.First(&existing, "id = ?", id)
+			dbCompanies[company.CompanyID] = existing
+			continue
+		}
+
 		companySize := getCompanySizes(db, companySizeInt)
 		// now create the company
 		dbCompany := models.Company{
@@ -218,8 +252,19 @@ func main() {
 		if companySize != nil {
 			dbCompany.SizeID = &companySize.ID
 		}
+		if domain := domains.Normalize(company.Website); domain != "" {
+			if taken, _ := domains.Taken(db, tenant.ID, domain, ""); taken {
+				log.Println("Domain already used by another company, not setting it:", domain)
+			} else {
+				dbCompany.Domain = &domain
+			}
+		}
 		fmt.Println("Creating company:", company.CompanyName, company.Website, company.Phone, company.Address, company.City, company.State, company.ZipCode, company.Country, company.CompanySize)
-		db.Create(&dbCompany)
+		if err := db.Create(&dbCompany).Error; domains.IsConflict(err) {
+			log.Println("Domain taken while importing, creating company without it:", *dbCompany.Domain)
+			dbCompany.Domain = nil
+			db.Create(&dbCompany)
+		}
 
 		dbCompanies[company.CompanyID] = dbCompany
 
@@ -250,6 +295,21 @@ func main() {
 		if !ok {
 			log.Fatal("Company not found for contact:", contact.CompanyID)
 		}
+		if id := duplicateOf(db, duplicates.Record{
+			EntityType: duplicates.EntityContact,
+			TenantID:   tenant.ID,
+			FirstName:  contact.FirstName,
+			LastName:   contact.LastName,
+			CompanyID:  &companyRecord.ID,
+			Emails:     []string{contact.Email},
+			Phones:     []string{contact.Phone},
+		}, "contact "+contact.FirstName+" "+contact.LastName); id != "" {
+			var existing models.Contact
+			db.First(&existing, "id = ?", id)
+			dbContacts[contact.ContactID] = existing
+			continue
+		}
+
 		dbContact := models.Contact{
 			FirstName:  contact.FirstName,
 			LastName:   contact.LastName,
@@ -272,6 +332,8 @@ func main() {
 
 	// now do the leads
 	dbLeads := map[string]models.Lead{}
+	// a contact can only be the origin of one lead
+	leadContacts := map[string]bool{}
 
 	for _, lead := range leadData {
 		var status models.LeadStatus
@@ -297,9 +359,38 @@ func main() {
 			if !ok {
 				log.Fatal("Company not found for lead:")
 			}
-			dbLead.ContactID = &contactRecord.ID
+			if leadContacts[contactRecord.ID] {
+				log.Println("Contact already has a lead, not linking lead:", lead.LeadID)
+			} else {
+				dbLead.ContactID = &contactRecord.ID
+				leadContacts[contactRecord.ID] = true
+			}
 			dbLead.CompanyID = contactRecord.CompanyID
 		}
+		if dbLead.CompanyID == nil {
+			companyID, err := domains.MatchCompany(db, tenant.ID, []string{lead.Email})
+			if err != nil {
+				log.Println("Failed to match company for lead:", lead.LeadID, err)
+			}
+			dbLead.CompanyID = companyID
+		}
+		if id := duplicateOf(db, duplicates.Record{
+			EntityType: duplicates.EntityLead,
+			TenantID:   tenant.ID,
+			FirstName:  lead.FirstName,
+			LastName:   lead.LastName,
+			CompanyID:  dbLead.CompanyID,
+			Emails:     []string{lead.Email},
+			Phones:     []string{lead.Phone},
+		}, "lead "+lead.FirstName+" "+lead.LastName); id != "" {
+			if dbLead.ContactID != nil {
+				delete(leadContacts, *dbLead.ContactID)
+			}
+			var existing models.Lead
+			db.First(&existing, "id = ?", id)
+			dbLeads[lead.LeadID] = existing
+			continue
+		}
 		fmt.Println("Creating lead:", lead.FirstName, lead.LastName, lead.Status)
 		db.Create(&dbLead)
 		dbLeads[lead.LeadID] = dbLead
@@ -308,6 +399,10 @@ func main() {
 		createPhoneNumber(db, lead.Phone, dbLead.ID, "lead", tenant.ID)
 		// now create the email address
 		createEmailAddress(db, lead.Email, dbLead.ID, "lead", tenant.ID)
+		// and route it with the tenant's assignment rules
+		if _, err := assignment.AssignLead(db, &dbLead, assignment.TriggerImport); err != nil {
+			log.Println("Failed to assign lead:", err)
+		}
 
 	}
 
@@ -324,16 +419,7 @@ func main() {
 		dealAmount, _ := strconv.ParseFloat(deal.Value, 64)
 		dealProbability, _ := strconv.Atoi(deal.Probability)
 		dealExpectedCloseDate, _ := models.ParseDate(deal.ExpectedCloseDate)
-		var stage models.Stage
-		err := db.Where("name = ? and tenant_id = ?", deal.Stage, tenant.ID).First(&stage).Error
-		if err == gorm.ErrRecordNotFound {
-			stage = models.Stage{
-				Name:       deal.Stage,
-				PipelineID: pipeline.ID,
-				TenantID:   tenant.ID,
-			}
-			db.Create(&stage)
-		}
+		stage := getStage(db, deal.Stage, pipeline.ID, tenant.ID)
 
 		// get the user and contact
 
@@ -367,4 +453,74 @@ func main() {
 
 	}
 
+	// now do the deal histories, oldest first so each row chains from the previous one
+	sort.SliceStable(dealHistoryData, func(i, j int) bool {
+		if dealHistoryData[i].DealID != dealHistoryData[j].DealID {
+			return dealHistoryData[i].DealID < dealHistoryData[j].DealID
+		}
+		return dealHistoryData[i].ChangedDate < dealHistoryData[j].ChangedDate
+	})
+
+	previousHistory := map[string]models.DealStageHistory{}
+	for _, history := range dealHistoryData {
+		dbDeal, ok := dbDeals[history.DealID]
+		if !ok {
+			log.Fatal("Deal not found for deal history:", history.DealID)
+		}
+
+		stage := getStage(db, history.Stage, pipeline.ID, tenant.ID)
+		amount := models.ParseFloat(history.Value)
+		probability := models.ParseInt(history.Probability)
+		movedAt, err := models.ParseDate(history.ChangedDate)
+		if err != nil {
+			log.Fatal("Failed to parse deal history date:", err)
+		}
+
+		dbHistory := models.DealStageHistory{
+			DealID:              dbDeal.ID,
+			ToStageID:           stage.ID,
+			ToAmount:            &amount,
+			ToProbability:       &probability,
+			ToCurrency:          &dbDeal.Currency,
+			ToExpectedCloseDate: dbDeal.ExpectedCloseDate,
+			MovedAt:             movedAt,
+		}
+		if history.Notes != "" {
+			dbHistory.Notes = &history.Notes
+		}
+		if dbUser, ok := dbUsers[history.UserID]; ok {
+			dbHistory.MovedBy = &dbUser.ID
+		}
+		if previous, ok := previousHistory[history.DealID]; ok {
+			dbHistory.FromStageID = &previous.ToStageID
+			dbHistory.FromAmount = previous.ToAmount
+			dbHistory.FromProbability = previous.ToProbability
+			dbHistory.FromCurrency = previous.ToCurrency
+			dbHistory.FromExpectedCloseDate = previous.ToExpectedCloseDate
+		}
+		fmt.Println("Creating deal history:", history.DealID, history.Stage, history.Value, history.Probability, history.ChangedDate)
+		db.Create(&dbHistory)
+		previousHistory[history.DealID] = dbHistory
+	}
+
+}
+
+// getStage finds a stage of the pipeline by name, creating it if needed
+func getStage(db *gorm.DB, name string, pipelineID string, tenantID string) models.Stage {
+	var stage models.Stage
+	err := db.Where("name = ? and tenant_id = ? and is_deleted = ?", name, tenantID, false).First(&stage).Error
+	if err == gorm.ErrRecordNotFound {
+		// append new stages after the existing ones
+		var maxOrder int
+		db.Model(&models.Stage{}).Where("pipeline_id = ? and is_deleted = ?", pipelineID, false).
+			Select("COALESCE(MAX(\"order\"), 0)").Scan(&maxOrder)
+		stage = models.Stage{
+			Name:       name,
+			Order:      maxOrder + 1,
+			PipelineID: pipelineID,
+			TenantID:   tenantID,
+		}
+		db.Create(&stage)
+	}
+	return stage
 }
@@ -446,6 +446,34 @@ func main() {
 		}
 	}
 
+	// Seed deal close reasons
+	closeReasons := []models.DealCloseReason{
+		{Name: "Price", Code: "PRICE", Outcome: "lost", Order: 1, IsActive: true, TenantID: tenant.ID},
+		{Name: "Lost to Competitor", Code: "COMPETITOR", Outcome: "lost", Order: 2, IsActive: true, TenantID: tenant.ID},
+		{Name: "No Decision", Code: "NO_DECISION", Outcome: "lost", Order: 3, IsActive: true, TenantID: tenant.ID},
+		{Name: "Missing Features", Code: "FEATURES", Outcome: "lost", Order: 4, IsActive: true, TenantID: tenant.ID},
+		{Name: "Product Fit", Code: "PRODUCT_FIT", Outcome: "won", Order: 1, IsActive: true, TenantID: tenant.ID},
+		{Name: "Relationship", Code: "RELATIONSHIP", Outcome: "won", Order: 2, IsActive: true, TenantID: tenant.ID},
+		{Name: "Pricing", Code: "PRICING", Outcome: "won", Order: 3, IsActive: true, TenantID: tenant.ID},
+	}
+
+	for _, reason := range closeReasons {
+		var existing models.DealCloseReason
+		if err := db.Where("code = ? AND tenant_id = ?", reason.Code, tenant.ID).First(&existing).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				if err := db.Create(&reason).Error; err != nil {
+					log.Printf("Failed to create close reason %s: %v", reason.Name, err)
+				} else {
+					log.Printf("Created close reason: %s", reason.Name)
+				}
+			} else {
+				log.Printf("Error checking close reason %s: %v", reason.Name, err)
+			}
+		} else {
+			log.Printf("Close reason %s already exists", reason.Name)
+		}
+	}
+
 	// Seed marketing source types
 	marketingSourceTypes := []models.MarketingSourceType{
 		{
@@ -0,0 +1,302 @@
+// Package contracts manages subscription contracts: renewal opportunities,
+// automatic renewals and expiry, and recurring revenue reporting.
+package contracts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	"gorm.io/gorm"
+
+	"finhub-backend/models"
+)
+
+// Contract statuses
+const (
+	StatusActive    = "active"
+	StatusRenewed   = "renewed"
+	StatusExpired   = "expired"
+	StatusCancelled = "cancelled"
+)
+
+// Billing frequencies
+const (
+	BillingMonthly   = "monthly"
+	BillingQuarterly = "quarterly"
+	BillingAnnual    = "annual"
+)
+
+// DefaultRenewalLeadDays is how long before the end date renewal deals are
+// opened when the tenant has not chosen
+const DefaultRenewalLeadDays = 90
+
+// RenewalSettings choose where and when renewal deals are opened. Without a
+// pipeline, renewals go into the pipeline of the contract's original deal.
+type RenewalSettings struct {
+	PipelineID *string `json:"pipelineId"`
+	LeadDays   int     `json:"leadDays"`
+}
+
+// ErrNoOpenStage is returned when a renewal pipeline has no open stage
+var ErrNoOpenStage = errors.New("renewal pipeline has no open stage")
+
+// LoadRenewalSettings reads the tenant's renewal settings from Tenant.Settings
+func LoadRenewalSettings(db *gorm.DB, tenantID string) (RenewalSettings, error) {
+	settings := RenewalSettings{LeadDays: DefaultRenewalLeadDays}
+	var raw *string
+	if err := db.Table("tenants").
+		Select("settings->'renewals'").
+		Where("id = ?", tenantID).
+		Scan(&raw).Error; err != nil {
+		return settings, err
+	}
+	if raw == nil {
+		return settings, nil
+	}
+	if err := json.Unmarshal([]byte(*raw), &settings); err != nil {
+		return settings, err
+	}
+	if settings.LeadDays <= 0 {
+		settings.LeadDays = DefaultRenewalLeadDays
+	}
+	return settings, nil
+}
+
+// SaveRenewalSettings stores the tenant's renewal settings in Tenant.Settings,
+// keeping any other settings
+func SaveRenewalSettings(db *gorm.DB, tenantID string, settings RenewalSettings) error {
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	return db.Exec(`UPDATE tenants SET settings = COALESCE(settings, '{}'::jsonb) || jsonb_build_object('renewals', ?::jsonb), updated_at = ? WHERE id = ?`,
+		string(raw), time.Now(), tenantID).Error
+}
+
+// TermMonths returns the length of a contract in whole months, at least one
+func TermMonths(start, end time.Time) int {
+	next := end.AddDate(0, 0, 1)
+	months := (next.Year()-start.Year())*12 + int(next.Month()-start.Month())
+	if next.Day() < start.Day() {
+		months--
+	}
+	if months < 1 {
+		return 1
+	}
+	return months
+}
+
+// AnnualAmount annualizes an amount covering the given term
+func AnnualAmount(amount float64, termMonths int) float64 {
+	return round(amount / float64(termMonths) * 12)
+}
+
+// MonthlyAmount returns the MRR of an ARR
+func MonthlyAmount(arr float64) float64 {
+	return round(arr / 12)
+}
+
+// Process runs the daily contract housekeeping for every active tenant:
+// renewal deals are opened LeadDays before contracts end, contracts whose
+// renewal deal was won or that auto-renew are renewed into a successor
+// contract, and ended contracts without one are expired. A failing tenant or
+// contract is logged and skipped; the failures are returned together.
+func Process(db *gorm.DB, now time.Time) error {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	var tenants []models.Tenant
+	if err := db.Select("id").Where("is_active = ?", true).Find(&tenants).Error; err != nil {
+		return err
+	}
+	var errs []error
+	for _, tenant := range tenants {
+		if err := processTenant(db, tenant.ID, today); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", tenant.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func processTenant(db *gorm.DB, tenantID string, today time.Time) error {
+	settings, err := LoadRenewalSettings(db, tenantID)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	// failed holds contracts that hit an error, left alone by later steps so
+	// that e.g. a failed renewal is not followed by expiry
+	failed := map[string]bool{}
+	fail := func(contractID string, err error) {
+		err = fmt.Errorf("contract %s: %w", contractID, err)
+		log.Printf("Contract processing for tenant %s: %v", tenantID, err)
+		errs = append(errs, err)
+		failed[contractID] = true
+	}
+
+	// Open renewal deals for contracts that will not renew by themselves
+	var expiring []models.Contract
+	if err := db.Where("tenant_id = ? AND status = ? AND is_deleted = ? AND auto_renew = ? AND renewal_deal_id IS NULL AND end_date <= ?",
+		tenantID, StatusActive, false, false, today.AddDate(0, 0, settings.LeadDays)).
+		Preload("Deal").
+		Find(&expiring).Error; err != nil {
+		return err
+	}
+	for i := range expiring {
+		if err := OpenRenewalDeal(db, &expiring[i], settings); err != nil {
+			fail(expiring[i].ID, err)
+		}
+	}
+
+	// Renew contracts whose renewal deal was won
+	var won []models.Contract
+	if err := db.Joins("JOIN deals ON deals.id = contracts.renewal_deal_id").
+		Joins("JOIN stages ON stages.id = deals.stage_id").
+		Where("contracts.tenant_id = ? AND contracts.status = ? AND contracts.is_deleted = ? AND stages.is_closed_won = ?",
+			tenantID, StatusActive, false, true).
+		Preload("RenewalDeal").
+		Find(&won).Error; err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for i := range won {
+		if failed[won[i].ID] {
+			continue
+		}
+		if err := Renew(db, &won[i], won[i].RenewalDeal); err != nil {
+			fail(won[i].ID, err)
+		}
+	}
+
+	// Contracts that have ended either auto-renew or expire
+	var ended []models.Contract
+	if err := db.Where("tenant_id = ? AND status = ? AND is_deleted = ? AND end_date < ?",
+		tenantID, StatusActive, false, today).
+		Find(&ended).Error; err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for i := range ended {
+		contract := &ended[i]
+		if failed[contract.ID] {
+			continue
+		}
+		if contract.AutoRenew {
+			err = Renew(db, contract, nil)
+		} else {
+			err = db.Model(contract).Update("status", StatusExpired).Error
+		}
+		if err != nil {
+			fail(contract.ID, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// OpenRenewalDeal creates the renewal deal for a contract in the first open
+// stage of the renewal pipeline, owned by the owner of the original deal and
+// expected to close on the contract's end date
+func OpenRenewalDeal(db *gorm.DB, contract *models.Contract, settings RenewalSettings) error {
+	pipelineID := settings.PipelineID
+	if pipelineID == nil && contract.Deal != nil {
+		pipelineID = &contract.Deal.PipelineID
+	}
+	if pipelineID == nil {
+		return nil
+	}
+
+	var stage models.Stage
+	if err := db.Where("pipeline_id = ? AND tenant_id = ? AND is_deleted = ? AND is_closed_won = ? AND is_closed_lost = ?",
+		*pipelineID, contract.TenantID, false, false, false).
+		Order(`"order" ASC`).
+		First(&stage).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNoOpenStage
+		}
+		return err
+	}
+
+	amount := round(contract.ARR * float64(TermMonths(contract.StartDate, contract.EndDate)) / 12)
+	closeDate := contract.EndDate
+	deal := models.Deal{
+		Name:              contract.Name + " renewal",
+		Amount:            &amount,
+		Currency:          contract.Currency,
+		Probability:       stage.Probability,
+		PipelineID:        stage.PipelineID,
+		StageID:           stage.ID,
+		ExpectedCloseDate: &closeDate,
+		CompanyID:         &contract.CompanyID,
+		TenantID:          contract.TenantID,
+	}
+	if contract.Deal != nil {
+		deal.ContactID = contract.Deal.ContactID
+		deal.AssignedUserID = contract.Deal.AssignedUserID
+	}
+
+	reason := "renewal"
+	notes := "Renewal of contract " + contract.Name
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&deal).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&models.DealStageHistory{
+			DealID:              deal.ID,
+			ToStageID:           deal.StageID,
+			ToAmount:            deal.Amount,
+			ToProbability:       &deal.Probability,
+			ToCurrency:          &deal.Currency,
+			ToExpectedCloseDate: deal.ExpectedCloseDate,
+			ChangeReason:        &reason,
+			Notes:               &notes,
+			MovedAt:             time.Now(),
+		}).Error; err != nil {
+			return err
+		}
+		contract.RenewalDealID = &deal.ID
+		return tx.Model(contract).Update("renewal_deal_id", deal.ID).Error
+	})
+}
+
+// Renew creates the successor of a contract, starting the day after it ends
+// and running for the same term. A won renewal deal sets the successor's
+// value; without one the contract renews at its current ARR.
+func Renew(db *gorm.DB, contract *models.Contract, deal *models.Deal) error {
+	term := TermMonths(contract.StartDate, contract.EndDate)
+	start := contract.EndDate.AddDate(0, 0, 1)
+	successor := models.Contract{
+		Name:             contract.Name,
+		CompanyID:        contract.CompanyID,
+		RenewedFromID:    &contract.ID,
+		StartDate:        start,
+		EndDate:          start.AddDate(0, term, -1),
+		BillingFrequency: contract.BillingFrequency,
+		Currency:         contract.Currency,
+		ARR:              contract.ARR,
+		MRR:              contract.MRR,
+		AutoRenew:        contract.AutoRenew,
+		Status:           StatusActive,
+		TenantID:         contract.TenantID,
+	}
+	if deal != nil {
+		successor.DealID = &deal.ID
+		if deal.Amount != nil {
+			successor.Currency = deal.Currency
+			successor.ARR = AnnualAmount(*deal.Amount, term)
+			successor.MRR = MonthlyAmount(successor.ARR)
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&successor).Error; err != nil {
+			return err
+		}
+		return tx.Model(contract).Update("status", StatusRenewed).Error
+	})
+}
+
+func round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,216 @@
+package contracts
+
+import (
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+
+	"finhub-backend/currency"
+	"finhub-backend/models"
+)
+
+// Kinds of ARR movement of one company between two month ends
+const (
+	MovementNew         = "new"
+	MovementExpansion   = "expansion"
+	MovementContraction = "contraction"
+	MovementChurn       = "churn"
+)
+
+// CompanyMovement is the change in one company's ARR over a month
+type CompanyMovement struct {
+	CompanyID   string  `json:"companyId"`
+	CompanyName string  `json:"companyName"`
+	Type        string  `json:"type"`
+	FromARR     float64 `json:"fromArr"`
+	ToARR       float64 `json:"toArr"`
+	Change      float64 `json:"change"`
+}
+
+// Month is the ARR bridge of one calendar month: ARR at the previous month
+// end, the new, expansion, contraction and churned ARR during the month and
+// ARR at the month end. Contraction and churn are positive amounts.
+type Month struct {
+	Month       string    `json:"month"`
+	MonthStart  time.Time `json:"monthStart"`
+	StartingARR float64   `json:"startingArr"`
+	NewARR      float64   `json:"newArr"`
+	Expansion   float64   `json:"expansionArr"`
+	Contraction float64   `json:"contractionArr"`
+	Churned     float64   `json:"churnedArr"`
+	EndingARR   float64   `json:"endingArr"`
+	EndingMRR   float64   `json:"endingMrr"`
+
+	StartingCustomers int `json:"startingCustomers"`
+	EndingCustomers   int `json:"endingCustomers"`
+	NewCustomers      int `json:"newCustomers"`
+	ChurnedCustomers  int `json:"churnedCustomers"`
+
+	// Churned and contraction ARR as a share of starting ARR
+	GrossChurnRate *float64 `json:"grossChurnRate"`
+	// Starting ARR of existing customers retained at the month end, with
+	// expansion, as a share of starting ARR
+	NetRetention *float64 `json:"netRetention"`
+
+	Currency  string            `json:"currency"`
+	Movements []CompanyMovement `json:"movements,omitempty"`
+	// Currencies of contracts in force at the month end that are left out
+	// of ARR for lack of a rate
+	UnconvertedCurrencies []string `json:"unconvertedCurrencies,omitempty"`
+}
+
+// Bridge returns the ARR bridge for each month from the month containing
+// from to the month containing to. A contract counts towards its company's
+// ARR at a month end when it has started and neither ended nor been
+// cancelled by then; months after today project the current contracts.
+// ARR is converted into the reporting currency at the rate on each
+// contract's start date, so exchange rate moves don't show as expansion.
+// Contracts in a currency without a rate are left out.
+func Bridge(db *gorm.DB, tenantID string, from, to time.Time) ([]Month, error) {
+	first := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, time.UTC)
+	last := time.Date(to.Year(), to.Month(), 1, 0, 0, 0, 0, time.UTC)
+	baseline := first.AddDate(0, 0, -1)
+
+	var contracts []models.Contract
+	if err := db.Where("tenant_id = ? AND is_deleted = ? AND start_date <= ? AND end_date >= ?",
+		tenantID, false, last.AddDate(0, 1, -1), baseline).
+		Preload("Company", func(db *gorm.DB) *gorm.DB { return db.Select("id", "name") }).
+		Find(&contracts).Error; err != nil {
+		return nil, err
+	}
+
+	converter, err := currency.NewConverter(db, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	arr := make([]float64, len(contracts))
+	converted := make([]bool, len(contracts))
+	names := map[string]string{}
+	for i, contract := range contracts {
+		arr[i], converted[i] = converter.Convert(contract.ARR, contract.Currency, contract.StartDate)
+		if contract.Company != nil {
+			names[contract.CompanyID] = contract.Company.Name
+		}
+	}
+
+	inForce := func(contract models.Contract, date time.Time) bool {
+		end := contract.EndDate
+		if contract.CancelledAt != nil && contract.CancelledAt.Before(end) {
+			end = contract.CancelledAt.AddDate(0, 0, -1)
+		}
+		return !contract.StartDate.After(date) && !end.Before(date)
+	}
+	// companyARR sums the contracts in force on a date by company
+	companyARR := func(date time.Time) map[string]float64 {
+		totals := map[string]float64{}
+		for i, contract := range contracts {
+			if converted[i] && inForce(contract, date) {
+				totals[contract.CompanyID] += arr[i]
+			}
+		}
+		return totals
+	}
+	// unconvertedAt lists the currencies of unconverted contracts in force
+	unconvertedAt := func(date time.Time) []string {
+		unconverted := currency.Unconverted{}
+		for i, contract := range contracts {
+			if !converted[i] && inForce(contract, date) {
+				unconverted[contract.Currency] = true
+			}
+		}
+		if len(unconverted) == 0 {
+			return nil
+		}
+		return unconverted.List()
+	}
+
+	var months []Month
+	previous := companyARR(baseline)
+	for start := first; !start.After(last); start = start.AddDate(0, 1, 0) {
+		end := start.AddDate(0, 1, -1)
+		current := companyARR(end)
+		month := Month{
+			Month:             start.Format("2006-01"),
+			MonthStart:        start,
+			StartingARR:       sum(previous),
+			EndingARR:         sum(current),
+			StartingCustomers: len(previous),
+			EndingCustomers:   len(current),
+			Currency:          converter.Target,
+			Movements:         []CompanyMovement{},
+		}
+		month.UnconvertedCurrencies = unconvertedAt(end)
+		month.EndingMRR = MonthlyAmount(month.EndingARR)
+
+		for _, companyID := range companyIDs(previous, current) {
+			before, after := previous[companyID], current[companyID]
+			change := round(after - before)
+			if change == 0 {
+				continue
+			}
+			movement := CompanyMovement{
+				CompanyID:   companyID,
+				CompanyName: names[companyID],
+				FromARR:     round(before),
+				ToARR:       round(after),
+				Change:      change,
+			}
+			switch {
+			case before == 0:
+				movement.Type = MovementNew
+				month.NewARR += after
+				month.NewCustomers++
+			case after == 0:
+				movement.Type = MovementChurn
+				month.Churned += before
+				month.ChurnedCustomers++
+			case change > 0:
+				movement.Type = MovementExpansion
+				month.Expansion += change
+			default:
+				movement.Type = MovementContraction
+				month.Contraction -= change
+			}
+			month.Movements = append(month.Movements, movement)
+		}
+
+		month.NewARR = round(month.NewARR)
+		month.Expansion = round(month.Expansion)
+		month.Contraction = round(month.Contraction)
+		month.Churned = round(month.Churned)
+		if month.StartingARR > 0 {
+			churnRate := round((month.Churned + month.Contraction) / month.StartingARR * 100)
+			retention := round((month.StartingARR + month.Expansion - month.Contraction - month.Churned) / month.StartingARR * 100)
+			month.GrossChurnRate, month.NetRetention = &churnRate, &retention
+		}
+
+		months = append(months, month)
+		previous = current
+	}
+	return months, nil
+}
+
+func sum(totals map[string]float64) float64 {
+	var total float64
+	for _, amount := range totals {
+		total += amount
+	}
+	return round(total)
+}
+
+// companyIDs returns the companies in either map in a stable order
+func companyIDs(a, b map[string]float64) []string {
+	seen := map[string]bool{}
+	var ids []string
+	for _, totals := range []map[string]float64{a, b} {
+		for id := range totals {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
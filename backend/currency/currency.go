@@ -0,0 +1,209 @@
+package currency
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"finhub-backend/audit"
+	"finhub-backend/models"
+)
+
+// DefaultReportingCurrency is used for tenants that have not chosen one
+const DefaultReportingCurrency = "USD"
+
+// Conversion date modes
+const (
+	// ConvertAtClose converts closed deals at the rate on their actual close
+	// date and open deals at the rate on their expected close date
+	ConvertAtClose = "close"
+	// ConvertAtToday converts every amount at today's rate
+	ConvertAtToday = "today"
+)
+
+// ErrInvalidCurrency is returned for codes that are not ISO 4217
+var ErrInvalidCurrency = errors.New("invalid ISO 4217 currency code")
+
+// ReportingCurrency returns the tenant's reporting currency from
+// Tenant.Settings, falling back to DefaultReportingCurrency
+func ReportingCurrency(db *gorm.DB, tenantID string) (string, error) {
+	var code *string
+	if err := db.Table("tenants").
+		Select("settings->>'reportingCurrency'").
+		Where("id = ?", tenantID).
+		Scan(&code).Error; err != nil {
+		return "", err
+	}
+	if code == nil || !Valid(*code) {
+		return DefaultReportingCurrency, nil
+	}
+	return *code, nil
+}
+
+// SetReportingCurrency stores the tenant's reporting currency in Tenant.Settings,
+// keeping any other settings
+func SetReportingCurrency(db *gorm.DB, tenantID, code string) error {
+	code = Normalize(code)
+	if !Valid(code) {
+		return ErrInvalidCurrency
+	}
+	return db.Exec(`UPDATE tenants SET settings = COALESCE(settings, '{}'::jsonb) || jsonb_build_object('reportingCurrency', ?::text), updated_at = ? WHERE id = ?`,
+		code, time.Now(), tenantID).Error
+}
+
+// SaveRates inserts exchange rates for the tenant, replacing existing rates
+// for the same currency pair and effective date. When the same pair and date
+// appear more than once, the last one wins.
+func SaveRates(db *gorm.DB, tenantID string, rates []models.ExchangeRate) error {
+	if len(rates) == 0 {
+		return nil
+	}
+	type rateKey struct{ base, quote, date string }
+	index := map[rateKey]int{}
+	unique := rates[:0] // compacted in place, so the caller's rates get their IDs
+	for _, rate := range rates {
+		rate.TenantID = tenantID
+		key := rateKey{rate.BaseCurrency, rate.Currency, rate.EffectiveDate.Format("2006-01-02")}
+		if i, ok := index[key]; ok {
+			unique[i] = rate
+			continue
+		}
+		index[key] = len(unique)
+		unique = append(unique, rate)
+	}
+	return audit.Skip(db).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "base_currency"}, {Name: "currency"}, {Name: "effective_date"}, {Name: "tenant_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "source", "updated_at"}),
+	}).CreateInBatches(&unique, 500).Error
+}
+
+type datedRate struct {
+	date time.Time
+	rate float64
+}
+
+type pair struct{ base, quote string }
+
+// Converter converts amounts into a target currency using a tenant's rates
+type Converter struct {
+	Target string
+	rates  map[pair][]datedRate // ascending by date
+	bases  []string
+}
+
+// NewConverter loads the tenant's exchange rates for converting into its
+// reporting currency
+func NewConverter(db *gorm.DB, tenantID string) (*Converter, error) {
+	target, err := ReportingCurrency(db, tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rates []models.ExchangeRate
+	if err := db.Where("tenant_id = ?", tenantID).Order("effective_date ASC").Find(&rates).Error; err != nil {
+		return nil, err
+	}
+
+	converter := &Converter{Target: target, rates: map[pair][]datedRate{}}
+	seenBase := map[string]bool{}
+	for _, rate := range rates {
+		if rate.Rate <= 0 {
+			continue
+		}
+		key := pair{rate.BaseCurrency, rate.Currency}
+		converter.rates[key] = append(converter.rates[key], datedRate{rate.EffectiveDate, rate.Rate})
+		if !seenBase[rate.BaseCurrency] {
+			seenBase[rate.BaseCurrency] = true
+			converter.bases = append(converter.bases, rate.BaseCurrency)
+		}
+	}
+	return converter, nil
+}
+
+// Convert converts amount from currency into the target currency at the
+// rate in effect on the given date. ok is false when no rate is known for
+// the currency; callers leave such amounts out of totals.
+func (c *Converter) Convert(amount float64, from string, at time.Time) (float64, bool) {
+	return c.ConvertTo(amount, from, c.Target, at)
+}
+
+// ConvertTo converts amount between two currencies at the rate in effect on
+// the given date, going through a common base currency when there is no
+// direct rate. ok is false when no conversion is possible.
+func (c *Converter) ConvertTo(amount float64, from, to string, at time.Time) (float64, bool) {
+	if from == to || from == "" {
+		return amount, true
+	}
+	if rate, ok := c.rate(from, to, at); ok {
+		return amount * rate, true
+	}
+	for _, base := range c.bases {
+		toBase, ok := c.rate(from, base, at)
+		if !ok {
+			continue
+		}
+		fromBase, ok := c.rate(base, to, at)
+		if !ok {
+			continue
+		}
+		return amount * toBase * fromBase, true
+	}
+	return amount, false
+}
+
+// rate returns how many units of quote one unit of base buys on the date
+func (c *Converter) rate(base, quote string, at time.Time) (float64, bool) {
+	if base == quote {
+		return 1, true
+	}
+	if rate, ok := rateAt(c.rates[pair{base, quote}], at); ok {
+		return rate, true
+	}
+	if rate, ok := rateAt(c.rates[pair{quote, base}], at); ok {
+		return 1 / rate, true
+	}
+	return 0, false
+}
+
+// rateAt returns the latest rate effective on or before at, or the earliest
+// rate when at predates them all
+func rateAt(rates []datedRate, at time.Time) (float64, bool) {
+	if len(rates) == 0 {
+		return 0, false
+	}
+	i := sort.Search(len(rates), func(i int) bool { return rates[i].date.After(at) })
+	if i == 0 {
+		return rates[0].rate, true
+	}
+	return rates[i-1].rate, true
+}
+
+// RateDate picks the date to convert a deal amount at for the given mode
+func RateDate(mode string, actualCloseDate, expectedCloseDate *time.Time, now time.Time) time.Time {
+	if mode == ConvertAtClose {
+		if actualCloseDate != nil {
+			return *actualCloseDate
+		}
+		if expectedCloseDate != nil {
+			return *expectedCloseDate
+		}
+	}
+	return now
+}
+
+// Unconverted collects the currencies of amounts that could not be converted
+// into the reporting currency
+type Unconverted map[string]bool
+
+// List returns the collected currency codes in order, never nil
+func (u Unconverted) List() []string {
+	codes := make([]string, 0, len(u))
+	for code := range u {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
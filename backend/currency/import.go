@@ -0,0 +1,118 @@
+package currency
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"finhub-backend/models"
+)
+
+// ParseCSV reads exchange rates from CSV with a header row containing date,
+// currency and rate columns and an optional base column. Rows without a base
+// use defaultBase. Dates are formatted 2006-01-02.
+func ParseCSV(r io.Reader, defaultBase string) ([]models.ExchangeRate, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"date", "currency", "rate"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing %s column", required)
+		}
+	}
+	baseColumn, hasBase := columns["base"]
+
+	source := "csv"
+	var rates []models.ExchangeRate
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		base := defaultBase
+		if hasBase && strings.TrimSpace(record[baseColumn]) != "" {
+			base = record[baseColumn]
+		}
+		rate, err := newRate(base, record[columns["currency"]], record[columns["date"]], record[columns["rate"]], source)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rates = append(rates, rate)
+	}
+	return rates, nil
+}
+
+// ecbEnvelope matches the ECB euro foreign exchange reference rate files,
+// e.g. eurofxref-daily.xml and eurofxref-hist.xml
+type ecbEnvelope struct {
+	Days []struct {
+		Time  string `xml:"time,attr"`
+		Rates []struct {
+			Currency string `xml:"currency,attr"`
+			Rate     string `xml:"rate,attr"`
+		} `xml:"Cube"`
+	} `xml:"Cube>Cube"`
+}
+
+// ParseECB reads exchange rates from an ECB-style XML file. ECB rates are
+// quoted against the euro.
+func ParseECB(r io.Reader) ([]models.ExchangeRate, error) {
+	var envelope ecbEnvelope
+	if err := xml.NewDecoder(r).Decode(&envelope); err != nil {
+		return nil, fmt.Errorf("parsing XML: %w", err)
+	}
+
+	source := "ecb"
+	var rates []models.ExchangeRate
+	for _, day := range envelope.Days {
+		for _, entry := range day.Rates {
+			rate, err := newRate("EUR", entry.Currency, day.Time, entry.Rate, source)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %w", day.Time, entry.Currency, err)
+			}
+			rates = append(rates, rate)
+		}
+	}
+	return rates, nil
+}
+
+func newRate(base, code, date, value, source string) (models.ExchangeRate, error) {
+	base, code = Normalize(base), Normalize(code)
+	if !Valid(base) {
+		return models.ExchangeRate{}, fmt.Errorf("%w: %q", ErrInvalidCurrency, base)
+	}
+	if !Valid(code) {
+		return models.ExchangeRate{}, fmt.Errorf("%w: %q", ErrInvalidCurrency, code)
+	}
+	effectiveDate, err := time.Parse("2006-01-02", strings.TrimSpace(date))
+	if err != nil {
+		return models.ExchangeRate{}, fmt.Errorf("invalid date %q", date)
+	}
+	rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || rate <= 0 {
+		return models.ExchangeRate{}, fmt.Errorf("invalid rate %q", value)
+	}
+	return models.ExchangeRate{
+		BaseCurrency:  base,
+		Currency:      code,
+		EffectiveDate: effectiveDate,
+		Rate:          rate,
+		Source:        &source,
+	}, nil
+}
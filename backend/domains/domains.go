@@ -0,0 +1,166 @@
+// Package domains normalizes company web domains, recognizes consumer email
+// providers and matches work email addresses to companies
+package domains
+
+import (
+	_ "embed"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+
+	"finhub-backend/models"
+)
+
+//go:embed freemail.txt
+var freeMailList string
+
+// freeMail holds the consumer email providers listed in freemail.txt
+var freeMail = parseList(freeMailList)
+
+func parseList(list string) map[string]bool {
+	domains := map[string]bool{}
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.ToLower(strings.TrimSpace(line))
+		if line != "" && !strings.HasPrefix(line, "#") {
+			domains[line] = true
+		}
+	}
+	return domains
+}
+
+// IsFreeMail reports whether an email address or domain belongs to a
+// consumer email provider
+func IsFreeMail(email string) bool {
+	domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+	return freeMail[domain]
+}
+
+// Normalize reduces a website or domain to its lower-case host without
+// "www.", e.g. "https://www.Example.com/about" to "example.com". It returns
+// "" when there is no host with a dot in it.
+func Normalize(website string) string {
+	website = strings.ToLower(strings.TrimSpace(website))
+	if website == "" {
+		return ""
+	}
+	if !strings.Contains(website, "://") {
+		website = "http://" + website
+	}
+	u, err := url.Parse(website)
+	if err != nil {
+		return ""
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(u.Hostname(), "www."), ".")
+	if !strings.Contains(host, ".") {
+		return ""
+	}
+	return host
+}
+
+// FromEmail returns the normalized domain of a work email address, or ""
+// for invalid and free-mail addresses
+func FromEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 || IsFreeMail(email) {
+		return ""
+	}
+	return Normalize(email[at+1:])
+}
+
+// uniqueIndex keeps the domains of a tenant's live companies unique
+const uniqueIndex = "idx_companies_tenant_domain"
+
+// CreateUniqueIndex adds the unique index on the domains of live companies.
+// It fails while companies share a domain, so it runs after Backfill.
+func CreateUniqueIndex(db *gorm.DB) error {
+	return db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS " + uniqueIndex +
+		" ON companies (tenant_id, domain) WHERE is_deleted = false AND domain IS NOT NULL").Error
+}
+
+// IsConflict reports whether err is a violation of the domain unique index,
+// i.e. another live company of the tenant got the domain first
+func IsConflict(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == uniqueIndex
+}
+
+// Taken reports whether another live company of the tenant has the domain.
+// excludeID is the company being saved, empty for new ones. The unique index
+// catches companies saved in between; see IsConflict.
+func Taken(db *gorm.DB, tenantID, domain, excludeID string) (bool, error) {
+	query := db.Model(&models.Company{}).Where("tenant_id = ? AND domain = ? AND is_deleted = ?", tenantID, domain, false)
+	if excludeID != "" {
+		query = query.Where("id <> ?", excludeID)
+	}
+	var count int64
+	err := query.Count(&count).Error
+	return count > 0, err
+}
+
+// MatchCompany returns the ID of the company whose domain matches the first
+// work address among emails, or nil. Subdomains match their parent domain,
+// e.g. jane@uk.example.com matches example.com, the most specific domain
+// winning.
+func MatchCompany(db *gorm.DB, tenantID string, emails []string) (*string, error) {
+	for _, email := range emails {
+		domain := FromEmail(email)
+		if domain == "" {
+			continue
+		}
+		var candidates []string
+		for labels := strings.Split(domain, "."); len(labels) > 1; labels = labels[1:] {
+			candidates = append(candidates, strings.Join(labels, "."))
+		}
+		var companies []models.Company
+		if err := db.Select("id", "domain").
+			Where("tenant_id = ? AND domain IN ? AND is_deleted = ?", tenantID, candidates, false).
+			Order("created_at ASC").
+			Find(&companies).Error; err != nil {
+			return nil, err
+		}
+		for _, candidate := range candidates {
+			for _, company := range companies {
+				if company.Domain != nil && *company.Domain == candidate {
+					return &company.ID, nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Backfill derives the domains of companies that have a website but no
+// domain, and normalizes stored domains. Companies whose domain another
+// company of the tenant already has are left alone.
+func Backfill(db *gorm.DB) error {
+	var companies []models.Company
+	return db.Select("id", "tenant_id", "website", "domain").
+		Where("is_deleted = ?", false).
+		Where("(domain IS NULL AND website IS NOT NULL) OR domain <> LOWER(domain) OR domain LIKE 'www.%' OR domain LIKE '%/%'").
+		FindInBatches(&companies, 500, func(batch *gorm.DB, _ int) error {
+			for _, company := range companies {
+				source := company.Website
+				if company.Domain != nil {
+					source = company.Domain
+				}
+				domain := Normalize(*source)
+				if domain == "" || (company.Domain != nil && *company.Domain == domain) {
+					continue
+				}
+				taken, err := Taken(db, company.TenantID, domain, company.ID)
+				if err != nil {
+					return err
+				}
+				if taken {
+					continue
+				}
+				if err := db.Model(&company).Update("domain", domain).Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		}).Error
+}
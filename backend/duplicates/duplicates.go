@@ -0,0 +1,499 @@
+// Package duplicates finds existing contacts, leads and companies a record
+// may duplicate, using the tenant's duplicate rules
+package duplicates
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+
+	"finhub-backend/domains"
+	"finhub-backend/models"
+)
+
+// Entity types rules apply to
+const (
+	EntityContact = "contact"
+	EntityLead    = "lead"
+	EntityCompany = "company"
+)
+
+// Match methods
+const (
+	MatchEmail       = "email"
+	MatchPhone       = "phone"
+	MatchNameCompany = "name_company"
+	MatchDomain      = "domain"
+)
+
+// Rule actions
+const (
+	ActionWarn  = "warn"
+	ActionBlock = "block"
+)
+
+// DefaultThreshold is the name similarity name_company rules require when
+// they set none
+const DefaultThreshold = 0.85
+
+// Scores of the exact match methods. Phones and domains are shared more
+// often than email addresses, so they score lower.
+const (
+	emailScore  = 1.0
+	phoneScore  = 0.9
+	domainScore = 0.8
+)
+
+// ValidMatch reports whether a match method applies to an entity type.
+// Domain rules only apply to companies.
+func ValidMatch(entityType, match string) bool {
+	switch match {
+	case MatchEmail, MatchPhone, MatchNameCompany:
+		return entityType == EntityContact || entityType == EntityLead || entityType == EntityCompany
+	case MatchDomain:
+		return entityType == EntityCompany
+	}
+	return false
+}
+
+// Record is what a contact, lead or company is compared on. ID is set for
+// existing records so they do not match themselves.
+type Record struct {
+	EntityType string
+	ID         string
+	TenantID   string
+
+	// People
+	FirstName string
+	LastName  string
+	CompanyID *string
+
+	// Companies
+	Name    string
+	Website string
+	Domain  string
+
+	Emails []string
+	Phones []string
+}
+
+// Reason is a rule that matched
+type Reason struct {
+	RuleID   string  `json:"ruleId"`
+	RuleName string  `json:"ruleName"`
+	Match    string  `json:"match"`
+	Action   string  `json:"action"`
+	Score    float64 `json:"score"`
+}
+
+// Match is an existing record that may be a duplicate. Score is the
+// highest score of its reasons.
+type Match struct {
+	EntityType string   `json:"entityType"`
+	EntityID   string   `json:"entityId"`
+	Name       string   `json:"name"`
+	Score      float64  `json:"score"`
+	Action     string   `json:"action"`
+	Reasons    []Reason `json:"reasons"`
+}
+
+// Result lists potential duplicates, best first. Blocked is set when a
+// block rule matched.
+type Result struct {
+	Matches []Match `json:"matches"`
+	Blocked bool    `json:"blocked"`
+}
+
+// candidate is an existing record found by a rule
+type candidate struct {
+	id    string
+	name  string
+	score float64
+}
+
+// Find evaluates the tenant's active rules for the record's entity type
+func Find(db *gorm.DB, r Record) (Result, error) {
+	result := Result{Matches: []Match{}}
+	var rules []models.DuplicateRule
+	if err := db.Where("tenant_id = ? AND entity_type = ? AND is_active = ?", r.TenantID, r.EntityType, true).
+		Order("created_at ASC").
+		Find(&rules).Error; err != nil {
+		return result, err
+	}
+	if len(rules) == 0 {
+		return result, nil
+	}
+
+	byID := map[string]*Match{}
+	for _, rule := range rules {
+		candidates, err := evaluate(db, &rule, r)
+		if err != nil {
+			return result, err
+		}
+		for _, c := range candidates {
+			if c.id == r.ID {
+				continue
+			}
+			match, ok := byID[c.id]
+			if !ok {
+				match = &Match{EntityType: r.EntityType, EntityID: c.id, Name: c.name, Action: ActionWarn}
+				byID[c.id] = match
+			}
+			match.Reasons = append(match.Reasons, Reason{RuleID: rule.ID, RuleName: rule.Name, Match: rule.Match, Action: rule.Action, Score: c.score})
+			if c.score > match.Score {
+				match.Score = c.score
+			}
+			if rule.Action == ActionBlock {
+				match.Action = ActionBlock
+				result.Blocked = true
+			}
+		}
+	}
+
+	for _, match := range byID {
+		result.Matches = append(result.Matches, *match)
+	}
+	sort.Slice(result.Matches, func(i, j int) bool {
+		a, b := result.Matches[i], result.Matches[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		return a.Name < b.Name
+	})
+	return result, nil
+}
+
+func evaluate(db *gorm.DB, rule *models.DuplicateRule, r Record) ([]candidate, error) {
+	switch rule.Match {
+	case MatchEmail:
+		var emails []string
+		for _, email := range r.Emails {
+			if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+				emails = append(emails, email)
+			}
+		}
+		if len(emails) == 0 {
+			return nil, nil
+		}
+		var ids []string
+		if err := db.Model(&models.EmailAddress{}).
+			Where("tenant_id = ? AND entity_type = ? AND LOWER(email) IN ?", r.TenantID, r.EntityType, emails).
+			Distinct().Pluck("entity_id", &ids).Error; err != nil {
+			return nil, err
+		}
+		return load(db, r, ids, emailScore)
+	case MatchPhone:
+		var phones []string
+		for _, phone := range r.Phones {
+			if phone = NormalizePhone(phone); phone != "" {
+				phones = append(phones, phone)
+			}
+		}
+		if len(phones) == 0 {
+			return nil, nil
+		}
+		var ids []string
+		if err := db.Model(&models.PhoneNumber{}).
+			Where("tenant_id = ? AND entity_type = ?", r.TenantID, r.EntityType).
+			Where(`RIGHT(regexp_replace(number, '[^0-9]', '', 'g'), 10) IN ?`, phones).
+			Distinct().Pluck("entity_id", &ids).Error; err != nil {
+			return nil, err
+		}
+		return load(db, r, ids, phoneScore)
+	case MatchNameCompany:
+		return byName(db, rule, r)
+	case MatchDomain:
+		domain := domains.Normalize(r.Domain)
+		if domain == "" {
+			domain = domains.Normalize(r.Website)
+		}
+		if domain == "" || r.EntityType != EntityCompany {
+			return nil, nil
+		}
+		var companies []models.Company
+		if err := db.Select("id", "name", "website", "domain").
+			Where("tenant_id = ? AND is_deleted = ?", r.TenantID, false).
+			Where("LOWER(domain) = ? OR website ILIKE ?", domain, "%"+domain+"%").
+			Find(&companies).Error; err != nil {
+			return nil, err
+		}
+		var candidates []candidate
+		for _, company := range companies {
+			if domains.Normalize(deref(company.Domain)) == domain || domains.Normalize(deref(company.Website)) == domain {
+				candidates = append(candidates, candidate{id: company.ID, name: company.Name, score: domainScore})
+			}
+		}
+		return candidates, nil
+	}
+	return nil, nil
+}
+
+// byName finds people at the same company, or companies, whose names are
+// at least the rule's threshold similar
+func byName(db *gorm.DB, rule *models.DuplicateRule, r Record) ([]candidate, error) {
+	threshold := DefaultThreshold
+	if rule.Threshold != nil {
+		threshold = *rule.Threshold
+	}
+
+	var names []candidate
+	switch r.EntityType {
+	case EntityCompany:
+		if NormalizeCompanyName(r.Name) == "" {
+			return nil, nil
+		}
+		var companies []models.Company
+		if err := db.Select("id", "name").Where("tenant_id = ? AND is_deleted = ?", r.TenantID, false).Find(&companies).Error; err != nil {
+			return nil, err
+		}
+		var candidates []candidate
+		target := NormalizeCompanyName(r.Name)
+		for _, company := range companies {
+			if score := Similarity(target, NormalizeCompanyName(company.Name)); score >= threshold {
+				candidates = append(candidates, candidate{id: company.ID, name: company.Name, score: round(score)})
+			}
+		}
+		return candidates, nil
+	case EntityContact:
+		if r.CompanyID == nil {
+			return nil, nil
+		}
+		var contacts []models.Contact
+		if err := db.Select("id", "first_name", "last_name").
+			Where("tenant_id = ? AND company_id = ? AND is_deleted = ?", r.TenantID, *r.CompanyID, false).
+			Find(&contacts).Error; err != nil {
+			return nil, err
+		}
+		for _, contact := range contacts {
+			names = append(names, candidate{id: contact.ID, name: strings.TrimSpace(contact.FirstName + " " + contact.LastName)})
+		}
+	case EntityLead:
+		if r.CompanyID == nil {
+			return nil, nil
+		}
+		var leads []models.Lead
+		if err := db.Select("id", "first_name", "last_name").
+			Where("tenant_id = ? AND company_id = ? AND is_deleted = ?", r.TenantID, *r.CompanyID, false).
+			Find(&leads).Error; err != nil {
+			return nil, err
+		}
+		for _, lead := range leads {
+			names = append(names, candidate{id: lead.ID, name: strings.TrimSpace(deref(lead.FirstName) + " " + deref(lead.LastName))})
+		}
+	}
+
+	target := NormalizeName(r.FirstName + " " + r.LastName)
+	if target == "" {
+		return nil, nil
+	}
+	var candidates []candidate
+	for _, c := range names {
+		if score := Similarity(target, NormalizeName(c.name)); score >= threshold {
+			c.score = round(score)
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates, nil
+}
+
+// load reads the names of the records of the given IDs that are not
+// deleted
+func load(db *gorm.DB, r Record, ids []string, score float64) ([]candidate, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var candidates []candidate
+	switch r.EntityType {
+	case EntityContact:
+		var contacts []models.Contact
+		if err := db.Select("id", "first_name", "last_name").
+			Where("id IN ? AND tenant_id = ? AND is_deleted = ?", ids, r.TenantID, false).
+			Find(&contacts).Error; err != nil {
+			return nil, err
+		}
+		for _, contact := range contacts {
+			candidates = append(candidates, candidate{id: contact.ID, name: strings.TrimSpace(contact.FirstName + " " + contact.LastName), score: score})
+		}
+	case EntityLead:
+		var leads []models.Lead
+		if err := db.Select("id", "first_name", "last_name").
+			Where("id IN ? AND tenant_id = ? AND is_deleted = ?", ids, r.TenantID, false).
+			Find(&leads).Error; err != nil {
+			return nil, err
+		}
+		for _, lead := range leads {
+			candidates = append(candidates, candidate{id: lead.ID, name: strings.TrimSpace(deref(lead.FirstName) + " " + deref(lead.LastName)), score: score})
+		}
+	case EntityCompany:
+		var companies []models.Company
+		if err := db.Select("id", "name").
+			Where("id IN ? AND tenant_id = ? AND is_deleted = ?", ids, r.TenantID, false).
+			Find(&companies).Error; err != nil {
+			return nil, err
+		}
+		for _, company := range companies {
+			candidates = append(candidates, candidate{id: company.ID, name: company.Name, score: score})
+		}
+	}
+	return candidates, nil
+}
+
+// Load builds the record of a stored contact, lead or company with its
+// email addresses and phone numbers
+func Load(db *gorm.DB, entityType, id, tenantID string) (Record, error) {
+	r := Record{EntityType: entityType, ID: id, TenantID: tenantID}
+	switch entityType {
+	case EntityContact:
+		var contact models.Contact
+		if err := db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", id, tenantID, false).First(&contact).Error; err != nil {
+			return r, err
+		}
+		r.FirstName, r.LastName, r.CompanyID = contact.FirstName, contact.LastName, contact.CompanyID
+	case EntityLead:
+		var lead models.Lead
+		if err := db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", id, tenantID, false).First(&lead).Error; err != nil {
+			return r, err
+		}
+		r.FirstName, r.LastName, r.CompanyID = deref(lead.FirstName), deref(lead.LastName), lead.CompanyID
+	case EntityCompany:
+		var company models.Company
+		if err := db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", id, tenantID, false).First(&company).Error; err != nil {
+			return r, err
+		}
+		r.Name, r.Website, r.Domain = company.Name, deref(company.Website), deref(company.Domain)
+	default:
+		return r, gorm.ErrRecordNotFound
+	}
+	if err := db.Model(&models.EmailAddress{}).Where("entity_type = ? AND entity_id = ?", entityType, id).Pluck("email", &r.Emails).Error; err != nil {
+		return r, err
+	}
+	if err := db.Model(&models.PhoneNumber{}).Where("entity_type = ? AND entity_id = ?", entityType, id).Pluck("number", &r.Phones).Error; err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// NormalizePhone reduces a phone number to its last ten digits, so numbers
+// with and without a country code compare equal. Numbers with fewer than
+// seven digits normalize to "".
+func NormalizePhone(number string) string {
+	var b strings.Builder
+	for _, r := range number {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	digits := b.String()
+	if len(digits) < 7 {
+		return ""
+	}
+	if len(digits) > 10 {
+		digits = digits[len(digits)-10:]
+	}
+	return digits
+}
+
+// NormalizeName lower-cases a name and reduces it to letters, digits and
+// single spaces
+func NormalizeName(name string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteRune(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		default:
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// legalSuffixes are dropped from company names before comparing them
+var legalSuffixes = map[string]bool{
+	"inc": true, "incorporated": true, "llc": true, "ltd": true, "limited": true,
+	"corp": true, "corporation": true, "co": true, "company": true, "plc": true,
+	"gmbh": true, "ag": true, "sa": true, "bv": true, "nv": true, "pty": true,
+}
+
+// NormalizeCompanyName normalizes a company name and drops a leading "the"
+// and trailing legal suffixes such as Inc or GmbH
+func NormalizeCompanyName(name string) string {
+	words := strings.Fields(NormalizeName(name))
+	if len(words) > 1 && words[0] == "the" {
+		words = words[1:]
+	}
+	for len(words) > 1 && legalSuffixes[words[len(words)-1]] {
+		words = words[:len(words)-1]
+	}
+	return strings.Join(words, " ")
+}
+
+// Similarity scores two normalized names from 0 to 1 with the
+// Jaro-Winkler measure
+func Similarity(a, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+	s, t := []rune(a), []rune(b)
+	window := max(len(s), len(t))/2 - 1
+	if window < 0 {
+		window = 0
+	}
+	sMatched := make([]bool, len(s))
+	tMatched := make([]bool, len(t))
+	matches := 0
+	for i := range s {
+		for j := max(0, i-window); j < min(len(t), i+window+1); j++ {
+			if !tMatched[j] && s[i] == t[j] {
+				sMatched[i], tMatched[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+	transpositions, j := 0, 0
+	for i := range s {
+		if !sMatched[i] {
+			continue
+		}
+		for !tMatched[j] {
+			j++
+		}
+		if s[i] != t[j] {
+			transpositions++
+		}
+		j++
+	}
+	m := float64(matches)
+	jaro := (m/float64(len(s)) + m/float64(len(t)) + (m-float64(transpositions)/2)/m) / 3
+
+	prefix := 0
+	for prefix < min(4, len(s), len(t)) && s[prefix] == t[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+func round(score float64) float64 {
+	return float64(int(score*100+0.5)) / 100
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,49 @@
+package duplicates
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		number, want string
+	}{
+		{"(415) 555-0100", "4155550100"},
+		{"+1 415 555 0100", "4155550100"},
+		{"001-415-555-0100", "4155550100"},
+		{"+44 20 7946 0958", "2079460958"},
+		{"555-0100", "5550100"},
+		{"555-010", ""},
+		{"ext. 12", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := NormalizePhone(tt.number); got != tt.want {
+			t.Errorf("NormalizePhone(%q) = %q, want %q", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"martha", "martha", 1},
+		{"martha", "marhta", 0.961},
+		{"dwayne", "duane", 0.84},
+		{"dixon", "dicksonx", 0.813},
+		{"abc", "xyz", 0},
+		{"", "martha", 0},
+		{"martha", "", 0},
+	}
+	for _, tt := range tests {
+		if got := Similarity(tt.a, tt.b); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("Similarity(%q, %q) = %.3f, want %.3f", tt.a, tt.b, got, tt.want)
+		}
+		if got, reverse := Similarity(tt.a, tt.b), Similarity(tt.b, tt.a); math.Abs(got-reverse) > 1e-9 {
+			t.Errorf("Similarity(%q, %q) = %.3f but reversed %.3f", tt.a, tt.b, got, reverse)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package forecast
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+
+	"finhub-backend/audit"
+	"finhub-backend/currency"
+	"finhub-backend/models"
+)
+
+// Forecast categories a rep can assign to a deal
+const (
+	CategoryPipeline = "pipeline"
+	CategoryBestCase = "best_case"
+	CategoryCommit   = "commit"
+	CategoryOmitted  = "omitted"
+)
+
+// Period types
+const (
+	PeriodMonth   = "month"
+	PeriodQuarter = "quarter"
+)
+
+// Options selects the deals that make up a forecast and how they are grouped
+type Options struct {
+	PeriodType      string // PeriodMonth or PeriodQuarter
+	From            time.Time
+	To              time.Time // exclusive
+	GroupByOwner    bool
+	GroupByPipeline bool
+	OwnerID         string
+	PipelineID      string
+	ConvertAt       string // currency.ConvertAtClose (default) or currency.ConvertAtToday
+
+	// SplitType credits owners by the deal's splits of this type instead of
+	// the assigned user. Deals without such splits credit the assigned user.
+	SplitType string
+}
+
+// Row holds the forecast figures for one period and, depending on the
+// grouping, one owner and pipeline.
+//
+// ClosedWon is the amount won in the period. Commit adds open deals marked
+// commit, BestCase additionally adds those marked best case. Pipeline is the
+// amount of all open, non-omitted deals expected to close in the period and
+// WeightedPipeline the same amounts weighted by probability.
+type Row struct {
+	Period           string    `json:"period"`
+	Currency         string    `json:"currency"`
+	PeriodStart      time.Time `json:"periodStart"`
+	OwnerID          *string   `json:"ownerId,omitempty"`
+	OwnerName        *string   `json:"ownerName,omitempty"`
+	PipelineID       *string   `json:"pipelineId,omitempty"`
+	PipelineName     *string   `json:"pipelineName,omitempty"`
+	ClosedWon        float64   `json:"closedWon"`
+	Commit           float64   `json:"commit"`
+	BestCase         float64   `json:"bestCase"`
+	Pipeline         float64   `json:"pipeline"`
+	WeightedPipeline float64   `json:"weightedPipeline"`
+	DealCount        int       `json:"dealCount"`
+	// Currencies of deals counted without an amount for lack of a rate
+	UnconvertedCurrencies []string `json:"unconvertedCurrencies,omitempty"`
+}
+
+// forecastDeal is the subset of a deal and its stage used by the forecast
+type forecastDeal struct {
+	ID                string
+	Amount            *float64
+	Currency          string
+	Probability       int
+	StageProbability  int
+	IsClosedWon       bool
+	ExpectedCloseDate *time.Time
+	ActualCloseDate   *time.Time
+	ForecastCategory  *string
+	AssignedUserID    *string
+	PipelineID        string
+}
+
+// probability of an open deal, falling back to its stage's probability
+// when none was set on the deal
+func (d forecastDeal) probability() int {
+	if d.Probability > 0 {
+		return d.Probability
+	}
+	return d.StageProbability
+}
+
+func (d forecastDeal) category() string {
+	if d.ForecastCategory == nil || *d.ForecastCategory == "" {
+		return CategoryPipeline
+	}
+	return *d.ForecastCategory
+}
+
+// PeriodStart returns the first day of the month or quarter containing t
+func PeriodStart(periodType string, t time.Time) time.Time {
+	month := t.Month()
+	if periodType == PeriodQuarter {
+		month = time.Month((int(month)-1)/3*3 + 1)
+	}
+	return time.Date(t.Year(), month, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// PeriodLabel names the period starting at start, e.g. 2026-10 or 2026-Q4
+func PeriodLabel(periodType string, start time.Time) string {
+	if periodType == PeriodQuarter {
+		return fmt.Sprintf("%d-Q%d", start.Year(), (int(start.Month())-1)/3+1)
+	}
+	return start.Format("2006-01")
+}
+
+// Compute builds the tenant's forecast. Won deals count in the period of
+// their actual close date, open deals in the period of their expected close
+// date. Lost deals and deals without a date are left out. Amounts are
+// converted into the tenant's reporting currency and, with a split type,
+// credited to owners by their split percentage. Deals in a currency without
+// a rate count without an amount and are listed in UnconvertedCurrencies.
+func Compute(db *gorm.DB, tenantID string, opts Options) ([]Row, error) {
+	converter, err := currency.NewConverter(db, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ConvertAt == "" {
+		opts.ConvertAt = currency.ConvertAtClose
+	}
+	now := time.Now()
+
+	query := db.Table("deals").
+		Select(`deals.id, deals.amount, deals.currency, deals.probability, stages.probability as stage_probability,
+			stages.is_closed_won, deals.expected_close_date, deals.actual_close_date,
+			deals.forecast_category, deals.assigned_user_id, deals.pipeline_id`).
+		Joins("JOIN stages ON deals.stage_id = stages.id").
+		Where("deals.tenant_id = ? AND deals.is_deleted = ? AND stages.is_closed_lost = ?", tenantID, false, false).
+		Where(`(stages.is_closed_won AND COALESCE(deals.actual_close_date, deals.expected_close_date) >= ? AND COALESCE(deals.actual_close_date, deals.expected_close_date) < ?)
+			OR (NOT stages.is_closed_won AND deals.expected_close_date >= ? AND deals.expected_close_date < ?)`,
+			opts.From, opts.To, opts.From, opts.To)
+	if opts.OwnerID != "" && opts.SplitType == "" {
+		query = query.Where("deals.assigned_user_id = ?", opts.OwnerID)
+	}
+	if opts.PipelineID != "" {
+		query = query.Where("deals.pipeline_id = ?", opts.PipelineID)
+	}
+
+	var deals []forecastDeal
+	if err := query.Scan(&deals).Error; err != nil {
+		return nil, err
+	}
+
+	var splits map[string][]Share
+	if opts.SplitType != "" {
+		ids := make([]string, len(deals))
+		for i, deal := range deals {
+			ids[i] = deal.ID
+		}
+		if splits, err = LoadSplits(db, opts.SplitType, ids); err != nil {
+			return nil, err
+		}
+	}
+
+	type rowKey struct{ period, owner, pipeline string }
+	rows := map[rowKey]*Row{}
+	lastDeal := map[rowKey]string{} // shares of one deal in the same row count it once
+	for _, deal := range deals {
+		closeDate := deal.ExpectedCloseDate
+		if deal.IsClosedWon && deal.ActualCloseDate != nil {
+			closeDate = deal.ActualCloseDate
+		}
+		start := PeriodStart(opts.PeriodType, *closeDate)
+
+		dealAmount, converted := 0.0, true
+		if deal.Amount != nil {
+			var actualCloseDate *time.Time
+			if deal.IsClosedWon {
+				actualCloseDate = closeDate
+			}
+			rateDate := currency.RateDate(opts.ConvertAt, actualCloseDate, deal.ExpectedCloseDate, now)
+			if dealAmount, converted = converter.Convert(*deal.Amount, deal.Currency, rateDate); !converted {
+				dealAmount = 0
+			}
+		}
+
+		shares := splits[deal.ID]
+		if len(shares) == 0 {
+			shares = []Share{{UserID: deal.AssignedUserID, Percentage: 100}}
+		}
+		for _, share := range shares {
+			if opts.OwnerID != "" && (share.UserID == nil || *share.UserID != opts.OwnerID) {
+				continue
+			}
+			key := rowKey{period: PeriodLabel(opts.PeriodType, start)}
+			row := &Row{Period: key.period, Currency: converter.Target, PeriodStart: start}
+			if opts.GroupByOwner && share.UserID != nil {
+				ownerID := *share.UserID
+				key.owner = ownerID
+				row.OwnerID = &ownerID
+			}
+			if opts.GroupByPipeline {
+				pipelineID := deal.PipelineID
+				key.pipeline = pipelineID
+				row.PipelineID = &pipelineID
+			}
+			if existing, ok := rows[key]; ok {
+				row = existing
+			} else {
+				rows[key] = row
+			}
+			addDeal(row, deal, dealAmount*share.Percentage/100, lastDeal[key] != deal.ID)
+			if !converted && !slices.Contains(row.UnconvertedCurrencies, deal.Currency) {
+				row.UnconvertedCurrencies = append(row.UnconvertedCurrencies, deal.Currency)
+				sort.Strings(row.UnconvertedCurrencies)
+			}
+			lastDeal[key] = deal.ID
+		}
+	}
+
+	result := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, *row)
+	}
+	if err := addNames(db, result); err != nil {
+		return nil, err
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].PeriodStart.Equal(result[j].PeriodStart) {
+			return result[i].PeriodStart.Before(result[j].PeriodStart)
+		}
+		if a, b := deref(result[i].OwnerName), deref(result[j].OwnerName); a != b {
+			return a < b
+		}
+		return deref(result[i].PipelineName) < deref(result[j].PipelineName)
+	})
+	return result, nil
+}
+
+// Share is the part of a deal credited to one user
+type Share struct {
+	UserID     *string
+	Percentage float64
+}
+
+// LoadSplits returns the splits of the given type for each deal
+func LoadSplits(db *gorm.DB, splitType string, dealIDs []string) (map[string][]Share, error) {
+	result := map[string][]Share{}
+	if len(dealIDs) == 0 {
+		return result, nil
+	}
+	var splits []models.DealSplit
+	if err := db.Where("split_type = ? AND deal_id IN ?", splitType, dealIDs).Find(&splits).Error; err != nil {
+		return nil, err
+	}
+	for _, split := range splits {
+		userID := split.UserID
+		result[split.DealID] = append(result[split.DealID], Share{UserID: &userID, Percentage: split.Percentage})
+	}
+	return result, nil
+}
+
+// addDeal adds a deal's amount, or the credited part of it, to a row
+func addDeal(row *Row, deal forecastDeal, amount float64, count bool) {
+	if count {
+		row.DealCount++
+	}
+
+	if deal.IsClosedWon {
+		row.ClosedWon += amount
+		row.Commit += amount
+		row.BestCase += amount
+		return
+	}
+
+	switch deal.category() {
+	case CategoryOmitted:
+		return
+	case CategoryCommit:
+		row.Commit += amount
+		row.BestCase += amount
+	case CategoryBestCase:
+		row.BestCase += amount
+	}
+	row.Pipeline += amount
+	row.WeightedPipeline += amount * float64(deal.probability()) / 100
+}
+
+// addNames fills in owner and pipeline names for display
+func addNames(db *gorm.DB, rows []Row) error {
+	var ownerIDs, pipelineIDs []string
+	for _, row := range rows {
+		if row.OwnerID != nil {
+			ownerIDs = append(ownerIDs, *row.OwnerID)
+		}
+		if row.PipelineID != nil {
+			pipelineIDs = append(pipelineIDs, *row.PipelineID)
+		}
+	}
+
+	ownerNames := map[string]string{}
+	if len(ownerIDs) > 0 {
+		var users []models.User
+		if err := db.Select("id", "first_name", "last_name").Where("id IN ?", ownerIDs).Find(&users).Error; err != nil {
+			return err
+		}
+		for _, user := range users {
+			ownerNames[user.ID] = user.FirstName + " " + user.LastName
+		}
+	}
+
+	pipelineNames := map[string]string{}
+	if len(pipelineIDs) > 0 {
+		var pipelines []models.Pipeline
+		if err := db.Select("id", "name").Where("id IN ?", pipelineIDs).Find(&pipelines).Error; err != nil {
+			return err
+		}
+		for _, pipeline := range pipelines {
+			pipelineNames[pipeline.ID] = pipeline.Name
+		}
+	}
+
+	for i := range rows {
+		if rows[i].OwnerID != nil {
+			name := ownerNames[*rows[i].OwnerID]
+			rows[i].OwnerName = &name
+		}
+		if rows[i].PipelineID != nil {
+			name := pipelineNames[*rows[i].PipelineID]
+			rows[i].PipelineName = &name
+		}
+	}
+	return nil
+}
+
+// CaptureSnapshots stores the monthly forecast by owner and pipeline for the
+// current and next quarter of every active tenant. Snapshots are dated to the
+// Monday of the week containing now and each week is only captured once, so
+// it is safe to call more often than weekly. A failing tenant does not stop
+// the others; the failures are returned together.
+func CaptureSnapshots(db *gorm.DB, now time.Time) error {
+	snapshotDate := WeekStart(now)
+
+	var tenants []models.Tenant
+	if err := db.Select("id").Where("is_active = ?", true).Find(&tenants).Error; err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, tenant := range tenants {
+		var count int64
+		if err := db.Model(&models.ForecastSnapshot{}).
+			Where("tenant_id = ? AND snapshot_date = ?", tenant.ID, snapshotDate).
+			Count(&count).Error; err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", tenant.ID, err))
+			continue
+		}
+		if count > 0 {
+			continue
+		}
+		if err := CaptureTenantSnapshot(db, tenant.ID, now); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", tenant.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CaptureTenantSnapshot replaces the tenant's snapshot for the week containing now
+func CaptureTenantSnapshot(db *gorm.DB, tenantID string, now time.Time) error {
+	snapshotDate := WeekStart(now)
+	from := PeriodStart(PeriodQuarter, now)
+	rows, err := Compute(db, tenantID, Options{
+		PeriodType:      PeriodMonth,
+		From:            from,
+		To:              from.AddDate(0, 6, 0),
+		GroupByOwner:    true,
+		GroupByPipeline: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	snapshots := make([]models.ForecastSnapshot, len(rows))
+	for i, row := range rows {
+		snapshots[i] = models.ForecastSnapshot{
+			SnapshotDate:     snapshotDate,
+			Period:           row.Period,
+			PeriodStart:      row.PeriodStart,
+			OwnerID:          row.OwnerID,
+			PipelineID:       row.PipelineID,
+			ClosedWon:        row.ClosedWon,
+			Commit:           row.Commit,
+			BestCase:         row.BestCase,
+			Pipeline:         row.Pipeline,
+			WeightedPipeline: row.WeightedPipeline,
+			DealCount:        row.DealCount,
+			Currency:         row.Currency,
+			TenantID:         tenantID,
+		}
+	}
+
+	return audit.Skip(db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tenant_id = ? AND snapshot_date = ?", tenantID, snapshotDate).
+			Delete(&models.ForecastSnapshot{}).Error; err != nil {
+			return err
+		}
+		if len(snapshots) == 0 {
+			return nil
+		}
+		return tx.Create(&snapshots).Error
+	})
+}
+
+// WeekStart returns the Monday of the week containing t
+func WeekStart(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/analytics"
+	"finhub-backend/models"
+)
+
+// AnalyticsHandler serves pipeline velocity and conversion analytics built
+// from the deal stage history. Every endpoint accepts from, to, pipelineId,
+// ownerId and source (the source of the lead a deal was converted from).
+type AnalyticsHandler struct {
+	db *gorm.DB
+}
+
+func NewAnalyticsHandler(db *gorm.DB) *AnalyticsHandler {
+	return &AnalyticsHandler{db: db}
+}
+
+// GetStageDurations returns how long deals spent in each stage
+func (h *AnalyticsHandler) GetStageDurations(c *gin.Context) {
+	tenantID, filters, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	durations, err := analytics.StageDurations(h.db, tenantID, filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute stage durations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"stages": durations})
+}
+
+// GetStageConversions returns stage-to-stage conversion rates of the deals
+// created in the date range
+func (h *AnalyticsHandler) GetStageConversions(c *gin.Context) {
+	tenantID, filters, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	conversions, transitions, err := analytics.StageConversions(h.db, tenantID, filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute stage conversions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"stages": conversions, "transitions": transitions})
+}
+
+// GetWinRates returns win rates of deals closed in the date range by owner,
+// or by lead source with ?groupBy=source
+func (h *AnalyticsHandler) GetWinRates(c *gin.Context) {
+	groupBy := c.DefaultQuery("groupBy", "owner")
+	if groupBy != "owner" && groupBy != "source" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "groupBy must be owner or source"})
+		return
+	}
+
+	tenantID, filters, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	rates, err := analytics.WinRates(h.db, tenantID, filters, groupBy)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute win rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"groupBy": groupBy, "winRates": rates})
+}
+
+// GetSalesCycle returns the sales cycle length of deals won in the date range
+func (h *AnalyticsHandler) GetSalesCycle(c *gin.Context) {
+	tenantID, filters, ok := h.parseRequest(c)
+	if !ok {
+		return
+	}
+
+	cycle, err := analytics.SalesCycleLength(h.db, tenantID, filters)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute sales cycle"})
+		return
+	}
+
+	c.JSON(http.StatusOK, cycle)
+}
+
+// parseRequest resolves the user's tenant and reads the common filters,
+// writing an error response and returning false when either fails
+func (h *AnalyticsHandler) parseRequest(c *gin.Context) (string, analytics.Filters, bool) {
+	filters := analytics.Filters{
+		PipelineID: c.Query("pipelineId"),
+		OwnerID:    c.Query("ownerId"),
+		Source:     c.Query("source"),
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return "", filters, false
+	}
+
+	from, err := parseDate(c.Query("from"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from"})
+		return "", filters, false
+	}
+	to, err := parseDate(c.Query("to"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to"})
+		return "", filters, false
+	}
+	filters.From, filters.To = from, to
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return "", filters, false
+	}
+
+	return user.TenantID, filters, true
+}
@@ -0,0 +1,844 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"finhub-backend/models"
+)
+
+// Approval statuses
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+)
+
+// ApprovalHandler manages discount approval rules and deal approvals
+type ApprovalHandler struct {
+	db *gorm.DB
+}
+
+type ApprovalStepRequest struct {
+	Type   string  `json:"type" binding:"required,oneof=manager role user"`
+	RoleID *string `json:"roleId"`
+	UserID *string `json:"userId"`
+}
+
+type ApprovalRuleRequest struct {
+	Name               string                `json:"name" binding:"required"`
+	Order              int                   `json:"order"`
+	IsActive           *bool                 `json:"isActive"`
+	MinDiscountPercent *float64              `json:"minDiscountPercent" binding:"omitempty,min=0,max=100"`
+	MinDiscountAmount  *float64              `json:"minDiscountAmount" binding:"omitempty,min=0"`
+	ProductFamily      *string               `json:"productFamily"`
+	Approvers          []ApprovalStepRequest `json:"approvers" binding:"required,min=1,dive"`
+}
+
+type ApprovalDecisionRequest struct {
+	Comment *string `json:"comment"`
+}
+
+// pricedLine is a deal or quote line as seen by the approval rules
+type pricedLine struct {
+	ProductID       string
+	Family          *string
+	Quantity        float64
+	ListPrice       float64 // price-book list price, or the unit price when the product has none
+	UnitPrice       float64
+	DiscountPercent float64
+	TotalPrice      float64
+}
+
+func NewApprovalHandler(db *gorm.DB) *ApprovalHandler {
+	return &ApprovalHandler{db: db}
+}
+
+func (h *ApprovalHandler) GetApprovalRules(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var rules []models.ApprovalRule
+	if err := h.db.Where("tenant_id = ?", user.TenantID).
+		Order("\"order\" ASC, name ASC").
+		Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch approval rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+func (h *ApprovalHandler) CreateApprovalRule(c *gin.Context) {
+	h.saveApprovalRule(c, "")
+}
+
+func (h *ApprovalHandler) UpdateApprovalRule(c *gin.Context) {
+	h.saveApprovalRule(c, c.Param("id"))
+}
+
+// saveApprovalRule creates a rule, or replaces the one with the given ID.
+// Only administrators may change approval rules.
+func (h *ApprovalHandler) saveApprovalRule(c *gin.Context, ruleID string) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req ApprovalRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var validationErrors []ValidationError
+	if req.MinDiscountPercent == nil && req.MinDiscountAmount == nil && req.ProductFamily == nil {
+		validationErrors = append(validationErrors, ValidationError{Field: "minDiscountPercent", Code: "required", Message: "Set a discount percentage, discount amount or product family"})
+	}
+	steps := make([]models.ApprovalStep, 0, len(req.Approvers))
+	for i, approver := range req.Approvers {
+		field := fmt.Sprintf("approvers[%d]", i)
+		step := models.ApprovalStep{Type: approver.Type}
+		switch approver.Type {
+		case "role":
+			var count int64
+			if approver.RoleID != nil {
+				h.db.Model(&models.UserRole{}).Where("id = ? AND tenant_id = ?", *approver.RoleID, user.TenantID).Count(&count)
+			}
+			if count == 0 {
+				validationErrors = append(validationErrors, ValidationError{Field: field + ".roleId", Code: "invalid_role", Message: "Role not found"})
+			}
+			step.RoleID = approver.RoleID
+		case "user":
+			var count int64
+			if approver.UserID != nil {
+				h.db.Model(&models.User{}).Where("id = ? AND tenant_id = ?", *approver.UserID, user.TenantID).Count(&count)
+			}
+			if count == 0 {
+				validationErrors = append(validationErrors, ValidationError{Field: field + ".userId", Code: "invalid_user", Message: "User not found"})
+			}
+			step.UserID = approver.UserID
+		}
+		steps = append(steps, step)
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	rule := models.ApprovalRule{TenantID: user.TenantID, IsActive: true}
+	status := http.StatusCreated
+	if ruleID != "" {
+		if err := h.db.Where("id = ? AND tenant_id = ?", ruleID, user.TenantID).First(&rule).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Approval rule not found"})
+			return
+		}
+		status = http.StatusOK
+	}
+	rule.Name = req.Name
+	rule.Order = req.Order
+	if req.IsActive != nil {
+		rule.IsActive = *req.IsActive
+	}
+	rule.MinDiscountPercent = req.MinDiscountPercent
+	rule.MinDiscountAmount = req.MinDiscountAmount
+	rule.ProductFamily = req.ProductFamily
+	rule.Approvers = steps
+
+	if err := h.db.WithContext(c).Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save approval rule"})
+		return
+	}
+
+	c.JSON(status, rule)
+}
+
+func (h *ApprovalHandler) DeleteApprovalRule(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	result := h.db.WithContext(c).Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).Delete(&models.ApprovalRule{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete approval rule"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Approval rule not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Approval rule deleted successfully"})
+}
+
+// GetDealApprovals lists a deal's approval requests with their decisions,
+// and whether its current line items need approval
+func (h *ApprovalHandler) GetDealApprovals(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var deal models.Deal
+	if err := h.db.Select("id", "tenant_id", "price_book_id", "currency").
+		Where("id = ? AND tenant_id = ? AND is_deleted = ?", c.Param("id"), user.TenantID, false).
+		First(&deal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
+		return
+	}
+
+	var approvals []models.DealApproval
+	if err := h.db.Where("deal_id = ?", deal.ID).
+		Preload("Decisions", func(db *gorm.DB) *gorm.DB { return db.Order("decided_at ASC") }).
+		Preload("Decisions.Approver").
+		Order("requested_at DESC").
+		Find(&approvals).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch approvals"})
+		return
+	}
+
+	lines, err := dealPricedLines(h.db, &deal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch line items"})
+		return
+	}
+	validationErrors, err := checkDiscountApproval(h.db, user.TenantID, deal.ID, lines)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate approval rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"approvals": approvals, "approvalRequired": len(validationErrors) > 0})
+}
+
+// RequestDealApproval submits the deal's current discounts for approval. The
+// approver chain is every matching rule's approvers, in rule order.
+func (h *ApprovalHandler) RequestDealApproval(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var deal models.Deal
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", c.Param("id"), user.TenantID, false).
+		First(&deal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
+		return
+	}
+
+	pending, err := hasPendingApproval(h.db, deal.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch approvals"})
+		return
+	}
+	if pending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Deal already has a pending approval"})
+		return
+	}
+
+	lines, err := dealPricedLines(h.db, &deal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch line items"})
+		return
+	}
+	rules, err := matchingApprovalRules(h.db, user.TenantID, lines)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate approval rules"})
+		return
+	}
+	if len(rules) == 0 {
+		c.JSON(http.StatusOK, gin.H{"approvalRequired": false})
+		return
+	}
+
+	requestedBy := userID.(string)
+	approval := models.DealApproval{
+		DealID:      deal.ID,
+		Status:      ApprovalPending,
+		Fingerprint: lineFingerprint(lines),
+		RequestedBy: &requestedBy,
+		RequestedAt: time.Now(),
+		TenantID:    user.TenantID,
+	}
+	approval.DiscountPercent, approval.DiscountAmount = discountSummary(lines, nil)
+	for _, rule := range rules {
+		approval.RuleIDs = append(approval.RuleIDs, rule.ID)
+		approval.Steps = append(approval.Steps, rule.Approvers...)
+	}
+
+	// Every step needs an approver other than the requester, who cannot
+	// decide their own request
+	validationErrors, err := validateApprovalSteps(h.db, user.TenantID, &deal, &approval)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve approvers"})
+		return
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	err = h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := lockDealWithoutApproval(tx, deal.ID); err != nil {
+			return err
+		}
+		// The lines may have changed since they were read
+		current, err := dealPricedLines(tx, &deal)
+		if err != nil {
+			return err
+		}
+		if lineFingerprint(current) != approval.Fingerprint {
+			return errApprovalStale
+		}
+		return tx.Create(&approval).Error
+	})
+	if errors.Is(err, errApprovalPending) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Deal already has a pending approval"})
+		return
+	}
+	if errors.Is(err, errApprovalStale) {
+		c.JSON(http.StatusConflict, gin.H{"error": "The deal's line items changed, please try again"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to request approval"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"approvalRequired": true, "approval": approval})
+}
+
+// GetApprovals lists the tenant's approvals, by default those pending. With
+// ?assigned=me only approvals waiting on the current user are returned.
+func (h *ApprovalHandler) GetApprovals(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var approvals []models.DealApproval
+	if err := h.db.Where("tenant_id = ? AND status = ?", user.TenantID, c.DefaultQuery("status", ApprovalPending)).
+		Preload("Deal").
+		Order("requested_at ASC").
+		Find(&approvals).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch approvals"})
+		return
+	}
+
+	if c.Query("assigned") == "me" {
+		assigned := []models.DealApproval{}
+		for _, approval := range approvals {
+			ok, err := canDecide(h.db, &user, &approval)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve approvers"})
+				return
+			}
+			if ok {
+				assigned = append(assigned, approval)
+			}
+		}
+		approvals = assigned
+	}
+
+	c.JSON(http.StatusOK, approvals)
+}
+
+func (h *ApprovalHandler) ApproveDealApproval(c *gin.Context) {
+	h.decide(c, ApprovalApproved)
+}
+
+func (h *ApprovalHandler) RejectDealApproval(c *gin.Context) {
+	h.decide(c, ApprovalRejected)
+}
+
+// decide records the current user's decision on the current step. Approving
+// the last step approves the request; any rejection rejects it.
+func (h *ApprovalHandler) decide(c *gin.Context, decision string) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req ApprovalDecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var approval models.DealApproval
+	if err := h.db.Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).First(&approval).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Approval not found"})
+		return
+	}
+	if approval.Status != ApprovalPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Approval has already been decided"})
+		return
+	}
+	if decision == ApprovalRejected && (req.Comment == nil || strings.TrimSpace(*req.Comment) == "") {
+		respondValidationErrors(c, []ValidationError{{Field: "comment", Code: "required", Message: "A comment is required to reject"}})
+		return
+	}
+
+	allowed, err := canDecide(h.db, &user, &approval)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve approvers"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not the approver for the current step"})
+		return
+	}
+
+	now := time.Now()
+	record := models.ApprovalDecision{
+		ApprovalID: approval.ID,
+		Step:       approval.CurrentStep,
+		ApproverID: user.ID,
+		Decision:   decision,
+		Comment:    req.Comment,
+		DecidedAt:  now,
+		TenantID:   user.TenantID,
+	}
+	if decision == ApprovalRejected {
+		approval.Status = ApprovalRejected
+		approval.DecidedAt = &now
+	} else {
+		approval.CurrentStep++
+		if approval.CurrentStep >= len(approval.Steps) {
+			approval.Status = ApprovalApproved
+			approval.DecidedAt = &now
+		}
+	}
+
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		// Guard against two approvers deciding the same step at once
+		result := tx.Model(&models.DealApproval{}).
+			Where("id = ? AND status = ? AND current_step = ?", approval.ID, ApprovalPending, record.Step).
+			Updates(map[string]interface{}{
+				"status":       approval.Status,
+				"current_step": approval.CurrentStep,
+				"decided_at":   approval.DecidedAt,
+				"updated_at":   now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errStaleApproval
+		}
+		return tx.Create(&record).Error
+	}); err != nil {
+		if err == errStaleApproval {
+			c.JSON(http.StatusConflict, gin.H{"error": "Approval was decided by someone else"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record decision"})
+		return
+	}
+
+	h.db.Preload("Decisions", func(db *gorm.DB) *gorm.DB { return db.Order("decided_at ASC") }).First(&approval, "id = ?", approval.ID)
+	c.JSON(http.StatusOK, approval)
+}
+
+var errStaleApproval = errors.New("approval changed concurrently")
+
+// canDecide reports whether user is the approver for the approval's current
+// step
+func canDecide(db *gorm.DB, user *models.User, approval *models.DealApproval) (bool, error) {
+	if approval.Status != ApprovalPending || approval.CurrentStep >= len(approval.Steps) {
+		return false, nil
+	}
+	// Nobody approves their own request
+	if approval.RequestedBy != nil && *approval.RequestedBy == user.ID {
+		return false, nil
+	}
+	step := approval.Steps[approval.CurrentStep]
+	switch step.Type {
+	case "user":
+		return step.UserID != nil && *step.UserID == user.ID, nil
+	case "role":
+		return step.RoleID != nil && user.RoleID != nil && *step.RoleID == *user.RoleID, nil
+	case "manager":
+		var deal models.Deal
+		if err := db.Select("id", "assigned_user_id").First(&deal, "id = ?", approval.DealID).Error; err != nil {
+			return false, err
+		}
+		manager, err := ownerManager(db, &deal, approval)
+		if err != nil || manager == nil {
+			return false, err
+		}
+		return *manager == user.ID, nil
+	}
+	return false, nil
+}
+
+// validateApprovalSteps returns an error for each step of the chain that
+// nobody but the requester could decide
+func validateApprovalSteps(db *gorm.DB, tenantID string, deal *models.Deal, approval *models.DealApproval) ([]ValidationError, error) {
+	requester := ""
+	if approval.RequestedBy != nil {
+		requester = *approval.RequestedBy
+	}
+	var validationErrors []ValidationError
+	for i, step := range approval.Steps {
+		field := fmt.Sprintf("steps[%d]", i)
+		switch step.Type {
+		case "user":
+			if step.UserID != nil && *step.UserID == requester {
+				validationErrors = append(validationErrors, ValidationError{Field: field, Code: "requester_approver", Message: "You cannot approve your own discount"})
+			}
+		case "role":
+			if step.RoleID == nil {
+				continue
+			}
+			var approvers int64
+			if err := db.Model(&models.User{}).
+				Where("tenant_id = ? AND role_id = ? AND is_active = ? AND id <> ?", tenantID, *step.RoleID, true, requester).
+				Count(&approvers).Error; err != nil {
+				return nil, err
+			}
+			if approvers == 0 {
+				validationErrors = append(validationErrors, ValidationError{Field: field, Code: "no_approver", Message: "Nobody else holds the role that approves the discount"})
+			}
+		case "manager":
+			manager, err := ownerManager(db, deal, approval)
+			if err != nil {
+				return nil, err
+			}
+			if manager == nil {
+				validationErrors = append(validationErrors, ValidationError{Field: field, Code: "no_manager", Message: "The deal owner has no manager to approve the discount"})
+			} else if *manager == requester {
+				validationErrors = append(validationErrors, ValidationError{Field: field, Code: "requester_approver", Message: "You cannot approve your own discount as the deal owner's manager"})
+			}
+		}
+	}
+	return validationErrors, nil
+}
+
+// ownerManager returns the manager of the deal owner, or of the requester
+// when the deal is unassigned
+func ownerManager(db *gorm.DB, deal *models.Deal, approval *models.DealApproval) (*string, error) {
+	ownerID := deal.AssignedUserID
+	if ownerID == nil {
+		ownerID = approval.RequestedBy
+	}
+	if ownerID == nil {
+		return nil, nil
+	}
+	var owner models.User
+	if err := db.Select("manager_id").First(&owner, "id = ?", *ownerID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return owner.ManagerID, nil
+}
+
+// hasPendingApproval reports whether the deal is waiting on a discount
+// approval
+func hasPendingApproval(db *gorm.DB, dealID string) (bool, error) {
+	var count int64
+	if err := db.Model(&models.DealApproval{}).
+		Where("deal_id = ? AND status = ?", dealID, ApprovalPending).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// errApprovalPending aborts a deal write that finds a pending approval once
+// the deal is locked
+var errApprovalPending = errors.New("deal approval pending")
+
+// errApprovalStale aborts an approval request whose lines changed while it
+// was being prepared
+var errApprovalStale = errors.New("deal line items changed")
+
+// lockDealWithoutApproval locks the deal row for the rest of the transaction
+// and fails with errApprovalPending when the deal is waiting on an approval.
+// Line item changes, closing as won and approval requests all take the lock,
+// so none of them can slip in between another's check and write.
+func lockDealWithoutApproval(tx *gorm.DB, dealID string) error {
+	var deal models.Deal
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&deal, "id = ?", dealID).Error; err != nil {
+		return err
+	}
+	pending, err := hasPendingApproval(tx, dealID)
+	if err != nil {
+		return err
+	}
+	if pending {
+		return errApprovalPending
+	}
+	return nil
+}
+
+// pendingApprovalError is returned for edits blocked by a pending approval
+var pendingApprovalError = ValidationError{
+	Field:   "lineItems",
+	Code:    "approval_pending",
+	Message: "The deal's discounts are awaiting approval and cannot be changed",
+}
+
+// checkDiscountApproval returns an error when the lines need approval under
+// the tenant's rules and the deal has no approval covering exactly these
+// lines
+func checkDiscountApproval(db *gorm.DB, tenantID, dealID string, lines []pricedLine) ([]ValidationError, error) {
+	rules, err := matchingApprovalRules(db, tenantID, lines)
+	if err != nil || len(rules) == 0 {
+		return nil, err
+	}
+
+	var approvals []models.DealApproval
+	if err := db.Where("deal_id = ? AND status IN ?", dealID, []string{ApprovalPending, ApprovalApproved}).
+		Find(&approvals).Error; err != nil {
+		return nil, err
+	}
+	fingerprint := lineFingerprint(lines)
+	for _, approval := range approvals {
+		if approval.Status == ApprovalPending {
+			return []ValidationError{pendingApprovalError}, nil
+		}
+		if approval.Fingerprint == fingerprint {
+			return nil, nil
+		}
+	}
+	return []ValidationError{{Field: "lineItems", Code: "approval_required", Message: "The discounts on this deal need approval"}}, nil
+}
+
+// matchingApprovalRules returns the tenant's active rules the lines meet, in
+// rule order
+func matchingApprovalRules(db *gorm.DB, tenantID string, lines []pricedLine) ([]models.ApprovalRule, error) {
+	if len(lines) == 0 {
+		return nil, nil
+	}
+	var rules []models.ApprovalRule
+	if err := db.Where("tenant_id = ? AND is_active = ?", tenantID, true).
+		Order("\"order\" ASC, name ASC").
+		Find(&rules).Error; err != nil {
+		return nil, err
+	}
+
+	var matched []models.ApprovalRule
+	for _, rule := range rules {
+		percent, amount := discountSummary(lines, rule.ProductFamily)
+		if rule.ProductFamily != nil && !hasFamily(lines, *rule.ProductFamily) {
+			continue
+		}
+		if rule.MinDiscountPercent == nil && rule.MinDiscountAmount == nil && percent <= 0 {
+			continue // a family-only rule applies to any discount on the family
+		}
+		if rule.MinDiscountPercent != nil && percent < *rule.MinDiscountPercent {
+			continue
+		}
+		if rule.MinDiscountAmount != nil && amount < *rule.MinDiscountAmount {
+			continue
+		}
+		matched = append(matched, rule)
+	}
+	return matched, nil
+}
+
+// discountSummary returns the largest discount percentage and the total
+// discount amount over the lines, limited to a product family when set. The
+// discount is measured against the list price, so a unit price cut below list
+// counts the same as a discount percentage.
+func discountSummary(lines []pricedLine, family *string) (float64, float64) {
+	var percent, amount float64
+	for _, line := range lines {
+		if family != nil && (line.Family == nil || !strings.EqualFold(*line.Family, *family)) {
+			continue
+		}
+		list := line.Quantity * line.ListPrice
+		if list <= 0 || line.TotalPrice >= list {
+			continue
+		}
+		if p := (1 - line.TotalPrice/list) * 100; p > percent {
+			percent = p
+		}
+		amount += list - line.TotalPrice
+	}
+	return math.Round(percent*100) / 100, lineItemTotal(1, amount, 0)
+}
+
+func hasFamily(lines []pricedLine, family string) bool {
+	for _, line := range lines {
+		if line.Family != nil && strings.EqualFold(*line.Family, family) {
+			return true
+		}
+	}
+	return false
+}
+
+// lineFingerprint identifies a set of priced lines regardless of order, so an
+// approval only covers the exact prices it was given
+func lineFingerprint(lines []pricedLine) string {
+	keys := make([]string, len(lines))
+	for i, line := range lines {
+		keys[i] = fmt.Sprintf("%s|%g|%.2f|%.2f|%g", line.ProductID, line.Quantity, line.ListPrice, line.UnitPrice, line.DiscountPercent)
+	}
+	sort.Strings(keys)
+	sum := sha256.Sum256([]byte(strings.Join(keys, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// dealPricedLines loads a deal's line items for the approval rules
+func dealPricedLines(db *gorm.DB, deal *models.Deal) ([]pricedLine, error) {
+	items, err := loadDealLineItems(db, []string{deal.ID})
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]pricedLine, 0, len(items[deal.ID]))
+	for _, item := range items[deal.ID] {
+		line := pricedLine{
+			ProductID:       item.ProductID,
+			Quantity:        item.Quantity,
+			UnitPrice:       item.UnitPrice,
+			DiscountPercent: item.DiscountPercent,
+			TotalPrice:      item.TotalPrice,
+		}
+		if item.Product != nil {
+			line.Family = item.Product.Family
+		}
+		lines = append(lines, line)
+	}
+	if err := setListPrices(db, deal.TenantID, deal.PriceBookID, deal.Currency, lines); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// quotePricedLines converts a quote's lines for the approval rules
+func quotePricedLines(db *gorm.DB, quote *models.Quote) ([]pricedLine, error) {
+	var productIDs []string
+	for _, line := range quote.Lines {
+		if line.ProductID != nil {
+			productIDs = append(productIDs, *line.ProductID)
+		}
+	}
+	families := map[string]*string{}
+	if len(productIDs) > 0 {
+		var products []models.Product
+		if err := db.Select("id", "family").Where("id IN ?", productIDs).Find(&products).Error; err != nil {
+			return nil, err
+		}
+		for _, product := range products {
+			families[product.ID] = product.Family
+		}
+	}
+	var deal models.Deal
+	if err := db.Select("id", "price_book_id").First(&deal, "id = ?", quote.DealID).Error; err != nil {
+		return nil, err
+	}
+
+	lines := make([]pricedLine, 0, len(quote.Lines))
+	for _, line := range quote.Lines {
+		priced := pricedLine{
+			Quantity:        line.Quantity,
+			UnitPrice:       line.UnitPrice,
+			DiscountPercent: line.DiscountPercent,
+			TotalPrice:      line.TotalPrice,
+		}
+		if line.ProductID != nil {
+			priced.ProductID = *line.ProductID
+			priced.Family = families[*line.ProductID]
+		}
+		lines = append(lines, priced)
+	}
+	if err := setListPrices(db, quote.TenantID, deal.PriceBookID, quote.Currency, lines); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// setListPrices looks up each line's list price in the price book. Lines
+// without a product or a list price fall back to their unit price, so only
+// their discount percentage counts.
+func setListPrices(db *gorm.DB, tenantID string, priceBookID *string, code string, lines []pricedLine) error {
+	for i := range lines {
+		lines[i].ListPrice = lines[i].UnitPrice
+		if lines[i].ProductID == "" {
+			continue
+		}
+		price, err := listPrice(db, tenantID, priceBookID, lines[i].ProductID, code)
+		if err != nil {
+			return err
+		}
+		if price != nil {
+			lines[i].ListPrice = *price
+		}
+	}
+	return nil
+}
@@ -0,0 +1,473 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/assignment"
+	"finhub-backend/models"
+)
+
+// AssignmentHandler manages lead assignment rules, the user groups they
+// round-robin between and the log of assignment decisions
+type AssignmentHandler struct {
+	db *gorm.DB
+}
+
+type AssignmentRuleRequest struct {
+	Name         string                       `json:"name" binding:"required"`
+	Order        int                          `json:"order"`
+	IsActive     *bool                        `json:"isActive"`
+	Conditions   []models.AssignmentCondition `json:"conditions"`
+	Method       string                       `json:"method" binding:"required,oneof=user round_robin territory"`
+	UserID       *string                      `json:"userId"`
+	GroupID      *string                      `json:"groupId"`
+	TerritoryID  *string                      `json:"territoryId"`
+	MaxOpenLeads *int                         `json:"maxOpenLeads" binding:"omitempty,min=1"`
+}
+
+type UserGroupMemberRequest struct {
+	UserID       string `json:"userId" binding:"required"`
+	Weight       *int   `json:"weight" binding:"omitempty,min=0"`
+	MaxOpenLeads *int   `json:"maxOpenLeads" binding:"omitempty,min=1"`
+}
+
+type UserGroupRequest struct {
+	Name        string                   `json:"name" binding:"required"`
+	Description *string                  `json:"description"`
+	IsActive    *bool                    `json:"isActive"`
+	Members     []UserGroupMemberRequest `json:"members" binding:"dive"`
+}
+
+func NewAssignmentHandler(db *gorm.DB) *AssignmentHandler {
+	return &AssignmentHandler{db: db}
+}
+
+func (h *AssignmentHandler) GetRules(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var rules []models.AssignmentRule
+	if err := h.db.Where("tenant_id = ?", user.TenantID).
+		Order("\"order\" ASC, name ASC").
+		Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch assignment rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+func (h *AssignmentHandler) CreateRule(c *gin.Context) {
+	h.saveRule(c, "")
+}
+
+func (h *AssignmentHandler) UpdateRule(c *gin.Context) {
+	h.saveRule(c, c.Param("id"))
+}
+
+// saveRule creates a rule, or replaces the one with the given ID. Only
+// administrators may change assignment rules.
+func (h *AssignmentHandler) saveRule(c *gin.Context, ruleID string) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req AssignmentRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var validationErrors []ValidationError
+	for i, condition := range req.Conditions {
+		field := fmt.Sprintf("conditions[%d]", i)
+		if !assignment.ValidField(condition.Field) {
+			validationErrors = append(validationErrors, ValidationError{Field: field + ".field", Code: "invalid_field", Message: fmt.Sprintf("Unknown field %q", condition.Field)})
+		}
+		switch condition.Operator {
+		case assignment.OperatorIn, assignment.OperatorNotIn, assignment.OperatorContains:
+		default:
+			validationErrors = append(validationErrors, ValidationError{Field: field + ".operator", Code: "invalid_operator", Message: "Operator must be in, not_in or contains"})
+		}
+		if len(condition.Values) == 0 {
+			validationErrors = append(validationErrors, ValidationError{Field: field + ".values", Code: "required", Message: "Set at least one value"})
+		}
+	}
+	var count int64
+	switch req.Method {
+	case assignment.MethodUser:
+		if req.UserID != nil {
+			h.db.Model(&models.User{}).Where("id = ? AND tenant_id = ?", *req.UserID, user.TenantID).Count(&count)
+		}
+		if count == 0 {
+			validationErrors = append(validationErrors, ValidationError{Field: "userId", Code: "invalid_user", Message: "User not found"})
+		}
+	case assignment.MethodRoundRobin:
+		if req.GroupID != nil {
+			h.db.Model(&models.UserGroup{}).Where("id = ? AND tenant_id = ?", *req.GroupID, user.TenantID).Count(&count)
+		}
+		if count == 0 {
+			validationErrors = append(validationErrors, ValidationError{Field: "groupId", Code: "invalid_group", Message: "User group not found"})
+		}
+	case assignment.MethodTerritory:
+		// Without a territory the lead's company territory is used
+		if req.TerritoryID != nil {
+			h.db.Model(&models.Territory{}).Where("id = ? AND tenant_id = ?", *req.TerritoryID, user.TenantID).Count(&count)
+		}
+		if req.TerritoryID != nil && count == 0 {
+			validationErrors = append(validationErrors, ValidationError{Field: "territoryId", Code: "invalid_territory", Message: "Territory not found"})
+		}
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	rule := models.AssignmentRule{TenantID: user.TenantID, IsActive: true}
+	status := http.StatusCreated
+	if ruleID != "" {
+		if err := h.db.Where("id = ? AND tenant_id = ?", ruleID, user.TenantID).First(&rule).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Assignment rule not found"})
+			return
+		}
+		status = http.StatusOK
+	}
+	rule.Name = req.Name
+	rule.Order = req.Order
+	if req.IsActive != nil {
+		rule.IsActive = *req.IsActive
+	}
+	rule.Conditions = req.Conditions
+	if rule.Conditions == nil {
+		rule.Conditions = []models.AssignmentCondition{}
+	}
+	rule.Method = req.Method
+	rule.UserID, rule.GroupID, rule.TerritoryID = nil, nil, nil
+	switch req.Method {
+	case assignment.MethodUser:
+		rule.UserID = req.UserID
+	case assignment.MethodRoundRobin:
+		rule.GroupID = req.GroupID
+	case assignment.MethodTerritory:
+		rule.TerritoryID = req.TerritoryID
+	}
+	rule.MaxOpenLeads = req.MaxOpenLeads
+
+	if err := h.db.WithContext(c).Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save assignment rule"})
+		return
+	}
+
+	c.JSON(status, rule)
+}
+
+func (h *AssignmentHandler) DeleteRule(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	result := h.db.WithContext(c).Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).Delete(&models.AssignmentRule{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete assignment rule"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Assignment rule not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Assignment rule deleted successfully"})
+}
+
+func (h *AssignmentHandler) GetUserGroups(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var groups []models.UserGroup
+	if err := h.db.Preload("Members", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC") }).
+		Preload("Members.User").
+		Where("tenant_id = ?", user.TenantID).
+		Order("name ASC").
+		Find(&groups).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch user groups"})
+		return
+	}
+
+	c.JSON(http.StatusOK, groups)
+}
+
+func (h *AssignmentHandler) CreateUserGroup(c *gin.Context) {
+	h.saveUserGroup(c, "")
+}
+
+func (h *AssignmentHandler) UpdateUserGroup(c *gin.Context) {
+	h.saveUserGroup(c, c.Param("id"))
+}
+
+// saveUserGroup creates a group, or replaces the one with the given ID along
+// with its members. Members that stay keep their assignment counts; new
+// members start level with the member furthest behind, so they don't
+// receive every lead until they catch up.
+func (h *AssignmentHandler) saveUserGroup(c *gin.Context, groupID string) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req UserGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var validationErrors []ValidationError
+	seen := map[string]bool{}
+	for i, member := range req.Members {
+		field := fmt.Sprintf("members[%d].userId", i)
+		if seen[member.UserID] {
+			validationErrors = append(validationErrors, ValidationError{Field: field, Code: "duplicate", Message: "User is listed twice"})
+		}
+		seen[member.UserID] = true
+		var count int64
+		h.db.Model(&models.User{}).Where("id = ? AND tenant_id = ?", member.UserID, user.TenantID).Count(&count)
+		if count == 0 {
+			validationErrors = append(validationErrors, ValidationError{Field: field, Code: "invalid_user", Message: "User not found"})
+		}
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	group := models.UserGroup{TenantID: user.TenantID, IsActive: true}
+	status := http.StatusCreated
+	if groupID != "" {
+		if err := h.db.Where("id = ? AND tenant_id = ?", groupID, user.TenantID).First(&group).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User group not found"})
+			return
+		}
+		status = http.StatusOK
+	}
+	group.Name = req.Name
+	group.Description = req.Description
+	if req.IsActive != nil {
+		group.IsActive = *req.IsActive
+	}
+
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Members").Save(&group).Error; err != nil {
+			return err
+		}
+
+		var existing []models.UserGroupMember
+		if err := tx.Where("group_id = ?", group.ID).Find(&existing).Error; err != nil {
+			return err
+		}
+		current := map[string]models.UserGroupMember{}
+		for _, member := range existing {
+			current[member.UserID] = member
+		}
+
+		// The lowest assigned share among members that stay, as count/weight
+		startCount, startWeight := 0, 0
+		for _, member := range existing {
+			if seen[member.UserID] && member.Weight > 0 &&
+				(startWeight == 0 || member.AssignedCount*startWeight < startCount*member.Weight) {
+				startCount, startWeight = member.AssignedCount, member.Weight
+			}
+		}
+
+		for _, memberReq := range req.Members {
+			member, ok := current[memberReq.UserID]
+			if !ok {
+				member = models.UserGroupMember{GroupID: group.ID, UserID: memberReq.UserID, Weight: 1}
+			}
+			if memberReq.Weight != nil {
+				member.Weight = *memberReq.Weight
+			}
+			member.MaxOpenLeads = memberReq.MaxOpenLeads
+			if !ok && startWeight > 0 {
+				member.AssignedCount = startCount * member.Weight / startWeight
+			}
+			if err := tx.Save(&member).Error; err != nil {
+				return err
+			}
+		}
+		for _, member := range existing {
+			if !seen[member.UserID] {
+				if err := tx.Delete(&member).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save user group"})
+		return
+	}
+
+	h.db.Preload("Members", func(db *gorm.DB) *gorm.DB { return db.Order("created_at ASC") }).
+		Preload("Members.User").
+		First(&group, "id = ?", group.ID)
+	c.JSON(status, group)
+}
+
+// DeleteUserGroup deletes a group that no assignment rule uses
+func (h *AssignmentHandler) DeleteUserGroup(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var group models.UserGroup
+	if err := h.db.Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).First(&group).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User group not found"})
+		return
+	}
+
+	var rules int64
+	h.db.Model(&models.AssignmentRule{}).Where("group_id = ?", group.ID).Count(&rules)
+	if rules > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "User group is used by assignment rules"})
+		return
+	}
+
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("group_id = ?", group.ID).Delete(&models.UserGroupMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&group).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user group"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User group deleted successfully"})
+}
+
+// GetLogs lists assignment decisions, newest first, optionally for one
+// lead, rule, user or outcome
+func (h *AssignmentHandler) GetLogs(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	if err != nil || pageSize < 1 || pageSize > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pageSize"})
+		return
+	}
+
+	query := h.db.Model(&models.AssignmentLog{}).Where("tenant_id = ?", user.TenantID)
+	for param, column := range map[string]string{"leadId": "lead_id", "ruleId": "rule_id", "userId": "user_id", "outcome": "outcome"} {
+		if value := c.Query(param); value != "" {
+			query = query.Where(column+" = ?", value)
+		}
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch assignment log"})
+		return
+	}
+	var logs []models.AssignmentLog
+	if err := query.Order("created_at DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&logs).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch assignment log"})
+		return
+	}
+
+	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
+	c.JSON(http.StatusOK, gin.H{
+		"entries":    logs,
+		"totalCount": total,
+		"page":       page,
+		"pageSize":   pageSize,
+		"totalPages": totalPages,
+		"hasMore":    page < totalPages,
+	})
+}
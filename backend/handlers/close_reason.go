@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/models"
+)
+
+// CloseReasonHandler manages the tenant's win and loss reasons
+type CloseReasonHandler struct {
+	db *gorm.DB
+}
+
+type CreateCloseReasonRequest struct {
+	Name        string  `json:"name" binding:"required"`
+	Code        string  `json:"code" binding:"required"`
+	Outcome     string  `json:"outcome" binding:"required,oneof=won lost"`
+	Description *string `json:"description"`
+	Order       int     `json:"order"`
+}
+
+type UpdateCloseReasonRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Order       *int    `json:"order"`
+	IsActive    *bool   `json:"isActive"`
+}
+
+func NewCloseReasonHandler(db *gorm.DB) *CloseReasonHandler {
+	return &CloseReasonHandler{db: db}
+}
+
+// GetCloseReasons lists active close reasons, optionally filtered by ?outcome=won|lost.
+// Inactive reasons are included with ?includeInactive=true.
+func (h *CloseReasonHandler) GetCloseReasons(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	query := h.db.Where("tenant_id = ?", user.TenantID)
+	if outcome := c.Query("outcome"); outcome != "" {
+		query = query.Where("outcome = ?", outcome)
+	}
+	if c.Query("includeInactive") != "true" {
+		query = query.Where("is_active = ?", true)
+	}
+
+	var reasons []models.DealCloseReason
+	if err := query.Order("outcome ASC, \"order\" ASC, name ASC").Find(&reasons).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch close reasons"})
+		return
+	}
+
+	c.JSON(http.StatusOK, reasons)
+}
+
+func (h *CloseReasonHandler) CreateCloseReason(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CreateCloseReasonRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var count int64
+	h.db.Model(&models.DealCloseReason{}).Where("tenant_id = ? AND code = ?", user.TenantID, req.Code).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A close reason with this code already exists"})
+		return
+	}
+
+	reason := models.DealCloseReason{
+		Name:        req.Name,
+		Code:        req.Code,
+		Outcome:     req.Outcome,
+		Description: req.Description,
+		Order:       req.Order,
+		IsActive:    true,
+		TenantID:    user.TenantID,
+	}
+
+	if err := h.db.WithContext(c).Create(&reason).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create close reason"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, reason)
+}
+
+func (h *CloseReasonHandler) UpdateCloseReason(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	reasonID := c.Param("id")
+	var req UpdateCloseReasonRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var reason models.DealCloseReason
+	if err := h.db.Where("id = ? AND tenant_id = ?", reasonID, user.TenantID).First(&reason).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Close reason not found"})
+		return
+	}
+
+	// Update fields
+	if req.Name != nil {
+		reason.Name = *req.Name
+	}
+	if req.Description != nil {
+		reason.Description = req.Description
+	}
+	if req.Order != nil {
+		reason.Order = *req.Order
+	}
+	if req.IsActive != nil {
+		reason.IsActive = *req.IsActive
+	}
+
+	if err := h.db.WithContext(c).Save(&reason).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update close reason"})
+		return
+	}
+
+	c.JSON(http.StatusOK, reason)
+}
+
+// DeleteCloseReason deactivates a close reason. It stays on the deals that
+// already use it so that reporting is unaffected.
+func (h *CloseReasonHandler) DeleteCloseReason(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	reasonID := c.Param("id")
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var reason models.DealCloseReason
+	if err := h.db.Where("id = ? AND tenant_id = ?", reasonID, user.TenantID).First(&reason).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Close reason not found"})
+		return
+	}
+
+	reason.IsActive = false
+	if err := h.db.WithContext(c).Save(&reason).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete close reason"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Close reason deactivated successfully"})
+}
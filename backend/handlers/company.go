@@ -2,11 +2,16 @@ package handlers
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
+	"finhub-backend/audit"
+	"finhub-backend/domains"
+	"finhub-backend/duplicates"
 	"finhub-backend/models"
+	"finhub-backend/scoring"
 )
 
 type CompanyHandler struct {
@@ -20,6 +25,10 @@ type CreateCompanyRequest struct {
 	IndustryID *string  `json:"industryId"`
 	SizeID     *string  `json:"sizeId"`
 	Revenue    *float64 `json:"revenue"`
+	ParentID   *string  `json:"parentId"`
+
+	Emails []string `json:"emails" binding:"dive,email"`
+	Phones []string `json:"phones" binding:"dive,required"`
 }
 
 type UpdateCompanyRequest struct {
@@ -29,6 +38,15 @@ type UpdateCompanyRequest struct {
 	IndustryID *string  `json:"industryId"`
 	SizeID     *string  `json:"sizeId"`
 	Revenue    *float64 `json:"revenue"`
+	// An empty ID detaches the company from its parent
+	ParentID *string `json:"parentId"`
+}
+
+// CompanyResponse is a saved company with the potential duplicates warn
+// rules found
+type CompanyResponse struct {
+	models.Company
+	Duplicates []duplicates.Match `json:"duplicates,omitempty"`
 }
 
 func NewCompanyHandler(db *gorm.DB) *CompanyHandler {
@@ -80,24 +98,85 @@ func (h *CompanyHandler) CreateCompany(c *gin.Context) {
 		return
 	}
 
+	var validationErrors []ValidationError
+	if req.ParentID != nil {
+		parentErrors, err := validateCompanyParent(h.db, user.TenantID, "", *req.ParentID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate parent company"})
+			return
+		}
+		validationErrors = append(validationErrors, parentErrors...)
+	}
+	// The domain is normalized, or derived from the website
+	var domain *string
+	if req.Domain != nil && strings.TrimSpace(*req.Domain) != "" {
+		normalized := domains.Normalize(*req.Domain)
+		if normalized == "" {
+			validationErrors = append(validationErrors, ValidationError{Field: "domain", Code: "invalid_domain", Message: "Enter a domain such as example.com"})
+		}
+		domain = &normalized
+	} else if req.Website != nil {
+		if derived := domains.Normalize(*req.Website); derived != "" {
+			domain = &derived
+		}
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+	if domain != nil {
+		taken, err := domains.Taken(h.db, user.TenantID, *domain, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check domain"})
+			return
+		}
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{"error": "A company with this domain already exists"})
+			return
+		}
+	}
+
 	userIDStr := userID.(string)
 	company := models.Company{
 		Name:       req.Name,
 		Website:    req.Website,
-		Domain:     req.Domain,
+		Domain:     domain,
 		IndustryID: req.IndustryID,
 		SizeID:     req.SizeID,
 		Revenue:    req.Revenue,
+		ParentID:   req.ParentID,
 		TenantID:   user.TenantID,
 		CreatedBy:  &userIDStr,
 	}
 
-	if err := h.db.Create(&company).Error; err != nil {
+	matches, ok := checkDuplicates(c, h.db, duplicates.Record{
+		EntityType: duplicates.EntityCompany,
+		TenantID:   user.TenantID,
+		Name:       company.Name,
+		Website:    deref(company.Website),
+		Domain:     deref(company.Domain),
+		Emails:     req.Emails,
+		Phones:     req.Phones,
+	})
+	if !ok {
+		return
+	}
+
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&company).Error; err != nil {
+			return err
+		}
+		return saveContactPoints(tx, "company", company.ID, user.TenantID, req.Emails, req.Phones)
+	}); err != nil {
+		if domains.IsConflict(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A company with this domain already exists"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create company"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, company)
+	c.JSON(http.StatusCreated, CompanyResponse{Company: company, Duplicates: matches})
 }
 
 func (h *CompanyHandler) GetCompany(c *gin.Context) {
@@ -159,12 +238,41 @@ func (h *CompanyHandler) UpdateCompany(c *gin.Context) {
 	if req.Name != nil {
 		company.Name = *req.Name
 	}
+	// The domain is normalized; one derived from the website follows it
+	domain := company.Domain
+	if req.Domain != nil {
+		company.Domain = nil
+		if strings.TrimSpace(*req.Domain) != "" {
+			normalized := domains.Normalize(*req.Domain)
+			if normalized == "" {
+				respondValidationErrors(c, []ValidationError{{Field: "domain", Code: "invalid_domain", Message: "Enter a domain such as example.com"}})
+				return
+			}
+			company.Domain = &normalized
+		}
+	} else if req.Website != nil && (company.Domain == nil || *company.Domain == domains.Normalize(deref(company.Website))) {
+		company.Domain = nil
+		if derived := domains.Normalize(*req.Website); derived != "" {
+			company.Domain = &derived
+		}
+	}
+	if company.Domain != nil && !equalStringPtr(company.Domain, domain) {
+		taken, err := domains.Taken(h.db, user.TenantID, *company.Domain, company.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check domain"})
+			return
+		}
+		if taken {
+			c.JSON(http.StatusConflict, gin.H{"error": "A company with this domain already exists"})
+			return
+		}
+	}
 	if req.Website != nil {
 		company.Website = req.Website
 	}
-	if req.Domain != nil {
-		company.Domain = req.Domain
-	}
+	// Lead scoring rules match on the company's industry and size
+	rescore := (req.IndustryID != nil && !equalStringPtr(company.IndustryID, req.IndustryID)) ||
+		(req.SizeID != nil && !equalStringPtr(company.SizeID, req.SizeID))
 	if req.IndustryID != nil {
 		company.IndustryID = req.IndustryID
 	}
@@ -174,13 +282,52 @@ func (h *CompanyHandler) UpdateCompany(c *gin.Context) {
 	if req.Revenue != nil {
 		company.Revenue = req.Revenue
 	}
+	if req.ParentID != nil {
+		company.ParentID = nil
+		if *req.ParentID != "" {
+			validationErrors, err := validateCompanyParent(h.db, user.TenantID, company.ID, *req.ParentID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate parent company"})
+				return
+			}
+			if len(validationErrors) > 0 {
+				respondValidationErrors(c, validationErrors)
+				return
+			}
+			company.ParentID = req.ParentID
+		}
+	}
 
-	if err := h.db.Save(&company).Error; err != nil {
+	var matches []duplicates.Match
+	if req.Name != nil || req.Website != nil || req.Domain != nil {
+		record, err := duplicates.Load(h.db, duplicates.EntityCompany, company.ID, user.TenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check duplicates"})
+			return
+		}
+		record.Name, record.Website, record.Domain = company.Name, deref(company.Website), deref(company.Domain)
+		var ok bool
+		if matches, ok = checkDuplicates(c, h.db, record); !ok {
+			return
+		}
+	}
+
+	if err := h.db.WithContext(c).Save(&company).Error; err != nil {
+		if domains.IsConflict(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A company with this domain already exists"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update company"})
 		return
 	}
+	if rescore {
+		if err := scoring.ScoreCompanyLeads(h.db.WithContext(c), company.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rescore company leads"})
+			return
+		}
+	}
 
-	c.JSON(http.StatusOK, company)
+	c.JSON(http.StatusOK, CompanyResponse{Company: company, Duplicates: matches})
 }
 
 func (h *CompanyHandler) DeleteCompany(c *gin.Context) {
@@ -206,9 +353,19 @@ func (h *CompanyHandler) DeleteCompany(c *gin.Context) {
 		return
 	}
 
+	var subsidiaries int64
+	if err := h.db.Model(&models.Company{}).Where("parent_id = ? AND is_deleted = ?", company.ID, false).Count(&subsidiaries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete company"})
+		return
+	}
+	if subsidiaries > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Company has subsidiaries"})
+		return
+	}
+
 	// Soft delete
 	company.IsDeleted = true
-	if err := h.db.Save(&company).Error; err != nil {
+	if err := h.db.WithContext(c).Set(audit.ActionKey, audit.ActionDelete).Save(&company).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete company"})
 		return
 	}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/currency"
+	"finhub-backend/models"
+)
+
+// companySubtreeSQL selects the IDs of a company and all its live
+// subsidiaries, at any depth
+const companySubtreeSQL = `WITH RECURSIVE subtree AS (
+	SELECT id FROM companies WHERE id = ?
+	UNION
+	SELECT companies.id FROM companies JOIN subtree ON companies.parent_id = subtree.id
+	WHERE companies.is_deleted = false
+) SELECT id FROM subtree`
+
+// CompanyHierarchyNode is a company in a hierarchy with its own figures and
+// the rollups over it and all its subsidiaries
+type CompanyHierarchyNode struct {
+	ID             string                  `json:"id"`
+	Name           string                  `json:"name"`
+	ParentID       *string                 `json:"parentId"`
+	Revenue        *float64                `json:"revenue"`
+	OpenDealAmount float64                 `json:"openDealAmount"`
+	ContactCount   int64                   `json:"contactCount"`
+	Rollup         CompanyRollup           `json:"rollup"`
+	Children       []*CompanyHierarchyNode `json:"children"`
+}
+
+// CompanyRollup sums figures over a company and its subsidiaries. Deal
+// amounts are in the tenant's reporting currency; deals in currencies without
+// a rate are left out.
+type CompanyRollup struct {
+	CompanyCount   int     `json:"companyCount"`
+	Revenue        float64 `json:"revenue"`
+	OpenDealAmount float64 `json:"openDealAmount"`
+	ContactCount   int64   `json:"contactCount"`
+}
+
+// GetCompanyHierarchy returns the whole hierarchy a company belongs to,
+// from its topmost parent down, with rollups at every level
+func (h *CompanyHandler) GetCompanyHierarchy(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var company models.Company
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", c.Param("id"), user.TenantID, false).
+		First(&company).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Company not found"})
+		return
+	}
+
+	ancestors, err := companyAncestorIDs(h.db, company.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch company hierarchy"})
+		return
+	}
+	rootID := company.ID
+	if len(ancestors) > 0 {
+		rootID = ancestors[len(ancestors)-1]
+	}
+
+	var companies []models.Company
+	if err := h.db.Select("id", "name", "parent_id", "revenue").
+		Where("tenant_id = ? AND id IN ("+companySubtreeSQL+")", user.TenantID, rootID).
+		Find(&companies).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch company hierarchy"})
+		return
+	}
+	ids := make([]string, len(companies))
+	nodes := make(map[string]*CompanyHierarchyNode, len(companies))
+	for i, company := range companies {
+		ids[i] = company.ID
+		nodes[company.ID] = &CompanyHierarchyNode{
+			ID:       company.ID,
+			Name:     company.Name,
+			ParentID: company.ParentID,
+			Revenue:  company.Revenue,
+			Children: []*CompanyHierarchyNode{},
+		}
+	}
+
+	var contactCounts []struct {
+		CompanyID string
+		Count     int64
+	}
+	if err := h.db.Model(&models.Contact{}).
+		Select("company_id, COUNT(*) as count").
+		Where("company_id IN ? AND is_deleted = ?", ids, false).
+		Group("company_id").
+		Scan(&contactCounts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count contacts"})
+		return
+	}
+	for _, count := range contactCounts {
+		nodes[count.CompanyID].ContactCount = count.Count
+	}
+
+	var dealTotals []struct {
+		CompanyID string
+		Currency  string
+		Amount    float64
+	}
+	if err := h.db.Model(&models.Deal{}).
+		Select("deals.company_id, deals.currency, COALESCE(SUM(deals.amount), 0) as amount").
+		Joins("JOIN stages ON stages.id = deals.stage_id").
+		Where("deals.company_id IN ? AND deals.is_deleted = ?", ids, false).
+		Where("stages.is_closed_won = ? AND stages.is_closed_lost = ?", false, false).
+		Group("deals.company_id, deals.currency").
+		Scan(&dealTotals).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute deal totals"})
+		return
+	}
+	converter, err := currency.NewConverter(h.db, user.TenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load exchange rates"})
+		return
+	}
+	now := time.Now()
+	unconverted := currency.Unconverted{}
+	for _, total := range dealTotals {
+		if amount, ok := converter.Convert(total.Amount, total.Currency, now); ok {
+			nodes[total.CompanyID].OpenDealAmount += amount
+		} else {
+			unconverted[total.Currency] = true
+		}
+	}
+
+	for _, node := range nodes {
+		if node.ID == rootID || node.ParentID == nil {
+			continue
+		}
+		if parent, ok := nodes[*node.ParentID]; ok {
+			parent.Children = append(parent.Children, node)
+		}
+	}
+	root := nodes[rootID]
+	rollUp(root)
+
+	c.JSON(http.StatusOK, gin.H{
+		"companyId":             company.ID,
+		"root":                  root,
+		"reportingCurrency":     converter.Target,
+		"unconvertedCurrencies": unconverted.List(),
+	})
+}
+
+// rollUp fills in the rollups of a node and its subsidiaries
+func rollUp(node *CompanyHierarchyNode) CompanyRollup {
+	sort.Slice(node.Children, func(i, j int) bool { return node.Children[i].Name < node.Children[j].Name })
+	node.OpenDealAmount = math.Round(node.OpenDealAmount*100) / 100
+	rollup := CompanyRollup{CompanyCount: 1, OpenDealAmount: node.OpenDealAmount, ContactCount: node.ContactCount}
+	if node.Revenue != nil {
+		rollup.Revenue = *node.Revenue
+	}
+	for _, child := range node.Children {
+		sub := rollUp(child)
+		rollup.CompanyCount += sub.CompanyCount
+		rollup.Revenue += sub.Revenue
+		rollup.OpenDealAmount += sub.OpenDealAmount
+		rollup.ContactCount += sub.ContactCount
+	}
+	rollup.OpenDealAmount = math.Round(rollup.OpenDealAmount*100) / 100
+	node.Rollup = rollup
+	return rollup
+}
+
+// companyAncestorIDs returns the parents of a company up to the top of its
+// hierarchy, nearest first
+func companyAncestorIDs(db *gorm.DB, companyID string) ([]string, error) {
+	var ancestors []string
+	seen := map[string]bool{companyID: true}
+	for id := companyID; ; {
+		var company models.Company
+		if err := db.Select("parent_id").Where("id = ?", id).Limit(1).Find(&company).Error; err != nil {
+			return nil, err
+		}
+		if company.ParentID == nil || seen[*company.ParentID] {
+			return ancestors, nil
+		}
+		id = *company.ParentID
+		seen[id] = true
+		ancestors = append(ancestors, id)
+	}
+}
+
+// validateCompanyParent checks that parentID is another live company of the
+// tenant and not one of companyID's subsidiaries, so the hierarchy cannot
+// loop. companyID is empty for new companies.
+func validateCompanyParent(db *gorm.DB, tenantID, companyID, parentID string) ([]ValidationError, error) {
+	if parentID == companyID {
+		return []ValidationError{{Field: "parentId", Code: "invalid_parent", Message: "A company cannot be its own parent"}}, nil
+	}
+	var count int64
+	if err := db.Model(&models.Company{}).
+		Where("id = ? AND tenant_id = ? AND is_deleted = ?", parentID, tenantID, false).
+		Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return []ValidationError{{Field: "parentId", Code: "invalid_parent", Message: "Parent company not found"}}, nil
+	}
+	if companyID == "" {
+		return nil, nil
+	}
+	ancestors, err := companyAncestorIDs(db, parentID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ancestors {
+		if id == companyID {
+			return []ValidationError{{Field: "parentId", Code: "invalid_parent", Message: "Parent company is a subsidiary of this company"}}, nil
+		}
+	}
+	return nil, nil
+}
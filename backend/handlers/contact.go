@@ -6,6 +6,9 @@ import (
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
+	"finhub-backend/audit"
+	"finhub-backend/domains"
+	"finhub-backend/duplicates"
 	"finhub-backend/models"
 )
 
@@ -23,6 +26,9 @@ type CreateContactRequest struct {
 	EmailOptIn     bool    `json:"emailOptIn"`
 	SmsOptIn       bool    `json:"smsOptIn"`
 	CallOptIn      bool    `json:"callOptIn"`
+
+	Emails []string `json:"emails" binding:"dive,email"`
+	Phones []string `json:"phones" binding:"dive,required"`
 }
 
 type UpdateContactRequest struct {
@@ -37,6 +43,13 @@ type UpdateContactRequest struct {
 	CallOptIn      *bool   `json:"callOptIn"`
 }
 
+// ContactResponse is a saved contact with the potential duplicates warn
+// rules found
+type ContactResponse struct {
+	models.Contact
+	Duplicates []duplicates.Match `json:"duplicates,omitempty"`
+}
+
 func NewContactHandler(db *gorm.DB) *ContactHandler {
 	return &ContactHandler{db: db}
 }
@@ -98,13 +111,40 @@ func (h *ContactHandler) CreateContact(c *gin.Context) {
 		TenantID:       user.TenantID,
 		CreatedBy:      &userIDStr,
 	}
+	// A work email links the contact to the company with its domain
+	if contact.CompanyID == nil {
+		companyID, err := domains.MatchCompany(h.db, user.TenantID, req.Emails)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to match company"})
+			return
+		}
+		contact.CompanyID = companyID
+	}
+
+	matches, ok := checkDuplicates(c, h.db, duplicates.Record{
+		EntityType: duplicates.EntityContact,
+		TenantID:   user.TenantID,
+		FirstName:  contact.FirstName,
+		LastName:   contact.LastName,
+		CompanyID:  contact.CompanyID,
+		Emails:     req.Emails,
+		Phones:     req.Phones,
+	})
+	if !ok {
+		return
+	}
 
-	if err := h.db.Create(&contact).Error; err != nil {
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&contact).Error; err != nil {
+			return err
+		}
+		return saveContactPoints(tx, "contact", contact.ID, user.TenantID, req.Emails, req.Phones)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create contact"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, contact)
+	c.JSON(http.StatusCreated, ContactResponse{Contact: contact, Duplicates: matches})
 }
 
 func (h *ContactHandler) GetContact(c *gin.Context) {
@@ -189,12 +229,26 @@ func (h *ContactHandler) UpdateContact(c *gin.Context) {
 		contact.CallOptIn = *req.CallOptIn
 	}
 
-	if err := h.db.Save(&contact).Error; err != nil {
+	var matches []duplicates.Match
+	if req.FirstName != nil || req.LastName != nil || req.CompanyID != nil {
+		record, err := duplicates.Load(h.db, duplicates.EntityContact, contact.ID, user.TenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check duplicates"})
+			return
+		}
+		record.FirstName, record.LastName, record.CompanyID = contact.FirstName, contact.LastName, contact.CompanyID
+		var ok bool
+		if matches, ok = checkDuplicates(c, h.db, record); !ok {
+			return
+		}
+	}
+
+	if err := h.db.WithContext(c).Save(&contact).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update contact"})
 		return
 	}
 
-	c.JSON(http.StatusOK, contact)
+	c.JSON(http.StatusOK, ContactResponse{Contact: contact, Duplicates: matches})
 }
 
 func (h *ContactHandler) DeleteContact(c *gin.Context) {
@@ -221,7 +275,7 @@ func (h *ContactHandler) DeleteContact(c *gin.Context) {
 
 	// Soft delete
 	contact.IsDeleted = true
-	if err := h.db.Save(&contact).Error; err != nil {
+	if err := h.db.WithContext(c).Set(audit.ActionKey, audit.ActionDelete).Save(&contact).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete contact"})
 		return
 	}
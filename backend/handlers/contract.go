@@ -0,0 +1,695 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/audit"
+	"finhub-backend/contracts"
+	"finhub-backend/currency"
+	"finhub-backend/models"
+)
+
+// ContractHandler manages subscription contracts and their renewal settings
+type ContractHandler struct {
+	db *gorm.DB
+}
+
+type CreateContractRequest struct {
+	Name             string   `json:"name" binding:"required"`
+	CompanyID        string   `json:"companyId" binding:"required"`
+	DealID           string   `json:"dealId" binding:"required"`
+	RenewedFromID    *string  `json:"renewedFromId"`
+	StartDate        string   `json:"startDate" binding:"required"`
+	EndDate          string   `json:"endDate" binding:"required"`
+	BillingFrequency string   `json:"billingFrequency" binding:"omitempty,oneof=monthly quarterly annual"`
+	Currency         *string  `json:"currency"`
+	ARR              *float64 `json:"arr" binding:"omitempty,min=0"`
+	MRR              *float64 `json:"mrr" binding:"omitempty,min=0"`
+	AutoRenew        bool     `json:"autoRenew"`
+	Notes            *string  `json:"notes"`
+}
+
+type UpdateContractRequest struct {
+	Name             *string  `json:"name"`
+	DealID           *string  `json:"dealId"`
+	StartDate        *string  `json:"startDate"`
+	EndDate          *string  `json:"endDate"`
+	BillingFrequency *string  `json:"billingFrequency" binding:"omitempty,oneof=monthly quarterly annual"`
+	Currency         *string  `json:"currency"`
+	ARR              *float64 `json:"arr" binding:"omitempty,min=0"`
+	MRR              *float64 `json:"mrr" binding:"omitempty,min=0"`
+	AutoRenew        *bool    `json:"autoRenew"`
+	Notes            *string  `json:"notes"`
+}
+
+type CancelContractRequest struct {
+	CancelledAt *string `json:"cancelledAt"`
+	Notes       *string `json:"notes"`
+}
+
+type RenewalSettingsRequest struct {
+	PipelineID *string `json:"pipelineId"`
+	LeadDays   int     `json:"leadDays" binding:"min=1,max=365"`
+}
+
+func NewContractHandler(db *gorm.DB) *ContractHandler {
+	return &ContractHandler{db: db}
+}
+
+// GetContracts lists contracts, filtered by companyId, status and
+// endingBefore (a date)
+func (h *ContractHandler) GetContracts(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	query := h.db.Where("tenant_id = ? AND is_deleted = ?", user.TenantID, false)
+	if companyID := c.Query("companyId"); companyID != "" {
+		query = query.Where("company_id = ?", companyID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if value := c.Query("endingBefore"); value != "" {
+		date, err := parseDate(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid endingBefore"})
+			return
+		}
+		query = query.Where("end_date <= ?", *date)
+	}
+
+	var result []models.Contract
+	if err := query.Preload("Company").Order("end_date ASC").Find(&result).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch contracts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *ContractHandler) GetContract(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	contractID := c.Param("id")
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var contract models.Contract
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", contractID, user.TenantID, false).
+		Preload("Company").
+		Preload("Deal").
+		Preload("RenewalDeal.Stage").
+		First(&contract).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Contract not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, contract)
+}
+
+// CreateContract records a contract sold in a closed-won deal. ARR can be
+// given directly or as MRR; without either it is the deal amount annualized
+// over the contract term. Creating a contract that renews another marks the
+// earlier one renewed.
+func (h *ContractHandler) CreateContract(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CreateContractRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	userIDStr := userID.(string)
+	contract := models.Contract{
+		Name:             req.Name,
+		CompanyID:        req.CompanyID,
+		DealID:           &req.DealID,
+		RenewedFromID:    req.RenewedFromID,
+		BillingFrequency: req.BillingFrequency,
+		AutoRenew:        req.AutoRenew,
+		Status:           contracts.StatusActive,
+		Notes:            req.Notes,
+		TenantID:         user.TenantID,
+		CreatedBy:        &userIDStr,
+	}
+	if contract.BillingFrequency == "" {
+		contract.BillingFrequency = contracts.BillingAnnual
+	}
+
+	validationErrors := setContractDates(&contract, &req.StartDate, &req.EndDate)
+	deal, dealErrors, err := h.validateContractDeal(&contract)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate contract"})
+		return
+	}
+	validationErrors = append(validationErrors, dealErrors...)
+
+	if req.Currency != nil {
+		contract.Currency = *req.Currency
+	} else if deal != nil {
+		contract.Currency = deal.Currency
+	}
+	if req.ARR == nil && req.MRR == nil && deal != nil && deal.Amount != nil {
+		amount := contracts.AnnualAmount(*deal.Amount, contracts.TermMonths(contract.StartDate, contract.EndDate))
+		req.ARR = &amount
+	}
+	validationErrors = append(validationErrors, setContractValue(&contract, req.ARR, req.MRR)...)
+
+	var predecessor *models.Contract
+	if req.RenewedFromID != nil {
+		var renewed models.Contract
+		if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", *req.RenewedFromID, user.TenantID, false).
+			First(&renewed).Error; err != nil {
+			validationErrors = append(validationErrors, ValidationError{Field: "renewedFromId", Code: "invalid_contract", Message: "Contract not found"})
+		} else if renewed.CompanyID != contract.CompanyID {
+			validationErrors = append(validationErrors, ValidationError{Field: "renewedFromId", Code: "company_mismatch", Message: "Renewed contract belongs to another company"})
+		} else if renewed.Status == contracts.StatusRenewed || renewed.Status == contracts.StatusCancelled {
+			validationErrors = append(validationErrors, ValidationError{Field: "renewedFromId", Code: "not_renewable", Message: "Contract has already been " + renewed.Status})
+		} else {
+			predecessor = &renewed
+		}
+	}
+
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&contract).Error; err != nil {
+			return err
+		}
+		if predecessor == nil {
+			return nil
+		}
+		predecessor.Status = contracts.StatusRenewed
+		return tx.Save(predecessor).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create contract"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, contract)
+}
+
+func (h *ContractHandler) UpdateContract(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	contractID := c.Param("id")
+	var req UpdateContractRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var contract models.Contract
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", contractID, user.TenantID, false).
+		First(&contract).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Contract not found"})
+		return
+	}
+
+	if req.Name != nil {
+		contract.Name = *req.Name
+	}
+	if req.BillingFrequency != nil {
+		contract.BillingFrequency = *req.BillingFrequency
+	}
+	if req.Currency != nil {
+		contract.Currency = *req.Currency
+	}
+	if req.AutoRenew != nil {
+		contract.AutoRenew = *req.AutoRenew
+	}
+	if req.Notes != nil {
+		contract.Notes = req.Notes
+	}
+
+	validationErrors := setContractDates(&contract, req.StartDate, req.EndDate)
+	if req.DealID != nil {
+		contract.DealID = req.DealID
+		_, dealErrors, err := h.validateContractDeal(&contract)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate contract"})
+			return
+		}
+		validationErrors = append(validationErrors, dealErrors...)
+	}
+	if req.ARR != nil || req.MRR != nil || req.Currency != nil {
+		validationErrors = append(validationErrors, setContractValue(&contract, req.ARR, req.MRR)...)
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	if err := h.db.WithContext(c).Save(&contract).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update contract"})
+		return
+	}
+
+	c.JSON(http.StatusOK, contract)
+}
+
+// CancelContract ends an active contract early. The contract stops counting
+// towards ARR on the cancellation date, today by default.
+func (h *ContractHandler) CancelContract(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	contractID := c.Param("id")
+	var req CancelContractRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var contract models.Contract
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", contractID, user.TenantID, false).
+		First(&contract).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Contract not found"})
+		return
+	}
+	if contract.Status != contracts.StatusActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only active contracts can be cancelled"})
+		return
+	}
+
+	now := time.Now()
+	cancelledAt := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if req.CancelledAt != nil {
+		date, err := parseDate(*req.CancelledAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cancelledAt"})
+			return
+		}
+		cancelledAt = *date
+	}
+	if cancelledAt.Before(contract.StartDate) || cancelledAt.After(contract.EndDate) {
+		respondValidationErrors(c, []ValidationError{{Field: "cancelledAt", Code: "invalid_date", Message: "Cancellation date must fall within the contract term"}})
+		return
+	}
+
+	contract.Status = contracts.StatusCancelled
+	contract.CancelledAt = &cancelledAt
+	if req.Notes != nil {
+		contract.Notes = req.Notes
+	}
+	if err := h.db.WithContext(c).Save(&contract).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel contract"})
+		return
+	}
+
+	c.JSON(http.StatusOK, contract)
+}
+
+// OpenRenewalDeal opens the contract's renewal deal now instead of waiting
+// for the renewal job
+func (h *ContractHandler) OpenRenewalDeal(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	contractID := c.Param("id")
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var contract models.Contract
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", contractID, user.TenantID, false).
+		Preload("Deal").
+		First(&contract).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Contract not found"})
+		return
+	}
+	if contract.Status != contracts.StatusActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only active contracts can be renewed"})
+		return
+	}
+	if contract.RenewalDealID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Contract already has a renewal deal", "renewalDealId": *contract.RenewalDealID})
+		return
+	}
+
+	settings, err := contracts.LoadRenewalSettings(h.db, user.TenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch renewal settings"})
+		return
+	}
+	if err := contracts.OpenRenewalDeal(h.db.WithContext(c), &contract, settings); err != nil {
+		if errors.Is(err, contracts.ErrNoOpenStage) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Renewal pipeline has no open stage"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open renewal deal"})
+		return
+	}
+	if contract.RenewalDealID == nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "No renewal pipeline configured"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, contract)
+}
+
+func (h *ContractHandler) DeleteContract(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	contractID := c.Param("id")
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var contract models.Contract
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", contractID, user.TenantID, false).
+		First(&contract).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Contract not found"})
+		return
+	}
+
+	now := time.Now()
+	contract.IsDeleted = true
+	contract.DeletedAt = &now
+	if err := h.db.WithContext(c).Set(audit.ActionKey, audit.ActionDelete).Save(&contract).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete contract"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Contract deleted successfully"})
+}
+
+func (h *ContractHandler) GetRenewalSettings(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	settings, err := contracts.LoadRenewalSettings(h.db, user.TenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch renewal settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateRenewalSettings chooses the pipeline renewal deals are opened in and
+// how many days before the end date. Only tenant administrators may change
+// them.
+func (h *ContractHandler) UpdateRenewalSettings(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req RenewalSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	if req.PipelineID != nil {
+		var count int64
+		h.db.Model(&models.Pipeline{}).Where("id = ? AND tenant_id = ? AND is_active = ?", *req.PipelineID, user.TenantID, true).Count(&count)
+		if count == 0 {
+			respondValidationErrors(c, []ValidationError{{Field: "pipelineId", Code: "invalid_pipeline", Message: "Pipeline not found"}})
+			return
+		}
+	}
+
+	settings := contracts.RenewalSettings{PipelineID: req.PipelineID, LeadDays: req.LeadDays}
+	if err := contracts.SaveRenewalSettings(h.db, user.TenantID, settings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update renewal settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// GetARR returns ARR at each month end with the new, expansion, contraction
+// and churned ARR behind the change. The range defaults to the last twelve
+// months.
+func (h *ContractHandler) GetARR(c *gin.Context) {
+	months, ok := h.bridge(c)
+	if !ok {
+		return
+	}
+	for i := range months {
+		months[i].Movements = nil
+	}
+	c.JSON(http.StatusOK, gin.H{"months": months})
+}
+
+// GetChurn returns churned and contracted ARR by month with the companies
+// behind it
+func (h *ContractHandler) GetChurn(c *gin.Context) {
+	months, ok := h.bridge(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"months": filterMovements(months, contracts.MovementChurn, contracts.MovementContraction)})
+}
+
+// GetExpansion returns expansion ARR and net retention by month with the
+// companies that expanded
+func (h *ContractHandler) GetExpansion(c *gin.Context) {
+	months, ok := h.bridge(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"months": filterMovements(months, contracts.MovementExpansion)})
+}
+
+// bridge computes the ARR bridge between the from and to query dates,
+// writing an error response and returning false on failure
+func (h *ContractHandler) bridge(c *gin.Context) ([]contracts.Month, bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, false
+	}
+
+	now := time.Now()
+	to := now
+	if value := c.Query("to"); value != "" {
+		date, err := parseDate(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to"})
+			return nil, false
+		}
+		to = *date
+	}
+	from := to.AddDate(0, -11, 0)
+	if value := c.Query("from"); value != "" {
+		date, err := parseDate(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from"})
+			return nil, false
+		}
+		from = *date
+	}
+	if from.After(to) || to.Sub(from) > 5*366*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to and at most five years earlier"})
+		return nil, false
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+
+	months, err := contracts.Bridge(h.db, user.TenantID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute recurring revenue"})
+		return nil, false
+	}
+	return months, true
+}
+
+// filterMovements keeps only the company movements of the given types
+func filterMovements(months []contracts.Month, types ...string) []contracts.Month {
+	for i := range months {
+		kept := []contracts.CompanyMovement{}
+		for _, movement := range months[i].Movements {
+			for _, kind := range types {
+				if movement.Type == kind {
+					kept = append(kept, movement)
+				}
+			}
+		}
+		months[i].Movements = kept
+	}
+	return months
+}
+
+// setContractDates parses and applies the start and end dates that were given
+func setContractDates(contract *models.Contract, startDate, endDate *string) []ValidationError {
+	var validationErrors []ValidationError
+	for _, date := range []struct {
+		field  string
+		value  *string
+		target *time.Time
+	}{
+		{"startDate", startDate, &contract.StartDate},
+		{"endDate", endDate, &contract.EndDate},
+	} {
+		if date.value == nil {
+			continue
+		}
+		parsed, err := parseDate(*date.value)
+		if err != nil || parsed == nil {
+			validationErrors = append(validationErrors, ValidationError{Field: date.field, Code: "invalid_date", Message: "Invalid date"})
+			continue
+		}
+		*date.target = *parsed
+	}
+	if len(validationErrors) == 0 && !contract.EndDate.After(contract.StartDate) {
+		validationErrors = append(validationErrors, ValidationError{Field: "endDate", Code: "invalid_dates", Message: "End date must be after the start date"})
+	}
+	return validationErrors
+}
+
+// setContractValue sets ARR and MRR from whichever was given and checks the
+// currency
+func setContractValue(contract *models.Contract, arr, mrr *float64) []ValidationError {
+	var validationErrors []ValidationError
+	switch {
+	case arr != nil && mrr != nil && math.Abs(*arr-*mrr*12) > 0.01:
+		validationErrors = append(validationErrors, ValidationError{Field: "mrr", Code: "arr_mismatch", Message: "MRR must be a twelfth of ARR"})
+	case arr != nil:
+		contract.ARR = *arr
+		contract.MRR = contracts.MonthlyAmount(*arr)
+	case mrr != nil:
+		contract.MRR = *mrr
+		contract.ARR = *mrr * 12
+	}
+	if arr == nil && mrr == nil && contract.ID == "" {
+		validationErrors = append(validationErrors, ValidationError{Field: "arr", Code: "required", Message: "ARR or MRR is required when the deal has no amount"})
+	}
+
+	contract.Currency = currency.Normalize(contract.Currency)
+	if !currency.Valid(contract.Currency) {
+		validationErrors = append(validationErrors, ValidationError{Field: "currency", Code: "invalid_currency", Message: "Invalid ISO 4217 currency code"})
+	}
+	return validationErrors
+}
+
+// validateContractDeal checks that the contract's deal exists, is closed won
+// and belongs to the contract's company. The company itself must exist.
+func (h *ContractHandler) validateContractDeal(contract *models.Contract) (*models.Deal, []ValidationError, error) {
+	var validationErrors []ValidationError
+
+	var count int64
+	if err := h.db.Model(&models.Company{}).
+		Where("id = ? AND tenant_id = ? AND is_deleted = ?", contract.CompanyID, contract.TenantID, false).
+		Count(&count).Error; err != nil {
+		return nil, nil, err
+	}
+	if count == 0 {
+		validationErrors = append(validationErrors, ValidationError{Field: "companyId", Code: "invalid_company", Message: "Company not found"})
+	}
+
+	if contract.DealID == nil {
+		return nil, validationErrors, nil
+	}
+	var deal models.Deal
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", *contract.DealID, contract.TenantID, false).
+		Preload("Stage").
+		First(&deal).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, append(validationErrors, ValidationError{Field: "dealId", Code: "invalid_deal", Message: "Deal not found"}), nil
+		}
+		return nil, nil, err
+	}
+	if !deal.Stage.IsClosedWon {
+		validationErrors = append(validationErrors, ValidationError{Field: "dealId", Code: "deal_not_won", Message: "Contracts can only be created from closed-won deals"})
+	}
+	if deal.CompanyID != nil && *deal.CompanyID != contract.CompanyID {
+		validationErrors = append(validationErrors, ValidationError{Field: "dealId", Code: "company_mismatch", Message: "Deal belongs to another company"})
+	}
+	return &deal, validationErrors, nil
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/currency"
+	"finhub-backend/models"
+)
+
+// CurrencyHandler manages the tenant's reporting currency and exchange rates
+type CurrencyHandler struct {
+	db *gorm.DB
+}
+
+type CurrencySettingsRequest struct {
+	ReportingCurrency string `json:"reportingCurrency" binding:"required"`
+}
+
+type CreateExchangeRateRequest struct {
+	BaseCurrency  string  `json:"baseCurrency" binding:"required"`
+	Currency      string  `json:"currency" binding:"required"`
+	EffectiveDate string  `json:"effectiveDate" binding:"required"`
+	Rate          float64 `json:"rate" binding:"required,gt=0"`
+}
+
+func NewCurrencyHandler(db *gorm.DB) *CurrencyHandler {
+	return &CurrencyHandler{db: db}
+}
+
+func (h *CurrencyHandler) GetSettings(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	code, err := currency.ReportingCurrency(h.db, user.TenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch currency settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reportingCurrency": code})
+}
+
+// UpdateSettings sets the tenant's reporting currency. Only tenant
+// administrators may change it.
+func (h *CurrencyHandler) UpdateSettings(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CurrencySettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	code := currency.Normalize(req.ReportingCurrency)
+	if !currency.Valid(code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ISO 4217 currency code"})
+		return
+	}
+	if err := currency.SetReportingCurrency(h.db, user.TenantID, code); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update currency settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"reportingCurrency": code})
+}
+
+// GetExchangeRates lists exchange rates, optionally filtered by currency,
+// baseCurrency and an effective date range (from, to)
+func (h *CurrencyHandler) GetExchangeRates(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	query := h.db.Where("tenant_id = ?", user.TenantID)
+	if code := c.Query("currency"); code != "" {
+		query = query.Where("currency = ?", currency.Normalize(code))
+	}
+	if base := c.Query("baseCurrency"); base != "" {
+		query = query.Where("base_currency = ?", currency.Normalize(base))
+	}
+	for param, condition := range map[string]string{"from": "effective_date >= ?", "to": "effective_date <= ?"} {
+		if value := c.Query(param); value != "" {
+			date, err := parseDate(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return
+			}
+			query = query.Where(condition, *date)
+		}
+	}
+
+	var rates []models.ExchangeRate
+	if err := query.Order("effective_date DESC, base_currency ASC, currency ASC").Find(&rates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch exchange rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rates)
+}
+
+// CreateExchangeRate adds or replaces a single rate
+func (h *CurrencyHandler) CreateExchangeRate(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CreateExchangeRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	base, code := currency.Normalize(req.BaseCurrency), currency.Normalize(req.Currency)
+	if !currency.Valid(base) || !currency.Valid(code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ISO 4217 currency code"})
+		return
+	}
+	date, err := parseDate(req.EffectiveDate)
+	if err != nil || date == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid effectiveDate"})
+		return
+	}
+
+	source := "manual"
+	rates := []models.ExchangeRate{{
+		BaseCurrency:  base,
+		Currency:      code,
+		EffectiveDate: *date,
+		Rate:          req.Rate,
+		Source:        &source,
+	}}
+	if err := currency.SaveRates(h.db, user.TenantID, rates); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save exchange rate"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, rates[0])
+}
+
+// ImportExchangeRates loads rates from an uploaded file (multipart field
+// "file") or the raw request body. ?format=csv (the default) expects date,
+// currency and rate columns with an optional base column, falling back to
+// ?base (default the reporting currency); ?format=ecb expects the ECB
+// euro reference rate XML.
+func (h *CurrencyHandler) ImportExchangeRates(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var body io.Reader = c.Request.Body
+	if file, err := c.FormFile("file"); err == nil {
+		opened, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+			return
+		}
+		defer opened.Close()
+		body = opened
+	}
+
+	var rates []models.ExchangeRate
+	var err error
+	switch c.DefaultQuery("format", "csv") {
+	case "csv":
+		base := c.Query("base")
+		if base == "" {
+			if base, err = currency.ReportingCurrency(h.db, user.TenantID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch currency settings"})
+				return
+			}
+		}
+		rates, err = currency.ParseCSV(body, base)
+	case "ecb":
+		rates, err = currency.ParseECB(body)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or ecb"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse exchange rates", "details": err.Error()})
+		return
+	}
+
+	if err := currency.SaveRates(h.db, user.TenantID, rates); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save exchange rates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"imported": len(rates)})
+}
+
+func (h *CurrencyHandler) DeleteExchangeRate(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	rateID := c.Param("id")
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	result := h.db.WithContext(c).Where("id = ? AND tenant_id = ?", rateID, user.TenantID).Delete(&models.ExchangeRate{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete exchange rate"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Exchange rate not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Exchange rate deleted successfully"})
+}
@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
+	"finhub-backend/audit"
+	"finhub-backend/currency"
 	"finhub-backend/models"
 )
 
@@ -24,6 +27,9 @@ type CreateDealRequest struct {
 	CompanyID         *string  `json:"companyId"`
 	ContactID         *string  `json:"contactId"`
 	AssignedUserID    *string  `json:"assignedUserId"`
+	ForecastCategory  *string  `json:"forecastCategory" binding:"omitempty,oneof=pipeline best_case commit omitted"`
+	PriceBookID       *string  `json:"priceBookId"`
+	Notes             *string  `json:"notes"`
 }
 
 type UpdateDealRequest struct {
@@ -37,6 +43,10 @@ type UpdateDealRequest struct {
 	CompanyID         *string  `json:"companyId"`
 	ContactID         *string  `json:"contactId"`
 	AssignedUserID    *string  `json:"assignedUserId"`
+	ForecastCategory  *string  `json:"forecastCategory" binding:"omitempty,oneof=pipeline best_case commit omitted"`
+	PriceBookID       *string  `json:"priceBookId"`
+	ChangeReason      *string  `json:"changeReason"`
+	Notes             *string  `json:"notes"`
 }
 
 func NewDealHandler(db *gorm.DB) *DealHandler {
@@ -58,7 +68,8 @@ func (h *DealHandler) GetDeals(c *gin.Context) {
 
 	var deals []models.Deal
 	if err := h.db.Where("tenant_id = ? AND is_deleted = ?", user.TenantID, false).
-		Preload("Pipeline").Preload("Stage").Preload("Company").Preload("Contact").Preload("AssignedUser").
+		Preload("Pipeline").Preload("Stage").Preload("Company").Preload("Contact").Preload("AssignedUser").Preload("CloseReason").
+		Scopes(preloadLineItems).
 		Find(&deals).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deals"})
 		return
@@ -86,22 +97,67 @@ func (h *DealHandler) CreateDeal(c *gin.Context) {
 		return
 	}
 
+	var expectedCloseDate *time.Time
+	if req.ExpectedCloseDate != nil {
+		date, err := parseDate(*req.ExpectedCloseDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expectedCloseDate"})
+			return
+		}
+		expectedCloseDate = date
+	}
+
 	userIDStr := userID.(string)
 	deal := models.Deal{
-		Name:           req.Name,
-		Amount:         req.Amount,
-		Currency:       req.Currency,
-		Probability:    req.Probability,
-		PipelineID:     req.PipelineID,
-		StageID:        req.StageID,
-		CompanyID:      req.CompanyID,
-		ContactID:      req.ContactID,
-		AssignedUserID: req.AssignedUserID,
-		TenantID:       user.TenantID,
-		CreatedBy:      &userIDStr,
-	}
-
-	if err := h.db.Create(&deal).Error; err != nil {
+		Name:              req.Name,
+		Amount:            req.Amount,
+		Currency:          req.Currency,
+		Probability:       req.Probability,
+		PipelineID:        req.PipelineID,
+		StageID:           req.StageID,
+		ExpectedCloseDate: expectedCloseDate,
+		CompanyID:         req.CompanyID,
+		ContactID:         req.ContactID,
+		AssignedUserID:    req.AssignedUserID,
+		ForecastCategory:  req.ForecastCategory,
+		PriceBookID:       req.PriceBookID,
+		TenantID:          user.TenantID,
+		CreatedBy:         &userIDStr,
+	}
+	if deal.Currency == "" {
+		code, err := currency.ReportingCurrency(h.db, user.TenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch currency settings"})
+			return
+		}
+		deal.Currency = code
+	} else if deal.Currency = currency.Normalize(deal.Currency); !currency.Valid(deal.Currency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ISO 4217 currency code"})
+		return
+	}
+
+	validationErrors, err := validateDealStage(h.db, user.TenantID, nil, &deal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate deal"})
+		return
+	}
+	closedErrors, err := validateClosedStageChange(h.db, nil, &deal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate deal"})
+		return
+	}
+	validationErrors = append(validationErrors, closedErrors...)
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&deal).Error; err != nil {
+			return err
+		}
+		return recordDealChange(tx, nil, &deal, DealChange{MovedBy: &userIDStr, Notes: req.Notes})
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create deal"})
 		return
 	}
@@ -126,7 +182,8 @@ func (h *DealHandler) GetDeal(c *gin.Context) {
 
 	var deal models.Deal
 	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", dealID, user.TenantID, false).
-		Preload("Pipeline").Preload("Stage").Preload("Company").Preload("Contact").Preload("AssignedUser").
+		Preload("Pipeline").Preload("Stage").Preload("Company").Preload("Contact").Preload("AssignedUser").Preload("CloseReason").
+		Scopes(preloadLineItems).
 		First(&deal).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
 		return
@@ -162,6 +219,8 @@ func (h *DealHandler) UpdateDeal(c *gin.Context) {
 		return
 	}
 
+	before := deal
+
 	// Update fields
 	if req.Name != nil {
 		deal.Name = *req.Name
@@ -170,7 +229,11 @@ func (h *DealHandler) UpdateDeal(c *gin.Context) {
 		deal.Amount = req.Amount
 	}
 	if req.Currency != nil {
-		deal.Currency = *req.Currency
+		deal.Currency = currency.Normalize(*req.Currency)
+		if !currency.Valid(deal.Currency) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ISO 4217 currency code"})
+			return
+		}
 	}
 	if req.Probability != nil {
 		deal.Probability = *req.Probability
@@ -181,6 +244,14 @@ func (h *DealHandler) UpdateDeal(c *gin.Context) {
 	if req.StageID != nil {
 		deal.StageID = *req.StageID
 	}
+	if req.ExpectedCloseDate != nil {
+		date, err := parseDate(*req.ExpectedCloseDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expectedCloseDate"})
+			return
+		}
+		deal.ExpectedCloseDate = date
+	}
 	if req.CompanyID != nil {
 		deal.CompanyID = req.CompanyID
 	}
@@ -190,8 +261,49 @@ func (h *DealHandler) UpdateDeal(c *gin.Context) {
 	if req.AssignedUserID != nil {
 		deal.AssignedUserID = req.AssignedUserID
 	}
+	if req.ForecastCategory != nil {
+		deal.ForecastCategory = req.ForecastCategory
+	}
+	if req.PriceBookID != nil {
+		deal.PriceBookID = req.PriceBookID
+		if *req.PriceBookID == "" {
+			deal.PriceBookID = nil
+		}
+	}
+
+	validationErrors, err := validateDealStage(h.db, user.TenantID, &before, &deal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate deal"})
+		return
+	}
+	lineItemErrors, err := validateLineItemDeal(h.db, &before, &deal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate deal"})
+		return
+	}
+	validationErrors = append(validationErrors, lineItemErrors...)
+	closedErrors, err := validateClosedStageChange(h.db, &before, &deal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate deal"})
+		return
+	}
+	validationErrors = append(validationErrors, closedErrors...)
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
 
-	if err := h.db.Save(&deal).Error; err != nil {
+	userIDStr := userID.(string)
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&deal).Error; err != nil {
+			return err
+		}
+		return recordDealChange(tx, &before, &deal, DealChange{
+			MovedBy:      &userIDStr,
+			ChangeReason: req.ChangeReason,
+			Notes:        req.Notes,
+		})
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update deal"})
 		return
 	}
@@ -199,6 +311,14 @@ func (h *DealHandler) UpdateDeal(c *gin.Context) {
 	c.JSON(http.StatusOK, deal)
 }
 
+// preloadLineItems loads a deal's line items with their products in display
+// order
+func preloadLineItems(db *gorm.DB) *gorm.DB {
+	return db.Preload("LineItems", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sort_order ASC, created_at ASC")
+	}).Preload("LineItems.Product")
+}
+
 func (h *DealHandler) DeleteDeal(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -223,7 +343,7 @@ func (h *DealHandler) DeleteDeal(c *gin.Context) {
 
 	// Soft delete
 	deal.IsDeleted = true
-	if err := h.db.Save(&deal).Error; err != nil {
+	if err := h.db.WithContext(c).Set(audit.ActionKey, audit.ActionDelete).Save(&deal).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete deal"})
 		return
 	}
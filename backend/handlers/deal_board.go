@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"finhub-backend/audit"
+	"finhub-backend/currency"
+	"finhub-backend/models"
+)
+
+// boardRankGap is the spacing between deals when a column is renumbered
+const boardRankGap = 1024.0
+
+type BoardRequest struct {
+	Filters  map[string]interface{} `json:"filters"`
+	PageSize int                    `json:"pageSize" binding:"min=0,max=100"`
+
+	// Load another page of a single column
+	StageID string `json:"stageId"`
+	Page    int    `json:"page" binding:"min=0"`
+}
+
+type BoardColumn struct {
+	Stage      models.Stage             `json:"stage"`
+	Deals      []map[string]interface{} `json:"deals"`
+	TotalCount int64                    `json:"totalCount"`
+	// Sum of deal amounts in the reporting currency at today's rates
+	TotalAmount float64 `json:"totalAmount"`
+	// Currencies of deals left out of TotalAmount for lack of a rate
+	UnconvertedCurrencies []string `json:"unconvertedCurrencies"`
+	Page                  int      `json:"page"`
+	PageSize              int      `json:"pageSize"`
+	HasMore               bool     `json:"hasMore"`
+}
+
+type MoveDealRequest struct {
+	StageID string `json:"stageId" binding:"required"`
+	// Deal directly above the new position; empty for the top of the column.
+	// Deals hidden by the board's filters that follow it stay below the
+	// moved deal.
+	AfterDealID *string `json:"afterDealId"`
+	// The deal's updatedAt as last seen by the client
+	UpdatedAt    time.Time `json:"updatedAt" binding:"required"`
+	ChangeReason *string   `json:"changeReason"`
+	Notes        *string   `json:"notes"`
+}
+
+var errBoardConflict = errors.New("board changed concurrently")
+
+// GetPipelineBoard returns the pipeline's stages in order, each with a page of
+// its deals in board order and the count and amount totals of all deals in
+// the column. Entity query filters narrow the deals shown.
+func (h *EntityHandler) GetPipelineBoard(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	pipelineID := c.Param("id")
+	var req BoardRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.PageSize == 0 {
+		req.PageSize = 20
+	}
+	if req.Page == 0 {
+		req.Page = 1
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var pipeline models.Pipeline
+	if err := h.db.Where("id = ? AND tenant_id = ?", pipelineID, user.TenantID).First(&pipeline).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pipeline not found"})
+		return
+	}
+
+	stageQuery := h.db.Where("pipeline_id = ? AND is_deleted = ?", pipeline.ID, false)
+	if req.StageID != "" {
+		stageQuery = stageQuery.Where("id = ?", req.StageID)
+	}
+	var stages []models.Stage
+	if err := stageQuery.Order(`"order" ASC`).Find(&stages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stages"})
+		return
+	}
+	if req.StageID != "" && len(stages) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stage not found"})
+		return
+	}
+
+	boardQuery := func() *gorm.DB {
+		return h.applyFilters(h.db.Model(&models.Deal{}).
+			Joins("LEFT JOIN stages ON deals.stage_id = stages.id").
+			Joins("LEFT JOIN companies ON deals.company_id = companies.id").
+			Joins("LEFT JOIN contacts ON deals.contact_id = contacts.id").
+			Joins("LEFT JOIN users ON deals.assigned_user_id = users.id").
+			Where("deals.tenant_id = ? AND deals.pipeline_id = ? AND deals.is_deleted = ?", user.TenantID, pipeline.ID, false),
+			req.Filters)
+	}
+
+	var totals []struct {
+		StageID  string
+		Currency string
+		Count    int64
+		Amount   float64
+	}
+	if err := boardQuery().
+		Select("deals.stage_id, deals.currency, COUNT(*) as count, COALESCE(SUM(deals.amount), 0) as amount").
+		Group("deals.stage_id, deals.currency").
+		Scan(&totals).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute board totals"})
+		return
+	}
+	converter, err := currency.NewConverter(h.db, user.TenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load exchange rates"})
+		return
+	}
+
+	now := time.Now()
+	columns := make([]BoardColumn, len(stages))
+	for i, stage := range stages {
+		column := BoardColumn{Stage: stage, Page: req.Page, PageSize: req.PageSize}
+		unconverted := currency.Unconverted{}
+		for _, total := range totals {
+			if total.StageID == stage.ID {
+				column.TotalCount += total.Count
+				if amount, ok := converter.Convert(total.Amount, total.Currency, now); ok {
+					column.TotalAmount += amount
+				} else {
+					unconverted[total.Currency] = true
+				}
+			}
+		}
+		column.UnconvertedCurrencies = unconverted.List()
+		column.TotalAmount = math.Round(column.TotalAmount*100) / 100
+
+		column.Deals, err = h.executeEntityQuery("deals", boardQuery().
+			Select(`
+				deals.id, deals.name, deals.amount, deals.currency, deals.probability,
+				deals.expected_close_date, deals.forecast_category, deals.stage_id,
+				deals.board_rank, deals.assigned_user_id, deals.updated_at,
+				companies.name as company_name,
+				users.first_name as owner_first_name,
+				users.last_name as owner_last_name
+			`).
+			Where("deals.stage_id = ?", stage.ID).
+			Order("deals.board_rank ASC, deals.created_at DESC, deals.id ASC").
+			Offset((req.Page-1)*req.PageSize).
+			Limit(req.PageSize))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deals"})
+			return
+		}
+		if column.Deals == nil {
+			column.Deals = []map[string]interface{}{}
+		}
+		column.HasMore = int64(req.Page*req.PageSize) < column.TotalCount
+		columns[i] = column
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"pipeline":          pipeline,
+		"stages":            columns,
+		"reportingCurrency": converter.Target,
+	})
+}
+
+// MoveDeal moves a deal on the board: into another stage of its pipeline
+// and to the position after another deal of that column, in one transaction.
+// The move is rejected with 409 when the deal changed since the client saw it
+// or the deal to follow is no longer in the column.
+func (h *DealHandler) MoveDeal(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	dealID := c.Param("id")
+	var req MoveDealRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var deal models.Deal
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", dealID, user.TenantID, false).
+		First(&deal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
+		return
+	}
+
+	before := deal
+	deal.StageID = req.StageID
+	validationErrors, err := validateDealStage(h.db, user.TenantID, &before, &deal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate deal"})
+		return
+	}
+	closedErrors, err := validateClosedStageChange(h.db, &before, &deal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate deal"})
+		return
+	}
+	validationErrors = append(validationErrors, closedErrors...)
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	userIDStr := userID.(string)
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		// The row lock and updatedAt check make sure the deal is still as
+		// validated above; the column lock serialises moves into the stage
+		var locked models.Deal
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "updated_at").
+			First(&locked, "id = ?", deal.ID).Error; err != nil {
+			return err
+		}
+		if !locked.UpdatedAt.Equal(req.UpdatedAt) {
+			return errBoardConflict
+		}
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "board:"+req.StageID).Error; err != nil {
+			return err
+		}
+
+		rank, err := boardRank(tx, &deal, req.AfterDealID)
+		if err != nil {
+			return err
+		}
+		deal.BoardRank = rank
+		if err := tx.Save(&deal).Error; err != nil {
+			return err
+		}
+		return recordDealChange(tx, &before, &deal, DealChange{
+			MovedBy:      &userIDStr,
+			ChangeReason: req.ChangeReason,
+			Notes:        req.Notes,
+		})
+	}); err != nil {
+		if errors.Is(err, errBoardConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": "The board changed since it was loaded; reload and try again"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move deal"})
+		return
+	}
+
+	c.JSON(http.StatusOK, deal)
+}
+
+// boardRank returns the rank that places deal directly after afterID (or at
+// the top) in its stage column. The whole column is ranked, whatever the
+// client's filters, so the deal lands right after afterID in any filtered
+// view too. Columns whose ranks leave no room are renumbered first.
+func boardRank(tx *gorm.DB, deal *models.Deal, afterID *string) (float64, error) {
+	var column []models.Deal
+	if err := tx.Select("id", "board_rank").
+		Where("stage_id = ? AND tenant_id = ? AND is_deleted = ? AND id <> ?", deal.StageID, deal.TenantID, false, deal.ID).
+		Order("board_rank ASC, created_at DESC, id ASC").
+		Find(&column).Error; err != nil {
+		return 0, err
+	}
+
+	position := 0
+	if afterID != nil && *afterID != "" {
+		position = -1
+		for i, other := range column {
+			if other.ID == *afterID {
+				position = i + 1
+				break
+			}
+		}
+		if position < 0 {
+			return 0, errBoardConflict
+		}
+	}
+
+	switch {
+	case len(column) == 0:
+		return boardRankGap, nil
+	case position == 0:
+		return column[0].BoardRank - boardRankGap, nil
+	case position == len(column):
+		return column[position-1].BoardRank + boardRankGap, nil
+	}
+	previous, next := column[position-1].BoardRank, column[position].BoardRank
+	if next-previous > 1e-6 {
+		return (previous + next) / 2, nil
+	}
+
+	// Renumber the column, leaving a slot at the position
+	for i, other := range column {
+		slot := i + 1
+		if i >= position {
+			slot++
+		}
+		if err := audit.Skip(tx).Model(&models.Deal{}).Where("id = ?", other.ID).
+			UpdateColumn("board_rank", float64(slot)*boardRankGap).Error; err != nil {
+			return 0, err
+		}
+	}
+	return float64(position+1) * boardRankGap, nil
+}
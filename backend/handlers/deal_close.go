@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/models"
+)
+
+type CloseDealRequest struct {
+	Outcome       string  `json:"outcome" binding:"required,oneof=won lost"`
+	StageID       *string `json:"stageId"`
+	CloseReasonID *string `json:"closeReasonId"`
+	Competitor    *string `json:"competitor"`
+	Notes         *string `json:"notes"`
+	CloseDate     *string `json:"closeDate"`
+}
+
+type ReopenDealRequest struct {
+	StageID      string  `json:"stageId" binding:"required"`
+	ChangeReason *string `json:"changeReason"`
+	Notes        *string `json:"notes"`
+}
+
+// validateClosedStageChange stops deals being closed or reopened through a
+// plain update, which would skip the close date, probability and reason
+// handling of the close and reopen endpoints
+func validateClosedStageChange(db *gorm.DB, before *models.Deal, after *models.Deal) ([]ValidationError, error) {
+	var errs []ValidationError
+
+	if before == nil || before.StageID != after.StageID {
+		var stage models.Stage
+		if err := db.Select("is_closed_won", "is_closed_lost").First(&stage, "id = ?", after.StageID).Error; err != nil {
+			if err == gorm.ErrRecordNotFound {
+				return nil, nil // reported by validateDealStage
+			}
+			return nil, err
+		}
+		if stage.IsClosedWon || stage.IsClosedLost {
+			errs = append(errs, ValidationError{Field: "stageId", Code: "close_required", Message: "Use the close endpoint to move a deal into a closed stage"})
+		}
+	}
+	if before == nil {
+		return errs, nil
+	}
+
+	var current models.Stage
+	if err := db.Select("is_closed_won", "is_closed_lost").First(&current, "id = ?", before.StageID).Error; err != nil {
+		return nil, err
+	}
+	if current.IsClosedWon || current.IsClosedLost {
+		if before.StageID != after.StageID {
+			errs = append(errs, ValidationError{Field: "stageId", Code: "reopen_required", Message: "Use the reopen endpoint to move a closed deal"})
+		}
+		if before.Probability != after.Probability {
+			errs = append(errs, ValidationError{Field: "probability", Code: "deal_closed", Message: "The probability of a closed deal cannot be changed"})
+		}
+	}
+	return errs, nil
+}
+
+// CloseDeal marks a deal as won or lost. It moves the deal into a closed stage
+// of its pipeline, sets the actual close date and forces the probability to
+// 100 or 0. Lost deals require a close reason.
+func (h *DealHandler) CloseDeal(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	dealID := c.Param("id")
+	var req CloseDealRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var deal models.Deal
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", dealID, user.TenantID, false).
+		Preload("Stage").First(&deal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
+		return
+	}
+	if deal.Stage.IsClosedWon || deal.Stage.IsClosedLost {
+		c.JSON(http.StatusConflict, gin.H{"error": "Deal is already closed"})
+		return
+	}
+	if req.Outcome == "won" {
+		if pending, err := hasPendingApproval(h.db, deal.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch approvals"})
+			return
+		} else if pending {
+			respondValidationErrors(c, []ValidationError{pendingApprovalError})
+			return
+		}
+	}
+
+	closeDate := time.Now()
+	if req.CloseDate != nil {
+		date, err := parseDate(*req.CloseDate)
+		if err != nil || date == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid closeDate"})
+			return
+		}
+		closeDate = *date
+	}
+
+	won := req.Outcome == "won"
+	var validationErrors []ValidationError
+
+	// Find the closed stage to move into
+	var stage models.Stage
+	stageQuery := h.db.Where("pipeline_id = ? AND tenant_id = ? AND is_deleted = ?", deal.PipelineID, user.TenantID, false)
+	if won {
+		stageQuery = stageQuery.Where("is_closed_won = ?", true)
+	} else {
+		stageQuery = stageQuery.Where("is_closed_lost = ?", true)
+	}
+	if req.StageID != nil {
+		stageQuery = stageQuery.Where("id = ?", *req.StageID)
+	}
+	if err := stageQuery.Order("\"order\" ASC").First(&stage).Error; err != nil {
+		if err != gorm.ErrRecordNotFound {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stage"})
+			return
+		}
+		validationErrors = append(validationErrors, ValidationError{
+			Field:   "stageId",
+			Code:    "invalid_stage",
+			Message: fmt.Sprintf("No closed %s stage found in the deal's pipeline", req.Outcome),
+		})
+	}
+
+	// Validate the close reason
+	if req.CloseReasonID != nil && *req.CloseReasonID != "" {
+		var reason models.DealCloseReason
+		if err := h.db.Where("id = ? AND tenant_id = ? AND is_active = ?", *req.CloseReasonID, user.TenantID, true).
+			First(&reason).Error; err != nil {
+			validationErrors = append(validationErrors, ValidationError{Field: "closeReasonId", Code: "invalid_close_reason", Message: "Close reason not found"})
+		} else if reason.Outcome != req.Outcome {
+			validationErrors = append(validationErrors, ValidationError{
+				Field:   "closeReasonId",
+				Code:    "invalid_close_reason",
+				Message: fmt.Sprintf("%s is not a %s reason", reason.Name, req.Outcome),
+			})
+		}
+	} else if !won {
+		validationErrors = append(validationErrors, ValidationError{Field: "closeReasonId", Code: "required", Message: "A loss reason is required to close a deal as lost"})
+	}
+
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	deal.Stage = models.Stage{}
+	before := deal
+
+	deal.StageID = stage.ID
+	deal.ActualCloseDate = &closeDate
+	deal.CloseReasonID = req.CloseReasonID
+	deal.Competitor = req.Competitor
+	deal.CloseNotes = req.Notes
+	if won {
+		deal.Probability = 100
+	} else {
+		deal.Probability = 0
+	}
+
+	validationErrors, err := validateDealStage(h.db, user.TenantID, &before, &deal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate deal"})
+		return
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	userIDStr := userID.(string)
+	changeReason := "Closed " + req.Outcome
+	err = h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if won {
+			if err := lockDealWithoutApproval(tx, deal.ID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Save(&deal).Error; err != nil {
+			return err
+		}
+		return recordDealChange(tx, &before, &deal, DealChange{
+			MovedBy:      &userIDStr,
+			ChangeReason: &changeReason,
+			Notes:        req.Notes,
+		})
+	})
+	if errors.Is(err, errApprovalPending) {
+		respondValidationErrors(c, []ValidationError{pendingApprovalError})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to close deal"})
+		return
+	}
+
+	h.db.Preload("Stage").Preload("CloseReason").First(&deal, "id = ?", deal.ID)
+	c.JSON(http.StatusOK, deal)
+}
+
+// ReopenDeal moves a closed deal back into an open stage, clearing its close
+// date and reason and taking the probability of the new stage
+func (h *DealHandler) ReopenDeal(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	dealID := c.Param("id")
+	var req ReopenDealRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var deal models.Deal
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", dealID, user.TenantID, false).
+		Preload("Stage").First(&deal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
+		return
+	}
+	if !deal.Stage.IsClosedWon && !deal.Stage.IsClosedLost {
+		c.JSON(http.StatusConflict, gin.H{"error": "Deal is not closed"})
+		return
+	}
+
+	var stage models.Stage
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", req.StageID, user.TenantID, false).
+		First(&stage).Error; err == nil && (stage.IsClosedWon || stage.IsClosedLost) {
+		respondValidationErrors(c, []ValidationError{{Field: "stageId", Code: "closed_stage", Message: "Deals must be reopened into an open stage"}})
+		return
+	}
+
+	deal.Stage = models.Stage{}
+	before := deal
+
+	deal.StageID = req.StageID
+	deal.Probability = stage.Probability
+	deal.ActualCloseDate = nil
+	deal.CloseReasonID = nil
+	deal.Competitor = nil
+	deal.CloseNotes = nil
+
+	validationErrors, err := validateDealStage(h.db, user.TenantID, &before, &deal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate deal"})
+		return
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	userIDStr := userID.(string)
+	changeReason := req.ChangeReason
+	if changeReason == nil {
+		changeReason = stringPtr("Reopened")
+	}
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&deal).Error; err != nil {
+			return err
+		}
+		return recordDealChange(tx, &before, &deal, DealChange{
+			MovedBy:      &userIDStr,
+			ChangeReason: changeReason,
+			Notes:        req.Notes,
+		})
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reopen deal"})
+		return
+	}
+
+	c.JSON(http.StatusOK, deal)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"finhub-backend/models"
+)
+
+var dealExportHeader = []string{
+	"deal_id", "deal_name", "pipeline", "stage", "company", "amount", "currency",
+	"probability", "expected_close_date", "actual_close_date",
+	"line_item_id", "sku", "product", "product_family", "quantity", "unit_price",
+	"discount_percent", "term_months", "total_price",
+}
+
+// ExportDeals writes the tenant's deals as CSV with one row per line item.
+// Deals without line items get a single row with the line item columns empty.
+func (h *DealHandler) ExportDeals(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	query := h.db.Where("tenant_id = ? AND is_deleted = ?", user.TenantID, false)
+	if pipelineID := c.Query("pipelineId"); pipelineID != "" {
+		query = query.Where("pipeline_id = ?", pipelineID)
+	}
+
+	var deals []models.Deal
+	if err := query.Preload("Pipeline").Preload("Stage").Preload("Company").
+		Scopes(preloadLineItems).
+		Order("created_at ASC").
+		Find(&deals).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deals"})
+		return
+	}
+
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", `attachment; filename="deals.csv"`)
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write(dealExportHeader)
+	for _, deal := range deals {
+		dealColumns := []string{
+			deal.ID,
+			deal.Name,
+			deal.Pipeline.Name,
+			deal.Stage.Name,
+			"",
+			formatFloatPtr(deal.Amount),
+			deal.Currency,
+			strconv.Itoa(deal.Probability),
+			formatDatePtr(deal.ExpectedCloseDate),
+			formatDatePtr(deal.ActualCloseDate),
+		}
+		if deal.Company != nil {
+			dealColumns[4] = deal.Company.Name
+		}
+
+		if len(deal.LineItems) == 0 {
+			writer.Write(append(dealColumns, make([]string, len(dealExportHeader)-len(dealColumns))...))
+			continue
+		}
+		for _, item := range deal.LineItems {
+			var sku, product, family string
+			if item.Product != nil {
+				sku, product = item.Product.SKU, item.Product.Name
+				if item.Product.Family != nil {
+					family = *item.Product.Family
+				}
+			}
+			termMonths := ""
+			if item.TermMonths != nil {
+				termMonths = strconv.Itoa(*item.TermMonths)
+			}
+			writer.Write(append(append([]string{}, dealColumns...),
+				item.ID,
+				sku,
+				product,
+				family,
+				strconv.FormatFloat(item.Quantity, 'f', -1, 64),
+				strconv.FormatFloat(item.UnitPrice, 'f', 2, 64),
+				strconv.FormatFloat(item.DiscountPercent, 'f', -1, 64),
+				termMonths,
+				strconv.FormatFloat(item.TotalPrice, 'f', 2, 64),
+			))
+		}
+	}
+	writer.Flush()
+}
+
+func formatFloatPtr(value *float64) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*value, 'f', 2, 64)
+}
+
+func formatDatePtr(value *time.Time) string {
+	if value == nil {
+		return ""
+	}
+	return value.Format("2006-01-02")
+}
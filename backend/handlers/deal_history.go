@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/models"
+)
+
+// DealChange carries the optional context recorded with a deal history row
+type DealChange struct {
+	MovedBy      *string
+	ChangeReason *string
+	Notes        *string
+}
+
+// recordDealChange writes a DealStageHistory row when the stage, amount,
+// probability, currency or expected close date of a deal changed between
+// before and after. Pass a nil before for newly created deals. It must be
+// called with the transaction that saved the deal.
+func recordDealChange(tx *gorm.DB, before *models.Deal, after *models.Deal, change DealChange) error {
+	history := models.DealStageHistory{
+		DealID:              after.ID,
+		ToStageID:           after.StageID,
+		ToAmount:            after.Amount,
+		ToProbability:       intPtr(after.Probability),
+		ToCurrency:          stringPtr(after.Currency),
+		ToExpectedCloseDate: after.ExpectedCloseDate,
+		ChangeReason:        change.ChangeReason,
+		Notes:               change.Notes,
+		MovedBy:             change.MovedBy,
+		MovedAt:             time.Now(),
+	}
+
+	if before != nil {
+		if before.StageID == after.StageID &&
+			equalFloatPtr(before.Amount, after.Amount) &&
+			before.Probability == after.Probability &&
+			before.Currency == after.Currency &&
+			equalTimePtr(before.ExpectedCloseDate, after.ExpectedCloseDate) {
+			return nil
+		}
+
+		history.FromStageID = stringPtr(before.StageID)
+		history.FromAmount = before.Amount
+		history.FromProbability = intPtr(before.Probability)
+		history.FromCurrency = stringPtr(before.Currency)
+		history.FromExpectedCloseDate = before.ExpectedCloseDate
+	}
+
+	return tx.Create(&history).Error
+}
+
+// GetDealHistory returns the stage history of a deal, newest first
+func (h *DealHandler) GetDealHistory(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	dealID := c.Param("id")
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var deal models.Deal
+	if err := h.db.Select("id").Where("id = ? AND tenant_id = ?", dealID, user.TenantID).
+		First(&deal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
+		return
+	}
+
+	var history []models.DealStageHistory
+	if err := h.db.Where("deal_id = ?", deal.ID).
+		Preload("FromStage").Preload("ToStage").
+		Order("moved_at DESC").
+		Find(&history).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deal history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
+
+// parseDate accepts either a plain date (2006-01-02) or an RFC 3339 timestamp.
+// An empty string yields nil, clearing the date.
+func parseDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func equalFloatPtr(a, b *float64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func equalTimePtr(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func equalStringPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
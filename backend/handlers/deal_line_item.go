@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/models"
+)
+
+type CreateDealLineItemRequest struct {
+	ProductID       string   `json:"productId" binding:"required"`
+	Description     *string  `json:"description"`
+	Quantity        *float64 `json:"quantity" binding:"omitempty,gt=0"`
+	UnitPrice       *float64 `json:"unitPrice" binding:"omitempty,min=0"`
+	DiscountPercent float64  `json:"discountPercent" binding:"min=0,max=100"`
+	TermMonths      *int     `json:"termMonths" binding:"omitempty,min=1"`
+	SortOrder       *int     `json:"sortOrder"`
+}
+
+type UpdateDealLineItemRequest struct {
+	Description     *string  `json:"description"`
+	Quantity        *float64 `json:"quantity" binding:"omitempty,gt=0"`
+	UnitPrice       *float64 `json:"unitPrice" binding:"omitempty,min=0"`
+	DiscountPercent *float64 `json:"discountPercent" binding:"omitempty,min=0,max=100"`
+	TermMonths      *int     `json:"termMonths" binding:"omitempty,min=1"`
+	SortOrder       *int     `json:"sortOrder"`
+}
+
+// lineItemTotal is quantity x unit price less the discount, in cents precision
+func lineItemTotal(quantity, unitPrice, discountPercent float64) float64 {
+	total := quantity * unitPrice * (1 - discountPercent/100)
+	return math.Round(total*100) / 100
+}
+
+// syncDealAmount sets the deal amount to the sum of its line items. Deals
+// without line items keep their manually entered amount.
+func syncDealAmount(tx *gorm.DB, deal *models.Deal) error {
+	var result struct {
+		Count int64
+		Total float64
+	}
+	if err := tx.Model(&models.DealLineItem{}).
+		Select("COUNT(*) as count, COALESCE(SUM(total_price), 0) as total").
+		Where("deal_id = ?", deal.ID).
+		Scan(&result).Error; err != nil {
+		return err
+	}
+	if result.Count == 0 {
+		return nil
+	}
+	total := math.Round(result.Total*100) / 100
+	deal.Amount = &total
+	return tx.Model(deal).Update("amount", total).Error
+}
+
+// loadDealLineItems returns the line items of the given deals keyed by deal ID
+func loadDealLineItems(db *gorm.DB, dealIDs []string) (map[string][]models.DealLineItem, error) {
+	byDeal := map[string][]models.DealLineItem{}
+	if len(dealIDs) == 0 {
+		return byDeal, nil
+	}
+	var items []models.DealLineItem
+	if err := db.Where("deal_id IN ?", dealIDs).
+		Preload("Product").
+		Order("sort_order ASC, created_at ASC").
+		Find(&items).Error; err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		byDeal[item.DealID] = append(byDeal[item.DealID], item)
+	}
+	return byDeal, nil
+}
+
+func (h *DealHandler) GetDealLineItems(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	dealID := c.Param("id")
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var deal models.Deal
+	if err := h.db.Select("id").Where("id = ? AND tenant_id = ? AND is_deleted = ?", dealID, user.TenantID, false).
+		First(&deal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
+		return
+	}
+
+	items, err := loadDealLineItems(h.db, []string{deal.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch line items"})
+		return
+	}
+	lineItems := items[deal.ID]
+	if lineItems == nil {
+		lineItems = []models.DealLineItem{}
+	}
+
+	c.JSON(http.StatusOK, lineItems)
+}
+
+// CreateDealLineItem adds a product to a deal. The unit price defaults to the
+// product's list price in the deal currency, taken from the deal's price book
+// or the tenant's default price book.
+func (h *DealHandler) CreateDealLineItem(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	dealID := c.Param("id")
+	var req CreateDealLineItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var deal models.Deal
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", dealID, user.TenantID, false).
+		First(&deal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
+		return
+	}
+	if pending, err := hasPendingApproval(h.db, deal.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch approvals"})
+		return
+	} else if pending {
+		respondValidationErrors(c, []ValidationError{pendingApprovalError})
+		return
+	}
+
+	var product models.Product
+	if err := h.db.Where("id = ? AND tenant_id = ?", req.ProductID, user.TenantID).First(&product).Error; err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Product not found"})
+		return
+	}
+	if !product.IsActive {
+		respondValidationErrors(c, []ValidationError{{Field: "productId", Code: "inactive", Message: "Product is not active"}})
+		return
+	}
+
+	unitPrice := req.UnitPrice
+	if unitPrice == nil {
+		price, err := listPrice(h.db, user.TenantID, deal.PriceBookID, product.ID, deal.Currency)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch list price"})
+			return
+		}
+		if price == nil {
+			respondValidationErrors(c, []ValidationError{{Field: "unitPrice", Code: "no_list_price", Message: "No list price for this product in " + deal.Currency}})
+			return
+		}
+		unitPrice = price
+	}
+
+	quantity := 1.0
+	if req.Quantity != nil {
+		quantity = *req.Quantity
+	}
+	item := models.DealLineItem{
+		DealID:          deal.ID,
+		ProductID:       product.ID,
+		Description:     req.Description,
+		Quantity:        quantity,
+		UnitPrice:       *unitPrice,
+		DiscountPercent: req.DiscountPercent,
+		TermMonths:      req.TermMonths,
+		TotalPrice:      lineItemTotal(quantity, *unitPrice, req.DiscountPercent),
+		TenantID:        user.TenantID,
+	}
+	if req.SortOrder != nil {
+		item.SortOrder = *req.SortOrder
+	} else {
+		var count int64
+		h.db.Model(&models.DealLineItem{}).Where("deal_id = ?", deal.ID).Count(&count)
+		item.SortOrder = int(count)
+	}
+
+	before := deal
+	userIDStr := userID.(string)
+	err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := lockDealWithoutApproval(tx, deal.ID); err != nil {
+			return err
+		}
+		if err := tx.Create(&item).Error; err != nil {
+			return err
+		}
+		if err := syncDealAmount(tx, &deal); err != nil {
+			return err
+		}
+		return recordDealChange(tx, &before, &deal, DealChange{MovedBy: &userIDStr})
+	})
+	if errors.Is(err, errApprovalPending) {
+		respondValidationErrors(c, []ValidationError{pendingApprovalError})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add line item"})
+		return
+	}
+
+	item.Product = &product
+	c.JSON(http.StatusCreated, gin.H{"lineItem": item, "dealAmount": deal.Amount})
+}
+
+func (h *DealHandler) UpdateDealLineItem(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	dealID := c.Param("id")
+	var req UpdateDealLineItemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var deal models.Deal
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", dealID, user.TenantID, false).
+		First(&deal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
+		return
+	}
+	if pending, err := hasPendingApproval(h.db, deal.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch approvals"})
+		return
+	} else if pending {
+		respondValidationErrors(c, []ValidationError{pendingApprovalError})
+		return
+	}
+
+	var item models.DealLineItem
+	if err := h.db.Where("id = ? AND deal_id = ?", c.Param("itemId"), deal.ID).First(&item).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Line item not found"})
+		return
+	}
+
+	if req.Description != nil {
+		item.Description = req.Description
+	}
+	if req.Quantity != nil {
+		item.Quantity = *req.Quantity
+	}
+	if req.UnitPrice != nil {
+		item.UnitPrice = *req.UnitPrice
+	}
+	if req.DiscountPercent != nil {
+		item.DiscountPercent = *req.DiscountPercent
+	}
+	if req.TermMonths != nil {
+		item.TermMonths = req.TermMonths
+	}
+	if req.SortOrder != nil {
+		item.SortOrder = *req.SortOrder
+	}
+	item.TotalPrice = lineItemTotal(item.Quantity, item.UnitPrice, item.DiscountPercent)
+
+	before := deal
+	userIDStr := userID.(string)
+	err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := lockDealWithoutApproval(tx, deal.ID); err != nil {
+			return err
+		}
+		if err := tx.Save(&item).Error; err != nil {
+			return err
+		}
+		if err := syncDealAmount(tx, &deal); err != nil {
+			return err
+		}
+		return recordDealChange(tx, &before, &deal, DealChange{MovedBy: &userIDStr})
+	})
+	if errors.Is(err, errApprovalPending) {
+		respondValidationErrors(c, []ValidationError{pendingApprovalError})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update line item"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lineItem": item, "dealAmount": deal.Amount})
+}
+
+// DeleteDealLineItem removes a line item. Removing the last one leaves the
+// deal amount at its last computed value, editable by hand again.
+func (h *DealHandler) DeleteDealLineItem(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	dealID := c.Param("id")
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var deal models.Deal
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", dealID, user.TenantID, false).
+		First(&deal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
+		return
+	}
+	if pending, err := hasPendingApproval(h.db, deal.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch approvals"})
+		return
+	} else if pending {
+		respondValidationErrors(c, []ValidationError{pendingApprovalError})
+		return
+	}
+
+	var item models.DealLineItem
+	if err := h.db.Where("id = ? AND deal_id = ?", c.Param("itemId"), deal.ID).First(&item).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Line item not found"})
+		return
+	}
+
+	before := deal
+	userIDStr := userID.(string)
+	err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := lockDealWithoutApproval(tx, deal.ID); err != nil {
+			return err
+		}
+		if err := tx.Delete(&item).Error; err != nil {
+			return err
+		}
+		if err := syncDealAmount(tx, &deal); err != nil {
+			return err
+		}
+		return recordDealChange(tx, &before, &deal, DealChange{MovedBy: &userIDStr})
+	})
+	if errors.Is(err, errApprovalPending) {
+		respondValidationErrors(c, []ValidationError{pendingApprovalError})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete line item"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Line item deleted successfully", "dealAmount": deal.Amount})
+}
+
+// validateLineItemDeal rejects edits that conflict with a deal's line items:
+// the amount is computed from them and they are priced in the deal currency
+func validateLineItemDeal(db *gorm.DB, before *models.Deal, after *models.Deal) ([]ValidationError, error) {
+	if equalFloatPtr(before.Amount, after.Amount) && before.Currency == after.Currency {
+		return nil, nil
+	}
+
+	var count int64
+	if err := db.Model(&models.DealLineItem{}).Where("deal_id = ?", after.ID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, nil
+	}
+
+	var errs []ValidationError
+	if !equalFloatPtr(before.Amount, after.Amount) {
+		errs = append(errs, ValidationError{Field: "amount", Code: "computed", Message: "The amount of a deal with line items is the sum of its line items"})
+	}
+	if before.Currency != after.Currency {
+		errs = append(errs, ValidationError{Field: "currency", Code: "line_items_currency", Message: "Remove the line items before changing the deal currency"})
+	}
+	return errs, nil
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/models"
+)
+
+// ValidationError describes a single rule a request failed
+type ValidationError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// respondValidationErrors writes the structured 422 response used for rule violations
+func respondValidationErrors(c *gin.Context, errs []ValidationError) {
+	c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Validation failed", "validationErrors": errs})
+}
+
+// dealRequiredFields maps the field names that can be required on entry to a
+// stage to a check that the deal has a value for them
+var dealRequiredFields = map[string]func(deal *models.Deal) bool{
+	"amount":            func(deal *models.Deal) bool { return deal.Amount != nil },
+	"expectedCloseDate": func(deal *models.Deal) bool { return deal.ExpectedCloseDate != nil },
+	"companyId":         func(deal *models.Deal) bool { return deal.CompanyID != nil && *deal.CompanyID != "" },
+	"contactId":         func(deal *models.Deal) bool { return deal.ContactID != nil && *deal.ContactID != "" },
+	"assignedUserId":    func(deal *models.Deal) bool { return deal.AssignedUserID != nil && *deal.AssignedUserID != "" },
+	"closeReasonId":     func(deal *models.Deal) bool { return deal.CloseReasonID != nil && *deal.CloseReasonID != "" },
+	"competitor":        func(deal *models.Deal) bool { return deal.Competitor != nil && *deal.Competitor != "" },
+}
+
+// validateRequiredFieldNames checks stage configuration against the fields that can be required
+func validateRequiredFieldNames(fields []string) error {
+	for _, field := range fields {
+		if _, ok := dealRequiredFields[field]; !ok {
+			return fmt.Errorf("unknown required field: %s", field)
+		}
+	}
+	return nil
+}
+
+// validateDealStage checks that a deal's pipeline and stage belong to the
+// tenant and each other, that a stage change is an allowed transition, and
+// that the fields required by a newly entered stage are set. Pass a nil
+// before for new deals.
+func validateDealStage(db *gorm.DB, tenantID string, before *models.Deal, after *models.Deal) ([]ValidationError, error) {
+	var errs []ValidationError
+
+	var pipeline models.Pipeline
+	if err := db.Where("id = ? AND tenant_id = ?", after.PipelineID, tenantID).First(&pipeline).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return []ValidationError{{Field: "pipelineId", Code: "invalid_pipeline", Message: "Pipeline not found"}}, nil
+		}
+		return nil, err
+	}
+	pipelineChanged := before == nil || before.PipelineID != after.PipelineID
+	if pipelineChanged && pipeline.ArchivedAt != nil {
+		errs = append(errs, ValidationError{Field: "pipelineId", Code: "pipeline_archived", Message: "Pipeline is archived"})
+	}
+
+	var stage models.Stage
+	if err := db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", after.StageID, tenantID, false).First(&stage).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return append(errs, ValidationError{Field: "stageId", Code: "invalid_stage", Message: "Stage not found"}), nil
+		}
+		return nil, err
+	}
+	if stage.PipelineID != after.PipelineID {
+		return append(errs, ValidationError{Field: "stageId", Code: "stage_not_in_pipeline", Message: "Stage does not belong to the deal's pipeline"}), nil
+	}
+
+	stageChanged := before == nil || before.StageID != after.StageID
+	if !stageChanged {
+		return errs, nil
+	}
+
+	if before != nil && !pipelineChanged {
+		var transitions []models.StageTransition
+		if err := db.Where("from_stage_id = ? AND tenant_id = ?", before.StageID, tenantID).Find(&transitions).Error; err != nil {
+			return nil, err
+		}
+		if len(transitions) > 0 {
+			allowed := false
+			for _, transition := range transitions {
+				if transition.ToStageID == after.StageID {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				errs = append(errs, ValidationError{
+					Field:   "stageId",
+					Code:    "transition_not_allowed",
+					Message: fmt.Sprintf("Deals cannot move directly to %s from their current stage", stage.Name),
+				})
+			}
+		}
+	}
+
+	for _, field := range stage.RequiredFields {
+		check, ok := dealRequiredFields[field]
+		if ok && !check(after) {
+			errs = append(errs, ValidationError{
+				Field:   field,
+				Code:    "required",
+				Message: fmt.Sprintf("%s is required to enter %s", field, stage.Name),
+			})
+		}
+	}
+
+	return errs, nil
+}
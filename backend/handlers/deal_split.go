@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/models"
+)
+
+// defaultSplitType is used for splits that don't name a type
+const defaultSplitType = "revenue"
+
+type DealSplitRequest struct {
+	SplitType  string  `json:"splitType"`
+	UserID     string  `json:"userId" binding:"required"`
+	Role       *string `json:"role"`
+	Percentage float64 `json:"percentage" binding:"gt=0,max=100"`
+}
+
+type SetDealSplitsRequest struct {
+	Splits []DealSplitRequest `json:"splits" binding:"dive"`
+}
+
+func (h *DealHandler) GetDealSplits(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	dealID := c.Param("id")
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var deal models.Deal
+	if err := h.db.Select("id").Where("id = ? AND tenant_id = ? AND is_deleted = ?", dealID, user.TenantID, false).
+		First(&deal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
+		return
+	}
+
+	var splits []models.DealSplit
+	if err := h.db.Where("deal_id = ?", deal.ID).
+		Preload("User").
+		Order("split_type ASC, percentage DESC").
+		Find(&splits).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch splits"})
+		return
+	}
+
+	c.JSON(http.StatusOK, splits)
+}
+
+// SetDealSplits replaces all splits on a deal. The percentages of each split
+// type must add up to 100.
+func (h *DealHandler) SetDealSplits(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	dealID := c.Param("id")
+	var req SetDealSplitsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var deal models.Deal
+	if err := h.db.Select("id").Where("id = ? AND tenant_id = ? AND is_deleted = ?", dealID, user.TenantID, false).
+		First(&deal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
+		return
+	}
+
+	var validationErrors []ValidationError
+	totals := map[string]float64{}
+	var types []string
+	seen := map[string]bool{}
+	splits := make([]models.DealSplit, 0, len(req.Splits))
+	for i, split := range req.Splits {
+		if split.SplitType == "" {
+			split.SplitType = defaultSplitType
+		}
+		field := fmt.Sprintf("splits[%d]", i)
+
+		key := split.SplitType + "|" + split.UserID
+		if seen[key] {
+			validationErrors = append(validationErrors, ValidationError{Field: field + ".userId", Code: "duplicate", Message: "User already has a " + split.SplitType + " split"})
+		}
+		seen[key] = true
+
+		var count int64
+		h.db.Model(&models.User{}).Where("id = ? AND tenant_id = ?", split.UserID, user.TenantID).Count(&count)
+		if count == 0 {
+			validationErrors = append(validationErrors, ValidationError{Field: field + ".userId", Code: "invalid_user", Message: "User not found"})
+		}
+
+		if _, ok := totals[split.SplitType]; !ok {
+			types = append(types, split.SplitType)
+		}
+		totals[split.SplitType] += split.Percentage
+		splits = append(splits, models.DealSplit{
+			DealID:     deal.ID,
+			SplitType:  split.SplitType,
+			UserID:     split.UserID,
+			Role:       split.Role,
+			Percentage: split.Percentage,
+			TenantID:   user.TenantID,
+		})
+	}
+	for _, splitType := range types {
+		if math.Abs(totals[splitType]-100) > 0.001 {
+			validationErrors = append(validationErrors, ValidationError{
+				Field:   "splits",
+				Code:    "invalid_total",
+				Message: fmt.Sprintf("%s splits add up to %g%%, not 100%%", splitType, totals[splitType]),
+			})
+		}
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("deal_id = ?", deal.ID).Delete(&models.DealSplit{}).Error; err != nil {
+			return err
+		}
+		if len(splits) == 0 {
+			return nil
+		}
+		return tx.Create(&splits).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save splits"})
+		return
+	}
+
+	c.JSON(http.StatusOK, splits)
+}
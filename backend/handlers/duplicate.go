@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/duplicates"
+	"finhub-backend/models"
+)
+
+// DuplicateHandler manages duplicate rules and reports potential
+// duplicates of records
+type DuplicateHandler struct {
+	db *gorm.DB
+}
+
+type DuplicateRuleRequest struct {
+	Name       string   `json:"name" binding:"required"`
+	EntityType string   `json:"entityType" binding:"required"`
+	Match      string   `json:"match" binding:"required"`
+	Action     string   `json:"action" binding:"required"`
+	Threshold  *float64 `json:"threshold"`
+	IsActive   *bool    `json:"isActive"`
+}
+
+// DuplicateCheckRequest describes a record before it is saved. ID excludes
+// an existing record from its own matches.
+type DuplicateCheckRequest struct {
+	EntityType string   `json:"entityType" binding:"required"`
+	ID         string   `json:"id"`
+	FirstName  string   `json:"firstName"`
+	LastName   string   `json:"lastName"`
+	CompanyID  *string  `json:"companyId"`
+	Name       string   `json:"name"`
+	Website    string   `json:"website"`
+	Domain     string   `json:"domain"`
+	Emails     []string `json:"emails"`
+	Phones     []string `json:"phones"`
+}
+
+func NewDuplicateHandler(db *gorm.DB) *DuplicateHandler {
+	return &DuplicateHandler{db: db}
+}
+
+func (h *DuplicateHandler) GetRules(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	query := h.db.Where("tenant_id = ?", user.TenantID)
+	if entityType := c.Query("entityType"); entityType != "" {
+		query = query.Where("entity_type = ?", entityType)
+	}
+	var rules []models.DuplicateRule
+	if err := query.Order("entity_type ASC, created_at ASC").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch duplicate rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+func (h *DuplicateHandler) CreateRule(c *gin.Context) {
+	h.saveRule(c, "")
+}
+
+func (h *DuplicateHandler) UpdateRule(c *gin.Context) {
+	h.saveRule(c, c.Param("id"))
+}
+
+// saveRule creates a rule, or replaces the one with the given ID. Only
+// administrators may change rules.
+func (h *DuplicateHandler) saveRule(c *gin.Context, ruleID string) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req DuplicateRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var validationErrors []ValidationError
+	switch req.EntityType {
+	case duplicates.EntityContact, duplicates.EntityLead, duplicates.EntityCompany:
+		if !duplicates.ValidMatch(req.EntityType, req.Match) {
+			validationErrors = append(validationErrors, ValidationError{Field: "match", Code: "invalid_match", Message: "Match must be email, phone, name_company or, for companies, domain"})
+		}
+	default:
+		validationErrors = append(validationErrors, ValidationError{Field: "entityType", Code: "invalid_entity_type", Message: "Entity type must be contact, lead or company"})
+	}
+	if req.Action != duplicates.ActionWarn && req.Action != duplicates.ActionBlock {
+		validationErrors = append(validationErrors, ValidationError{Field: "action", Code: "invalid_action", Message: "Action must be warn or block"})
+	}
+	if req.Threshold != nil && (*req.Threshold <= 0 || *req.Threshold > 1) {
+		validationErrors = append(validationErrors, ValidationError{Field: "threshold", Code: "invalid_threshold", Message: "Threshold must be above 0 and at most 1"})
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	rule := models.DuplicateRule{TenantID: user.TenantID, IsActive: true}
+	status := http.StatusCreated
+	if ruleID != "" {
+		if err := h.db.Where("id = ? AND tenant_id = ?", ruleID, user.TenantID).First(&rule).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Duplicate rule not found"})
+			return
+		}
+		status = http.StatusOK
+	}
+	rule.Name = req.Name
+	rule.EntityType = req.EntityType
+	rule.Match = req.Match
+	rule.Action = req.Action
+	rule.Threshold = nil
+	if req.Match == duplicates.MatchNameCompany {
+		rule.Threshold = req.Threshold
+	}
+	if req.IsActive != nil {
+		rule.IsActive = *req.IsActive
+	}
+
+	if err := h.db.WithContext(c).Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save duplicate rule"})
+		return
+	}
+
+	c.JSON(status, rule)
+}
+
+func (h *DuplicateHandler) DeleteRule(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	result := h.db.WithContext(c).Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).Delete(&models.DuplicateRule{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete duplicate rule"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Duplicate rule not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Duplicate rule deleted successfully"})
+}
+
+// CheckDuplicates reports the potential duplicates of a record before it is
+// saved, e.g. while a form is being filled in
+func (h *DuplicateHandler) CheckDuplicates(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req DuplicateCheckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	result, err := duplicates.Find(h.db, duplicates.Record{
+		EntityType: req.EntityType,
+		ID:         req.ID,
+		TenantID:   user.TenantID,
+		FirstName:  req.FirstName,
+		LastName:   req.LastName,
+		CompanyID:  req.CompanyID,
+		Name:       req.Name,
+		Website:    req.Website,
+		Domain:     req.Domain,
+		Emails:     req.Emails,
+		Phones:     req.Phones,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check duplicates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetDuplicates returns a handler listing the potential duplicates of a
+// stored record of the given entity type
+func (h *DuplicateHandler) GetDuplicates(entityType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		var user models.User
+		if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		record, err := duplicates.Load(h.db, entityType, c.Param("id"), user.TenantID)
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load record"})
+			return
+		}
+		result, err := duplicates.Find(h.db, record)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check duplicates"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// checkDuplicates runs the duplicate rules for a record about to be saved
+// and returns the matches to warn about. When a block rule matched it
+// responds 409 with the matches and returns false.
+func checkDuplicates(c *gin.Context, db *gorm.DB, record duplicates.Record) ([]duplicates.Match, bool) {
+	result, err := duplicates.Find(db, record)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check duplicates"})
+		return nil, false
+	}
+	if result.Blocked {
+		c.JSON(http.StatusConflict, gin.H{"error": "Record duplicates an existing record", "duplicates": result.Matches})
+		return nil, false
+	}
+	return result.Matches, true
+}
+
+// saveContactPoints adds email addresses and phone numbers to a new
+// record, the first of each as primary
+func saveContactPoints(tx *gorm.DB, entityType, entityID, tenantID string, emails, phones []string) error {
+	for i, email := range emails {
+		if err := tx.Create(&models.EmailAddress{Email: email, IsPrimary: i == 0, EntityID: entityID, EntityType: entityType, TenantID: tenantID}).Error; err != nil {
+			return err
+		}
+	}
+	for i, phone := range phones {
+		if err := tx.Create(&models.PhoneNumber{Number: phone, IsPrimary: i == 0, EntityID: entityID, EntityType: entityType, TenantID: tenantID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -4,11 +4,13 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 
+	"finhub-backend/currency"
 	"finhub-backend/models"
 )
 
@@ -24,6 +26,9 @@ type EntityQueryRequest struct {
 	SortOrder  string                 `json:"sortOrder"` // "asc" or "desc"
 	Filters    map[string]interface{} `json:"filters"`
 	View       string                 `json:"view"` // view configuration name
+
+	// "close" or "today" adds deal amounts converted into the reporting currency
+	ConvertAt string `json:"convertAt" binding:"omitempty,oneof=close today"`
 }
 
 type EntityQueryResponse struct {
@@ -35,6 +40,8 @@ type EntityQueryResponse struct {
 	HasMore    bool                     `json:"hasMore"`
 	SortBy     string                   `json:"sortBy"`
 	SortOrder  string                   `json:"sortOrder"`
+
+	ReportingCurrency string `json:"reportingCurrency,omitempty"`
 }
 
 type EntityViewConfig struct {
@@ -133,6 +140,25 @@ func (h *EntityHandler) GetEntityList(c *gin.Context) {
 		return
 	}
 
+	// Convert deal amounts into the reporting currency when asked to
+	var reportingCurrency string
+	if req.ConvertAt != "" && strings.ToLower(req.EntityType) == "deals" {
+		converter, err := currency.NewConverter(h.db, user.TenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load exchange rates"})
+			return
+		}
+		convertDealRows(entities, converter, req.ConvertAt)
+		reportingCurrency = converter.Target
+	}
+
+	if strings.ToLower(req.EntityType) == "deals" {
+		if err := attachDealLineItems(h.db, entities); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch line items"})
+			return
+		}
+	}
+
 	// Calculate pagination info
 	totalPages := int((totalCount + int64(req.PageSize) - 1) / int64(req.PageSize))
 	hasMore := req.Page < totalPages
@@ -146,6 +172,8 @@ func (h *EntityHandler) GetEntityList(c *gin.Context) {
 		HasMore:    hasMore,
 		SortBy:     req.SortBy,
 		SortOrder:  req.SortOrder,
+
+		ReportingCurrency: reportingCurrency,
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -235,8 +263,10 @@ func (h *EntityHandler) buildEntityQuery(entityType string, tenantID string, fil
 		query = h.db.Model(&models.Deal{}).
 			Select(`
 				deals.id, deals.name, deals.amount, deals.currency, deals.expected_close_date,
-				deals.probability, deals.created_at, deals.updated_at,
+				deals.probability, deals.actual_close_date, deals.competitor,
+				deals.created_at, deals.updated_at,
 				stages.name as stage_name,
+				deal_close_reasons.name as close_reason_name,
 				companies.name as company_name,
 				contacts.first_name as contact_first_name,
 				contacts.last_name as contact_last_name,
@@ -247,6 +277,7 @@ func (h *EntityHandler) buildEntityQuery(entityType string, tenantID string, fil
 			Joins("LEFT JOIN companies ON deals.company_id = companies.id").
 			Joins("LEFT JOIN contacts ON deals.contact_id = contacts.id").
 			Joins("LEFT JOIN users ON deals.assigned_user_id = users.id").
+			Joins("LEFT JOIN deal_close_reasons ON deals.close_reason_id = deal_close_reasons.id").
 			Where("deals.tenant_id = ?", tenantID)
 
 	default:
@@ -259,6 +290,57 @@ func (h *EntityHandler) buildEntityQuery(entityType string, tenantID string, fil
 	return query, nil
 }
 
+// convertDealRows adds converted_amount, in the converter's target
+// currency, to deal rows. convertAt selects the rate date (see currency.RateDate).
+// converted_amount is nil when the deal's currency has no known rate.
+func convertDealRows(rows []map[string]interface{}, converter *currency.Converter, convertAt string) {
+	now := time.Now()
+	for _, row := range rows {
+		amount, ok := toFloat(row["amount"])
+		if !ok {
+			row["converted_amount"] = nil
+			continue
+		}
+		code, _ := row["currency"].(string)
+		var actualCloseDate, expectedCloseDate *time.Time
+		if t, ok := row["actual_close_date"].(time.Time); ok {
+			actualCloseDate = &t
+		}
+		if t, ok := row["expected_close_date"].(time.Time); ok {
+			expectedCloseDate = &t
+		}
+		rateDate := currency.RateDate(convertAt, actualCloseDate, expectedCloseDate, now)
+		if converted, ok := converter.Convert(amount, code, rateDate); ok {
+			row["converted_amount"] = converted
+		} else {
+			row["converted_amount"] = nil
+		}
+	}
+}
+
+// attachDealLineItems adds each deal row's line items as line_items
+func attachDealLineItems(db *gorm.DB, rows []map[string]interface{}) error {
+	ids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if id, ok := row["id"].(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	items, err := loadDealLineItems(db, ids)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		id, _ := row["id"].(string)
+		lineItems := items[id]
+		if lineItems == nil {
+			lineItems = []models.DealLineItem{}
+		}
+		row["line_items"] = lineItems
+	}
+	return nil
+}
+
 // applyFilters applies the provided filters to the query
 func (h *EntityHandler) applyFilters(query *gorm.DB, filters map[string]interface{}) *gorm.DB {
 	if filters == nil {
@@ -282,6 +364,12 @@ func (h *EntityHandler) applyFilters(query *gorm.DB, filters map[string]interfac
 			query = query.Where("companies.industry_id = ?", value)
 		case "size_id":
 			query = query.Where("companies.size_id = ?", value)
+		case "territory_id":
+			// Companies in the territory or any of its child territories
+			query = query.Where("companies.id IN (SELECT company_id FROM company_territories WHERE territory_id IN ("+territorySubtreeSQL+"))", value)
+		case "company_tree_id":
+			// The company and all its subsidiaries
+			query = query.Where("companies.id IN ("+companySubtreeSQL+")", value)
 		case "status_id":
 			query = query.Where("leads.status_id = ?", value)
 		case "temperature_id":
@@ -300,6 +388,23 @@ func (h *EntityHandler) applyFilters(query *gorm.DB, filters map[string]interfac
 			query = query.Where("deals.amount >= ?", value)
 		case "amount_max":
 			query = query.Where("deals.amount <= ?", value)
+		case "outcome":
+			switch value {
+			case "won":
+				query = query.Where("stages.is_closed_won = ?", true)
+			case "lost":
+				query = query.Where("stages.is_closed_lost = ?", true)
+			case "open":
+				query = query.Where("stages.is_closed_won = ? AND stages.is_closed_lost = ?", false, false)
+			}
+		case "close_reason_id":
+			query = query.Where("deals.close_reason_id = ?", value)
+		case "competitor":
+			query = query.Where("deals.competitor ILIKE ?", fmt.Sprintf("%%%v%%", value))
+		case "closed_after":
+			query = query.Where("deals.actual_close_date >= ?", value)
+		case "closed_before":
+			query = query.Where("deals.actual_close_date <= ?", value)
 		}
 	}
 
@@ -325,6 +430,9 @@ func (h *EntityHandler) applySorting(query *gorm.DB, sortBy, sortOrder string) *
 		"amount":         "deals.amount",
 		"probability":    "deals.probability",
 		"expected_close": "deals.expected_close_date",
+		"actual_close":   "deals.actual_close_date",
+		"close_reason":   "deal_close_reasons.name",
+		"competitor":     "deals.competitor",
 		"created_at":     "created_at",
 		"updated_at":     "updated_at",
 		"contact_count":  "contact_count",
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/currency"
+	"finhub-backend/models"
+)
+
+type EntityAggregateRequest struct {
+	EntityType string                 `json:"entityType" binding:"required"`
+	GroupBy    []string               `json:"groupBy" binding:"required,min=1,max=3"`
+	Filters    map[string]interface{} `json:"filters"`
+
+	// Rate date for deal amounts, "close" (default) or "today"
+	ConvertAt string `json:"convertAt" binding:"omitempty,oneof=close today"`
+
+	// Credit deal amounts to owners by their splits of this type
+	SplitType string `json:"splitType"`
+}
+
+type EntityAggregateResponse struct {
+	EntityType        string                   `json:"entityType"`
+	GroupBy           []string                 `json:"groupBy"`
+	Groups            []map[string]interface{} `json:"groups"`
+	ReportingCurrency string                   `json:"reportingCurrency,omitempty"`
+	// Currencies of deals left out of the amounts for lack of a rate
+	UnconvertedCurrencies []string `json:"unconvertedCurrencies,omitempty"`
+}
+
+// aggregateDimensions lists the columns each entity type can be grouped by,
+// keyed by the name used in groupBy. The expressions refer to the tables
+// joined by aggregateBaseQuery.
+var aggregateDimensions = map[string]map[string]string{
+	"deals": {
+		"pipeline":             "pipelines.name",
+		"stage":                "stages.name",
+		"owner":                "TRIM(CONCAT(users.first_name, ' ', users.last_name))",
+		"company":              "companies.name",
+		"currency":             "deals.currency",
+		"outcome":              "CASE WHEN stages.is_closed_won THEN 'won' WHEN stages.is_closed_lost THEN 'lost' ELSE 'open' END",
+		"close_reason":         "deal_close_reasons.name",
+		"competitor":           "deals.competitor",
+		"close_month":          "TO_CHAR(deals.actual_close_date, 'YYYY-MM')",
+		"expected_close_month": "TO_CHAR(deals.expected_close_date, 'YYYY-MM')",
+	},
+	"leads": {
+		"status":      "lead_statuses.name",
+		"temperature": "lead_temperatures.name",
+		"source":      "leads.source",
+		"campaign":    "leads.campaign",
+		"company":     "companies.name",
+	},
+	"companies": {
+		"industry": "industries.name",
+		"size":     "company_sizes.name",
+	},
+	"contacts": {
+		"company":    "companies.name",
+		"department": "contacts.department",
+	},
+}
+
+// splitDimensions are the deal dimensions when amounts are credited by
+// split: owner is the split user, or the assigned user for deals without
+// splits of the type
+var splitDimensions = func() map[string]string {
+	dimensions := map[string]string{
+		"owner":      "TRIM(CONCAT(split_users.first_name, ' ', split_users.last_name))",
+		"split_role": "deal_splits.role",
+	}
+	for name, expr := range aggregateDimensions["deals"] {
+		if _, ok := dimensions[name]; !ok {
+			dimensions[name] = expr
+		}
+	}
+	return dimensions
+}()
+
+// splitMetrics weights deal amounts by split percentage
+const splitMetrics = "COUNT(DISTINCT deals.id) as count, COUNT(deals.amount) as amount_count, " +
+	"COALESCE(SUM(deals.amount * COALESCE(deal_splits.percentage, 100) / 100), 0) as total_amount"
+
+// aggregateMetrics are the values computed for every group
+var aggregateMetrics = map[string]string{
+	"deals":     "COUNT(*) as count, COUNT(deals.amount) as amount_count, COALESCE(SUM(deals.amount), 0) as total_amount",
+	"leads":     "COUNT(*) as count, AVG(leads.score) as average_score",
+	"companies": "COUNT(*) as count, COALESCE(SUM(companies.revenue), 0) as total_revenue",
+	"contacts":  "COUNT(*) as count",
+}
+
+// GetEntityAggregates groups an entity type by up to three dimensions and
+// returns counts and totals for each group. It accepts the same filters as
+// the entity query.
+func (h *EntityHandler) GetEntityAggregates(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req EntityAggregateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	entityType := strings.ToLower(req.EntityType)
+	dimensions, ok := aggregateDimensions[entityType]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unsupported entity type: %s", req.EntityType)})
+		return
+	}
+	metrics := aggregateMetrics[entityType]
+	if req.SplitType != "" {
+		if entityType != "deals" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "splitType only applies to deals"})
+			return
+		}
+		dimensions, metrics = splitDimensions, splitMetrics
+	}
+
+	selects := make([]string, 0, len(req.GroupBy)+1)
+	positions := make([]string, 0, len(req.GroupBy))
+	for i, name := range req.GroupBy {
+		expr, ok := dimensions[name]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("cannot group %s by %s", entityType, name)})
+			return
+		}
+		selects = append(selects, fmt.Sprintf("%s as %s", expr, name))
+		positions = append(positions, strconv.Itoa(i+1))
+	}
+	groupPositions := append([]string{}, positions...)
+	if entityType == "deals" {
+		// Deal amounts are summed per currency and rate date here and
+		// converted to the reporting currency afterwards
+		if req.ConvertAt == "" {
+			req.ConvertAt = currency.ConvertAtClose
+		}
+		rateDate := "CURRENT_DATE"
+		if req.ConvertAt == currency.ConvertAtClose {
+			rateDate = "COALESCE(deals.actual_close_date, deals.expected_close_date, CURRENT_DATE)::date"
+		}
+		selects = append(selects, "deals.currency as currency_code", rateDate+" as rate_date")
+		groupPositions = append(groupPositions, strconv.Itoa(len(positions)+1), strconv.Itoa(len(positions)+2))
+	}
+	selects = append(selects, metrics)
+
+	baseQuery := h.aggregateBaseQuery(entityType, user.TenantID)
+	if req.SplitType != "" {
+		baseQuery = baseQuery.
+			Joins("LEFT JOIN deal_splits ON deal_splits.deal_id = deals.id AND deal_splits.split_type = ?", req.SplitType).
+			Joins("LEFT JOIN users split_users ON split_users.id = COALESCE(deal_splits.user_id, deals.assigned_user_id)")
+	}
+	query := h.applyFilters(baseQuery, req.Filters).
+		Select(strings.Join(selects, ", ")).
+		Group(strings.Join(groupPositions, ", ")).
+		Order(strings.Join(positions, ", "))
+
+	groups, err := h.executeEntityQuery(entityType, query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate entities"})
+		return
+	}
+
+	response := EntityAggregateResponse{
+		EntityType: entityType,
+		GroupBy:    req.GroupBy,
+		Groups:     groups,
+	}
+	if entityType == "deals" {
+		converter, err := currency.NewConverter(h.db, user.TenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load exchange rates"})
+			return
+		}
+		unconverted := currency.Unconverted{}
+		response.Groups = mergeDealAmounts(groups, req.GroupBy, converter, unconverted)
+		response.ReportingCurrency = converter.Target
+		response.UnconvertedCurrencies = unconverted.List()
+	}
+	if response.Groups == nil {
+		response.Groups = []map[string]interface{}{}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// mergeDealAmounts combines deal groups that were split by currency and rate
+// date, converting their totals into the reporting currency. Amounts in
+// currencies without a rate are left out and their codes added to unconverted.
+func mergeDealAmounts(rows []map[string]interface{}, groupBy []string, converter *currency.Converter, unconverted currency.Unconverted) []map[string]interface{} {
+	var merged []map[string]interface{}
+	byKey := map[string]map[string]interface{}{}
+	amountCounts := map[string]float64{}
+
+	for _, row := range rows {
+		values := make([]interface{}, len(groupBy))
+		for i, name := range groupBy {
+			values[i] = row[name]
+		}
+		key := fmt.Sprintf("%#v", values)
+
+		group, ok := byKey[key]
+		if !ok {
+			group = map[string]interface{}{"count": 0.0, "total_amount": 0.0}
+			for _, name := range groupBy {
+				group[name] = row[name]
+			}
+			byKey[key] = group
+			merged = append(merged, group)
+		}
+
+		count, _ := toFloat(row["count"])
+		amountCount, _ := toFloat(row["amount_count"])
+		total, _ := toFloat(row["total_amount"])
+		code, _ := row["currency_code"].(string)
+		rateDate, _ := row["rate_date"].(time.Time)
+
+		group["count"] = group["count"].(float64) + count
+		if amount, ok := converter.Convert(total, code, rateDate); ok {
+			group["total_amount"] = group["total_amount"].(float64) + amount
+			amountCounts[key] += amountCount
+		} else if amountCount > 0 {
+			unconverted[code] = true
+		}
+	}
+
+	for key, group := range byKey {
+		group["average_amount"] = nil
+		if amountCounts[key] > 0 {
+			group["average_amount"] = group["total_amount"].(float64) / amountCounts[key]
+		}
+	}
+	return merged
+}
+
+// toFloat reads a numeric column value as returned by the database driver
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	case []byte:
+		f, err := strconv.ParseFloat(string(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// aggregateBaseQuery joins the tables referenced by aggregateDimensions for
+// the entity type, restricted to the tenant's live records
+func (h *EntityHandler) aggregateBaseQuery(entityType string, tenantID string) *gorm.DB {
+	switch entityType {
+	case "deals":
+		return h.db.Model(&models.Deal{}).
+			Joins("LEFT JOIN pipelines ON deals.pipeline_id = pipelines.id").
+			Joins("LEFT JOIN stages ON deals.stage_id = stages.id").
+			Joins("LEFT JOIN companies ON deals.company_id = companies.id").
+			Joins("LEFT JOIN users ON deals.assigned_user_id = users.id").
+			Joins("LEFT JOIN deal_close_reasons ON deals.close_reason_id = deal_close_reasons.id").
+			Where("deals.tenant_id = ? AND deals.is_deleted = ?", tenantID, false)
+	case "leads":
+		return h.db.Model(&models.Lead{}).
+			Joins("LEFT JOIN lead_statuses ON leads.status_id = lead_statuses.id").
+			Joins("LEFT JOIN lead_temperatures ON leads.temperature_id = lead_temperatures.id").
+			Joins("LEFT JOIN companies ON leads.company_id = companies.id").
+			Where("leads.tenant_id = ? AND leads.is_deleted = ?", tenantID, false)
+	case "companies":
+		return h.db.Model(&models.Company{}).
+			Joins("LEFT JOIN industries ON companies.industry_id = industries.id").
+			Joins("LEFT JOIN company_sizes ON companies.size_id = company_sizes.id").
+			Where("companies.tenant_id = ? AND companies.is_deleted = ?", tenantID, false)
+	default:
+		return h.db.Model(&models.Contact{}).
+			Joins("LEFT JOIN companies ON contacts.company_id = companies.id").
+			Where("contacts.tenant_id = ? AND contacts.is_deleted = ?", tenantID, false)
+	}
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/currency"
+	"finhub-backend/forecast"
+	"finhub-backend/models"
+)
+
+type ForecastHandler struct {
+	db *gorm.DB
+}
+
+func NewForecastHandler(db *gorm.DB) *ForecastHandler {
+	return &ForecastHandler{db: db}
+}
+
+// GetForecast returns closed won, commit, best case and weighted pipeline
+// figures per period (?period=month|quarter) between from and to, optionally
+// broken down by owner and pipeline (?groupBy=owner,pipeline). Amounts are in
+// the reporting currency, converted at the close date rate or, with
+// ?convertAt=today, at today's rate. ?splitType credits owners by deal split.
+func (h *ForecastHandler) GetForecast(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	periodType := c.DefaultQuery("period", forecast.PeriodMonth)
+	if periodType != forecast.PeriodMonth && periodType != forecast.PeriodQuarter {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "period must be month or quarter"})
+		return
+	}
+
+	convertAt := c.DefaultQuery("convertAt", currency.ConvertAtClose)
+	if convertAt != currency.ConvertAtClose && convertAt != currency.ConvertAtToday {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "convertAt must be close or today"})
+		return
+	}
+
+	opts := forecast.Options{
+		PeriodType: periodType,
+		OwnerID:    c.Query("ownerId"),
+		PipelineID: c.Query("pipelineId"),
+		ConvertAt:  convertAt,
+		SplitType:  c.Query("splitType"),
+	}
+	for _, group := range strings.Split(c.Query("groupBy"), ",") {
+		switch strings.TrimSpace(group) {
+		case "":
+		case "owner":
+			opts.GroupByOwner = true
+		case "pipeline":
+			opts.GroupByPipeline = true
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": "groupBy may only contain owner and pipeline"})
+			return
+		}
+	}
+
+	from, to, err := forecastRange(c, periodType)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	opts.From, opts.To = from, to
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	rows, err := forecast.Compute(h.db, user.TenantID, opts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute forecast"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"period": periodType,
+		"from":   from,
+		"to":     to,
+		"rows":   rows,
+	})
+}
+
+// GetSnapshots returns the weekly forecast snapshots taken between from and
+// to, optionally for a single forecast period (?forecastPeriod=2026-10),
+// owner or pipeline
+func (h *ForecastHandler) GetSnapshots(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	query := h.db.Where("tenant_id = ?", user.TenantID)
+	for param, column := range map[string]string{"from": "snapshot_date >= ?", "to": "snapshot_date <= ?"} {
+		if value := c.Query(param); value != "" {
+			date, err := parseDate(value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + param})
+				return
+			}
+			query = query.Where(column, *date)
+		}
+	}
+	if period := c.Query("forecastPeriod"); period != "" {
+		query = query.Where("period = ?", period)
+	}
+	if ownerID := c.Query("ownerId"); ownerID != "" {
+		query = query.Where("owner_id = ?", ownerID)
+	}
+	if pipelineID := c.Query("pipelineId"); pipelineID != "" {
+		query = query.Where("pipeline_id = ?", pipelineID)
+	}
+
+	var snapshots []models.ForecastSnapshot
+	if err := query.Order("snapshot_date ASC, period_start ASC").Find(&snapshots).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch forecast snapshots"})
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshots)
+}
+
+// CaptureSnapshot takes (or retakes) this week's forecast snapshot for the
+// tenant without waiting for the weekly job
+func (h *ForecastHandler) CaptureSnapshot(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	now := time.Now()
+	if err := forecast.CaptureTenantSnapshot(h.db, user.TenantID, now); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to capture forecast snapshot"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"snapshotDate": forecast.WeekStart(now)})
+}
+
+// forecastRange reads the from/to query parameters. By default the forecast
+// covers the current and next two months, or the current and next three
+// quarters.
+func forecastRange(c *gin.Context, periodType string) (time.Time, time.Time, error) {
+	from := forecast.PeriodStart(periodType, time.Now())
+	if value := c.Query("from"); value != "" {
+		date, err := parseDate(value)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid from")
+		}
+		from = *date
+	}
+
+	to := from.AddDate(0, 3, 0)
+	if periodType == forecast.PeriodQuarter {
+		to = from.AddDate(1, 0, 0)
+	}
+	if value := c.Query("to"); value != "" {
+		date, err := parseDate(value)
+		if err != nil {
+			return time.Time{}, time.Time{}, errors.New("invalid to")
+		}
+		to = *date
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, errors.New("from must be before to")
+	}
+	return from, to, nil
+}
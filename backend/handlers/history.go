@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/audit"
+	"finhub-backend/models"
+)
+
+type HistoryHandler struct {
+	db *gorm.DB
+}
+
+type HistoryResponse struct {
+	Entries    []models.ActivityLog `json:"entries"`
+	TotalCount int64                `json:"totalCount"`
+	Page       int                  `json:"page"`
+	PageSize   int                  `json:"pageSize"`
+	TotalPages int                  `json:"totalPages"`
+	HasMore    bool                 `json:"hasMore"`
+}
+
+type RestoreRequest struct {
+	Timestamp time.Time `json:"timestamp" binding:"required"`
+	Fields    []string  `json:"fields"`
+}
+
+type UndoUserChangesRequest struct {
+	UserID string    `json:"userId" binding:"required"`
+	From   time.Time `json:"from" binding:"required"`
+	To     time.Time `json:"to" binding:"required"`
+}
+
+func NewHistoryHandler(db *gorm.DB) *HistoryHandler {
+	return &HistoryHandler{db: db}
+}
+
+// GetHistory returns a handler serving the paginated change timeline of a
+// single record of the given entity type (e.g. "company", "deal")
+func (h *HistoryHandler) GetHistory(entityType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		entityID := c.Param("id")
+
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+			return
+		}
+		pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+		if err != nil || pageSize < 1 || pageSize > 200 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pageSize"})
+			return
+		}
+
+		var user models.User
+		if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		query := h.db.Model(&models.ActivityLog{}).
+			Where("tenant_id = ? AND entity_type = ? AND entity_id = ?", user.TenantID, entityType, entityID)
+
+		if field := c.Query("field"); field != "" {
+			query = query.Where("field_name = ?", field)
+		}
+
+		var totalCount int64
+		if err := query.Count(&totalCount).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count history"})
+			return
+		}
+
+		var entries []models.ActivityLog
+		if err := query.Order("created_at DESC, field_name ASC").
+			Offset((page - 1) * pageSize).Limit(pageSize).
+			Find(&entries).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch history"})
+			return
+		}
+
+		totalPages := int((totalCount + int64(pageSize) - 1) / int64(pageSize))
+
+		c.JSON(http.StatusOK, HistoryResponse{
+			Entries:    entries,
+			TotalCount: totalCount,
+			Page:       page,
+			PageSize:   pageSize,
+			TotalPages: totalPages,
+			HasMore:    page < totalPages,
+		})
+	}
+}
+
+// RestoreRecord returns a handler that reverts a record of the given entity
+// type, or selected fields of it, to its state at a point in time
+func (h *HistoryHandler) RestoreRecord(entityType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		entityID := c.Param("id")
+		var req RestoreRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var user models.User
+		if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		record, err := audit.NewRecord(entityType)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var result *audit.RestoreResult
+		err = h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("id = ? AND tenant_id = ?", entityID, user.TenantID).First(record).Error; err != nil {
+				return err
+			}
+			result, err = audit.RestoreTo(tx, record, req.Timestamp, req.Fields)
+			return err
+		})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Record not found"})
+			return
+		}
+		if errors.Is(err, audit.ErrReadOnly) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Record is read-only"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to restore record", "details": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"result": result, "record": record})
+	}
+}
+
+// UndoUserChanges reverts all changes a user made in a time window. Only
+// tenant administrators may use it.
+func (h *HistoryHandler) UndoUserChanges(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req UndoUserChangesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !req.From.Before(req.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must be before to"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var target models.User
+	if err := h.db.Select("id").Where("id = ? AND tenant_id = ?", req.UserID, user.TenantID).First(&target).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Target user not found"})
+		return
+	}
+
+	var results []audit.RestoreResult
+	err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var err error
+		results, err = audit.UndoUserChanges(tx, user.TenantID, target.ID, req.From, req.To)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to undo changes", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
@@ -6,7 +6,12 @@ import (
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 
+	"finhub-backend/assignment"
+	"finhub-backend/audit"
+	"finhub-backend/domains"
+	"finhub-backend/duplicates"
 	"finhub-backend/models"
+	"finhub-backend/scoring"
 )
 
 type LeadHandler struct {
@@ -24,6 +29,9 @@ type CreateLeadRequest struct {
 	Score          int     `json:"score"`
 	CompanyID      *string `json:"companyId"`
 	AssignedUserID *string `json:"assignedUserId"`
+
+	Emails []string `json:"emails" binding:"dive,email"`
+	Phones []string `json:"phones" binding:"dive,required"`
 }
 
 type UpdateLeadRequest struct {
@@ -39,6 +47,13 @@ type UpdateLeadRequest struct {
 	AssignedUserID *string `json:"assignedUserId"`
 }
 
+// LeadResponse is a saved lead with the potential duplicates warn rules
+// found
+type LeadResponse struct {
+	models.Lead
+	Duplicates []duplicates.Match `json:"duplicates,omitempty"`
+}
+
 func NewLeadHandler(db *gorm.DB) *LeadHandler {
 	return &LeadHandler{db: db}
 }
@@ -101,13 +116,49 @@ func (h *LeadHandler) CreateLead(c *gin.Context) {
 		TenantID:       user.TenantID,
 		CreatedBy:      &userIDStr,
 	}
+	// A work email links the lead to the company with its domain
+	if lead.CompanyID == nil {
+		companyID, err := domains.MatchCompany(h.db, user.TenantID, req.Emails)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to match company"})
+			return
+		}
+		lead.CompanyID = companyID
+	}
+
+	matches, ok := checkDuplicates(c, h.db, duplicates.Record{
+		EntityType: duplicates.EntityLead,
+		TenantID:   user.TenantID,
+		FirstName:  deref(lead.FirstName),
+		LastName:   deref(lead.LastName),
+		CompanyID:  lead.CompanyID,
+		Emails:     req.Emails,
+		Phones:     req.Phones,
+	})
+	if !ok {
+		return
+	}
 
-	if err := h.db.Create(&lead).Error; err != nil {
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&lead).Error; err != nil {
+			return err
+		}
+		if err := saveContactPoints(tx, "lead", lead.ID, user.TenantID, req.Emails, req.Phones); err != nil {
+			return err
+		}
+		// Routing and scoring commit with the lead, so a failure cannot
+		// leave it created but unassigned
+		if _, err := assignment.AssignLead(tx, &lead, assignment.TriggerCreate); err != nil {
+			return err
+		}
+		return scoring.ScoreLead(tx, lead.ID)
+	}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create lead"})
 		return
 	}
+	h.db.First(&lead, "id = ?", lead.ID)
 
-	c.JSON(http.StatusCreated, lead)
+	c.JSON(http.StatusCreated, LeadResponse{Lead: lead, Duplicates: matches})
 }
 
 func (h *LeadHandler) GetLead(c *gin.Context) {
@@ -162,6 +213,10 @@ func (h *LeadHandler) UpdateLead(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Lead not found"})
 		return
 	}
+	if lead.ConvertedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Converted leads are read-only"})
+		return
+	}
 
 	// Update fields
 	if req.FirstName != nil {
@@ -195,12 +250,31 @@ func (h *LeadHandler) UpdateLead(c *gin.Context) {
 		lead.AssignedUserID = req.AssignedUserID
 	}
 
-	if err := h.db.Save(&lead).Error; err != nil {
+	var matches []duplicates.Match
+	if req.FirstName != nil || req.LastName != nil || req.CompanyID != nil {
+		record, err := duplicates.Load(h.db, duplicates.EntityLead, lead.ID, user.TenantID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check duplicates"})
+			return
+		}
+		record.FirstName, record.LastName, record.CompanyID = deref(lead.FirstName), deref(lead.LastName), lead.CompanyID
+		var ok bool
+		if matches, ok = checkDuplicates(c, h.db, record); !ok {
+			return
+		}
+	}
+
+	if err := h.db.WithContext(c).Save(&lead).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update lead"})
 		return
 	}
+	if err := scoring.ScoreLead(h.db.WithContext(c), lead.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to score lead"})
+		return
+	}
+	h.db.First(&lead, "id = ?", lead.ID)
 
-	c.JSON(http.StatusOK, lead)
+	c.JSON(http.StatusOK, LeadResponse{Lead: lead, Duplicates: matches})
 }
 
 func (h *LeadHandler) DeleteLead(c *gin.Context) {
@@ -227,7 +301,7 @@ func (h *LeadHandler) DeleteLead(c *gin.Context) {
 
 	// Soft delete
 	lead.IsDeleted = true
-	if err := h.db.Save(&lead).Error; err != nil {
+	if err := h.db.WithContext(c).Set(audit.ActionKey, audit.ActionDelete).Save(&lead).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete lead"})
 		return
 	}
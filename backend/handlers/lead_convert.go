@@ -0,0 +1,543 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"finhub-backend/currency"
+	"finhub-backend/domains"
+	"finhub-backend/models"
+)
+
+type ConvertLeadRequest struct {
+	// Company to convert into. Without one the lead's company is used, then a
+	// company named CompanyName is matched or created.
+	CompanyID   *string `json:"companyId"`
+	CompanyName *string `json:"companyName"`
+
+	// Contact to convert into. Without one the lead's contact is used, then a
+	// contact with one of the lead's email addresses is matched or one is
+	// created from the lead.
+	ContactID *string `json:"contactId"`
+
+	// Creates a deal when present
+	Deal *ConvertLeadDealRequest `json:"deal"`
+}
+
+type ConvertLeadDealRequest struct {
+	Name              *string  `json:"name"`
+	Amount            *float64 `json:"amount"`
+	Currency          string   `json:"currency"`
+	PipelineID        string   `json:"pipelineId" binding:"required"`
+	StageID           string   `json:"stageId" binding:"required"`
+	ExpectedCloseDate *string  `json:"expectedCloseDate"`
+}
+
+// ConversionResult lists the records a conversion created and matched, and
+// how many lead rows were carried over to the contact
+type ConversionResult struct {
+	Lead        models.Lead            `json:"lead"`
+	Created     map[string]interface{} `json:"created"`
+	Matched     map[string]interface{} `json:"matched"`
+	CarriedOver map[string]int         `json:"carriedOver"`
+}
+
+var errLeadConverted = errors.New("lead already converted")
+
+// errConversionInvalid carries validation errors found inside the conversion
+// transaction
+type errConversionInvalid []ValidationError
+
+func (e errConversionInvalid) Error() string { return "invalid conversion" }
+
+// ConvertLead converts a lead into a company, contact and optionally a deal.
+// Phone numbers, email addresses, addresses and communications of the lead
+// are carried over to the contact, the lead's conversion fields are set and
+// it becomes read-only. Everything happens in one transaction.
+func (h *LeadHandler) ConvertLead(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	leadID := c.Param("id")
+	var req ConvertLeadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var expectedCloseDate *time.Time
+	if req.Deal != nil && req.Deal.ExpectedCloseDate != nil {
+		date, err := parseDate(*req.Deal.ExpectedCloseDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expectedCloseDate"})
+			return
+		}
+		expectedCloseDate = date
+	}
+
+	userIDStr := userID.(string)
+	result := ConversionResult{
+		Created:     map[string]interface{}{},
+		Matched:     map[string]interface{}{},
+		CarriedOver: map[string]int{},
+	}
+
+	err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		lead := &result.Lead
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND tenant_id = ? AND is_deleted = ?", leadID, user.TenantID, false).
+			First(lead).Error; err != nil {
+			return err
+		}
+		if lead.ConvertedAt != nil {
+			return errLeadConverted
+		}
+
+		var emails []models.EmailAddress
+		if err := tx.Where("entity_type = ? AND entity_id = ?", "lead", lead.ID).Order("is_primary DESC").Find(&emails).Error; err != nil {
+			return err
+		}
+
+		company, companyCreated, err := convertLeadCompany(tx, lead, &req, emails, userIDStr)
+		if err != nil {
+			return err
+		}
+		if company != nil {
+			if companyCreated {
+				result.Created["company"] = company
+			} else {
+				result.Matched["company"] = company
+			}
+		}
+
+		contact, contactCreated, err := convertLeadContact(tx, lead, &req, company, emails, userIDStr)
+		if err != nil {
+			return err
+		}
+		if contactCreated {
+			result.Created["contact"] = contact
+		} else {
+			result.Matched["contact"] = contact
+		}
+
+		if err := carryOverContactInfo(tx, lead, contact, company, companyCreated, result.CarriedOver); err != nil {
+			return err
+		}
+
+		var deal *models.Deal
+		if req.Deal != nil {
+			deal, err = convertLeadDeal(tx, lead, req.Deal, company, contact, expectedCloseDate, userIDStr)
+			if err != nil {
+				return err
+			}
+			result.Created["deal"] = deal
+		}
+
+		// Communications stay linked to the lead and gain the contact and deal
+		communications := map[string]interface{}{"contact_id": gorm.Expr("COALESCE(contact_id, ?)", contact.ID)}
+		if deal != nil {
+			communications["deal_id"] = gorm.Expr("COALESCE(deal_id, ?)", deal.ID)
+		}
+		update := tx.Model(&models.Communication{}).Where("lead_id = ?", lead.ID).Updates(communications)
+		if update.Error != nil {
+			return update.Error
+		}
+		result.CarriedOver["communications"] = int(update.RowsAffected)
+
+		now := time.Now()
+		lead.ConvertedAt = &now
+		if deal != nil {
+			lead.ConvertedToDealID = &deal.ID
+		}
+		if company != nil {
+			lead.CompanyID = &company.ID
+		}
+		// Contacts can only be linked to one lead
+		var linked int64
+		if err := tx.Model(&models.Lead{}).Where("contact_id = ? AND id <> ?", contact.ID, lead.ID).Count(&linked).Error; err != nil {
+			return err
+		}
+		if linked == 0 {
+			lead.ContactID = &contact.ID
+		}
+		var status models.LeadStatus
+		if err := tx.Where("tenant_id = ? AND code = ?", lead.TenantID, "CONVERTED").First(&status).Error; err == nil {
+			lead.StatusID = &status.ID
+		}
+		return tx.Save(lead).Error
+	})
+	if err != nil {
+		var invalid errConversionInvalid
+		switch {
+		case errors.As(err, &invalid):
+			respondValidationErrors(c, invalid)
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lead not found"})
+		case errors.Is(err, errLeadConverted):
+			c.JSON(http.StatusConflict, gin.H{"error": "Lead has already been converted"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to convert lead"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// convertLeadCompany resolves the company a lead converts into. It returns
+// nil when the lead has no company and none was named.
+func convertLeadCompany(tx *gorm.DB, lead *models.Lead, req *ConvertLeadRequest, emails []models.EmailAddress, userID string) (*models.Company, bool, error) {
+	companyID := req.CompanyID
+	if companyID == nil {
+		companyID = lead.CompanyID
+	}
+	if companyID != nil {
+		var company models.Company
+		if err := tx.Where("id = ? AND tenant_id = ? AND is_deleted = ?", *companyID, lead.TenantID, false).First(&company).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, false, errConversionInvalid{{Field: "companyId", Code: "invalid_company", Message: "Company not found"}}
+			}
+			return nil, false, err
+		}
+		return &company, false, nil
+	}
+
+	if req.CompanyName == nil || strings.TrimSpace(*req.CompanyName) == "" {
+		return nil, false, nil
+	}
+	name := strings.TrimSpace(*req.CompanyName)
+
+	var company models.Company
+	err := tx.Where("tenant_id = ? AND is_deleted = ? AND LOWER(name) = LOWER(?)", lead.TenantID, false, name).
+		Order("created_at ASC").
+		First(&company).Error
+	if err == nil {
+		return &company, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, err
+	}
+
+	company = models.Company{
+		Name:           name,
+		AssignedUserID: lead.AssignedUserID,
+		TenantID:       lead.TenantID,
+		CreatedBy:      &userID,
+	}
+	if len(emails) > 0 {
+		if domain := domains.FromEmail(emails[0].Email); domain != "" {
+			taken, err := domains.Taken(tx, lead.TenantID, domain, "")
+			if err != nil {
+				return nil, false, err
+			}
+			if !taken {
+				company.Domain = &domain
+			}
+		}
+	}
+	// The domain may have been taken since it was checked; the company is
+	// then created without one
+	err = tx.Transaction(func(tx *gorm.DB) error { return tx.Create(&company).Error })
+	if domains.IsConflict(err) {
+		company.Domain = nil
+		err = tx.Create(&company).Error
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &company, true, nil
+}
+
+// convertLeadContact resolves the contact a lead converts into
+func convertLeadContact(tx *gorm.DB, lead *models.Lead, req *ConvertLeadRequest, company *models.Company, emails []models.EmailAddress, userID string) (*models.Contact, bool, error) {
+	contactID := req.ContactID
+	if contactID == nil {
+		contactID = lead.ContactID
+	}
+	if contactID != nil {
+		var contact models.Contact
+		if err := tx.Where("id = ? AND tenant_id = ? AND is_deleted = ?", *contactID, lead.TenantID, false).First(&contact).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, false, errConversionInvalid{{Field: "contactId", Code: "invalid_contact", Message: "Contact not found"}}
+			}
+			return nil, false, err
+		}
+		return &contact, false, nil
+	}
+
+	if len(emails) > 0 {
+		addresses := make([]string, len(emails))
+		for i, email := range emails {
+			addresses[i] = strings.ToLower(email.Email)
+		}
+		var contact models.Contact
+		err := tx.Joins("JOIN email_addresses ON email_addresses.entity_id = contacts.id AND email_addresses.entity_type = 'contact'").
+			Where("contacts.tenant_id = ? AND contacts.is_deleted = ? AND LOWER(email_addresses.email) IN ?", lead.TenantID, false, addresses).
+			Order("contacts.created_at ASC").
+			First(&contact).Error
+		if err == nil {
+			return &contact, false, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, err
+		}
+	}
+
+	if lead.FirstName == nil || lead.LastName == nil || *lead.FirstName == "" || *lead.LastName == "" {
+		return nil, false, errConversionInvalid{{Field: "lastName", Code: "required", Message: "The lead needs a first and last name to create a contact"}}
+	}
+	contact := models.Contact{
+		FirstName:      *lead.FirstName,
+		LastName:       *lead.LastName,
+		JobTitle:       lead.Title,
+		OriginalSource: lead.Source,
+		TenantID:       lead.TenantID,
+		CreatedBy:      &userID,
+	}
+	if company != nil {
+		contact.CompanyID = &company.ID
+	}
+	if err := tx.Create(&contact).Error; err != nil {
+		return nil, false, err
+	}
+	return &contact, true, nil
+}
+
+// carryOverContactInfo copies the lead's phone numbers, email addresses,
+// addresses and social media accounts to the contact, skipping ones the
+// contact already has. A newly created company also gets the lead's primary
+// address. Rows are only marked primary when the contact has no primary row
+// of that kind.
+func carryOverContactInfo(tx *gorm.DB, lead *models.Lead, contact *models.Contact, company *models.Company, companyCreated bool, counts map[string]int) error {
+	var phones, existingPhones []models.PhoneNumber
+	if err := tx.Where("entity_type = ? AND entity_id = ?", "lead", lead.ID).Find(&phones).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("entity_type = ? AND entity_id = ?", "contact", contact.ID).Find(&existingPhones).Error; err != nil {
+		return err
+	}
+	seen, hasPrimary := map[string]bool{}, false
+	for _, phone := range existingPhones {
+		seen[phoneDigits(phone.Number)] = true
+		hasPrimary = hasPrimary || phone.IsPrimary
+	}
+	for _, phone := range phones {
+		if seen[phoneDigits(phone.Number)] {
+			continue
+		}
+		seen[phoneDigits(phone.Number)] = true
+		phone.ID, phone.EntityType, phone.EntityID = "", "contact", contact.ID
+		phone.IsPrimary = phone.IsPrimary && !hasPrimary
+		hasPrimary = hasPrimary || phone.IsPrimary
+		if err := tx.Create(&phone).Error; err != nil {
+			return err
+		}
+		counts["phoneNumbers"]++
+	}
+
+	var emails, existingEmails []models.EmailAddress
+	if err := tx.Where("entity_type = ? AND entity_id = ?", "lead", lead.ID).Find(&emails).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("entity_type = ? AND entity_id = ?", "contact", contact.ID).Find(&existingEmails).Error; err != nil {
+		return err
+	}
+	seen, hasPrimary = map[string]bool{}, false
+	for _, email := range existingEmails {
+		seen[strings.ToLower(email.Email)] = true
+		hasPrimary = hasPrimary || email.IsPrimary
+	}
+	for _, email := range emails {
+		if seen[strings.ToLower(email.Email)] {
+			continue
+		}
+		seen[strings.ToLower(email.Email)] = true
+		email.ID, email.EntityType, email.EntityID = "", "contact", contact.ID
+		email.IsPrimary = email.IsPrimary && !hasPrimary
+		hasPrimary = hasPrimary || email.IsPrimary
+		if err := tx.Create(&email).Error; err != nil {
+			return err
+		}
+		counts["emailAddresses"]++
+	}
+
+	var addresses, existingAddresses []models.Address
+	if err := tx.Where("entity_type = ? AND entity_id = ?", "lead", lead.ID).Order("is_primary DESC").Find(&addresses).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("entity_type = ? AND entity_id = ?", "contact", contact.ID).Find(&existingAddresses).Error; err != nil {
+		return err
+	}
+	seen, hasPrimary = map[string]bool{}, false
+	for _, address := range existingAddresses {
+		seen[addressKey(address)] = true
+		hasPrimary = hasPrimary || address.IsPrimary
+	}
+	for i, address := range addresses {
+		if companyCreated && company != nil && i == 0 {
+			companyAddress := address
+			companyAddress.ID, companyAddress.EntityType, companyAddress.EntityID = "", "company", company.ID
+			companyAddress.IsPrimary = true
+			if err := tx.Create(&companyAddress).Error; err != nil {
+				return err
+			}
+		}
+		if seen[addressKey(address)] {
+			continue
+		}
+		seen[addressKey(address)] = true
+		address.ID, address.EntityType, address.EntityID = "", "contact", contact.ID
+		address.IsPrimary = address.IsPrimary && !hasPrimary
+		hasPrimary = hasPrimary || address.IsPrimary
+		if err := tx.Create(&address).Error; err != nil {
+			return err
+		}
+		counts["addresses"]++
+	}
+
+	var accounts, existingAccounts []models.SocialMediaAccount
+	if err := tx.Where("entity_type = ? AND entity_id = ?", "lead", lead.ID).Find(&accounts).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("entity_type = ? AND entity_id = ?", "contact", contact.ID).Find(&existingAccounts).Error; err != nil {
+		return err
+	}
+	seen = map[string]bool{}
+	for _, account := range existingAccounts {
+		seen[socialKey(account)] = true
+	}
+	for _, account := range accounts {
+		if seen[socialKey(account)] {
+			continue
+		}
+		seen[socialKey(account)] = true
+		account.ID, account.EntityType, account.EntityID = "", "contact", contact.ID
+		if err := tx.Create(&account).Error; err != nil {
+			return err
+		}
+		counts["socialMediaAccounts"]++
+	}
+	return nil
+}
+
+// convertLeadDeal creates the deal of a conversion in the chosen pipeline and
+// stage, owned by the lead's owner
+func convertLeadDeal(tx *gorm.DB, lead *models.Lead, req *ConvertLeadDealRequest, company *models.Company, contact *models.Contact, expectedCloseDate *time.Time, userID string) (*models.Deal, error) {
+	deal := models.Deal{
+		Amount:            req.Amount,
+		Currency:          req.Currency,
+		PipelineID:        req.PipelineID,
+		StageID:           req.StageID,
+		ExpectedCloseDate: expectedCloseDate,
+		ContactID:         &contact.ID,
+		AssignedUserID:    lead.AssignedUserID,
+		TenantID:          lead.TenantID,
+		CreatedBy:         &userID,
+	}
+	if deal.AssignedUserID == nil {
+		deal.AssignedUserID = &userID
+	}
+	switch {
+	case req.Name != nil && *req.Name != "":
+		deal.Name = *req.Name
+	case company != nil:
+		deal.Name = company.Name
+	default:
+		deal.Name = contact.FirstName + " " + contact.LastName
+	}
+	if company != nil {
+		deal.CompanyID = &company.ID
+	}
+
+	if deal.Currency == "" {
+		code, err := currency.ReportingCurrency(tx, lead.TenantID)
+		if err != nil {
+			return nil, err
+		}
+		deal.Currency = code
+	} else if deal.Currency = currency.Normalize(deal.Currency); !currency.Valid(deal.Currency) {
+		return nil, errConversionInvalid{{Field: "deal.currency", Code: "invalid_currency", Message: "Invalid ISO 4217 currency code"}}
+	}
+
+	validationErrors, err := validateDealStage(tx, lead.TenantID, nil, &deal)
+	if err != nil {
+		return nil, err
+	}
+	closedErrors, err := validateClosedStageChange(tx, nil, &deal)
+	if err != nil {
+		return nil, err
+	}
+	validationErrors = append(validationErrors, closedErrors...)
+	if len(validationErrors) > 0 {
+		for i := range validationErrors {
+			validationErrors[i].Field = "deal." + validationErrors[i].Field
+		}
+		return nil, errConversionInvalid(validationErrors)
+	}
+
+	var stage models.Stage
+	if err := tx.Select("probability").First(&stage, "id = ?", deal.StageID).Error; err != nil {
+		return nil, err
+	}
+	deal.Probability = stage.Probability
+
+	if err := tx.Create(&deal).Error; err != nil {
+		return nil, err
+	}
+	reason := "lead_conversion"
+	if err := recordDealChange(tx, nil, &deal, DealChange{MovedBy: &userID, ChangeReason: &reason}); err != nil {
+		return nil, err
+	}
+	return &deal, nil
+}
+
+// phoneDigits reduces a phone number to its digits for comparison
+func phoneDigits(number string) string {
+	var b strings.Builder
+	for _, r := range number {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func addressKey(address models.Address) string {
+	parts := []*string{address.Street1, address.PostalCode, address.Country}
+	key := make([]string, len(parts))
+	for i, part := range parts {
+		if part != nil {
+			key[i] = strings.ToLower(strings.TrimSpace(*part))
+		}
+	}
+	return strings.Join(key, "|")
+}
+
+func socialKey(account models.SocialMediaAccount) string {
+	typeID, url, username := "", "", ""
+	if account.TypeID != nil {
+		typeID = *account.TypeID
+	}
+	if account.URL != nil {
+		url = strings.ToLower(*account.URL)
+	}
+	if account.Username != nil {
+		username = strings.ToLower(*account.Username)
+	}
+	return typeID + "|" + url + "|" + username
+}
@@ -0,0 +1,442 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/models"
+	"finhub-backend/scoring"
+)
+
+// LeadScoringHandler manages lead scoring rules and thresholds, score
+// breakdowns and the marketing interactions rules count
+type LeadScoringHandler struct {
+	db *gorm.DB
+}
+
+type LeadScoringRuleRequest struct {
+	Name       string   `json:"name" binding:"required"`
+	Attribute  string   `json:"attribute" binding:"required"`
+	Values     []string `json:"values"`
+	MinCount   int      `json:"minCount" binding:"min=0"`
+	WithinDays *int     `json:"withinDays" binding:"omitempty,min=1"`
+	Points     int      `json:"points"`
+	Order      int      `json:"order"`
+	IsActive   *bool    `json:"isActive"`
+}
+
+type LeadScoreThresholdRequest struct {
+	MinScore      int    `json:"minScore"`
+	TemperatureID string `json:"temperatureId" binding:"required"`
+}
+
+type UpdateLeadScoreThresholdsRequest struct {
+	Thresholds []LeadScoreThresholdRequest `json:"thresholds" binding:"dive"`
+}
+
+type CreateMarketingInteractionRequest struct {
+	Type       string     `json:"type" binding:"required,oneof=view click conversion"`
+	LeadID     *string    `json:"leadId"`
+	ContactID  *string    `json:"contactId"`
+	OccurredAt *time.Time `json:"occurredAt"`
+}
+
+func NewLeadScoringHandler(db *gorm.DB) *LeadScoringHandler {
+	return &LeadScoringHandler{db: db}
+}
+
+func (h *LeadScoringHandler) GetRules(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var rules []models.LeadScoringRule
+	if err := h.db.Where("tenant_id = ?", user.TenantID).
+		Order("\"order\" ASC, name ASC").
+		Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch scoring rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+func (h *LeadScoringHandler) CreateRule(c *gin.Context) {
+	h.saveRule(c, "")
+}
+
+func (h *LeadScoringHandler) UpdateRule(c *gin.Context) {
+	h.saveRule(c, c.Param("id"))
+}
+
+// saveRule creates a rule, or replaces the one with the given ID, and
+// rescores the tenant's open leads. Only administrators may change rules.
+func (h *LeadScoringHandler) saveRule(c *gin.Context, ruleID string) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req LeadScoringRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	if validationErrors := h.validateRule(user.TenantID, &req); len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	rule := models.LeadScoringRule{TenantID: user.TenantID, IsActive: true}
+	status := http.StatusCreated
+	if ruleID != "" {
+		if err := h.db.Where("id = ? AND tenant_id = ?", ruleID, user.TenantID).First(&rule).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Scoring rule not found"})
+			return
+		}
+		status = http.StatusOK
+	}
+	rule.Name = req.Name
+	rule.Attribute = req.Attribute
+	rule.Values = req.Values
+	rule.MinCount = req.MinCount
+	if rule.MinCount == 0 {
+		rule.MinCount = 1
+	}
+	rule.WithinDays = req.WithinDays
+	rule.Points = req.Points
+	rule.Order = req.Order
+	if req.IsActive != nil {
+		rule.IsActive = *req.IsActive
+	}
+
+	if err := h.db.WithContext(c).Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save scoring rule"})
+		return
+	}
+	if err := scoring.ScoreTenant(h.db.WithContext(c), user.TenantID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rescore leads"})
+		return
+	}
+
+	c.JSON(status, rule)
+}
+
+func (h *LeadScoringHandler) validateRule(tenantID string, req *LeadScoringRuleRequest) []ValidationError {
+	var validationErrors []ValidationError
+	known := false
+	for _, attribute := range scoring.Attributes {
+		known = known || attribute == req.Attribute
+	}
+	switch {
+	case !known:
+		validationErrors = append(validationErrors, ValidationError{Field: "attribute", Code: "invalid_attribute", Message: fmt.Sprintf("Unknown attribute %q", req.Attribute)})
+	case scoring.IsActivity(req.Attribute):
+		if len(req.Values) > 0 {
+			validationErrors = append(validationErrors, ValidationError{Field: "values", Code: "not_allowed", Message: "Activity rules match on minCount and withinDays, not values"})
+		}
+	case len(req.Values) == 0:
+		validationErrors = append(validationErrors, ValidationError{Field: "values", Code: "required", Message: "Set at least one value to match"})
+	case req.Attribute == scoring.AttributeEmailDomainType:
+		for _, value := range req.Values {
+			if value != "free" && value != "business" {
+				validationErrors = append(validationErrors, ValidationError{Field: "values", Code: "invalid_value", Message: "Email domain type must be free or business"})
+				break
+			}
+		}
+	case req.Attribute == scoring.AttributeIndustry || req.Attribute == scoring.AttributeCompanySize:
+		var count int64
+		if req.Attribute == scoring.AttributeIndustry {
+			h.db.Model(&models.Industry{}).Where("id IN ? AND tenant_id = ?", req.Values, tenantID).Count(&count)
+		} else {
+			h.db.Model(&models.CompanySize{}).Where("id IN ? AND tenant_id = ?", req.Values, tenantID).Count(&count)
+		}
+		if int(count) != len(req.Values) {
+			validationErrors = append(validationErrors, ValidationError{Field: "values", Code: "invalid_value", Message: "Values must be IDs of existing picklist entries"})
+		}
+	}
+	return validationErrors
+}
+
+func (h *LeadScoringHandler) DeleteRule(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	result := h.db.WithContext(c).Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).Delete(&models.LeadScoringRule{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete scoring rule"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scoring rule not found"})
+		return
+	}
+	if err := scoring.ScoreTenant(h.db.WithContext(c), user.TenantID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rescore leads"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Scoring rule deleted successfully"})
+}
+
+func (h *LeadScoringHandler) GetThresholds(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var thresholds []models.LeadScoreThreshold
+	if err := h.db.Preload("Temperature").Where("tenant_id = ?", user.TenantID).
+		Order("min_score DESC").
+		Find(&thresholds).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch score thresholds"})
+		return
+	}
+
+	c.JSON(http.StatusOK, thresholds)
+}
+
+// UpdateThresholds replaces the tenant's score thresholds and rescores its
+// open leads
+func (h *LeadScoringHandler) UpdateThresholds(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req UpdateLeadScoreThresholdsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var validationErrors []ValidationError
+	seen := map[int]bool{}
+	for i, threshold := range req.Thresholds {
+		field := fmt.Sprintf("thresholds[%d]", i)
+		if seen[threshold.MinScore] {
+			validationErrors = append(validationErrors, ValidationError{Field: field + ".minScore", Code: "duplicate", Message: "Thresholds must have different minimum scores"})
+		}
+		seen[threshold.MinScore] = true
+		var count int64
+		h.db.Model(&models.LeadTemperature{}).Where("id = ? AND tenant_id = ?", threshold.TemperatureID, user.TenantID).Count(&count)
+		if count == 0 {
+			validationErrors = append(validationErrors, ValidationError{Field: field + ".temperatureId", Code: "invalid_temperature", Message: "Lead temperature not found"})
+		}
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	thresholds := make([]models.LeadScoreThreshold, len(req.Thresholds))
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("tenant_id = ?", user.TenantID).Delete(&models.LeadScoreThreshold{}).Error; err != nil {
+			return err
+		}
+		for i, threshold := range req.Thresholds {
+			thresholds[i] = models.LeadScoreThreshold{
+				MinScore:      threshold.MinScore,
+				TemperatureID: threshold.TemperatureID,
+				TenantID:      user.TenantID,
+			}
+			if err := tx.Create(&thresholds[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save score thresholds"})
+		return
+	}
+	if err := scoring.ScoreTenant(h.db.WithContext(c), user.TenantID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rescore leads"})
+		return
+	}
+
+	c.JSON(http.StatusOK, thresholds)
+}
+
+// GetLeadScore returns the lead's stored score and the live breakdown of the
+// rules that contribute to it
+func (h *LeadScoringHandler) GetLeadScore(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var lead models.Lead
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", c.Param("id"), user.TenantID, false).
+		First(&lead).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lead not found"})
+		return
+	}
+
+	result, err := scoring.Evaluate(h.db, &lead, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compute lead score"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"leadId":        lead.ID,
+		"score":         lead.Score,
+		"scoredAt":      lead.ScoredAt,
+		"temperatureId": lead.TemperatureID,
+		"breakdown":     result,
+	})
+}
+
+// CreateInteraction records a view, click or conversion of a marketing asset
+// by a lead or contact, counts it on the asset and rescores the lead
+func (h *LeadScoringHandler) CreateInteraction(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CreateMarketingInteractionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var asset models.MarketingAsset
+	if err := h.db.Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).First(&asset).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Marketing asset not found"})
+		return
+	}
+
+	var validationErrors []ValidationError
+	if req.LeadID == nil && req.ContactID == nil {
+		validationErrors = append(validationErrors, ValidationError{Field: "leadId", Code: "required", Message: "Set the lead or contact that interacted"})
+	}
+	if req.LeadID != nil {
+		var count int64
+		h.db.Model(&models.Lead{}).Where("id = ? AND tenant_id = ? AND is_deleted = ?", *req.LeadID, user.TenantID, false).Count(&count)
+		if count == 0 {
+			validationErrors = append(validationErrors, ValidationError{Field: "leadId", Code: "invalid_lead", Message: "Lead not found"})
+		}
+	}
+	if req.ContactID != nil {
+		var count int64
+		h.db.Model(&models.Contact{}).Where("id = ? AND tenant_id = ? AND is_deleted = ?", *req.ContactID, user.TenantID, false).Count(&count)
+		if count == 0 {
+			validationErrors = append(validationErrors, ValidationError{Field: "contactId", Code: "invalid_contact", Message: "Contact not found"})
+		}
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	interaction := models.MarketingInteraction{
+		AssetID:    asset.ID,
+		Type:       req.Type,
+		LeadID:     req.LeadID,
+		ContactID:  req.ContactID,
+		OccurredAt: time.Now(),
+		TenantID:   user.TenantID,
+	}
+	if req.OccurredAt != nil {
+		interaction.OccurredAt = *req.OccurredAt
+	}
+	counter := map[string]string{"view": "views", "click": "clicks", "conversion": "conversions"}[req.Type]
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&interaction).Error; err != nil {
+			return err
+		}
+		return tx.Model(&asset).UpdateColumn(counter, gorm.Expr(counter+" + 1")).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record interaction"})
+		return
+	}
+
+	// Rescore the lead, or the lead of the contact
+	var leadIDs []string
+	if req.LeadID != nil {
+		leadIDs = append(leadIDs, *req.LeadID)
+	}
+	if req.ContactID != nil {
+		var contactLeadIDs []string
+		h.db.Model(&models.Lead{}).Where("contact_id = ?", *req.ContactID).Pluck("id", &contactLeadIDs)
+		leadIDs = append(leadIDs, contactLeadIDs...)
+	}
+	for _, leadID := range leadIDs {
+		if err := scoring.ScoreLead(h.db.WithContext(c), leadID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rescore lead"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusCreated, interaction)
+}
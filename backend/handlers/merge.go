@@ -0,0 +1,615 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+
+	"finhub-backend/audit"
+	"finhub-backend/models"
+	"finhub-backend/territories"
+)
+
+// ActionMerge is the audit action recorded for changes made by a merge
+const ActionMerge = "merge"
+
+type MergeHandler struct {
+	db *gorm.DB
+}
+
+type MergeRequest struct {
+	MasterID string   `json:"masterId" binding:"required"`
+	LoserIDs []string `json:"loserIds" binding:"required,min=1,dive,required"`
+
+	// Record whose value survives, by column (e.g. job_title) and by custom
+	// field name. Other fields keep the master's value, or take the first
+	// loser's when the master has none.
+	Fields       map[string]string `json:"fields"`
+	CustomFields map[string]string `json:"customFields"`
+}
+
+type MergeResult struct {
+	Merge  models.RecordMerge `json:"merge"`
+	Master interface{}        `json:"master"`
+}
+
+// unmergedColumns are never taken from a loser
+var unmergedColumns = map[string]bool{
+	"created_by":     true,
+	"is_deleted":     true,
+	"deleted_at":     true,
+	"merged_into_id": true,
+	"parent_id":      true, // see reparentCompanyRows
+}
+
+// errMergeInvalid carries validation errors found inside the merge
+// transaction
+type errMergeInvalid []ValidationError
+
+func (e errMergeInvalid) Error() string { return "invalid merge" }
+
+func NewMergeHandler(db *gorm.DB) *MergeHandler {
+	return &MergeHandler{db: db}
+}
+
+// MergeRecords returns a handler merging losers into a master record of the
+// given entity type (contact or company). The master takes the surviving
+// field values, related rows are moved to it, and the losers are deleted
+// pointing at the master. Everything happens in one transaction and is
+// recorded as a RecordMerge.
+func (h *MergeHandler) MergeRecords(entityType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+			return
+		}
+
+		var req MergeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var user models.User
+		if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+
+		seen := map[string]bool{req.MasterID: true}
+		for _, id := range req.LoserIDs {
+			if seen[id] {
+				respondValidationErrors(c, []ValidationError{{Field: "loserIds", Code: "invalid_losers", Message: "Losers must be distinct and exclude the master"}})
+				return
+			}
+			seen[id] = true
+		}
+
+		userIDStr := userID.(string)
+		var result MergeResult
+		err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+			merge, master, err := mergeRecords(tx, entityType, user.TenantID, &req)
+			if err != nil {
+				return err
+			}
+			merge.MergedBy = &userIDStr
+			if err := tx.Create(merge).Error; err != nil {
+				return err
+			}
+			result.Merge, result.Master = *merge, master
+			return nil
+		})
+		if err != nil {
+			var invalid errMergeInvalid
+			if errors.As(err, &invalid) {
+				respondValidationErrors(c, invalid)
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge records"})
+			return
+		}
+
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func (h *MergeHandler) GetMerges(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	if err != nil || pageSize < 1 || pageSize > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pageSize"})
+		return
+	}
+
+	query := h.db.Model(&models.RecordMerge{}).Where("tenant_id = ?", user.TenantID)
+	if entityType := c.Query("entityType"); entityType != "" {
+		query = query.Where("entity_type = ?", entityType)
+	}
+	if masterID := c.Query("masterId"); masterID != "" {
+		query = query.Where("master_id = ?", masterID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch merges"})
+		return
+	}
+	var merges []models.RecordMerge
+	if err := query.Order("created_at DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&merges).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch merges"})
+		return
+	}
+
+	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
+	c.JSON(http.StatusOK, gin.H{
+		"entries":    merges,
+		"totalCount": total,
+		"page":       page,
+		"pageSize":   pageSize,
+		"totalPages": totalPages,
+		"hasMore":    page < totalPages,
+	})
+}
+
+func (h *MergeHandler) GetMerge(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var merge models.RecordMerge
+	if err := h.db.Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).First(&merge).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Merge not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, merge)
+}
+
+// mergeRecords performs a merge and returns its record, not yet saved, and
+// the master as it is afterwards
+func mergeRecords(tx *gorm.DB, entityType, tenantID string, req *MergeRequest) (*models.RecordMerge, interface{}, error) {
+	// Every write of the merge is audited as a merge
+	merging := tx.Set(audit.ActionKey, ActionMerge).Session(&gorm.Session{})
+
+	ids := append([]string{req.MasterID}, req.LoserIDs...)
+	records := make([]interface{}, len(ids))
+	for i, id := range ids {
+		record, err := audit.NewRecord(entityType)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND tenant_id = ? AND is_deleted = ?", id, tenantID, false).
+			First(record).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				if i == 0 {
+					return nil, nil, errMergeInvalid{{Field: "masterId", Code: "not_found", Message: "Master record not found"}}
+				}
+				return nil, nil, errMergeInvalid{{Field: "loserIds", Code: "not_found", Message: "Loser " + id + " not found"}}
+			}
+			return nil, nil, err
+		}
+		records[i] = record
+	}
+	master := records[0]
+
+	merge := &models.RecordMerge{
+		EntityType: entityType,
+		MasterID:   req.MasterID,
+		LoserIDs:   req.LoserIDs,
+		Reparented: map[string]int{},
+		TenantID:   tenantID,
+	}
+	// The losers' history moves to the master, marked so restores of the
+	// master skip it. Entries written by the merge itself stay with the
+	// losers.
+	update := tx.Model(&models.ActivityLog{}).
+		Where("tenant_id = ? AND entity_type = ? AND entity_id IN ?", tenantID, entityType, req.LoserIDs).
+		Updates(map[string]interface{}{"entity_id": req.MasterID, "merged_from_id": gorm.Expr("entity_id")})
+	if update.Error != nil {
+		return nil, nil, update.Error
+	}
+	merge.Reparented["activity"] = int(update.RowsAffected)
+
+	// Losers are deleted before the master takes their values, so that
+	// unique values such as a company's domain are free by then
+	now := time.Now()
+	for _, loser := range records[1:] {
+		if err := merging.Model(loser).Updates(map[string]interface{}{
+			"is_deleted":     true,
+			"deleted_at":     now,
+			"merged_into_id": req.MasterID,
+		}).Error; err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var err error
+	if merge.Fields, err = mergeFields(merging, ids, records, req.Fields); err != nil {
+		return nil, nil, err
+	}
+	if merge.CustomFields, err = mergeCustomFields(tx, entityType, tenantID, ids, req.CustomFields); err != nil {
+		return nil, nil, err
+	}
+
+	switch entityType {
+	case "contact":
+		err = reparentContactRows(merging, req.MasterID, req.LoserIDs, merge.Reparented)
+	case "company":
+		err = reparentCompanyRows(merging, req.MasterID, req.LoserIDs, merge.Reparented)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := moveContactPoints(merging, entityType, req.MasterID, req.LoserIDs, merge.Reparented); err != nil {
+		return nil, nil, err
+	}
+
+	if entityType == "company" {
+		for _, id := range ids {
+			if _, err := territories.AssignCompany(tx, id); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if err := tx.Where("id = ?", req.MasterID).First(master).Error; err != nil {
+		return nil, nil, err
+	}
+	return merge, master, nil
+}
+
+// mergeFields gives the master the surviving value of each field and
+// returns the fields it took from a loser. Without a choice the master keeps
+// its value; empty strings and unset values are filled from the first loser
+// that has one.
+func mergeFields(tx *gorm.DB, ids []string, records []interface{}, chosen map[string]string) (map[string]string, error) {
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(records[0]); err != nil {
+		return nil, err
+	}
+	values := make([]reflect.Value, len(records))
+	for i, record := range records {
+		values[i] = reflect.Indirect(reflect.ValueOf(record))
+	}
+	index := map[string]int{}
+	for i, id := range ids {
+		index[id] = i
+	}
+
+	var fields []*schema.Field
+	mergeable := map[string]bool{}
+	for _, field := range audit.AuditedFields(stmt.Schema) {
+		if !unmergedColumns[field.DBName] {
+			fields = append(fields, field)
+			mergeable[field.DBName] = true
+		}
+	}
+	var validationErrors []ValidationError
+	for column, id := range chosen {
+		if !mergeable[column] {
+			validationErrors = append(validationErrors, ValidationError{Field: "fields." + column, Code: "invalid_field", Message: "Field cannot be merged"})
+		} else if _, ok := index[id]; !ok {
+			validationErrors = append(validationErrors, ValidationError{Field: "fields." + column, Code: "invalid_record", Message: "Survivor must be the master or one of the losers"})
+		}
+	}
+	if len(validationErrors) > 0 {
+		return nil, errMergeInvalid(validationErrors)
+	}
+
+	taken := map[string]string{}
+	updates := map[string]interface{}{}
+	for _, field := range fields {
+		source := 0
+		if id, ok := chosen[field.DBName]; ok {
+			source = index[id]
+		} else if kind := field.FieldType.Kind(); kind == reflect.Ptr || kind == reflect.String {
+			for i := range values {
+				if _, isZero := field.ValueOf(stmt.Context, values[i]); !isZero {
+					source = i
+					break
+				}
+			}
+		}
+		if source == 0 {
+			continue
+		}
+		value, _ := field.ValueOf(stmt.Context, values[source])
+		current, _ := field.ValueOf(stmt.Context, values[0])
+		taken[field.DBName] = ids[source]
+		if !reflect.DeepEqual(value, current) {
+			updates[field.DBName] = value
+		}
+	}
+	if len(updates) == 0 {
+		return taken, nil
+	}
+	return taken, tx.Model(records[0]).Updates(updates).Error
+}
+
+// mergeCustomFields gives the master the surviving value of each custom
+// field, chosen like mergeFields, and returns the fields it took from a
+// loser. Values the master does not take stay with their loser.
+func mergeCustomFields(tx *gorm.DB, entityType, tenantID string, ids []string, chosen map[string]string) (map[string]string, error) {
+	var fields []models.CustomField
+	if err := tx.Where("tenant_id = ? AND entity_type = ?", tenantID, entityType).Find(&fields).Error; err != nil {
+		return nil, err
+	}
+	byName := map[string]models.CustomField{}
+	for _, field := range fields {
+		byName[field.Name] = field
+	}
+	index := map[string]int{}
+	for i, id := range ids {
+		index[id] = i
+	}
+	var validationErrors []ValidationError
+	for name, id := range chosen {
+		if _, ok := byName[name]; !ok {
+			validationErrors = append(validationErrors, ValidationError{Field: "customFields." + name, Code: "invalid_field", Message: "Custom field not found"})
+		} else if _, ok := index[id]; !ok {
+			validationErrors = append(validationErrors, ValidationError{Field: "customFields." + name, Code: "invalid_record", Message: "Survivor must be the master or one of the losers"})
+		}
+	}
+	if len(validationErrors) > 0 {
+		return nil, errMergeInvalid(validationErrors)
+	}
+
+	var values []models.CustomFieldValue
+	if err := tx.Where("entity_type = ? AND entity_id IN ?", entityType, ids).Find(&values).Error; err != nil {
+		return nil, err
+	}
+	byField := map[string]map[string]models.CustomFieldValue{}
+	for _, value := range values {
+		if byField[value.FieldID] == nil {
+			byField[value.FieldID] = map[string]models.CustomFieldValue{}
+		}
+		byField[value.FieldID][value.EntityID] = value
+	}
+
+	masterID := ids[0]
+	taken := map[string]string{}
+	for _, field := range fields {
+		held := byField[field.ID]
+		source, ok := chosen[field.Name]
+		if !ok {
+			source = masterID
+			if _, ok := held[masterID]; !ok {
+				for _, id := range ids[1:] {
+					if _, ok := held[id]; ok {
+						source = id
+						break
+					}
+				}
+			}
+		}
+		if source == masterID {
+			continue
+		}
+		taken[field.Name] = source
+		if current, ok := held[masterID]; ok {
+			if err := tx.Delete(&current).Error; err != nil {
+				return nil, err
+			}
+		}
+		if value, ok := held[source]; ok {
+			if err := tx.Model(&value).Update("entity_id", masterID).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+	return taken, nil
+}
+
+// reparentContactRows moves the deals, communications and marketing
+// interactions of the losers to the master contact. A contact is the origin
+// of at most one lead, so a loser's lead only moves when the master has none.
+// Tasks follow their leads and deals.
+func reparentContactRows(tx *gorm.DB, masterID string, loserIDs []string, counts map[string]int) error {
+	moves := []struct {
+		key   string
+		model interface{}
+	}{
+		{"deals", &models.Deal{}},
+		{"communications", &models.Communication{}},
+		{"marketingInteractions", &models.MarketingInteraction{}},
+	}
+	for _, move := range moves {
+		update := tx.Model(move.model).Where("contact_id IN ?", loserIDs).Update("contact_id", masterID)
+		if update.Error != nil {
+			return update.Error
+		}
+		counts[move.key] = int(update.RowsAffected)
+	}
+
+	var linked int64
+	if err := tx.Model(&models.Lead{}).Where("contact_id = ?", masterID).Count(&linked).Error; err != nil {
+		return err
+	}
+	if linked > 0 {
+		return nil
+	}
+	var lead models.Lead
+	if err := tx.Where("contact_id IN ?", loserIDs).Order("created_at ASC").Limit(1).Find(&lead).Error; err != nil {
+		return err
+	}
+	if lead.ID == "" {
+		return nil
+	}
+	if err := tx.Model(&lead).Update("contact_id", masterID).Error; err != nil {
+		return err
+	}
+	counts["leads"] = 1
+	return nil
+}
+
+// reparentCompanyRows moves the contacts, leads, deals, contracts and
+// subsidiaries of the losers to the master company. Tasks follow their leads
+// and deals. The master keeps its parent, or takes the first loser's parent
+// when it has none; a parent among the losers is replaced by that loser's
+// own parent.
+func reparentCompanyRows(tx *gorm.DB, masterID string, loserIDs []string, counts map[string]int) error {
+	moves := []struct {
+		key   string
+		model interface{}
+	}{
+		{"contacts", &models.Contact{}},
+		{"leads", &models.Lead{}},
+		{"deals", &models.Deal{}},
+		{"contracts", &models.Contract{}},
+	}
+	for _, move := range moves {
+		update := tx.Model(move.model).Where("company_id IN ?", loserIDs).Update("company_id", masterID)
+		if update.Error != nil {
+			return update.Error
+		}
+		counts[move.key] = int(update.RowsAffected)
+	}
+
+	var companies []models.Company
+	if err := tx.Select("id", "parent_id").Where("id IN ?", append([]string{masterID}, loserIDs...)).Find(&companies).Error; err != nil {
+		return err
+	}
+	parents := make(map[string]*string, len(companies))
+	for _, company := range companies {
+		parents[company.ID] = company.ParentID
+	}
+
+	// Subsidiaries of the losers move under the master
+	update := tx.Model(&models.Company{}).Where("parent_id IN ? AND id <> ?", loserIDs, masterID).Update("parent_id", masterID)
+	if update.Error != nil {
+		return update.Error
+	}
+	counts["subsidiaries"] = int(update.RowsAffected)
+
+	parentID := parents[masterID]
+	for _, id := range loserIDs {
+		if parentID != nil {
+			break
+		}
+		parentID = parents[id]
+	}
+	// A parent among the merged companies gives way to its own parent
+	seen := map[string]bool{masterID: true}
+	for parentID != nil && !seen[*parentID] {
+		if _, merged := parents[*parentID]; !merged {
+			break
+		}
+		seen[*parentID] = true
+		parentID = parents[*parentID]
+	}
+	if parentID != nil && seen[*parentID] {
+		parentID = nil
+	}
+
+	if parentID != nil {
+		ancestors, err := companyAncestorIDs(tx, *parentID)
+		if err != nil {
+			return err
+		}
+		for _, id := range ancestors {
+			if id == masterID {
+				return errMergeInvalid{{Field: "parentId", Code: "invalid_parent", Message: "The merged company would be its own subsidiary"}}
+			}
+		}
+	}
+	if current := parents[masterID]; (current == nil) != (parentID == nil) || (current != nil && *current != *parentID) {
+		return tx.Model(&models.Company{ID: masterID}).Update("parent_id", parentID).Error
+	}
+	return nil
+}
+
+// moveContactPoints moves the losers' phone numbers, email addresses,
+// addresses and social media accounts to the master. Rows the master already
+// has stay with their loser, and moved rows are only primary when the master
+// has no primary row of that kind.
+func moveContactPoints(tx *gorm.DB, entityType, masterID string, loserIDs []string, counts map[string]int) error {
+	var err error
+	counts["phoneNumbers"], err = moveRows(tx, entityType, masterID, loserIDs,
+		func(phone models.PhoneNumber) (string, bool) { return phoneDigits(phone.Number), phone.IsPrimary })
+	if err != nil {
+		return err
+	}
+	counts["emailAddresses"], err = moveRows(tx, entityType, masterID, loserIDs,
+		func(email models.EmailAddress) (string, bool) { return strings.ToLower(email.Email), email.IsPrimary })
+	if err != nil {
+		return err
+	}
+	counts["addresses"], err = moveRows(tx, entityType, masterID, loserIDs,
+		func(address models.Address) (string, bool) { return addressKey(address), address.IsPrimary })
+	if err != nil {
+		return err
+	}
+	counts["socialMediaAccounts"], err = moveRows(tx, entityType, masterID, loserIDs,
+		func(account models.SocialMediaAccount) (string, bool) { return socialKey(account), account.IsPrimary })
+	return err
+}
+
+// moveRows moves the rows of one contact point model from the losers to the
+// master. key identifies duplicates and reports whether a row is primary.
+func moveRows[T any](tx *gorm.DB, entityType, masterID string, loserIDs []string, key func(T) (string, bool)) (int, error) {
+	var existing, rows []T
+	if err := tx.Where("entity_type = ? AND entity_id = ?", entityType, masterID).Find(&existing).Error; err != nil {
+		return 0, err
+	}
+	if err := tx.Where("entity_type = ? AND entity_id IN ?", entityType, loserIDs).Order("is_primary DESC").Find(&rows).Error; err != nil {
+		return 0, err
+	}
+	seen, hasPrimary := map[string]bool{}, false
+	for _, row := range existing {
+		k, primary := key(row)
+		seen[k] = true
+		hasPrimary = hasPrimary || primary
+	}
+	moved := 0
+	for _, row := range rows {
+		k, primary := key(row)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		primary = primary && !hasPrimary
+		hasPrimary = hasPrimary || primary
+		if err := tx.Model(&row).Updates(map[string]interface{}{"entity_id": masterID, "is_primary": primary}).Error; err != nil {
+			return 0, err
+		}
+		moved++
+	}
+	return moved, nil
+}
@@ -0,0 +1,797 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"finhub-backend/audit"
+	"finhub-backend/models"
+)
+
+type PipelineHandler struct {
+	db *gorm.DB
+}
+
+type PipelineResponse struct {
+	models.Pipeline
+	Stages []models.Stage `json:"stages"`
+}
+
+type CreatePipelineRequest struct {
+	Name   string               `json:"name" binding:"required"`
+	Stages []CreateStageRequest `json:"stages"`
+}
+
+type UpdatePipelineRequest struct {
+	Name     *string `json:"name"`
+	IsActive *bool   `json:"isActive"`
+	// Stage deals move to when a quote is accepted; empty clears it
+	QuoteAcceptedStageID *string `json:"quoteAcceptedStageId"`
+}
+
+type CreateStageRequest struct {
+	Name           string   `json:"name" binding:"required"`
+	Order          *int     `json:"order"`
+	Probability    int      `json:"probability" binding:"min=0,max=100"`
+	IsClosedWon    bool     `json:"isClosedWon"`
+	IsClosedLost   bool     `json:"isClosedLost"`
+	Color          *string  `json:"color"`
+	RequiredFields []string `json:"requiredFields"`
+}
+
+type UpdateStageRequest struct {
+	Name           *string   `json:"name"`
+	Probability    *int      `json:"probability" binding:"omitempty,min=0,max=100"`
+	IsClosedWon    *bool     `json:"isClosedWon"`
+	IsClosedLost   *bool     `json:"isClosedLost"`
+	Color          *string   `json:"color"`
+	RequiredFields *[]string `json:"requiredFields"`
+}
+
+type StageTransitionRequest struct {
+	FromStageID string `json:"fromStageId" binding:"required"`
+	ToStageID   string `json:"toStageId" binding:"required"`
+}
+
+type SetTransitionsRequest struct {
+	Transitions []StageTransitionRequest `json:"transitions" binding:"dive"`
+}
+
+type ReorderStagesRequest struct {
+	StageIDs []string `json:"stageIds" binding:"required,min=1"`
+}
+
+type DeleteStageRequest struct {
+	DestinationStageID *string `json:"destinationStageId"`
+	ChangeReason       *string `json:"changeReason"`
+}
+
+func NewPipelineHandler(db *gorm.DB) *PipelineHandler {
+	return &PipelineHandler{db: db}
+}
+
+// GetPipelines lists the tenant's pipelines with their stages in order.
+// Archived pipelines are only included with ?includeArchived=true.
+func (h *PipelineHandler) GetPipelines(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	query := h.db.Where("tenant_id = ?", user.TenantID)
+	if c.Query("includeArchived") != "true" {
+		query = query.Where("archived_at IS NULL")
+	}
+
+	var pipelines []models.Pipeline
+	if err := query.Order("name ASC").Find(&pipelines).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pipelines"})
+		return
+	}
+
+	response := make([]PipelineResponse, len(pipelines))
+	for i, pipeline := range pipelines {
+		stages, err := h.loadStages(pipeline.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stages"})
+			return
+		}
+		response[i] = PipelineResponse{Pipeline: pipeline, Stages: stages}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *PipelineHandler) GetPipeline(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	pipelineID := c.Param("id")
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var pipeline models.Pipeline
+	if err := h.db.Where("id = ? AND tenant_id = ?", pipelineID, user.TenantID).First(&pipeline).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pipeline not found"})
+		return
+	}
+
+	stages, err := h.loadStages(pipeline.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, PipelineResponse{Pipeline: pipeline, Stages: stages})
+}
+
+// CreatePipeline creates a pipeline, optionally with its initial stages
+func (h *PipelineHandler) CreatePipeline(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CreatePipelineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for _, stage := range req.Stages {
+		if stage.IsClosedWon && stage.IsClosedLost {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "A stage cannot be both closed won and closed lost"})
+			return
+		}
+		if err := validateRequiredFieldNames(stage.RequiredFields); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	pipeline := models.Pipeline{
+		Name:     req.Name,
+		IsActive: true,
+		TenantID: user.TenantID,
+	}
+	var stages []models.Stage
+
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&pipeline).Error; err != nil {
+			return err
+		}
+		for i, stageReq := range req.Stages {
+			stage := newStage(stageReq, pipeline, i+1)
+			if err := tx.Create(&stage).Error; err != nil {
+				return err
+			}
+			stages = append(stages, stage)
+		}
+		return nil
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create pipeline"})
+		return
+	}
+
+	if stages == nil {
+		stages = []models.Stage{}
+	}
+	c.JSON(http.StatusCreated, PipelineResponse{Pipeline: pipeline, Stages: stages})
+}
+
+func (h *PipelineHandler) UpdatePipeline(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	pipelineID := c.Param("id")
+	var req UpdatePipelineRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var pipeline models.Pipeline
+	if err := h.db.Where("id = ? AND tenant_id = ?", pipelineID, user.TenantID).First(&pipeline).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pipeline not found"})
+		return
+	}
+
+	// Update fields
+	if req.Name != nil {
+		pipeline.Name = *req.Name
+	}
+	if req.IsActive != nil {
+		pipeline.IsActive = *req.IsActive
+	}
+	if req.QuoteAcceptedStageID != nil {
+		pipeline.QuoteAcceptedStageID = nil
+		if *req.QuoteAcceptedStageID != "" {
+			var stage models.Stage
+			if err := h.db.Where("id = ? AND pipeline_id = ? AND is_deleted = ?", *req.QuoteAcceptedStageID, pipeline.ID, false).
+				First(&stage).Error; err != nil {
+				respondValidationErrors(c, []ValidationError{{Field: "quoteAcceptedStageId", Code: "invalid_stage", Message: "Stage not found in this pipeline"}})
+				return
+			}
+			if stage.IsClosedLost {
+				respondValidationErrors(c, []ValidationError{{Field: "quoteAcceptedStageId", Code: "invalid_stage", Message: "Accepted quotes cannot move deals into a lost stage"}})
+				return
+			}
+			pipeline.QuoteAcceptedStageID = &stage.ID
+		}
+	}
+
+	if err := h.db.WithContext(c).Save(&pipeline).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update pipeline"})
+		return
+	}
+
+	c.JSON(http.StatusOK, pipeline)
+}
+
+// ArchivePipeline hides a pipeline from lists and stops new deals being added to it.
+// Existing deals are kept.
+func (h *PipelineHandler) ArchivePipeline(c *gin.Context) {
+	h.setArchived(c, true)
+}
+
+func (h *PipelineHandler) UnarchivePipeline(c *gin.Context) {
+	h.setArchived(c, false)
+}
+
+func (h *PipelineHandler) setArchived(c *gin.Context, archived bool) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	pipelineID := c.Param("id")
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var pipeline models.Pipeline
+	if err := h.db.Where("id = ? AND tenant_id = ?", pipelineID, user.TenantID).First(&pipeline).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pipeline not found"})
+		return
+	}
+
+	if archived {
+		now := time.Now()
+		pipeline.ArchivedAt = &now
+		pipeline.IsActive = false
+	} else {
+		pipeline.ArchivedAt = nil
+		pipeline.IsActive = true
+	}
+
+	if err := h.db.WithContext(c).Save(&pipeline).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update pipeline"})
+		return
+	}
+
+	c.JSON(http.StatusOK, pipeline)
+}
+
+// CreateStage adds a stage to a pipeline. Without an explicit order the stage
+// is appended after the last one.
+func (h *PipelineHandler) CreateStage(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	pipelineID := c.Param("id")
+	var req CreateStageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.IsClosedWon && req.IsClosedLost {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A stage cannot be both closed won and closed lost"})
+		return
+	}
+	if err := validateRequiredFieldNames(req.RequiredFields); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var pipeline models.Pipeline
+	if err := h.db.Where("id = ? AND tenant_id = ?", pipelineID, user.TenantID).First(&pipeline).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pipeline not found"})
+		return
+	}
+
+	var stage models.Stage
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var maxOrder int
+		if err := tx.Model(&models.Stage{}).
+			Where("pipeline_id = ? AND is_deleted = ?", pipeline.ID, false).
+			Select("COALESCE(MAX(\"order\"), 0)").Scan(&maxOrder).Error; err != nil {
+			return err
+		}
+
+		order := maxOrder + 1
+		if req.Order != nil && *req.Order >= 1 && *req.Order <= maxOrder {
+			order = *req.Order
+			// make room for the new stage
+			if err := tx.Model(&models.Stage{}).
+				Where("pipeline_id = ? AND is_deleted = ? AND \"order\" >= ?", pipeline.ID, false, order).
+				Update("order", gorm.Expr("\"order\" + 1")).Error; err != nil {
+				return err
+			}
+		}
+
+		stage = newStage(req, pipeline, order)
+		return tx.Create(&stage).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create stage"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, stage)
+}
+
+func (h *PipelineHandler) UpdateStage(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	stageID := c.Param("id")
+	var req UpdateStageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var stage models.Stage
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", stageID, user.TenantID, false).
+		First(&stage).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stage not found"})
+		return
+	}
+
+	// Update fields
+	if req.Name != nil {
+		stage.Name = *req.Name
+	}
+	if req.Probability != nil {
+		stage.Probability = *req.Probability
+	}
+	if req.IsClosedWon != nil {
+		stage.IsClosedWon = *req.IsClosedWon
+	}
+	if req.IsClosedLost != nil {
+		stage.IsClosedLost = *req.IsClosedLost
+	}
+	if req.Color != nil {
+		stage.Color = req.Color
+	}
+	if req.RequiredFields != nil {
+		if err := validateRequiredFieldNames(*req.RequiredFields); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		stage.RequiredFields = *req.RequiredFields
+	}
+
+	if stage.IsClosedWon && stage.IsClosedLost {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A stage cannot be both closed won and closed lost"})
+		return
+	}
+
+	if err := h.db.WithContext(c).Save(&stage).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update stage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stage)
+}
+
+// ReorderStages sets the order of a pipeline's stages. Every active stage of
+// the pipeline must be listed exactly once.
+func (h *PipelineHandler) ReorderStages(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	pipelineID := c.Param("id")
+	var req ReorderStagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var pipeline models.Pipeline
+	if err := h.db.Where("id = ? AND tenant_id = ?", pipelineID, user.TenantID).First(&pipeline).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pipeline not found"})
+		return
+	}
+
+	stages, err := h.loadStages(pipeline.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stages"})
+		return
+	}
+
+	byID := map[string]models.Stage{}
+	for _, stage := range stages {
+		byID[stage.ID] = stage
+	}
+	seen := map[string]bool{}
+	for _, id := range req.StageIDs {
+		if _, ok := byID[id]; !ok || seen[id] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "stageIds must list each stage of the pipeline once"})
+			return
+		}
+		seen[id] = true
+	}
+	if len(seen) != len(stages) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "stageIds must list each stage of the pipeline once"})
+		return
+	}
+
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		for i, id := range req.StageIDs {
+			stage := byID[id]
+			if stage.Order == i+1 {
+				continue
+			}
+			if err := tx.Model(&stage).Update("order", i+1).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reorder stages"})
+		return
+	}
+
+	stages, err = h.loadStages(pipeline.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, PipelineResponse{Pipeline: pipeline, Stages: stages})
+}
+
+// DeleteStage removes a stage from its pipeline. If deals are still in the
+// stage a destination stage in the same pipeline is required; the deals are
+// moved there, with history, in the same transaction as the delete.
+func (h *PipelineHandler) DeleteStage(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	stageID := c.Param("id")
+	var req DeleteStageRequest
+	// the body is optional when the stage is empty
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var stage models.Stage
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", stageID, user.TenantID, false).
+		First(&stage).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Stage not found"})
+		return
+	}
+
+	var destination *models.Stage
+	if req.DestinationStageID != nil {
+		var dest models.Stage
+		if err := h.db.Where("id = ? AND pipeline_id = ? AND tenant_id = ? AND is_deleted = ?",
+			*req.DestinationStageID, stage.PipelineID, user.TenantID, false).
+			First(&dest).Error; err != nil || dest.ID == stage.ID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Destination stage must be another stage of the same pipeline"})
+			return
+		}
+		destination = &dest
+	}
+
+	reason := req.ChangeReason
+	if reason == nil {
+		reason = stringPtr("Stage " + stage.Name + " deleted")
+	}
+
+	userIDStr := userID.(string)
+	var moved int
+	err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		// Accepted quotes advance deals into the pipeline's quote accepted
+		// stage, so it has to be changed before the stage can go
+		var pipeline models.Pipeline
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "quote_accepted_stage_id").
+			First(&pipeline, "id = ?", stage.PipelineID).Error; err != nil {
+			return err
+		}
+		if pipeline.QuoteAcceptedStageID != nil && *pipeline.QuoteAcceptedStageID == stage.ID {
+			return errStageQuoteAccepted
+		}
+
+		var deals []models.Deal
+		if err := tx.Where("stage_id = ? AND tenant_id = ?", stage.ID, user.TenantID).Find(&deals).Error; err != nil {
+			return err
+		}
+		if len(deals) > 0 && destination == nil {
+			return errStageHasDeals
+		}
+
+		for i := range deals {
+			before := deals[i]
+			deals[i].StageID = destination.ID
+			if err := tx.Model(&deals[i]).Update("stage_id", destination.ID).Error; err != nil {
+				return err
+			}
+			if err := recordDealChange(tx, &before, &deals[i], DealChange{MovedBy: &userIDStr, ChangeReason: reason}); err != nil {
+				return err
+			}
+		}
+		moved = len(deals)
+
+		now := time.Now()
+		if err := tx.Set(audit.ActionKey, audit.ActionDelete).Model(&stage).
+			Updates(map[string]interface{}{"is_deleted": true, "deleted_at": now}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("from_stage_id = ? OR to_stage_id = ?", stage.ID, stage.ID).
+			Delete(&models.StageTransition{}).Error; err != nil {
+			return err
+		}
+
+		// close the gap left in the ordering
+		return tx.Model(&models.Stage{}).
+			Where("pipeline_id = ? AND is_deleted = ? AND \"order\" > ?", stage.PipelineID, false, stage.Order).
+			Update("order", gorm.Expr("\"order\" - 1")).Error
+	})
+	if errors.Is(err, errStageHasDeals) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Stage still has deals; destinationStageId is required"})
+		return
+	}
+	if errors.Is(err, errStageQuoteAccepted) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Stage is the pipeline's quote accepted stage; change quoteAcceptedStageId first"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete stage"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stage deleted successfully", "movedDeals": moved})
+}
+
+var (
+	errStageHasDeals      = errors.New("stage has deals")
+	errStageQuoteAccepted = errors.New("stage is the quote accepted stage")
+)
+
+// GetTransitions lists the allowed stage transitions of a pipeline
+func (h *PipelineHandler) GetTransitions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	pipelineID := c.Param("id")
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var pipeline models.Pipeline
+	if err := h.db.Where("id = ? AND tenant_id = ?", pipelineID, user.TenantID).First(&pipeline).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pipeline not found"})
+		return
+	}
+
+	transitions := []models.StageTransition{}
+	if err := h.db.Where("pipeline_id = ?", pipeline.ID).
+		Preload("FromStage").Preload("ToStage").
+		Find(&transitions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transitions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, transitions)
+}
+
+// SetTransitions replaces the allowed stage transitions of a pipeline. An
+// empty list removes all restrictions.
+func (h *PipelineHandler) SetTransitions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	pipelineID := c.Param("id")
+	var req SetTransitionsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var pipeline models.Pipeline
+	if err := h.db.Where("id = ? AND tenant_id = ?", pipelineID, user.TenantID).First(&pipeline).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pipeline not found"})
+		return
+	}
+
+	stages, err := h.loadStages(pipeline.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stages"})
+		return
+	}
+	inPipeline := map[string]bool{}
+	for _, stage := range stages {
+		inPipeline[stage.ID] = true
+	}
+
+	transitions := []models.StageTransition{}
+	seen := map[[2]string]bool{}
+	for _, t := range req.Transitions {
+		if !inPipeline[t.FromStageID] || !inPipeline[t.ToStageID] || t.FromStageID == t.ToStageID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Transitions must link two different stages of the pipeline"})
+			return
+		}
+		key := [2]string{t.FromStageID, t.ToStageID}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		transitions = append(transitions, models.StageTransition{
+			PipelineID:  pipeline.ID,
+			FromStageID: t.FromStageID,
+			ToStageID:   t.ToStageID,
+			TenantID:    user.TenantID,
+		})
+	}
+
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("pipeline_id = ?", pipeline.ID).Delete(&models.StageTransition{}).Error; err != nil {
+			return err
+		}
+		if len(transitions) == 0 {
+			return nil
+		}
+		return tx.Create(&transitions).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save transitions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, transitions)
+}
+
+func (h *PipelineHandler) loadStages(pipelineID string) ([]models.Stage, error) {
+	stages := []models.Stage{}
+	err := h.db.Where("pipeline_id = ? AND is_deleted = ?", pipelineID, false).
+		Order("\"order\" ASC").Find(&stages).Error
+	return stages, err
+}
+
+func newStage(req CreateStageRequest, pipeline models.Pipeline, order int) models.Stage {
+	return models.Stage{
+		Name:           req.Name,
+		Order:          order,
+		Probability:    req.Probability,
+		IsClosedWon:    req.IsClosedWon,
+		IsClosedLost:   req.IsClosedLost,
+		Color:          req.Color,
+		RequiredFields: req.RequiredFields,
+		PipelineID:     pipeline.ID,
+		TenantID:       pipeline.TenantID,
+	}
+}
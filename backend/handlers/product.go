@@ -0,0 +1,539 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"finhub-backend/currency"
+	"finhub-backend/models"
+)
+
+// ProductHandler manages the product catalog and price books
+type ProductHandler struct {
+	db *gorm.DB
+}
+
+type CreateProductRequest struct {
+	SKU         string  `json:"sku" binding:"required"`
+	Name        string  `json:"name" binding:"required"`
+	Family      *string `json:"family"`
+	Description *string `json:"description"`
+	IsActive    *bool   `json:"isActive"`
+}
+
+type UpdateProductRequest struct {
+	SKU         *string `json:"sku"`
+	Name        *string `json:"name"`
+	Family      *string `json:"family"`
+	Description *string `json:"description"`
+	IsActive    *bool   `json:"isActive"`
+}
+
+type CreatePriceBookRequest struct {
+	Name        string  `json:"name" binding:"required"`
+	Description *string `json:"description"`
+	IsDefault   bool    `json:"isDefault"`
+}
+
+type UpdatePriceBookRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	IsDefault   *bool   `json:"isDefault"`
+	IsActive    *bool   `json:"isActive"`
+}
+
+type PriceBookEntryRequest struct {
+	ProductID string  `json:"productId" binding:"required"`
+	Currency  string  `json:"currency" binding:"required"`
+	ListPrice float64 `json:"listPrice" binding:"min=0"`
+}
+
+type SetPriceBookEntriesRequest struct {
+	Entries []PriceBookEntryRequest `json:"entries" binding:"required,dive"`
+}
+
+func NewProductHandler(db *gorm.DB) *ProductHandler {
+	return &ProductHandler{db: db}
+}
+
+// GetProducts lists the tenant's products, optionally filtered by family and
+// active flag (?active=true)
+func (h *ProductHandler) GetProducts(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	query := h.db.Where("tenant_id = ?", user.TenantID)
+	if family := c.Query("family"); family != "" {
+		query = query.Where("family = ?", family)
+	}
+	if active := c.Query("active"); active != "" {
+		query = query.Where("is_active = ?", active == "true")
+	}
+
+	var products []models.Product
+	if err := query.Order("name ASC").Find(&products).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		return
+	}
+
+	c.JSON(http.StatusOK, products)
+}
+
+func (h *ProductHandler) GetProduct(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	productID := c.Param("id")
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var product models.Product
+	if err := h.db.Where("id = ? AND tenant_id = ?", productID, user.TenantID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	var entries []models.PriceBookEntry
+	if err := h.db.Where("product_id = ? AND tenant_id = ?", product.ID, user.TenantID).
+		Order("price_book_id ASC, currency ASC").Find(&entries).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch prices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"product": product, "prices": entries})
+}
+
+func (h *ProductHandler) CreateProduct(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CreateProductRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var existing int64
+	h.db.Model(&models.Product{}).Where("tenant_id = ? AND sku = ?", user.TenantID, req.SKU).Count(&existing)
+	if existing > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A product with this SKU already exists"})
+		return
+	}
+
+	product := models.Product{
+		SKU:         req.SKU,
+		Name:        req.Name,
+		Family:      req.Family,
+		Description: req.Description,
+		IsActive:    req.IsActive == nil || *req.IsActive,
+		TenantID:    user.TenantID,
+	}
+	if err := h.db.WithContext(c).Create(&product).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create product"})
+		return
+	}
+	// The column default would otherwise turn an explicit false into true
+	if !product.IsActive {
+		h.db.WithContext(c).Model(&product).Update("is_active", false)
+	}
+
+	c.JSON(http.StatusCreated, product)
+}
+
+func (h *ProductHandler) UpdateProduct(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	productID := c.Param("id")
+	var req UpdateProductRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var product models.Product
+	if err := h.db.Where("id = ? AND tenant_id = ?", productID, user.TenantID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	if req.SKU != nil && *req.SKU != product.SKU {
+		var existing int64
+		h.db.Model(&models.Product{}).Where("tenant_id = ? AND sku = ? AND id <> ?", user.TenantID, *req.SKU, product.ID).Count(&existing)
+		if existing > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "A product with this SKU already exists"})
+			return
+		}
+		product.SKU = *req.SKU
+	}
+	if req.Name != nil {
+		product.Name = *req.Name
+	}
+	if req.Family != nil {
+		product.Family = req.Family
+	}
+	if req.Description != nil {
+		product.Description = req.Description
+	}
+	if req.IsActive != nil {
+		product.IsActive = *req.IsActive
+	}
+
+	if err := h.db.WithContext(c).Save(&product).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update product"})
+		return
+	}
+
+	c.JSON(http.StatusOK, product)
+}
+
+// DeleteProduct deactivates a product. Products stay in the catalog so
+// existing deal line items keep their reference.
+func (h *ProductHandler) DeleteProduct(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	productID := c.Param("id")
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var product models.Product
+	if err := h.db.Where("id = ? AND tenant_id = ?", productID, user.TenantID).First(&product).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+		return
+	}
+
+	product.IsActive = false
+	if err := h.db.WithContext(c).Save(&product).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete product"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Product deactivated successfully"})
+}
+
+func (h *ProductHandler) GetPriceBooks(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var priceBooks []models.PriceBook
+	if err := h.db.Where("tenant_id = ?", user.TenantID).
+		Order("is_default DESC, name ASC").Find(&priceBooks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch price books"})
+		return
+	}
+
+	c.JSON(http.StatusOK, priceBooks)
+}
+
+// GetPriceBook returns a price book with its entries, optionally limited to
+// one currency
+func (h *ProductHandler) GetPriceBook(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	priceBookID := c.Param("id")
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	code := currency.Normalize(c.Query("currency"))
+	var priceBook models.PriceBook
+	if err := h.db.Where("id = ? AND tenant_id = ?", priceBookID, user.TenantID).
+		Preload("Entries", func(db *gorm.DB) *gorm.DB {
+			if code != "" {
+				db = db.Where("currency = ?", code)
+			}
+			return db.Order("currency ASC")
+		}).
+		Preload("Entries.Product").
+		First(&priceBook).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price book not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, priceBook)
+}
+
+func (h *ProductHandler) CreatePriceBook(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CreatePriceBookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	priceBook := models.PriceBook{
+		Name:        req.Name,
+		Description: req.Description,
+		IsDefault:   req.IsDefault,
+		IsActive:    true,
+		TenantID:    user.TenantID,
+	}
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if priceBook.IsDefault {
+			if err := clearDefaultPriceBook(tx, user.TenantID); err != nil {
+				return err
+			}
+		}
+		return tx.Create(&priceBook).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create price book"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, priceBook)
+}
+
+func (h *ProductHandler) UpdatePriceBook(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	priceBookID := c.Param("id")
+	var req UpdatePriceBookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var priceBook models.PriceBook
+	if err := h.db.Where("id = ? AND tenant_id = ?", priceBookID, user.TenantID).First(&priceBook).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price book not found"})
+		return
+	}
+
+	if req.Name != nil {
+		priceBook.Name = *req.Name
+	}
+	if req.Description != nil {
+		priceBook.Description = req.Description
+	}
+	if req.IsDefault != nil {
+		priceBook.IsDefault = *req.IsDefault
+	}
+	if req.IsActive != nil {
+		priceBook.IsActive = *req.IsActive
+	}
+
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if priceBook.IsDefault {
+			if err := clearDefaultPriceBook(tx, user.TenantID); err != nil {
+				return err
+			}
+		}
+		return tx.Save(&priceBook).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update price book"})
+		return
+	}
+
+	c.JSON(http.StatusOK, priceBook)
+}
+
+// SetPriceBookEntries adds or replaces list prices in a price book. Entries
+// are keyed by product and currency; prices not in the request are kept.
+func (h *ProductHandler) SetPriceBookEntries(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	priceBookID := c.Param("id")
+	var req SetPriceBookEntriesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var priceBook models.PriceBook
+	if err := h.db.Where("id = ? AND tenant_id = ?", priceBookID, user.TenantID).First(&priceBook).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price book not found"})
+		return
+	}
+
+	entries := make([]models.PriceBookEntry, 0, len(req.Entries))
+	productIDs := map[string]bool{}
+	for _, entry := range req.Entries {
+		code := currency.Normalize(entry.Currency)
+		if !currency.Valid(code) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ISO 4217 currency code", "details": entry.Currency})
+			return
+		}
+		productIDs[entry.ProductID] = true
+		entries = append(entries, models.PriceBookEntry{
+			PriceBookID: priceBook.ID,
+			ProductID:   entry.ProductID,
+			Currency:    code,
+			ListPrice:   entry.ListPrice,
+			TenantID:    user.TenantID,
+		})
+	}
+
+	ids := make([]string, 0, len(productIDs))
+	for id := range productIDs {
+		ids = append(ids, id)
+	}
+	var found int64
+	h.db.Model(&models.Product{}).Where("id IN ? AND tenant_id = ?", ids, user.TenantID).Count(&found)
+	if int(found) != len(ids) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown product in entries"})
+		return
+	}
+
+	if len(entries) > 0 {
+		if err := h.db.WithContext(c).Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "price_book_id"}, {Name: "product_id"}, {Name: "currency"}},
+			DoUpdates: clause.AssignmentColumns([]string{"list_price", "updated_at"}),
+		}).Create(&entries).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save prices"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+func (h *ProductHandler) DeletePriceBookEntry(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	result := h.db.WithContext(c).
+		Where("id = ? AND price_book_id = ? AND tenant_id = ?", c.Param("entryId"), c.Param("id"), user.TenantID).
+		Delete(&models.PriceBookEntry{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete price"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Price not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Price deleted successfully"})
+}
+
+// clearDefaultPriceBook unsets the tenant's current default price book so
+// another can take its place
+func clearDefaultPriceBook(tx *gorm.DB, tenantID string) error {
+	return tx.Model(&models.PriceBook{}).
+		Where("tenant_id = ? AND is_default = ?", tenantID, true).
+		Update("is_default", false).Error
+}
+
+// listPrice looks up a product's price in the given currency, using the
+// price book when set and the tenant's default price book otherwise
+func listPrice(db *gorm.DB, tenantID string, priceBookID *string, productID, code string) (*float64, error) {
+	query := db.Model(&models.PriceBookEntry{}).
+		Joins("JOIN price_books ON price_books.id = price_book_entries.price_book_id").
+		Where("price_book_entries.tenant_id = ? AND price_book_entries.product_id = ? AND price_book_entries.currency = ?", tenantID, productID, code).
+		Where("price_books.is_active = ?", true)
+	if priceBookID != nil {
+		query = query.Where("price_books.id = ?", *priceBookID)
+	} else {
+		query = query.Where("price_books.is_default = ?", true)
+	}
+
+	var entries []models.PriceBookEntry
+	if err := query.Limit(1).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	return &entries[0].ListPrice, nil
+}
@@ -0,0 +1,953 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"finhub-backend/audit"
+	"finhub-backend/models"
+	"finhub-backend/quotedoc"
+)
+
+// Quote statuses
+const (
+	QuoteDraft    = "draft"
+	QuoteSent     = "sent"
+	QuoteAccepted = "accepted"
+	QuoteRejected = "rejected"
+)
+
+// defaultQuoteValidity is how long a quote is valid when no expiry is given
+const defaultQuoteValidity = 30 * 24 * time.Hour
+
+// QuoteHandler manages deal quotes, their PDFs and quote templates
+type QuoteHandler struct {
+	db *gorm.DB
+}
+
+type CreateQuoteRequest struct {
+	ExpiresAt  *string `json:"expiresAt"`
+	Terms      *string `json:"terms"`
+	TemplateID *string `json:"templateId"`
+}
+
+type UpdateQuoteRequest struct {
+	ExpiresAt  *string `json:"expiresAt"`
+	Terms      *string `json:"terms"`
+	TemplateID *string `json:"templateId"`
+}
+
+type AcceptQuoteRequest struct {
+	Notes *string `json:"notes"`
+	// Move the deal to the pipeline's quote accepted stage, default true
+	AdvanceStage *bool `json:"advanceStage"`
+}
+
+type RejectQuoteRequest struct {
+	Notes *string `json:"notes"`
+}
+
+type QuoteTemplateRequest struct {
+	Name      string `json:"name" binding:"required"`
+	Body      string `json:"body" binding:"required"`
+	IsDefault bool   `json:"isDefault"`
+}
+
+func NewQuoteHandler(db *gorm.DB) *QuoteHandler {
+	return &QuoteHandler{db: db}
+}
+
+// GetDealQuotes lists every quote version for a deal, newest first
+func (h *QuoteHandler) GetDealQuotes(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	dealID := c.Param("id")
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var quotes []models.Quote
+	if err := h.db.Where("deal_id = ? AND tenant_id = ?", dealID, user.TenantID).
+		Order("number DESC, version DESC").
+		Find(&quotes).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch quotes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, quotes)
+}
+
+// CreateQuote starts a new quote for a deal from its current line items
+func (h *QuoteHandler) CreateQuote(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	dealID := c.Param("id")
+	var req CreateQuoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var deal models.Deal
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", dealID, user.TenantID, false).
+		First(&deal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
+		return
+	}
+
+	expiresAt := time.Now().Add(defaultQuoteValidity).Truncate(24 * time.Hour)
+	if req.ExpiresAt != nil {
+		date, err := parseDate(*req.ExpiresAt)
+		if err != nil || date == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expiresAt"})
+			return
+		}
+		expiresAt = *date
+	}
+
+	userIDStr := userID.(string)
+	quote := models.Quote{
+		DealID:     deal.ID,
+		Version:    1,
+		Status:     QuoteDraft,
+		ExpiresAt:  &expiresAt,
+		Terms:      req.Terms,
+		TemplateID: req.TemplateID,
+		TenantID:   user.TenantID,
+		CreatedBy:  &userIDStr,
+	}
+
+	var validationErrors []ValidationError
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		number, err := nextQuoteNumber(tx, user.TenantID)
+		if err != nil {
+			return err
+		}
+		quote.Number = number
+		validationErrors, err = snapshotLineItems(tx, &deal, &quote)
+		if err != nil || len(validationErrors) > 0 {
+			return err
+		}
+		return tx.Create(&quote).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create quote"})
+		return
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	c.JSON(http.StatusCreated, quote)
+}
+
+func (h *QuoteHandler) GetQuote(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	quote, err := h.loadQuote(c.Param("id"), user.TenantID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Quote not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, quote)
+}
+
+// UpdateQuote changes the expiry, terms or template of a draft quote
+func (h *QuoteHandler) UpdateQuote(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req UpdateQuoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	quote, err := h.loadQuote(c.Param("id"), user.TenantID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Quote not found"})
+		return
+	}
+	if quote.Status != QuoteDraft {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only draft quotes can be edited; create a new version instead"})
+		return
+	}
+
+	if req.ExpiresAt != nil {
+		date, err := parseDate(*req.ExpiresAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expiresAt"})
+			return
+		}
+		quote.ExpiresAt = date
+	}
+	if req.Terms != nil {
+		quote.Terms = req.Terms
+	}
+	if req.TemplateID != nil {
+		quote.TemplateID = req.TemplateID
+		if *req.TemplateID == "" {
+			quote.TemplateID = nil
+		}
+	}
+
+	if err := h.db.WithContext(c).Omit("Lines").Save(quote).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update quote"})
+		return
+	}
+
+	c.JSON(http.StatusOK, quote)
+}
+
+// CreateQuoteVersion creates the next version of a quote from the deal's
+// current line items. Expiry, terms and template carry over unless given.
+func (h *QuoteHandler) CreateQuoteVersion(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req CreateQuoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	previous, err := h.loadQuote(c.Param("id"), user.TenantID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Quote not found"})
+		return
+	}
+
+	var deal models.Deal
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", previous.DealID, user.TenantID, false).
+		First(&deal).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
+		return
+	}
+
+	userIDStr := userID.(string)
+	quote := models.Quote{
+		DealID:     deal.ID,
+		Number:     previous.Number,
+		Status:     QuoteDraft,
+		ExpiresAt:  previous.ExpiresAt,
+		Terms:      previous.Terms,
+		TemplateID: previous.TemplateID,
+		TenantID:   user.TenantID,
+		CreatedBy:  &userIDStr,
+	}
+	if req.ExpiresAt != nil {
+		date, err := parseDate(*req.ExpiresAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expiresAt"})
+			return
+		}
+		quote.ExpiresAt = date
+	}
+	if req.Terms != nil {
+		quote.Terms = req.Terms
+	}
+	if req.TemplateID != nil {
+		quote.TemplateID = req.TemplateID
+	}
+
+	var validationErrors []ValidationError
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := lockQuoteNumbers(tx, user.TenantID); err != nil {
+			return err
+		}
+		var latest int
+		if err := tx.Model(&models.Quote{}).
+			Select("COALESCE(MAX(version), 0)").
+			Where("tenant_id = ? AND number = ?", user.TenantID, previous.Number).
+			Scan(&latest).Error; err != nil {
+			return err
+		}
+		quote.Version = latest + 1
+		validationErrors, err = snapshotLineItems(tx, &deal, &quote)
+		if err != nil || len(validationErrors) > 0 {
+			return err
+		}
+		return tx.Create(&quote).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create quote version"})
+		return
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	c.JSON(http.StatusCreated, quote)
+}
+
+// GenerateQuotePDF renders the quote and attaches the PDF to its deal,
+// replacing the PDF from any earlier rendering
+func (h *QuoteHandler) GenerateQuotePDF(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	quote, err := h.loadQuote(c.Param("id"), user.TenantID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Quote not found"})
+		return
+	}
+
+	var attachment *models.DealAttachment
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		attachment, err = attachQuotePDF(tx, quote, userID.(string))
+		return err
+	}); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Failed to render quote", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, attachment)
+}
+
+// SendQuote marks the latest version of a draft quote as sent, rendering
+// its PDF. Discounts that need approval must have been approved first.
+func (h *QuoteHandler) SendQuote(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	quote, err := h.loadQuote(c.Param("id"), user.TenantID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Quote not found"})
+		return
+	}
+	if quote.Status != QuoteDraft {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only draft quotes can be sent"})
+		return
+	}
+	validationErrors, err := h.validateLatestVersion(quote)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch quote versions"})
+		return
+	}
+	lines, err := quotePricedLines(h.db, quote)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch quote lines"})
+		return
+	}
+	approvalErrors, err := checkDiscountApproval(h.db, user.TenantID, quote.DealID, lines)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate approval rules"})
+		return
+	}
+	validationErrors = append(validationErrors, approvalErrors...)
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	now := time.Now()
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if _, err := attachQuotePDF(tx, quote, userID.(string)); err != nil {
+			return err
+		}
+		quote.Status = QuoteSent
+		quote.SentAt = &now
+		return tx.Omit("Lines").Save(quote).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send quote", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, quote)
+}
+
+// AcceptQuote records the customer's acceptance of a sent quote. Unless
+// advanceStage is false, the deal moves to its pipeline's quote accepted
+// stage when one is configured.
+func (h *QuoteHandler) AcceptQuote(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req AcceptQuoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	quote, err := h.loadQuote(c.Param("id"), user.TenantID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Quote not found"})
+		return
+	}
+	if quote.Status != QuoteSent {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only sent quotes can be accepted"})
+		return
+	}
+
+	now := time.Now()
+	validationErrors, err := h.validateLatestVersion(quote)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch quote versions"})
+		return
+	}
+	if quote.ExpiresAt != nil && quote.ExpiresAt.Before(now.Truncate(24*time.Hour)) {
+		validationErrors = append(validationErrors, ValidationError{Field: "expiresAt", Code: "expired", Message: "The quote has expired"})
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	userIDStr := userID.(string)
+	changeReason := fmt.Sprintf("Quote %s v%d accepted", quote.Number, quote.Version)
+	var deal models.Deal
+	err = h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		// Only one decision wins when an accept and a reject race
+		result := tx.Model(&models.Quote{}).
+			Where("id = ? AND status = ?", quote.ID, QuoteSent).
+			Updates(map[string]interface{}{
+				"status":         QuoteAccepted,
+				"accepted_at":    now,
+				"decision_notes": req.Notes,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errQuoteDecided
+		}
+		quote.Status = QuoteAccepted
+		quote.AcceptedAt = &now
+		quote.DecisionNotes = req.Notes
+
+		// Work out the stage move on the deal as it is now, holding its lock
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND tenant_id = ? AND is_deleted = ?", quote.DealID, user.TenantID, false).
+			First(&deal).Error; err != nil {
+			return err
+		}
+		if req.AdvanceStage != nil && !*req.AdvanceStage {
+			return nil
+		}
+		before := deal
+		validationErrors, err := advanceDealOnAcceptance(tx, user.TenantID, &deal, now)
+		if err != nil {
+			return err
+		}
+		if len(validationErrors) > 0 {
+			return errQuoteAcceptInvalid(validationErrors)
+		}
+		if deal.StageID == before.StageID {
+			return nil
+		}
+		// Closing as won is blocked by a pending discount approval, as in
+		// CloseDeal
+		if deal.ActualCloseDate != nil && before.ActualCloseDate == nil {
+			if err := lockDealWithoutApproval(tx, deal.ID); err != nil {
+				return err
+			}
+		}
+		deal.UpdatedAt = now
+		if err := tx.Model(&deal).Select("stage_id", "probability", "actual_close_date", "updated_at").Updates(&deal).Error; err != nil {
+			return err
+		}
+		return recordDealChange(tx, &before, &deal, DealChange{MovedBy: &userIDStr, ChangeReason: &changeReason, Notes: req.Notes})
+	})
+	if err != nil {
+		var invalid errQuoteAcceptInvalid
+		switch {
+		case errors.As(err, &invalid):
+			respondValidationErrors(c, invalid)
+		case errors.Is(err, errQuoteDecided):
+			c.JSON(http.StatusConflict, gin.H{"error": "Only sent quotes can be accepted"})
+		case errors.Is(err, errApprovalPending):
+			respondValidationErrors(c, []ValidationError{pendingApprovalError})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Deal not found"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to accept quote"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"quote": quote, "deal": deal})
+}
+
+// RejectQuote records that the customer declined a sent quote
+func (h *QuoteHandler) RejectQuote(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req RejectQuoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	quote, err := h.loadQuote(c.Param("id"), user.TenantID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Quote not found"})
+		return
+	}
+	if quote.Status != QuoteSent {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only sent quotes can be rejected"})
+		return
+	}
+
+	now := time.Now()
+	result := h.db.WithContext(c).Model(&models.Quote{}).
+		Where("id = ? AND status = ?", quote.ID, QuoteSent).
+		Updates(map[string]interface{}{
+			"status":         QuoteRejected,
+			"rejected_at":    now,
+			"decision_notes": req.Notes,
+		})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject quote"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only sent quotes can be rejected"})
+		return
+	}
+	quote.Status = QuoteRejected
+	quote.RejectedAt = &now
+	quote.DecisionNotes = req.Notes
+
+	c.JSON(http.StatusOK, quote)
+}
+
+// GetDealAttachments lists the files attached to a deal
+func (h *QuoteHandler) GetDealAttachments(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var attachments []models.DealAttachment
+	if err := h.db.Omit("content").
+		Where("deal_id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).
+		Order("created_at DESC").
+		Find(&attachments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attachments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, attachments)
+}
+
+// DownloadDealAttachment returns the contents of a deal attachment
+func (h *QuoteHandler) DownloadDealAttachment(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var attachment models.DealAttachment
+	if err := h.db.Where("id = ? AND deal_id = ? AND tenant_id = ?", c.Param("attachmentId"), c.Param("id"), user.TenantID).
+		First(&attachment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", attachment.Filename))
+	c.Data(http.StatusOK, attachment.MimeType, attachment.Content)
+}
+
+func (h *QuoteHandler) GetQuoteTemplates(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var templates []models.QuoteTemplate
+	if err := h.db.Where("tenant_id = ?", user.TenantID).
+		Order("is_default DESC, name ASC").
+		Find(&templates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch quote templates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"templates": templates, "defaultBody": quotedoc.DefaultTemplate})
+}
+
+func (h *QuoteHandler) CreateQuoteTemplate(c *gin.Context) {
+	h.saveQuoteTemplate(c, "")
+}
+
+func (h *QuoteHandler) UpdateQuoteTemplate(c *gin.Context) {
+	h.saveQuoteTemplate(c, c.Param("id"))
+}
+
+// saveQuoteTemplate creates a template, or replaces the one with the given ID.
+// Templates must parse before they are stored.
+func (h *QuoteHandler) saveQuoteTemplate(c *gin.Context, templateID string) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req QuoteTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if _, err := quotedoc.Parse(req.Body); err != nil {
+		respondValidationErrors(c, []ValidationError{{Field: "body", Code: "invalid_template", Message: err.Error()}})
+		return
+	}
+
+	tmpl := models.QuoteTemplate{TenantID: user.TenantID}
+	status := http.StatusCreated
+	if templateID != "" {
+		if err := h.db.Where("id = ? AND tenant_id = ?", templateID, user.TenantID).First(&tmpl).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Quote template not found"})
+			return
+		}
+		status = http.StatusOK
+	}
+	tmpl.Name = req.Name
+	tmpl.Body = req.Body
+	tmpl.IsDefault = req.IsDefault
+
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if tmpl.IsDefault {
+			if err := tx.Model(&models.QuoteTemplate{}).
+				Where("tenant_id = ? AND is_default = ?", user.TenantID, true).
+				Update("is_default", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Save(&tmpl).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save quote template"})
+		return
+	}
+
+	c.JSON(status, tmpl)
+}
+
+func (h *QuoteHandler) DeleteQuoteTemplate(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	result := h.db.WithContext(c).Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).Delete(&models.QuoteTemplate{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete quote template"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Quote template not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Quote template deleted successfully"})
+}
+
+func (h *QuoteHandler) loadQuote(quoteID, tenantID string) (*models.Quote, error) {
+	var quote models.Quote
+	if err := h.db.Where("id = ? AND tenant_id = ?", quoteID, tenantID).
+		Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("sort_order ASC") }).
+		First(&quote).Error; err != nil {
+		return nil, err
+	}
+	return &quote, nil
+}
+
+// validateLatestVersion rejects actions on quote versions that have been
+// superseded by a newer one
+func (h *QuoteHandler) validateLatestVersion(quote *models.Quote) ([]ValidationError, error) {
+	var newer int64
+	if err := h.db.Model(&models.Quote{}).
+		Where("tenant_id = ? AND number = ? AND version > ?", quote.TenantID, quote.Number, quote.Version).
+		Count(&newer).Error; err != nil {
+		return nil, err
+	}
+	if newer > 0 {
+		return []ValidationError{{Field: "version", Code: "superseded", Message: "A newer version of this quote exists"}}, nil
+	}
+	return nil, nil
+}
+
+// lockQuoteNumbers holds the tenant's quote numbering lock until tx ends, so
+// that concurrent quotes and versions cannot be given the same number
+func lockQuoteNumbers(tx *gorm.DB, tenantID string) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", "quote-number:"+tenantID).Error
+}
+
+// nextQuoteNumber returns the tenant's next quote number, Q-00001 onwards
+func nextQuoteNumber(tx *gorm.DB, tenantID string) (string, error) {
+	if err := lockQuoteNumbers(tx, tenantID); err != nil {
+		return "", err
+	}
+	var count int64
+	if err := tx.Model(&models.Quote{}).
+		Where("tenant_id = ? AND version = ?", tenantID, 1).
+		Count(&count).Error; err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Q-%05d", count+1), nil
+}
+
+// snapshotLineItems copies the deal's line items and totals onto the quote.
+// A deal without line items cannot be quoted.
+func snapshotLineItems(tx *gorm.DB, deal *models.Deal, quote *models.Quote) ([]ValidationError, error) {
+	items, err := loadDealLineItems(tx, []string{deal.ID})
+	if err != nil {
+		return nil, err
+	}
+	if len(items[deal.ID]) == 0 {
+		return []ValidationError{{Field: "lineItems", Code: "required", Message: "Add line items to the deal before creating a quote"}}, nil
+	}
+
+	quote.Currency = deal.Currency
+	quote.Subtotal, quote.Total = 0, 0
+	quote.Lines = nil
+	for _, item := range items[deal.ID] {
+		line := models.QuoteLineItem{
+			ProductID:       stringPtr(item.ProductID),
+			Description:     item.Description,
+			Quantity:        item.Quantity,
+			UnitPrice:       item.UnitPrice,
+			DiscountPercent: item.DiscountPercent,
+			TermMonths:      item.TermMonths,
+			TotalPrice:      item.TotalPrice,
+			SortOrder:       item.SortOrder,
+			TenantID:        quote.TenantID,
+		}
+		if item.Product != nil {
+			line.SKU = item.Product.SKU
+			line.Name = item.Product.Name
+		}
+		quote.Lines = append(quote.Lines, line)
+		quote.Subtotal += item.Quantity * item.UnitPrice
+		quote.Total += item.TotalPrice
+	}
+	quote.Subtotal = lineItemTotal(1, quote.Subtotal, 0)
+	quote.Total = lineItemTotal(1, quote.Total, 0)
+	quote.DiscountTotal = lineItemTotal(1, quote.Subtotal-quote.Total, 0)
+	return nil, nil
+}
+
+// attachQuotePDF renders the quote with its template, or the tenant's default
+// template, and stores the PDF as an attachment on the deal
+func attachQuotePDF(tx *gorm.DB, quote *models.Quote, userID string) (*models.DealAttachment, error) {
+	var deal models.Deal
+	if err := tx.Preload("Company").Preload("Contact").First(&deal, "id = ?", quote.DealID).Error; err != nil {
+		return nil, err
+	}
+	var tenant models.Tenant
+	if err := tx.Select("name").First(&tenant, "id = ?", quote.TenantID).Error; err != nil {
+		return nil, err
+	}
+
+	var templates []models.QuoteTemplate
+	query := tx.Where("tenant_id = ?", quote.TenantID)
+	if quote.TemplateID != nil {
+		query = query.Where("id = ?", *quote.TemplateID)
+	} else {
+		query = query.Where("is_default = ?", true)
+	}
+	if err := query.Limit(1).Find(&templates).Error; err != nil {
+		return nil, err
+	}
+	body := quotedoc.DefaultTemplate
+	if len(templates) > 0 {
+		body = templates[0].Body
+	}
+
+	content, err := quotedoc.Render(body, quotedoc.Data{Tenant: tenant.Name, Quote: *quote, Deal: deal})
+	if err != nil {
+		return nil, err
+	}
+
+	if quote.AttachmentID != nil {
+		if err := tx.Where("id = ?", *quote.AttachmentID).Delete(&models.DealAttachment{}).Error; err != nil {
+			return nil, err
+		}
+	}
+	attachment := models.DealAttachment{
+		DealID:    quote.DealID,
+		QuoteID:   &quote.ID,
+		Filename:  fmt.Sprintf("%s-v%d.pdf", quote.Number, quote.Version),
+		MimeType:  "application/pdf",
+		Size:      len(content),
+		Content:   content,
+		TenantID:  quote.TenantID,
+		CreatedBy: &userID,
+	}
+	// File contents are kept out of the audit log
+	if err := audit.Skip(tx).Create(&attachment).Error; err != nil {
+		return nil, err
+	}
+	quote.AttachmentID = &attachment.ID
+	if err := tx.Model(quote).Update("attachment_id", attachment.ID).Error; err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// errQuoteDecided is returned when a quote stopped being sent before it could
+// be accepted or rejected
+var errQuoteDecided = errors.New("quote already decided")
+
+// errQuoteAcceptInvalid carries validation errors found inside the acceptance
+// transaction
+type errQuoteAcceptInvalid []ValidationError
+
+func (e errQuoteAcceptInvalid) Error() string { return "invalid quote acceptance" }
+
+// advanceDealOnAcceptance moves the deal into its pipeline's quote accepted
+// stage, closing it as won when that stage is closed won. Open deals only.
+func advanceDealOnAcceptance(db *gorm.DB, tenantID string, deal *models.Deal, now time.Time) ([]ValidationError, error) {
+	var pipeline models.Pipeline
+	if err := db.Select("quote_accepted_stage_id").First(&pipeline, "id = ?", deal.PipelineID).Error; err != nil {
+		return nil, err
+	}
+	if pipeline.QuoteAcceptedStageID == nil || *pipeline.QuoteAcceptedStageID == deal.StageID {
+		return nil, nil
+	}
+
+	var current, target models.Stage
+	if err := db.First(&current, "id = ?", deal.StageID).Error; err != nil {
+		return nil, err
+	}
+	if current.IsClosedWon || current.IsClosedLost {
+		return nil, nil
+	}
+	if err := db.Where("id = ? AND is_deleted = ?", *pipeline.QuoteAcceptedStageID, false).First(&target).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	before := *deal
+	deal.StageID = target.ID
+	if target.IsClosedWon {
+		deal.ActualCloseDate = &now
+		deal.Probability = 100
+	}
+	return validateDealStage(db, tenantID, &before, deal)
+}
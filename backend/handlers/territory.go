@@ -0,0 +1,488 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"finhub-backend/models"
+	"finhub-backend/territories"
+)
+
+// TerritoryHandler manages territories and the companies matched to them
+type TerritoryHandler struct {
+	db *gorm.DB
+}
+
+type TerritoryRequest struct {
+	Name        string   `json:"name" binding:"required"`
+	TypeID      *string  `json:"typeId"`
+	ParentID    *string  `json:"parentId"`
+	Countries   []string `json:"countries"`
+	States      []string `json:"states"`
+	Cities      []string `json:"cities"`
+	PostalCodes []string `json:"postalCodes"`
+	Industries  []string `json:"industries"`
+	CompanySize []string `json:"companySize"`
+	OwnerID     *string  `json:"ownerId"`
+}
+
+type TerritoryMemberRequest struct {
+	UserID string `json:"userId" binding:"required"`
+	Role   string `json:"role" binding:"required"`
+}
+
+type TerritoryMembersRequest struct {
+	Members []TerritoryMemberRequest `json:"members" binding:"dive"`
+}
+
+// territorySubtreeSQL selects the IDs of a territory and all its child
+// territories, at any depth
+const territorySubtreeSQL = `WITH RECURSIVE subtree AS (
+	SELECT id FROM territories WHERE id = ?
+	UNION
+	SELECT territories.id FROM territories JOIN subtree ON territories.parent_id = subtree.id
+) SELECT id FROM subtree`
+
+// territoryMemberRoles are the roles users can hold in a territory besides
+// owner
+var territoryMemberRoles = map[string]bool{
+	"manager":   true,
+	"sales_rep": true,
+	"overlay":   true,
+	"support":   true,
+}
+
+type TerritoryResponse struct {
+	models.Territory
+	CompanyCount int64 `json:"companyCount"`
+}
+
+func NewTerritoryHandler(db *gorm.DB) *TerritoryHandler {
+	return &TerritoryHandler{db: db}
+}
+
+func (h *TerritoryHandler) GetTerritories(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var list []models.Territory
+	if err := h.db.Preload("Owner").Where("tenant_id = ?", user.TenantID).
+		Order("name ASC").
+		Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch territories"})
+		return
+	}
+
+	var counts []struct {
+		TerritoryID string
+		Count       int64
+	}
+	if err := h.db.Model(&models.CompanyTerritory{}).
+		Select("territory_id, COUNT(*) as count").
+		Where("tenant_id = ?", user.TenantID).
+		Group("territory_id").
+		Scan(&counts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count territory companies"})
+		return
+	}
+	countByID := map[string]int64{}
+	for _, count := range counts {
+		countByID[count.TerritoryID] = count.Count
+	}
+
+	response := make([]TerritoryResponse, len(list))
+	for i, territory := range list {
+		response[i] = TerritoryResponse{Territory: territory, CompanyCount: countByID[territory.ID]}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (h *TerritoryHandler) GetTerritory(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var territory models.Territory
+	if err := h.db.Preload("Owner").Preload("Members.User").Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).
+		First(&territory).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Territory not found"})
+		return
+	}
+
+	var count int64
+	h.db.Model(&models.CompanyTerritory{}).Where("territory_id = ?", territory.ID).Count(&count)
+	c.JSON(http.StatusOK, TerritoryResponse{Territory: territory, CompanyCount: count})
+}
+
+func (h *TerritoryHandler) CreateTerritory(c *gin.Context) {
+	h.saveTerritory(c, "")
+}
+
+func (h *TerritoryHandler) UpdateTerritory(c *gin.Context) {
+	h.saveTerritory(c, c.Param("id"))
+}
+
+// saveTerritory creates a territory, or replaces the one with the given ID,
+// and recomputes the tenant's company memberships. Only administrators may
+// change territories; a territory cannot be nested under itself or its
+// descendants.
+func (h *TerritoryHandler) saveTerritory(c *gin.Context, territoryID string) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req TerritoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var validationErrors []ValidationError
+	for i, pattern := range req.PostalCodes {
+		if !territories.ValidPostalPattern(pattern) {
+			validationErrors = append(validationErrors, ValidationError{Field: fmt.Sprintf("postalCodes[%d]", i), Code: "invalid_postal_code", Message: "Use a postal code, a prefix ending in * or a range of equal-length codes such as 10000-14999"})
+		}
+	}
+	if req.TypeID != nil {
+		var count int64
+		h.db.Model(&models.TerritoryType{}).Where("id = ? AND tenant_id = ?", *req.TypeID, user.TenantID).Count(&count)
+		if count == 0 {
+			validationErrors = append(validationErrors, ValidationError{Field: "typeId", Code: "invalid_type", Message: "Territory type not found"})
+		}
+	}
+	if req.OwnerID != nil {
+		var count int64
+		h.db.Model(&models.User{}).Where("id = ? AND tenant_id = ?", *req.OwnerID, user.TenantID).Count(&count)
+		if count == 0 {
+			validationErrors = append(validationErrors, ValidationError{Field: "ownerId", Code: "invalid_user", Message: "User not found"})
+		}
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	territory := models.Territory{TenantID: user.TenantID}
+	status := http.StatusCreated
+	if territoryID != "" {
+		if err := h.db.Where("id = ? AND tenant_id = ?", territoryID, user.TenantID).First(&territory).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Territory not found"})
+			return
+		}
+		status = http.StatusOK
+	}
+	territory.Name = req.Name
+	territory.TypeID = req.TypeID
+	territory.Countries = nonNil(req.Countries)
+	territory.States = nonNil(req.States)
+	territory.Cities = nonNil(req.Cities)
+	territory.PostalCodes = nonNil(req.PostalCodes)
+	territory.Industries = nonNil(req.Industries)
+	territory.CompanySize = nonNil(req.CompanySize)
+	territory.OwnerID = req.OwnerID
+	territory.ParentID = req.ParentID
+
+	if territory.ParentID != nil {
+		var list []models.Territory
+		if err := h.db.Where("tenant_id = ? AND id <> ?", user.TenantID, territory.ID).Find(&list).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch territories"})
+			return
+		}
+		if territory.ID == "" {
+			territory.ID = uuid.New().String()
+		}
+		if err := territories.ValidateHierarchy(append(list, territory)); err != nil {
+			respondValidationErrors(c, []ValidationError{{Field: "parentId", Code: "invalid_parent", Message: err.Error()}})
+			return
+		}
+	}
+
+	if err := h.db.WithContext(c).Omit("Owner", "Members").Save(&territory).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save territory"})
+		return
+	}
+	if err := territories.AssignTenant(h.db.WithContext(c), user.TenantID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recompute territory membership"})
+		return
+	}
+
+	c.JSON(status, territory)
+}
+
+// DeleteTerritory deletes a territory that no assignment rule routes to,
+// with its memberships
+func (h *TerritoryHandler) DeleteTerritory(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var territory models.Territory
+	if err := h.db.Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).First(&territory).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Territory not found"})
+		return
+	}
+
+	var rules int64
+	h.db.Model(&models.AssignmentRule{}).Where("territory_id = ?", territory.ID).Count(&rules)
+	if rules > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Territory is used by assignment rules"})
+		return
+	}
+	var children int64
+	h.db.Model(&models.Territory{}).Where("parent_id = ?", territory.ID).Count(&children)
+	if children > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Territory has child territories"})
+		return
+	}
+
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("territory_id = ?", territory.ID).Delete(&models.CompanyTerritory{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("territory_id = ?", territory.ID).Delete(&models.TerritoryMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&territory).Error
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete territory"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Territory deleted successfully"})
+}
+
+// SetTerritoryMembers replaces the users working a territory and their roles
+func (h *TerritoryHandler) SetTerritoryMembers(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req TerritoryMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var territory models.Territory
+	if err := h.db.Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).First(&territory).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Territory not found"})
+		return
+	}
+
+	var validationErrors []ValidationError
+	seen := map[string]bool{}
+	for i, member := range req.Members {
+		field := fmt.Sprintf("members[%d].userId", i)
+		if seen[member.UserID] {
+			validationErrors = append(validationErrors, ValidationError{Field: field, Code: "duplicate", Message: "User is listed twice"})
+		}
+		seen[member.UserID] = true
+		var count int64
+		h.db.Model(&models.User{}).Where("id = ? AND tenant_id = ?", member.UserID, user.TenantID).Count(&count)
+		if count == 0 {
+			validationErrors = append(validationErrors, ValidationError{Field: field, Code: "invalid_user", Message: "User not found"})
+		}
+		if !territoryMemberRoles[member.Role] {
+			validationErrors = append(validationErrors, ValidationError{Field: fmt.Sprintf("members[%d].role", i), Code: "invalid_role", Message: "Role must be manager, sales_rep, overlay or support"})
+		}
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var existing []models.TerritoryMember
+		if err := tx.Where("territory_id = ?", territory.ID).Find(&existing).Error; err != nil {
+			return err
+		}
+		current := map[string]models.TerritoryMember{}
+		for _, member := range existing {
+			if !seen[member.UserID] {
+				if err := tx.Delete(&member).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			current[member.UserID] = member
+		}
+		for _, memberReq := range req.Members {
+			member, ok := current[memberReq.UserID]
+			if !ok {
+				member = models.TerritoryMember{TerritoryID: territory.ID, UserID: memberReq.UserID, TenantID: user.TenantID}
+			} else if member.Role == memberReq.Role {
+				continue
+			}
+			member.Role = memberReq.Role
+			if err := tx.Omit("User").Save(&member).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save territory members"})
+		return
+	}
+
+	var members []models.TerritoryMember
+	h.db.Preload("User").Where("territory_id = ?", territory.ID).Order("created_at ASC").Find(&members)
+	c.JSON(http.StatusOK, members)
+}
+
+// GetTerritoryCompanies lists the companies matched to a territory
+func (h *TerritoryHandler) GetTerritoryCompanies(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var territory models.Territory
+	if err := h.db.Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).First(&territory).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Territory not found"})
+		return
+	}
+
+	var companies []models.Company
+	if err := h.db.Preload("Industry").Preload("Size").
+		Joins("JOIN company_territories ON company_territories.company_id = companies.id").
+		Where("company_territories.territory_id = ? AND companies.is_deleted = ?", territory.ID, false).
+		Order("companies.name ASC").
+		Find(&companies).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch companies"})
+		return
+	}
+
+	c.JSON(http.StatusOK, companies)
+}
+
+// GetCompanyTerritories lists the territories a company is matched to
+func (h *TerritoryHandler) GetCompanyTerritories(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var company models.Company
+	if err := h.db.Where("id = ? AND tenant_id = ? AND is_deleted = ?", c.Param("id"), user.TenantID, false).
+		First(&company).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Company not found"})
+		return
+	}
+
+	var list []models.Territory
+	if err := h.db.Preload("Owner").
+		Joins("JOIN company_territories ON company_territories.territory_id = territories.id").
+		Where("company_territories.company_id = ?", company.ID).
+		Order("territories.name ASC").
+		Find(&list).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch territories"})
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// RecomputeTerritories rebuilds the territory memberships of all of the
+// tenant's companies, e.g. after a bulk import
+func (h *TerritoryHandler) RecomputeTerritories(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	if err := territories.AssignTenant(h.db.WithContext(c), user.TenantID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to recompute territory membership"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Territory membership recomputed"})
+}
+
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"finhub-backend/models"
+	"finhub-backend/territories"
+)
+
+// TerritoryRealignmentHandler previews, applies and undoes bulk changes to
+// territory definitions and the owners they imply
+type TerritoryRealignmentHandler struct {
+	db *gorm.DB
+}
+
+type TerritoryRealignmentRequest struct {
+	Name        string                       `json:"name" binding:"required"`
+	Territories []models.TerritoryDefinition `json:"territories" binding:"required,min=1"`
+}
+
+func NewTerritoryRealignmentHandler(db *gorm.DB) *TerritoryRealignmentHandler {
+	return &TerritoryRealignmentHandler{db: db}
+}
+
+func (h *TerritoryRealignmentHandler) GetRealignments(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var realignments []models.TerritoryRealignment
+	if err := h.db.Omit("definitions", "previous").
+		Where("tenant_id = ?", user.TenantID).
+		Order("created_at DESC").
+		Find(&realignments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch realignments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, realignments)
+}
+
+func (h *TerritoryRealignmentHandler) GetRealignment(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var realignment models.TerritoryRealignment
+	if err := h.db.Preload("Changes", func(db *gorm.DB) *gorm.DB {
+		return db.Order("company_id ASC, entity_type ASC, entity_name ASC")
+	}).Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).
+		First(&realignment).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Realignment not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, realignment)
+}
+
+// CreateRealignment previews a set of territory definitions: it records
+// which companies, leads and deals would change owner without changing
+// anything. Definitions with an existing ID replace or delete that
+// territory; the rest create territories, with the given ID if any so
+// other definitions can nest under them.
+func (h *TerritoryRealignmentHandler) CreateRealignment(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req TerritoryRealignmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	validationErrors, err := h.validateDefinitions(user.TenantID, req.Territories)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate territories"})
+		return
+	}
+	if len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	changes, err := territories.Preview(h.db, user.TenantID, req.Territories)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to preview realignment"})
+		return
+	}
+
+	realignment := models.TerritoryRealignment{
+		Name:        req.Name,
+		Status:      territories.StatusPreview,
+		Definitions: req.Territories,
+		TenantID:    user.TenantID,
+		CreatedBy:   &user.ID,
+	}
+	for _, change := range changes {
+		switch change.EntityType {
+		case "company":
+			realignment.CompanyCount++
+		case "lead":
+			realignment.LeadCount++
+		case "deal":
+			realignment.DealCount++
+		}
+	}
+
+	if err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Changes").Create(&realignment).Error; err != nil {
+			return err
+		}
+		for i := range changes {
+			changes[i].RealignmentID = realignment.ID
+		}
+		if len(changes) > 0 {
+			return tx.CreateInBatches(&changes, 500).Error
+		}
+		return nil
+	}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save realignment"})
+		return
+	}
+
+	realignment.Changes = changes
+	c.JSON(http.StatusCreated, realignment)
+}
+
+// validateDefinitions checks realignment definitions against the tenant's
+// territories, giving new ones an ID
+func (h *TerritoryRealignmentHandler) validateDefinitions(tenantID string, defs []models.TerritoryDefinition) ([]ValidationError, error) {
+	var current []models.Territory
+	if err := h.db.Where("tenant_id = ?", tenantID).Find(&current).Error; err != nil {
+		return nil, err
+	}
+	existing := map[string]bool{}
+	for _, territory := range current {
+		existing[territory.ID] = true
+	}
+
+	var validationErrors []ValidationError
+	seen := map[string]bool{}
+	for i := range defs {
+		def := &defs[i]
+		field := func(name string) string { return fmt.Sprintf("territories[%d].%s", i, name) }
+		def.Created = false
+		def.Members = nil
+
+		switch {
+		case def.ID == "" && def.Delete:
+			validationErrors = append(validationErrors, ValidationError{Field: field("id"), Code: "required", Message: "Territory to delete is required"})
+			continue
+		case def.ID == "":
+			def.ID = uuid.New().String()
+		case seen[def.ID]:
+			validationErrors = append(validationErrors, ValidationError{Field: field("id"), Code: "duplicate", Message: "Territory is listed twice"})
+			continue
+		case !existing[def.ID]:
+			if _, err := uuid.Parse(def.ID); err != nil {
+				validationErrors = append(validationErrors, ValidationError{Field: field("id"), Code: "invalid_id", Message: "New territory IDs must be UUIDs"})
+				continue
+			}
+			var count int64
+			h.db.Model(&models.Territory{}).Where("id = ?", def.ID).Count(&count)
+			if count > 0 || def.Delete {
+				validationErrors = append(validationErrors, ValidationError{Field: field("id"), Code: "not_found", Message: "Territory not found"})
+				continue
+			}
+		}
+		seen[def.ID] = true
+
+		if def.Delete {
+			var rules int64
+			h.db.Model(&models.AssignmentRule{}).Where("territory_id = ?", def.ID).Count(&rules)
+			if rules > 0 {
+				validationErrors = append(validationErrors, ValidationError{Field: field("delete"), Code: "in_use", Message: "Territory is used by assignment rules"})
+			}
+			continue
+		}
+
+		if def.Name == "" {
+			validationErrors = append(validationErrors, ValidationError{Field: field("name"), Code: "required", Message: "Name is required"})
+		}
+		for j, pattern := range def.PostalCodes {
+			if !territories.ValidPostalPattern(pattern) {
+				validationErrors = append(validationErrors, ValidationError{Field: field(fmt.Sprintf("postalCodes[%d]", j)), Code: "invalid_postal_code", Message: "Use a postal code, a prefix ending in * or a range of equal-length codes such as 10000-14999"})
+			}
+		}
+		if def.TypeID != nil {
+			var count int64
+			h.db.Model(&models.TerritoryType{}).Where("id = ? AND tenant_id = ?", *def.TypeID, tenantID).Count(&count)
+			if count == 0 {
+				validationErrors = append(validationErrors, ValidationError{Field: field("typeId"), Code: "invalid_type", Message: "Territory type not found"})
+			}
+		}
+		if def.OwnerID != nil {
+			var count int64
+			h.db.Model(&models.User{}).Where("id = ? AND tenant_id = ?", *def.OwnerID, tenantID).Count(&count)
+			if count == 0 {
+				validationErrors = append(validationErrors, ValidationError{Field: field("ownerId"), Code: "invalid_user", Message: "User not found"})
+			}
+		}
+		def.Countries = nonNil(def.Countries)
+		def.States = nonNil(def.States)
+		def.Cities = nonNil(def.Cities)
+		def.PostalCodes = nonNil(def.PostalCodes)
+		def.Industries = nonNil(def.Industries)
+		def.CompanySize = nonNil(def.CompanySize)
+	}
+	if len(validationErrors) > 0 {
+		return validationErrors, nil
+	}
+
+	if err := territories.ValidateHierarchy(territories.Propose(current, defs)); err != nil {
+		validationErrors = append(validationErrors, ValidationError{Field: "territories", Code: "invalid_parent", Message: err.Error()})
+	}
+	return validationErrors, nil
+}
+
+// ApplyRealignment saves a previewed realignment's definitions and moves the
+// owners of its companies, leads and deals in one transaction. Records
+// whose owner changed since the preview are skipped.
+func (h *TerritoryRealignmentHandler) ApplyRealignment(c *gin.Context) {
+	h.transition(c, territories.StatusPreview, func(tx *gorm.DB, realignment *models.TerritoryRealignment, user *models.User) error {
+		previous, err := territories.Apply(tx, realignment)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		undoUntil := now.Add(territories.UndoWindow)
+		realignment.Status = territories.StatusApplied
+		realignment.Previous = previous
+		realignment.AppliedAt = &now
+		realignment.AppliedBy = &user.ID
+		realignment.UndoUntil = &undoUntil
+		realignment.UpdatedAt = now
+		return tx.Model(realignment).
+			Select("status", "previous", "applied_at", "applied_by", "undo_until", "updated_at").
+			Updates(realignment).Error
+	})
+}
+
+// UndoRealignment restores the territories an applied realignment changed
+// and returns its records to their previous owners, within the undo window
+func (h *TerritoryRealignmentHandler) UndoRealignment(c *gin.Context) {
+	h.transition(c, territories.StatusApplied, func(tx *gorm.DB, realignment *models.TerritoryRealignment, user *models.User) error {
+		if err := territories.Undo(tx, realignment); err != nil {
+			return err
+		}
+		now := time.Now()
+		realignment.Status = territories.StatusUndone
+		realignment.UndoneAt = &now
+		realignment.UndoneBy = &user.ID
+		realignment.UpdatedAt = now
+		return tx.Model(realignment).
+			Select("status", "undone_at", "undone_by", "updated_at").
+			Updates(realignment).Error
+	})
+}
+
+// transition locks a realignment in the given status and runs fn on it
+func (h *TerritoryRealignmentHandler) transition(c *gin.Context, status string, fn func(tx *gorm.DB, realignment *models.TerritoryRealignment, user *models.User) error) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var conflict string
+	err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		var realignment models.TerritoryRealignment
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).
+			First(&realignment).Error; err != nil {
+			return err
+		}
+		if realignment.Status != status {
+			conflict = fmt.Sprintf("Realignment is %s", realignment.Status)
+			return nil
+		}
+		if status == territories.StatusApplied && realignment.UndoUntil != nil && time.Now().After(*realignment.UndoUntil) {
+			conflict = "Undo window has passed"
+			return nil
+		}
+		if status == territories.StatusPreview {
+			// A later realignment may have changed the same territories
+			var applied int64
+			if err := tx.Model(&models.TerritoryRealignment{}).
+				Where("tenant_id = ? AND status = ? AND applied_at > ?", user.TenantID, territories.StatusApplied, realignment.CreatedAt).
+				Count(&applied).Error; err != nil {
+				return err
+			}
+			if applied > 0 {
+				conflict = "Another realignment was applied after this preview; create a new preview"
+				return nil
+			}
+		}
+		if err := tx.Where("realignment_id = ?", realignment.ID).Find(&realignment.Changes).Error; err != nil {
+			return err
+		}
+		return fn(tx, &realignment, &user)
+	})
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Realignment not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update realignment"})
+		return
+	}
+	if conflict != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": conflict})
+		return
+	}
+
+	var realignment models.TerritoryRealignment
+	h.db.Preload("Changes").First(&realignment, "id = ?", c.Param("id"))
+	c.JSON(http.StatusOK, realignment)
+}
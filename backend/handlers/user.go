@@ -72,7 +72,7 @@ func (h *UserHandler) UpdateCurrentUser(c *gin.Context) {
 		user.Avatar = req.Avatar
 	}
 
-	if err := h.db.Save(&user).Error; err != nil {
+	if err := h.db.WithContext(c).Save(&user).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
 		return
 	}
@@ -82,3 +82,95 @@ func (h *UserHandler) UpdateCurrentUser(c *gin.Context) {
 
 	c.JSON(http.StatusOK, user)
 }
+
+type SetManagerRequest struct {
+	ManagerID *string `json:"managerId"`
+}
+
+// GetUsers lists the users in the current user's tenant
+func (h *UserHandler) GetUsers(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var users []models.User
+	if err := h.db.Where("tenant_id = ?", user.TenantID).
+		Preload("Role").
+		Order("last_name ASC, first_name ASC").
+		Find(&users).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, users)
+}
+
+// SetManager sets or clears a user's manager, who approves their discounts.
+// Only administrators may change reporting lines.
+func (h *UserHandler) SetManager(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req SetManagerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var admin models.User
+	if err := h.db.Preload("Role").First(&admin, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if admin.Role == nil || admin.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Where("id = ? AND tenant_id = ?", c.Param("id"), admin.TenantID).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	user.ManagerID = nil
+	if req.ManagerID != nil && *req.ManagerID != "" {
+		// Walk up from the new manager to make sure the user is not above them
+		for current := *req.ManagerID; ; {
+			if current == user.ID {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "A user cannot report to themselves or their own reports"})
+				return
+			}
+			var manager models.User
+			if err := h.db.Select("id", "manager_id").Where("id = ? AND tenant_id = ?", current, admin.TenantID).
+				First(&manager).Error; err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Manager not found"})
+				return
+			}
+			if manager.ManagerID == nil {
+				break
+			}
+			current = *manager.ManagerID
+		}
+		user.ManagerID = req.ManagerID
+	}
+
+	if err := h.db.WithContext(c).Model(&user).Update("manager_id", user.ManagerID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user"})
+		return
+	}
+
+	user.Password = ""
+	c.JSON(http.StatusOK, user)
+}
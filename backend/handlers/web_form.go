@@ -0,0 +1,684 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"finhub-backend/assignment"
+	"finhub-backend/audit"
+	"finhub-backend/domains"
+	"finhub-backend/duplicates"
+	"finhub-backend/models"
+	"finhub-backend/scoring"
+)
+
+// WebFormHandler manages web-to-lead forms and takes their public
+// submissions
+type WebFormHandler struct {
+	db *gorm.DB
+}
+
+type WebFormRequest struct {
+	Name           string                `json:"name" binding:"required"`
+	Fields         []models.WebFormField `json:"fields" binding:"required,min=1"`
+	AllowedOrigins []string              `json:"allowedOrigins"`
+	HoneypotField  string                `json:"honeypotField"`
+	RateLimit      int                   `json:"rateLimit" binding:"min=0"`
+	Source         *string               `json:"source"`
+	StatusID       *string               `json:"statusId"`
+	IsActive       *bool                 `json:"isActive"`
+}
+
+// Web form defaults
+const (
+	defaultHoneypotField = "website"
+	defaultFormRateLimit = 10
+	// maxSubmissionBytes bounds the body of a public form post
+	maxSubmissionBytes = 16 << 10
+)
+
+// webFormFields are the lead attributes form fields can fill
+var webFormFields = map[string]bool{
+	"firstName": true,
+	"lastName":  true,
+	"title":     true,
+	"email":     true,
+	"phone":     true,
+}
+
+// utmParameters map UTM parameters to MarketingSource columns
+var utmParameters = []struct{ param, column string }{
+	{"utm_source", "source"},
+	{"utm_medium", "medium"},
+	{"utm_campaign", "campaign"},
+	{"utm_term", "term"},
+	{"utm_content", "content"},
+}
+
+// Web form submission outcomes
+const (
+	submissionCreated   = "created"
+	submissionSpam      = "spam"
+	submissionRejected  = "rejected"
+	submissionDuplicate = "duplicate"
+	// Recorded when the submission is accepted for processing and kept when
+	// processing fails
+	submissionFailed = "failed"
+)
+
+func NewWebFormHandler(db *gorm.DB) *WebFormHandler {
+	return &WebFormHandler{db: db}
+}
+
+func (h *WebFormHandler) GetWebForms(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var forms []models.WebForm
+	if err := h.db.Where("tenant_id = ?", user.TenantID).Order("name ASC").Find(&forms).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch web forms"})
+		return
+	}
+
+	c.JSON(http.StatusOK, forms)
+}
+
+func (h *WebFormHandler) GetWebForm(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	var form models.WebForm
+	if err := h.db.Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).First(&form).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Web form not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, form)
+}
+
+func (h *WebFormHandler) CreateWebForm(c *gin.Context) {
+	h.saveWebForm(c, "")
+}
+
+func (h *WebFormHandler) UpdateWebForm(c *gin.Context) {
+	h.saveWebForm(c, c.Param("id"))
+}
+
+// saveWebForm creates a form with a new token, or replaces the settings of
+// the one with the given ID. Only administrators may change forms.
+func (h *WebFormHandler) saveWebForm(c *gin.Context, formID string) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req WebFormRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	if req.HoneypotField == "" {
+		req.HoneypotField = defaultHoneypotField
+	}
+	if req.RateLimit == 0 {
+		req.RateLimit = defaultFormRateLimit
+	}
+	if validationErrors := h.validateWebForm(user.TenantID, &req); len(validationErrors) > 0 {
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	form := models.WebForm{TenantID: user.TenantID, IsActive: true, CreatedBy: &user.ID}
+	status := http.StatusCreated
+	if formID != "" {
+		if err := h.db.Where("id = ? AND tenant_id = ?", formID, user.TenantID).First(&form).Error; err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Web form not found"})
+			return
+		}
+		status = http.StatusOK
+	} else {
+		token, err := newFormToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate form token"})
+			return
+		}
+		form.Token = token
+	}
+	form.Name = req.Name
+	form.Fields = req.Fields
+	form.AllowedOrigins = make([]string, 0, len(req.AllowedOrigins))
+	for _, origin := range req.AllowedOrigins {
+		form.AllowedOrigins = append(form.AllowedOrigins, normalizeOrigin(origin))
+	}
+	form.HoneypotField = req.HoneypotField
+	form.RateLimit = req.RateLimit
+	form.Source = req.Source
+	form.StatusID = req.StatusID
+	if req.IsActive != nil {
+		form.IsActive = *req.IsActive
+	}
+
+	if err := h.db.WithContext(c).Save(&form).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save web form"})
+		return
+	}
+
+	c.JSON(status, form)
+}
+
+func (h *WebFormHandler) validateWebForm(tenantID string, req *WebFormRequest) []ValidationError {
+	var validationErrors []ValidationError
+	names := map[string]bool{}
+	hasContact := false
+	for i, field := range req.Fields {
+		if field.Name == "" {
+			validationErrors = append(validationErrors, ValidationError{Field: fmt.Sprintf("fields[%d].name", i), Code: "required", Message: "Field name is required"})
+		} else if names[field.Name] {
+			validationErrors = append(validationErrors, ValidationError{Field: fmt.Sprintf("fields[%d].name", i), Code: "duplicate", Message: "Field name is used twice"})
+		}
+		names[field.Name] = true
+		if !webFormFields[field.Field] {
+			validationErrors = append(validationErrors, ValidationError{Field: fmt.Sprintf("fields[%d].field", i), Code: "invalid_field", Message: "Field must be firstName, lastName, title, email or phone"})
+		}
+		hasContact = hasContact || field.Field == "email" || field.Field == "phone"
+	}
+	if !hasContact {
+		validationErrors = append(validationErrors, ValidationError{Field: "fields", Code: "required", Message: "Map at least one field to email or phone"})
+	}
+	if names[req.HoneypotField] {
+		validationErrors = append(validationErrors, ValidationError{Field: "honeypotField", Code: "conflict", Message: "Honeypot field must not be a form field"})
+	}
+	for i, origin := range req.AllowedOrigins {
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" {
+			validationErrors = append(validationErrors, ValidationError{Field: fmt.Sprintf("allowedOrigins[%d]", i), Code: "invalid_origin", Message: "Origins look like https://www.example.com"})
+		}
+	}
+	if req.StatusID != nil {
+		var count int64
+		h.db.Model(&models.LeadStatus{}).Where("id = ? AND tenant_id = ?", *req.StatusID, tenantID).Count(&count)
+		if count == 0 {
+			validationErrors = append(validationErrors, ValidationError{Field: "statusId", Code: "invalid_status", Message: "Lead status not found"})
+		}
+	}
+	return validationErrors
+}
+
+func (h *WebFormHandler) DeleteWebForm(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	result := h.db.WithContext(c).Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).Delete(&models.WebForm{})
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete web form"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Web form not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Web form deleted successfully"})
+}
+
+// RegenerateToken replaces a form's token, e.g. after it leaked; websites
+// posting with the old token are rejected
+func (h *WebFormHandler) RegenerateToken(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Preload("Role").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if user.Role == nil || user.Role.Code != "ADMIN" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+		return
+	}
+
+	var form models.WebForm
+	if err := h.db.Where("id = ? AND tenant_id = ?", c.Param("id"), user.TenantID).First(&form).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Web form not found"})
+		return
+	}
+	token, err := newFormToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate form token"})
+		return
+	}
+	if err := h.db.WithContext(c).Model(&form).Update("token", token).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save web form"})
+		return
+	}
+
+	c.JSON(http.StatusOK, form)
+}
+
+// GetSubmissions lists a form's submissions, newest first
+func (h *WebFormHandler) GetSubmissions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var user models.User
+	if err := h.db.Select("tenant_id").First(&user, "id = ?", userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid page"})
+		return
+	}
+	pageSize, err := strconv.Atoi(c.DefaultQuery("pageSize", "50"))
+	if err != nil || pageSize < 1 || pageSize > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pageSize"})
+		return
+	}
+
+	query := h.db.Model(&models.WebFormSubmission{}).
+		Where("form_id = ? AND tenant_id = ?", c.Param("id"), user.TenantID)
+	if outcome := c.Query("outcome"); outcome != "" {
+		query = query.Where("outcome = ?", outcome)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch submissions"})
+		return
+	}
+	var submissions []models.WebFormSubmission
+	if err := query.Order("created_at DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&submissions).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch submissions"})
+		return
+	}
+
+	totalPages := int((total + int64(pageSize) - 1) / int64(pageSize))
+	c.JSON(http.StatusOK, gin.H{
+		"entries":    submissions,
+		"totalCount": total,
+		"page":       page,
+		"pageSize":   pageSize,
+		"totalPages": totalPages,
+		"hasMore":    page < totalPages,
+	})
+}
+
+// Submit creates a lead from a public form post, JSON or form encoded.
+// UTM parameters in the body or query string select the lead's marketing
+// source. Spam caught by the honeypot and submissions blocked as
+// duplicates get the same response as a real submission so senders cannot
+// tell.
+func (h *WebFormHandler) Submit(c *gin.Context) {
+	var form models.WebForm
+	if err := h.db.Where("token = ? AND is_active = ?", c.Param("token"), true).First(&form).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Form not found"})
+		return
+	}
+
+	submission := models.WebFormSubmission{
+		FormID:    form.ID,
+		IPAddress: c.ClientIP(),
+		TenantID:  form.TenantID,
+	}
+	origin := requestOrigin(c.Request)
+	if origin != "" {
+		submission.Origin = &origin
+	}
+	if len(form.AllowedOrigins) > 0 && !containsOrigin(form.AllowedOrigins, origin) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Origin not allowed"})
+		return
+	}
+
+	// The submission is recorded before it is processed, counting and
+	// inserting under a lock on the form and address, so that concurrent
+	// posts cannot all get past the rate limit
+	submission.Outcome = submissionFailed
+	limited := false
+	err := h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", form.ID+"|"+submission.IPAddress).Error; err != nil {
+			return err
+		}
+		var recent int64
+		if err := tx.Model(&models.WebFormSubmission{}).
+			Where("form_id = ? AND ip_address = ? AND created_at > ?", form.ID, submission.IPAddress, time.Now().Add(-time.Hour)).
+			Count(&recent).Error; err != nil {
+			return err
+		}
+		if recent >= int64(form.RateLimit) {
+			limited = true
+			return nil
+		}
+		return audit.Skip(tx).Create(&submission).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process submission"})
+		return
+	}
+	if limited {
+		c.Header("Retry-After", "3600")
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many submissions, try again later"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSubmissionBytes)
+	values, err := submissionValues(c)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Form data too large"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid form data"})
+		return
+	}
+
+	accepted := gin.H{"message": "Thank you, your details were received"}
+	if values[form.HoneypotField] != "" {
+		submission.Outcome = submissionSpam
+		logSubmissionOutcome(h.db, &submission)
+		c.JSON(http.StatusCreated, accepted)
+		return
+	}
+
+	lead := models.Lead{TenantID: form.TenantID, StatusID: form.StatusID, Source: form.Source}
+	var email, phone string
+	var validationErrors []ValidationError
+	for _, field := range form.Fields {
+		value := strings.TrimSpace(values[field.Name])
+		if value == "" {
+			if field.Required {
+				validationErrors = append(validationErrors, ValidationError{Field: field.Name, Code: "required", Message: "This field is required"})
+			}
+			continue
+		}
+		switch field.Field {
+		case "firstName":
+			lead.FirstName = &value
+		case "lastName":
+			lead.LastName = &value
+		case "title":
+			lead.Title = &value
+		case "email":
+			if address, err := mail.ParseAddress(value); err != nil || address.Address != value {
+				validationErrors = append(validationErrors, ValidationError{Field: field.Name, Code: "invalid_email", Message: "Enter a valid email address"})
+			}
+			email = strings.ToLower(value)
+		case "phone":
+			phone = value
+		}
+	}
+	if len(validationErrors) == 0 && email == "" && phone == "" {
+		validationErrors = append(validationErrors, ValidationError{Field: "email", Code: "required", Message: "Enter an email address or phone number"})
+	}
+	if len(validationErrors) > 0 {
+		detail := validationErrors[0].Field + ": " + validationErrors[0].Code
+		submission.Outcome = submissionRejected
+		submission.Detail = &detail
+		logSubmissionOutcome(h.db, &submission)
+		respondValidationErrors(c, validationErrors)
+		return
+	}
+
+	record := duplicates.Record{
+		EntityType: duplicates.EntityLead,
+		TenantID:   form.TenantID,
+		FirstName:  deref(lead.FirstName),
+		LastName:   deref(lead.LastName),
+	}
+	if email != "" {
+		record.Emails = []string{email}
+		// A work email links the lead to the company with its domain
+		companyID, err := domains.MatchCompany(h.db, form.TenantID, record.Emails)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process submission"})
+			return
+		}
+		lead.CompanyID, record.CompanyID = companyID, companyID
+	}
+	if phone != "" {
+		record.Phones = []string{phone}
+	}
+	found, err := duplicates.Find(h.db, record)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process submission"})
+		return
+	}
+	if found.Blocked {
+		// The visitor is already known; tell them nothing different
+		detail := "duplicate of lead " + found.Matches[0].EntityID
+		submission.Outcome = submissionDuplicate
+		submission.Detail = &detail
+		logSubmissionOutcome(h.db, &submission)
+		c.JSON(http.StatusCreated, accepted)
+		return
+	}
+
+	err = h.db.WithContext(c).Transaction(func(tx *gorm.DB) error {
+		source, err := h.marketingSource(tx, form.TenantID, values)
+		if err != nil {
+			return err
+		}
+		if source != nil {
+			lead.MarketingSourceID = &source.ID
+			if source.Source != nil {
+				lead.Source = source.Source
+			}
+			lead.Campaign = source.Campaign
+		}
+		if err := tx.Create(&lead).Error; err != nil {
+			return err
+		}
+		if email != "" {
+			if err := tx.Create(&models.EmailAddress{Email: email, IsPrimary: true, EntityID: lead.ID, EntityType: "lead", TenantID: form.TenantID}).Error; err != nil {
+				return err
+			}
+		}
+		if phone != "" {
+			if err := tx.Create(&models.PhoneNumber{Number: phone, IsPrimary: true, EntityID: lead.ID, EntityType: "lead", TenantID: form.TenantID}).Error; err != nil {
+				return err
+			}
+		}
+		if _, err := assignment.AssignLead(tx, &lead, assignment.TriggerWebForm); err != nil {
+			return err
+		}
+		if err := scoring.ScoreLead(tx, lead.ID); err != nil {
+			return err
+		}
+		submission.Outcome = submissionCreated
+		submission.LeadID = &lead.ID
+		return saveSubmissionOutcome(tx, &submission)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process submission"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, accepted)
+}
+
+// saveSubmissionOutcome records how processing a submission ended
+func saveSubmissionOutcome(db *gorm.DB, submission *models.WebFormSubmission) error {
+	return audit.Skip(db).Model(submission).Select("outcome", "detail", "lead_id").Updates(submission).Error
+}
+
+// logSubmissionOutcome records the outcome of a submission whose response no
+// longer depends on it, logging a failure instead
+func logSubmissionOutcome(db *gorm.DB, submission *models.WebFormSubmission) {
+	if err := saveSubmissionOutcome(db, submission); err != nil {
+		log.Printf("Failed to record outcome %s of web form submission %s: %v", submission.Outcome, submission.ID, err)
+	}
+}
+
+// marketingSource finds the tenant's marketing source with exactly the
+// submitted UTM values, creating it when there is none. It returns nil when
+// the submission has no UTM parameters.
+func (h *WebFormHandler) marketingSource(tx *gorm.DB, tenantID string, values map[string]string) (*models.MarketingSource, error) {
+	source := models.MarketingSource{TenantID: tenantID}
+	fields := map[string]**string{
+		"source":   &source.Source,
+		"medium":   &source.Medium,
+		"campaign": &source.Campaign,
+		"term":     &source.Term,
+		"content":  &source.Content,
+	}
+	query := tx.Where("tenant_id = ?", tenantID)
+	var parts []string
+	for _, utm := range utmParameters {
+		var value *string
+		if v := strings.TrimSpace(values[utm.param]); v != "" {
+			value = &v
+			parts = append(parts, v)
+		}
+		*fields[utm.column] = value
+		query = query.Where(utm.column+" IS NOT DISTINCT FROM ?", value)
+	}
+	if len(parts) == 0 {
+		return nil, nil
+	}
+
+	var existing models.MarketingSource
+	if err := query.Order("created_at ASC").Limit(1).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	if existing.ID != "" {
+		return &existing, nil
+	}
+	source.Name = strings.Join(parts, " / ")
+	if err := tx.Create(&source).Error; err != nil {
+		return nil, err
+	}
+	return &source, nil
+}
+
+// submissionValues reads the posted fields, and UTM parameters from the
+// query string when the body has none
+func submissionValues(c *gin.Context) (map[string]string, error) {
+	values := map[string]string{}
+	if strings.HasPrefix(c.ContentType(), "application/json") {
+		var body map[string]interface{}
+		if err := json.NewDecoder(c.Request.Body).Decode(&body); err != nil {
+			return nil, err
+		}
+		for key, value := range body {
+			switch v := value.(type) {
+			case string:
+				values[key] = v
+			case float64, bool:
+				values[key] = fmt.Sprint(v)
+			}
+		}
+	} else {
+		if err := c.Request.ParseForm(); err != nil {
+			return nil, err
+		}
+		for key := range c.Request.PostForm {
+			values[key] = c.Request.PostForm.Get(key)
+		}
+	}
+	for _, utm := range utmParameters {
+		if values[utm.param] == "" {
+			values[utm.param] = c.Query(utm.param)
+		}
+	}
+	return values, nil
+}
+
+// requestOrigin returns the scheme and host the request was posted from,
+// from the Origin header or else the Referer
+func requestOrigin(r *http.Request) string {
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "null" {
+		return normalizeOrigin(origin)
+	}
+	if referer, err := url.Parse(r.Referer()); err == nil && referer.Host != "" {
+		return normalizeOrigin(referer.Scheme + "://" + referer.Host)
+	}
+	return ""
+}
+
+func normalizeOrigin(origin string) string {
+	return strings.ToLower(strings.TrimRight(strings.TrimSpace(origin), "/"))
+}
+
+func containsOrigin(allowed []string, origin string) bool {
+	for _, a := range allowed {
+		if origin != "" && a == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// newFormToken returns a random token that is hard to guess
+func newFormToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
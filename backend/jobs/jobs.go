@@ -0,0 +1,30 @@
+package jobs
+
+import (
+	"log"
+	"time"
+)
+
+// Every runs fn in the background, once straight away and then at every
+// interval. Failures are logged and retried on the next tick.
+func Every(name string, interval time.Duration, fn func() error) {
+	go func() {
+		run(name, fn)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			run(name, fn)
+		}
+	}()
+}
+
+func run(name string, fn func() error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Job %s panicked: %v", name, r)
+		}
+	}()
+	if err := fn(); err != nil {
+		log.Printf("Job %s failed: %v", name, err)
+	}
+}
@@ -10,10 +10,17 @@ import (
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 
+	"finhub-backend/audit"
 	"finhub-backend/config"
+	"finhub-backend/contracts"
+	"finhub-backend/domains"
+	"finhub-backend/forecast"
 	"finhub-backend/handlers"
+	"finhub-backend/jobs"
 	"finhub-backend/middleware"
 	"finhub-backend/models"
+	"finhub-backend/scoring"
+	"finhub-backend/territories"
 )
 
 func main() {
@@ -33,23 +40,35 @@ func main() {
 		&models.UserRole{},
 		&models.Industry{},
 		&models.CompanySize{},
+		&models.ExchangeRate{},
 		&models.LeadStatus{},
 		&models.LeadTemperature{},
 		&models.Pipeline{},
 		&models.Stage{},
+		&models.StageTransition{},
+		&models.DealCloseReason{},
+		&models.DealSplit{},
 		&models.MarketingSourceType{},
 		&models.MarketingSource{},
 		&models.MarketingAssetType{},
 		&models.MarketingAsset{},
+		&models.MarketingInteraction{},
+		&models.WebForm{},
+		&models.WebFormSubmission{},
 		&models.CommunicationType{},
 		&models.TaskType{},
 		&models.TerritoryType{},
 		&models.Territory{},
+		&models.TerritoryMember{},
+		&models.CompanyTerritory{},
+		&models.TerritoryRealignment{},
+		&models.TerritoryRealignmentChange{},
 		&models.CustomField{},
 		&models.CustomFieldValue{},
 		&models.CustomObject{},
 		&models.ActivityLog{},
 		&models.DealStageHistory{},
+		&models.ForecastSnapshot{},
 		&models.PhoneNumberType{},
 		&models.PhoneNumber{},
 		&models.EmailAddressType{},
@@ -66,11 +85,63 @@ func main() {
 		&models.Contact{},
 		&models.Lead{},
 		&models.Deal{},
+		&models.Product{},
+		&models.PriceBook{},
+		&models.PriceBookEntry{},
+		&models.DealLineItem{},
+		&models.QuoteTemplate{},
+		&models.Quote{},
+		&models.QuoteLineItem{},
+		&models.DealAttachment{},
+		&models.ApprovalRule{},
+		&models.DealApproval{},
+		&models.ApprovalDecision{},
+		&models.Contract{},
+		&models.LeadScoringRule{},
+		&models.LeadScoreThreshold{},
+		&models.UserGroup{},
+		&models.UserGroupMember{},
+		&models.AssignmentRule{},
+		&models.AssignmentLog{},
+		&models.DuplicateRule{},
+		&models.RecordMerge{},
 		&models.Task{},
 		&models.Communication{},
 	); err != nil {
 		log.Fatal("Failed to migrate database:", err)
 	}
+	// Derive company domains from websites for companies saved before domains
+	// were normalized
+	if err := domains.Backfill(db); err != nil {
+		log.Println("Failed to backfill company domains:", err)
+	}
+	// Created after the backfill has normalized existing domains; it fails
+	// while companies share a domain, which then has to be cleaned up
+	if err := domains.CreateUniqueIndex(db); err != nil {
+		log.Println("Failed to create company domain index:", err)
+	}
+
+	// Record field-level changes to tenant-owned models in the activity log
+	if err := audit.Register(db); err != nil {
+		log.Fatal("Failed to register audit callbacks:", err)
+	}
+	// Keep company territory membership in step with companies and addresses
+	if err := territories.Register(db); err != nil {
+		log.Fatal("Failed to register territory callbacks:", err)
+	}
+
+	// Background jobs. Forecast snapshots are taken once a week; checking more
+	// often makes sure a restart does not skip one.
+	jobs.Every("forecast snapshots", 6*time.Hour, func() error {
+		return forecast.CaptureSnapshots(db, time.Now())
+	})
+	jobs.Every("contract renewals", 6*time.Hour, func() error {
+		return contracts.Process(db, time.Now())
+	})
+	// Lead scores are refreshed hourly so activity leaving rule windows counts
+	jobs.Every("lead scoring", time.Hour, func() error {
+		return scoring.ScoreAll(db)
+	})
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(db, cfg)
@@ -81,16 +152,38 @@ func main() {
 	dealHandler := handlers.NewDealHandler(db)
 	picklistHandler := handlers.NewPicklistHandler(db)
 	entityHandler := handlers.NewEntityHandler(db)
+	historyHandler := handlers.NewHistoryHandler(db)
+	pipelineHandler := handlers.NewPipelineHandler(db)
+	closeReasonHandler := handlers.NewCloseReasonHandler(db)
+	forecastHandler := handlers.NewForecastHandler(db)
+	analyticsHandler := handlers.NewAnalyticsHandler(db)
+	currencyHandler := handlers.NewCurrencyHandler(db)
+	productHandler := handlers.NewProductHandler(db)
+	quoteHandler := handlers.NewQuoteHandler(db)
+	approvalHandler := handlers.NewApprovalHandler(db)
+	contractHandler := handlers.NewContractHandler(db)
+	leadScoringHandler := handlers.NewLeadScoringHandler(db)
+	assignmentHandler := handlers.NewAssignmentHandler(db)
+	territoryHandler := handlers.NewTerritoryHandler(db)
+	territoryRealignmentHandler := handlers.NewTerritoryRealignmentHandler(db)
+	webFormHandler := handlers.NewWebFormHandler(db)
+	duplicateHandler := handlers.NewDuplicateHandler(db)
+	mergeHandler := handlers.NewMergeHandler(db)
 
 	// Setup router
 	r := gin.Default()
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
 
 	// CORS middleware
 	r.Use(middleware.CORS())
+	r.Use(middleware.RequestInfo())
 
 	// Public routes
 	r.POST("/api/auth/register", authHandler.Register)
 	r.POST("/api/auth/login", authHandler.Login)
+	r.POST("/api/public/web-forms/:token", webFormHandler.Submit)
 
 	// Protected routes
 	api := r.Group("/api")
@@ -99,20 +192,32 @@ func main() {
 	// User routes
 	api.GET("/users/me", userHandler.GetCurrentUser)
 	api.PUT("/users/me", userHandler.UpdateCurrentUser)
+	api.GET("/users", userHandler.GetUsers)
+	api.PUT("/users/:id/manager", userHandler.SetManager)
 
 	// Company routes
 	api.GET("/companies", companyHandler.GetCompanies)
 	api.POST("/companies", companyHandler.CreateCompany)
+	api.POST("/companies/merge", mergeHandler.MergeRecords("company"))
 	api.GET("/companies/:id", companyHandler.GetCompany)
 	api.PUT("/companies/:id", companyHandler.UpdateCompany)
 	api.DELETE("/companies/:id", companyHandler.DeleteCompany)
+	api.GET("/companies/:id/history", historyHandler.GetHistory("company"))
+	api.POST("/companies/:id/restore", historyHandler.RestoreRecord("company"))
+	api.GET("/companies/:id/territories", territoryHandler.GetCompanyTerritories)
+	api.GET("/companies/:id/hierarchy", companyHandler.GetCompanyHierarchy)
+	api.GET("/companies/:id/duplicates", duplicateHandler.GetDuplicates("company"))
 
 	// Contact routes
 	api.GET("/contacts", contactHandler.GetContacts)
 	api.POST("/contacts", contactHandler.CreateContact)
+	api.POST("/contacts/merge", mergeHandler.MergeRecords("contact"))
 	api.GET("/contacts/:id", contactHandler.GetContact)
 	api.PUT("/contacts/:id", contactHandler.UpdateContact)
 	api.DELETE("/contacts/:id", contactHandler.DeleteContact)
+	api.GET("/contacts/:id/history", historyHandler.GetHistory("contact"))
+	api.POST("/contacts/:id/restore", historyHandler.RestoreRecord("contact"))
+	api.GET("/contacts/:id/duplicates", duplicateHandler.GetDuplicates("contact"))
 
 	// Lead routes
 	api.GET("/leads", leadHandler.GetLeads)
@@ -120,13 +225,188 @@ func main() {
 	api.GET("/leads/:id", leadHandler.GetLead)
 	api.PUT("/leads/:id", leadHandler.UpdateLead)
 	api.DELETE("/leads/:id", leadHandler.DeleteLead)
+	api.POST("/leads/:id/convert", leadHandler.ConvertLead)
+	api.GET("/leads/:id/score", leadScoringHandler.GetLeadScore)
+	api.GET("/leads/:id/history", historyHandler.GetHistory("lead"))
+	api.POST("/leads/:id/restore", historyHandler.RestoreRecord("lead"))
+	api.GET("/leads/:id/duplicates", duplicateHandler.GetDuplicates("lead"))
 
 	// Deal routes
 	api.GET("/deals", dealHandler.GetDeals)
 	api.POST("/deals", dealHandler.CreateDeal)
+	api.GET("/deals/export", dealHandler.ExportDeals)
 	api.GET("/deals/:id", dealHandler.GetDeal)
 	api.PUT("/deals/:id", dealHandler.UpdateDeal)
 	api.DELETE("/deals/:id", dealHandler.DeleteDeal)
+	api.GET("/deals/:id/history", historyHandler.GetHistory("deal"))
+	api.GET("/deals/:id/stage-history", dealHandler.GetDealHistory)
+	api.POST("/deals/:id/restore", historyHandler.RestoreRecord("deal"))
+	api.POST("/deals/:id/close", dealHandler.CloseDeal)
+	api.POST("/deals/:id/reopen", dealHandler.ReopenDeal)
+	api.GET("/deals/:id/line-items", dealHandler.GetDealLineItems)
+	api.POST("/deals/:id/line-items", dealHandler.CreateDealLineItem)
+	api.PUT("/deals/:id/line-items/:itemId", dealHandler.UpdateDealLineItem)
+	api.DELETE("/deals/:id/line-items/:itemId", dealHandler.DeleteDealLineItem)
+	api.GET("/deals/:id/quotes", quoteHandler.GetDealQuotes)
+	api.POST("/deals/:id/quotes", quoteHandler.CreateQuote)
+	api.GET("/deals/:id/attachments", quoteHandler.GetDealAttachments)
+	api.GET("/deals/:id/approvals", approvalHandler.GetDealApprovals)
+	api.POST("/deals/:id/approvals", approvalHandler.RequestDealApproval)
+	api.GET("/deals/:id/splits", dealHandler.GetDealSplits)
+	api.PUT("/deals/:id/splits", dealHandler.SetDealSplits)
+	api.POST("/deals/:id/move", dealHandler.MoveDeal)
+	api.GET("/deals/:id/attachments/:attachmentId", quoteHandler.DownloadDealAttachment)
+
+	// Quote routes
+	api.GET("/quotes/:id", quoteHandler.GetQuote)
+	api.PUT("/quotes/:id", quoteHandler.UpdateQuote)
+	api.POST("/quotes/:id/versions", quoteHandler.CreateQuoteVersion)
+	api.POST("/quotes/:id/pdf", quoteHandler.GenerateQuotePDF)
+	api.POST("/quotes/:id/send", quoteHandler.SendQuote)
+	api.POST("/quotes/:id/accept", quoteHandler.AcceptQuote)
+	api.POST("/quotes/:id/reject", quoteHandler.RejectQuote)
+	api.GET("/quote-templates", quoteHandler.GetQuoteTemplates)
+	api.POST("/quote-templates", quoteHandler.CreateQuoteTemplate)
+	api.PUT("/quote-templates/:id", quoteHandler.UpdateQuoteTemplate)
+	api.DELETE("/quote-templates/:id", quoteHandler.DeleteQuoteTemplate)
+
+	// Approval routes
+	api.GET("/approvals", approvalHandler.GetApprovals)
+	api.POST("/approvals/:id/approve", approvalHandler.ApproveDealApproval)
+	api.POST("/approvals/:id/reject", approvalHandler.RejectDealApproval)
+	api.GET("/approval-rules", approvalHandler.GetApprovalRules)
+	api.POST("/approval-rules", approvalHandler.CreateApprovalRule)
+	api.PUT("/approval-rules/:id", approvalHandler.UpdateApprovalRule)
+	api.DELETE("/approval-rules/:id", approvalHandler.DeleteApprovalRule)
+
+	// Contract routes
+	api.GET("/contracts", contractHandler.GetContracts)
+	api.POST("/contracts", contractHandler.CreateContract)
+	api.GET("/contracts/renewal-settings", contractHandler.GetRenewalSettings)
+	api.PUT("/contracts/renewal-settings", contractHandler.UpdateRenewalSettings)
+	api.GET("/contracts/:id", contractHandler.GetContract)
+	api.PUT("/contracts/:id", contractHandler.UpdateContract)
+	api.DELETE("/contracts/:id", contractHandler.DeleteContract)
+	api.POST("/contracts/:id/cancel", contractHandler.CancelContract)
+	api.POST("/contracts/:id/renewal-deal", contractHandler.OpenRenewalDeal)
+
+	// Lead scoring routes
+	api.GET("/lead-scoring/rules", leadScoringHandler.GetRules)
+	api.POST("/lead-scoring/rules", leadScoringHandler.CreateRule)
+	api.PUT("/lead-scoring/rules/:id", leadScoringHandler.UpdateRule)
+	api.DELETE("/lead-scoring/rules/:id", leadScoringHandler.DeleteRule)
+	api.GET("/lead-scoring/thresholds", leadScoringHandler.GetThresholds)
+	api.PUT("/lead-scoring/thresholds", leadScoringHandler.UpdateThresholds)
+	api.POST("/marketing-assets/:id/interactions", leadScoringHandler.CreateInteraction)
+
+	// Assignment routes
+	api.GET("/assignment-rules", assignmentHandler.GetRules)
+	api.POST("/assignment-rules", assignmentHandler.CreateRule)
+	api.PUT("/assignment-rules/:id", assignmentHandler.UpdateRule)
+	api.DELETE("/assignment-rules/:id", assignmentHandler.DeleteRule)
+	api.GET("/assignment-logs", assignmentHandler.GetLogs)
+	api.GET("/user-groups", assignmentHandler.GetUserGroups)
+	api.POST("/user-groups", assignmentHandler.CreateUserGroup)
+	api.PUT("/user-groups/:id", assignmentHandler.UpdateUserGroup)
+	api.DELETE("/user-groups/:id", assignmentHandler.DeleteUserGroup)
+
+	// Territory routes
+	api.GET("/territories", territoryHandler.GetTerritories)
+	api.POST("/territories", territoryHandler.CreateTerritory)
+	api.POST("/territories/recompute", territoryHandler.RecomputeTerritories)
+	api.GET("/territories/:id", territoryHandler.GetTerritory)
+	api.PUT("/territories/:id", territoryHandler.UpdateTerritory)
+	api.DELETE("/territories/:id", territoryHandler.DeleteTerritory)
+	api.GET("/territories/:id/companies", territoryHandler.GetTerritoryCompanies)
+	api.PUT("/territories/:id/members", territoryHandler.SetTerritoryMembers)
+
+	// Territory realignment routes
+	api.GET("/territory-realignments", territoryRealignmentHandler.GetRealignments)
+	api.POST("/territory-realignments", territoryRealignmentHandler.CreateRealignment)
+	api.GET("/territory-realignments/:id", territoryRealignmentHandler.GetRealignment)
+	api.POST("/territory-realignments/:id/apply", territoryRealignmentHandler.ApplyRealignment)
+	api.POST("/territory-realignments/:id/undo", territoryRealignmentHandler.UndoRealignment)
+
+	// Web-to-lead form routes
+	api.GET("/web-forms", webFormHandler.GetWebForms)
+	api.POST("/web-forms", webFormHandler.CreateWebForm)
+	api.GET("/web-forms/:id", webFormHandler.GetWebForm)
+	api.PUT("/web-forms/:id", webFormHandler.UpdateWebForm)
+	api.DELETE("/web-forms/:id", webFormHandler.DeleteWebForm)
+	api.POST("/web-forms/:id/token", webFormHandler.RegenerateToken)
+	api.GET("/web-forms/:id/submissions", webFormHandler.GetSubmissions)
+
+	// Duplicate detection routes
+	api.GET("/duplicate-rules", duplicateHandler.GetRules)
+	api.POST("/duplicate-rules", duplicateHandler.CreateRule)
+	api.PUT("/duplicate-rules/:id", duplicateHandler.UpdateRule)
+	api.DELETE("/duplicate-rules/:id", duplicateHandler.DeleteRule)
+	api.POST("/duplicates/check", duplicateHandler.CheckDuplicates)
+	api.GET("/merges", mergeHandler.GetMerges)
+	api.GET("/merges/:id", mergeHandler.GetMerge)
+
+	// Product routes
+	api.GET("/products", productHandler.GetProducts)
+	api.POST("/products", productHandler.CreateProduct)
+	api.GET("/products/:id", productHandler.GetProduct)
+	api.PUT("/products/:id", productHandler.UpdateProduct)
+	api.DELETE("/products/:id", productHandler.DeleteProduct)
+
+	// Price book routes
+	api.GET("/price-books", productHandler.GetPriceBooks)
+	api.POST("/price-books", productHandler.CreatePriceBook)
+	api.GET("/price-books/:id", productHandler.GetPriceBook)
+	api.PUT("/price-books/:id", productHandler.UpdatePriceBook)
+	api.PUT("/price-books/:id/entries", productHandler.SetPriceBookEntries)
+	api.DELETE("/price-books/:id/entries/:entryId", productHandler.DeletePriceBookEntry)
+
+	// Close reason routes
+	api.GET("/close-reasons", closeReasonHandler.GetCloseReasons)
+	api.POST("/close-reasons", closeReasonHandler.CreateCloseReason)
+	api.PUT("/close-reasons/:id", closeReasonHandler.UpdateCloseReason)
+	api.DELETE("/close-reasons/:id", closeReasonHandler.DeleteCloseReason)
+
+	// Pipeline routes
+	api.GET("/pipelines", pipelineHandler.GetPipelines)
+	api.POST("/pipelines", pipelineHandler.CreatePipeline)
+	api.GET("/pipelines/:id", pipelineHandler.GetPipeline)
+	api.PUT("/pipelines/:id", pipelineHandler.UpdatePipeline)
+	api.POST("/pipelines/:id/archive", pipelineHandler.ArchivePipeline)
+	api.POST("/pipelines/:id/unarchive", pipelineHandler.UnarchivePipeline)
+	api.POST("/pipelines/:id/stages", pipelineHandler.CreateStage)
+	api.PUT("/pipelines/:id/stages/order", pipelineHandler.ReorderStages)
+	api.GET("/pipelines/:id/transitions", pipelineHandler.GetTransitions)
+	api.PUT("/pipelines/:id/transitions", pipelineHandler.SetTransitions)
+	api.POST("/pipelines/:id/board", entityHandler.GetPipelineBoard)
+
+	// Stage routes
+	api.PUT("/stages/:id", pipelineHandler.UpdateStage)
+	api.DELETE("/stages/:id", pipelineHandler.DeleteStage)
+
+	// Forecast routes
+	api.GET("/forecast", forecastHandler.GetForecast)
+	api.GET("/forecast/snapshots", forecastHandler.GetSnapshots)
+	api.POST("/forecast/snapshots", forecastHandler.CaptureSnapshot)
+
+	// Analytics routes
+	api.GET("/analytics/stage-durations", analyticsHandler.GetStageDurations)
+	api.GET("/analytics/stage-conversions", analyticsHandler.GetStageConversions)
+	api.GET("/analytics/win-rates", analyticsHandler.GetWinRates)
+	api.GET("/analytics/sales-cycle", analyticsHandler.GetSalesCycle)
+	api.GET("/analytics/arr", contractHandler.GetARR)
+	api.GET("/analytics/churn", contractHandler.GetChurn)
+	api.GET("/analytics/expansion", contractHandler.GetExpansion)
+
+	// Currency routes
+	api.GET("/currency/settings", currencyHandler.GetSettings)
+	api.PUT("/currency/settings", currencyHandler.UpdateSettings)
+	api.GET("/exchange-rates", currencyHandler.GetExchangeRates)
+	api.POST("/exchange-rates", currencyHandler.CreateExchangeRate)
+	api.POST("/exchange-rates/import", currencyHandler.ImportExchangeRates)
+	api.DELETE("/exchange-rates/:id", currencyHandler.DeleteExchangeRate)
+
+	// Admin routes
+	api.POST("/admin/activity/undo", historyHandler.UndoUserChanges)
 
 	// Picklist routes
 	api.GET("/picklists/:entity", picklistHandler.GetPicklistByEntity)
@@ -134,6 +414,7 @@ func main() {
 
 	// Entity routes
 	api.POST("/entities/query", entityHandler.GetEntityList)
+	api.POST("/entities/aggregate", entityHandler.GetEntityAggregates)
 	api.GET("/entities/:entityType/views", entityHandler.GetEntityViews)
 
 	// Start server
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// RequestInfo stores the client IP and user agent on the context so that
+// database writes made with db.WithContext(c) can be attributed in the audit trail
+func RequestInfo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set("ip_address", c.ClientIP())
+		if userAgent := c.Request.UserAgent(); userAgent != "" {
+			c.Set("user_agent", userAgent)
+		}
+
+		c.Next()
+	}
+}
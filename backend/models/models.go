@@ -22,6 +22,8 @@ type User struct {
 	RoleID *string   `json:"roleId" gorm:"column:role_id;type:uuid"`
 	Role   *UserRole `json:"role,omitempty" gorm:"foreignKey:RoleID"`
 
+	ManagerID *string `json:"managerId" gorm:"column:manager_id;type:uuid"`
+
 	IsActive bool `json:"isActive" gorm:"column:is_active;default:true"`
 
 	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
@@ -155,6 +157,23 @@ type CompanySize struct {
 	// Companies []Company `json:"companies,omitempty"`
 }
 
+// ExchangeRate is the tenant's rate for converting BaseCurrency into Currency
+// (1 BaseCurrency = Rate Currency) from EffectiveDate until the next rate
+type ExchangeRate struct {
+	ID            string    `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	BaseCurrency  string    `json:"baseCurrency" gorm:"column:base_currency;size:3;not null;uniqueIndex:idx_exchange_rate"`
+	Currency      string    `json:"currency" gorm:"size:3;not null;uniqueIndex:idx_exchange_rate"`
+	EffectiveDate time.Time `json:"effectiveDate" gorm:"column:effective_date;type:date;not null;uniqueIndex:idx_exchange_rate"`
+	Rate          float64   `json:"rate" gorm:"not null"`
+	Source        *string   `json:"source"` // csv, ecb or manual
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null;uniqueIndex:idx_exchange_rate"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
 // ============================================================================
 // COMPANIES AND CONTACTS
 // ============================================================================
@@ -174,6 +193,10 @@ type Company struct {
 	Revenue    *float64 `json:"revenue"`
 	ExternalID *string  `json:"externalId" gorm:"column:external_id"`
 
+	// Parent of a subsidiary; the hierarchy never loops
+	ParentID *string  `json:"parentId" gorm:"column:parent_id;type:uuid;index"`
+	Parent   *Company `json:"parent,omitempty" gorm:"foreignKey:ParentID"`
+
 	AssignedUserID *string `json:"assignedUserId" gorm:"column:assigned_user_id;type:uuid"`
 	AssignedUser   *User   `json:"assignedUser,omitempty" gorm:"foreignKey:AssignedUserID"`
 
@@ -186,6 +209,9 @@ type Company struct {
 	IsDeleted bool       `json:"isDeleted" gorm:"column:is_deleted;default:false"`
 	DeletedAt *time.Time `json:"deletedAt" gorm:"column:deleted_at"`
 
+	// Set on a deleted company that was merged into another
+	MergedIntoID *string `json:"mergedIntoId" gorm:"column:merged_into_id;type:uuid"`
+
 	// Remove these fields to avoid circular references
 	// Contacts []Contact `json:"contacts,omitempty"`
 	// Deals    []Deal    `json:"deals,omitempty"`
@@ -224,6 +250,9 @@ type Contact struct {
 	IsDeleted bool       `json:"isDeleted" gorm:"column:is_deleted;default:false"`
 	DeletedAt *time.Time `json:"deletedAt" gorm:"column:deleted_at"`
 
+	// Set on a deleted contact that was merged into another
+	MergedIntoID *string `json:"mergedIntoId" gorm:"column:merged_into_id;type:uuid"`
+
 	// Remove these fields to avoid circular references
 	// Leads []Lead `json:"leads,omitempty"`
 	// Deals []Deal `json:"deals,omitempty"`
@@ -249,6 +278,10 @@ type Lead struct {
 	Campaign *string `json:"campaign"`
 	Score    int     `json:"score" gorm:"default:0"`
 
+	// Set for leads captured by a web form from the UTM parameters of the visit
+	MarketingSourceID *string          `json:"marketingSourceId" gorm:"column:marketing_source_id;type:uuid"`
+	MarketingSource   *MarketingSource `json:"marketingSource,omitempty" gorm:"foreignKey:MarketingSourceID"`
+
 	CompanyID *string  `json:"companyId" gorm:"column:company_id;type:uuid"`
 	Company   *Company `json:"company,omitempty" gorm:"foreignKey:CompanyID"`
 
@@ -258,6 +291,9 @@ type Lead struct {
 	AssignedUserID *string `json:"assignedUserId" gorm:"column:assigned_user_id;type:uuid"`
 	AssignedUser   *User   `json:"assignedUser,omitempty" gorm:"foreignKey:AssignedUserID"`
 
+	// Set when the score was last computed from the tenant's scoring rules
+	ScoredAt *time.Time `json:"scoredAt" gorm:"column:scored_at"`
+
 	ConvertedAt       *time.Time `json:"convertedAt" gorm:"column:converted_at"`
 	ConvertedToDealID *string    `json:"convertedToDealId" gorm:"column:converted_to_deal_id;type:uuid"`
 
@@ -287,6 +323,26 @@ type Deal struct {
 	ExpectedCloseDate *time.Time `json:"expectedCloseDate" gorm:"column:expected_close_date"`
 	ActualCloseDate   *time.Time `json:"actualCloseDate" gorm:"column:actual_close_date"`
 
+	// Set when the deal is closed won or lost
+	CloseReasonID *string          `json:"closeReasonId" gorm:"column:close_reason_id;type:uuid"`
+	CloseReason   *DealCloseReason `json:"closeReason,omitempty" gorm:"foreignKey:CloseReasonID"`
+	Competitor    *string          `json:"competitor"`
+	CloseNotes    *string          `json:"closeNotes" gorm:"column:close_notes;type:text"`
+
+	// Rep's forecast call: pipeline, best_case, commit or omitted. Nil is
+	// treated as pipeline.
+	ForecastCategory *string `json:"forecastCategory" gorm:"column:forecast_category"`
+
+	// Position within its stage column on the pipeline board, ascending
+	BoardRank float64 `json:"boardRank" gorm:"column:board_rank;default:0"`
+
+	// Price book used to price new line items
+	PriceBookID *string    `json:"priceBookId" gorm:"column:price_book_id;type:uuid"`
+	PriceBook   *PriceBook `json:"priceBook,omitempty" gorm:"foreignKey:PriceBookID"`
+
+	// When present, Amount is the sum of the line item totals
+	LineItems []DealLineItem `json:"lineItems,omitempty" gorm:"foreignKey:DealID"`
+
 	CompanyID *string  `json:"companyId" gorm:"column:company_id;type:uuid"`
 	Company   *Company `json:"company,omitempty" gorm:"foreignKey:CompanyID"`
 
@@ -307,9 +363,13 @@ type Deal struct {
 }
 
 type Pipeline struct {
-	ID       string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
-	Name     string `json:"name" gorm:"not null"`
-	IsActive bool   `json:"isActive" gorm:"column:is_active;default:true"`
+	ID         string     `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Name       string     `json:"name" gorm:"not null"`
+	IsActive   bool       `json:"isActive" gorm:"column:is_active;default:true"`
+	ArchivedAt *time.Time `json:"archivedAt" gorm:"column:archived_at"`
+
+	// Stage deals move to when a quote is accepted
+	QuoteAcceptedStageID *string `json:"quoteAcceptedStageId" gorm:"column:quote_accepted_stage_id;type:uuid"`
 
 	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
 	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
@@ -331,16 +391,566 @@ type Stage struct {
 	IsClosedLost bool    `json:"isClosedLost" gorm:"column:is_closed_lost;default:false"`
 	Color        *string `json:"color"`
 
+	// Deal fields that must be filled in before a deal can enter the stage
+	RequiredFields []string `json:"requiredFields" gorm:"column:required_fields;type:jsonb;serializer:json"`
+
 	PipelineID string   `json:"pipelineId" gorm:"column:pipeline_id;type:uuid;not null"`
 	Pipeline   Pipeline `json:"pipeline,omitempty" gorm:"foreignKey:PipelineID"`
 
 	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
 	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
 
+	CreatedAt time.Time  `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time  `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+	IsDeleted bool       `json:"isDeleted" gorm:"column:is_deleted;default:false"`
+	DeletedAt *time.Time `json:"deletedAt" gorm:"column:deleted_at"`
+
 	// Remove the Deals field to avoid circular reference
 	// Deals []Deal `json:"deals,omitempty"`
 }
 
+// StageTransition allows deals to move from one stage to another. Once a
+// stage has any transitions defined, deals in it may only move to the listed
+// stages; stages without transitions are unrestricted.
+type StageTransition struct {
+	ID string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+
+	PipelineID string `json:"pipelineId" gorm:"column:pipeline_id;type:uuid;not null;index"`
+
+	FromStageID string `json:"fromStageId" gorm:"column:from_stage_id;type:uuid;not null"`
+	FromStage   *Stage `json:"fromStage,omitempty" gorm:"foreignKey:FromStageID"`
+
+	ToStageID string `json:"toStageId" gorm:"column:to_stage_id;type:uuid;not null"`
+	ToStage   *Stage `json:"toStage,omitempty" gorm:"foreignKey:ToStageID"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+}
+
+// DealCloseReason is a tenant-managed reason recorded when a deal is won or lost
+type DealCloseReason struct {
+	ID          string  `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Name        string  `json:"name" gorm:"not null"`
+	Code        string  `json:"code" gorm:"not null"`
+	Outcome     string  `json:"outcome" gorm:"not null"` // "won" or "lost"
+	Description *string `json:"description"`
+	Order       int     `json:"order" gorm:"not null"`
+	IsActive    bool    `json:"isActive" gorm:"column:is_active;default:true"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+// DealSplit credits a percentage of a deal to a user. The splits of each
+// type on a deal add up to 100%.
+type DealSplit struct {
+	ID        string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	DealID    string `json:"dealId" gorm:"column:deal_id;type:uuid;not null;uniqueIndex:idx_deal_split"`
+	SplitType string `json:"splitType" gorm:"column:split_type;not null;default:'revenue';uniqueIndex:idx_deal_split"`
+
+	UserID string `json:"userId" gorm:"column:user_id;type:uuid;not null;uniqueIndex:idx_deal_split"`
+	User   *User  `json:"user,omitempty" gorm:"foreignKey:UserID"`
+
+	Role       *string `json:"role"` // e.g. AE, SE, partner manager
+	Percentage float64 `json:"percentage" gorm:"not null"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+// ============================================================================
+// PRODUCTS AND PRICING
+// ============================================================================
+
+type Product struct {
+	ID          string  `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	SKU         string  `json:"sku" gorm:"column:sku;not null;uniqueIndex:idx_product_sku"`
+	Name        string  `json:"name" gorm:"not null"`
+	Family      *string `json:"family"`
+	Description *string `json:"description" gorm:"type:text"`
+	IsActive    bool    `json:"isActive" gorm:"column:is_active;default:true"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null;uniqueIndex:idx_product_sku"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+type PriceBook struct {
+	ID          string  `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Name        string  `json:"name" gorm:"not null"`
+	Description *string `json:"description"`
+	IsDefault   bool    `json:"isDefault" gorm:"column:is_default;default:false"`
+	IsActive    bool    `json:"isActive" gorm:"column:is_active;default:true"`
+
+	Entries []PriceBookEntry `json:"entries,omitempty" gorm:"foreignKey:PriceBookID"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+// PriceBookEntry is a product's list price in one currency
+type PriceBookEntry struct {
+	ID          string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	PriceBookID string `json:"priceBookId" gorm:"column:price_book_id;type:uuid;not null;uniqueIndex:idx_price_book_entry"`
+
+	ProductID string   `json:"productId" gorm:"column:product_id;type:uuid;not null;uniqueIndex:idx_price_book_entry"`
+	Product   *Product `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+
+	Currency  string  `json:"currency" gorm:"size:3;not null;uniqueIndex:idx_price_book_entry"`
+	ListPrice float64 `json:"listPrice" gorm:"column:list_price;not null"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+// DealLineItem is a product sold on a deal, priced in the deal's currency.
+// TotalPrice is Quantity x UnitPrice less DiscountPercent.
+type DealLineItem struct {
+	ID     string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	DealID string `json:"dealId" gorm:"column:deal_id;type:uuid;not null;index"`
+
+	ProductID string   `json:"productId" gorm:"column:product_id;type:uuid;not null"`
+	Product   *Product `json:"product,omitempty" gorm:"foreignKey:ProductID"`
+
+	Description     *string `json:"description"`
+	Quantity        float64 `json:"quantity" gorm:"not null;default:1"`
+	UnitPrice       float64 `json:"unitPrice" gorm:"column:unit_price;not null"`
+	DiscountPercent float64 `json:"discountPercent" gorm:"column:discount_percent;default:0"`
+	TermMonths      *int    `json:"termMonths" gorm:"column:term_months"` // subscription term the price covers
+	TotalPrice      float64 `json:"totalPrice" gorm:"column:total_price;not null"`
+	SortOrder       int     `json:"sortOrder" gorm:"column:sort_order;default:0"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+// ============================================================================
+// QUOTES
+// ============================================================================
+
+// QuoteTemplate is a text/template rendered into the quote PDF. Output lines
+// starting with "# " are headings and lines of "---" are rules.
+type QuoteTemplate struct {
+	ID        string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Name      string `json:"name" gorm:"not null"`
+	Body      string `json:"body" gorm:"type:text;not null"`
+	IsDefault bool   `json:"isDefault" gorm:"column:is_default;default:false"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+// Quote is one version of a quote for a deal. Versions share a Number and
+// snapshot the deal's line items when created.
+type Quote struct {
+	ID     string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	DealID string `json:"dealId" gorm:"column:deal_id;type:uuid;not null;index"`
+	Deal   *Deal  `json:"deal,omitempty" gorm:"foreignKey:DealID"`
+
+	Number  string `json:"number" gorm:"not null;uniqueIndex:idx_quote_version"`
+	Version int    `json:"version" gorm:"not null;default:1;uniqueIndex:idx_quote_version"`
+	Status  string `json:"status" gorm:"not null;default:'draft'"` // draft, sent, accepted, rejected
+
+	Currency      string     `json:"currency" gorm:"size:3;not null"`
+	Subtotal      float64    `json:"subtotal" gorm:"not null"`
+	DiscountTotal float64    `json:"discountTotal" gorm:"column:discount_total;not null"`
+	Total         float64    `json:"total" gorm:"not null"`
+	ExpiresAt     *time.Time `json:"expiresAt" gorm:"column:expires_at;type:date"`
+	Terms         *string    `json:"terms" gorm:"type:text"`
+
+	TemplateID   *string `json:"templateId" gorm:"column:template_id;type:uuid"`
+	AttachmentID *string `json:"attachmentId" gorm:"column:attachment_id;type:uuid"`
+
+	SentAt        *time.Time `json:"sentAt" gorm:"column:sent_at"`
+	AcceptedAt    *time.Time `json:"acceptedAt" gorm:"column:accepted_at"`
+	RejectedAt    *time.Time `json:"rejectedAt" gorm:"column:rejected_at"`
+	DecisionNotes *string    `json:"decisionNotes" gorm:"column:decision_notes;type:text"`
+
+	Lines []QuoteLineItem `json:"lines,omitempty" gorm:"foreignKey:QuoteID"`
+
+	TenantID  string  `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null;uniqueIndex:idx_quote_version"`
+	Tenant    Tenant  `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+	CreatedBy *string `json:"createdBy" gorm:"column:created_by;type:uuid"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+// QuoteLineItem is a deal line item as it stood when the quote version was
+// created
+type QuoteLineItem struct {
+	ID      string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	QuoteID string `json:"quoteId" gorm:"column:quote_id;type:uuid;not null;index"`
+
+	ProductID       *string `json:"productId" gorm:"column:product_id;type:uuid"`
+	SKU             string  `json:"sku" gorm:"column:sku"`
+	Name            string  `json:"name" gorm:"not null"`
+	Description     *string `json:"description"`
+	Quantity        float64 `json:"quantity" gorm:"not null"`
+	UnitPrice       float64 `json:"unitPrice" gorm:"column:unit_price;not null"`
+	DiscountPercent float64 `json:"discountPercent" gorm:"column:discount_percent;default:0"`
+	TermMonths      *int    `json:"termMonths" gorm:"column:term_months"`
+	TotalPrice      float64 `json:"totalPrice" gorm:"column:total_price;not null"`
+	SortOrder       int     `json:"sortOrder" gorm:"column:sort_order;default:0"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+}
+
+// DealAttachment is a file stored against a deal, such as a quote PDF
+type DealAttachment struct {
+	ID       string  `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	DealID   string  `json:"dealId" gorm:"column:deal_id;type:uuid;not null;index"`
+	QuoteID  *string `json:"quoteId" gorm:"column:quote_id;type:uuid"`
+	Filename string  `json:"filename" gorm:"not null"`
+	MimeType string  `json:"mimeType" gorm:"column:mime_type;not null"`
+	Size     int     `json:"size" gorm:"not null"`
+	Content  []byte  `json:"-" gorm:"type:bytea;not null"`
+
+	TenantID  string  `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant    Tenant  `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+	CreatedBy *string `json:"createdBy" gorm:"column:created_by;type:uuid"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+}
+
+// ============================================================================
+// APPROVALS
+// ============================================================================
+
+// ApprovalStep is one approver in a chain: the deal owner's manager, any user
+// with a role, or a specific user
+type ApprovalStep struct {
+	Type   string  `json:"type"` // manager, role, user
+	RoleID *string `json:"roleId,omitempty"`
+	UserID *string `json:"userId,omitempty"`
+}
+
+// ApprovalRule requires approval for deals whose line items meet all of the
+// rule's set conditions. Discounts are measured on line items of
+// ProductFamily when it is set.
+type ApprovalRule struct {
+	ID       string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Name     string `json:"name" gorm:"not null"`
+	Order    int    `json:"order" gorm:"default:0"`
+	IsActive bool   `json:"isActive" gorm:"column:is_active;default:true"`
+
+	MinDiscountPercent *float64 `json:"minDiscountPercent" gorm:"column:min_discount_percent"`
+	MinDiscountAmount  *float64 `json:"minDiscountAmount" gorm:"column:min_discount_amount"`
+	ProductFamily      *string  `json:"productFamily" gorm:"column:product_family"`
+
+	Approvers []ApprovalStep `json:"approvers" gorm:"type:jsonb;serializer:json"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+// DealApproval is a request to approve the discounts on a deal's line items
+// as they stood when it was requested
+type DealApproval struct {
+	ID     string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	DealID string `json:"dealId" gorm:"column:deal_id;type:uuid;not null;index"`
+	Deal   *Deal  `json:"deal,omitempty" gorm:"foreignKey:DealID"`
+
+	Status      string         `json:"status" gorm:"not null;default:'pending'"` // pending, approved, rejected
+	RuleIDs     []string       `json:"ruleIds" gorm:"column:rule_ids;type:jsonb;serializer:json"`
+	Steps       []ApprovalStep `json:"steps" gorm:"type:jsonb;serializer:json"`
+	CurrentStep int            `json:"currentStep" gorm:"column:current_step;default:0"`
+
+	DiscountPercent float64 `json:"discountPercent" gorm:"column:discount_percent"`
+	DiscountAmount  float64 `json:"discountAmount" gorm:"column:discount_amount"`
+	Fingerprint     string  `json:"-" gorm:"not null"` // line items the approval covers
+
+	RequestedBy *string    `json:"requestedBy" gorm:"column:requested_by;type:uuid"`
+	RequestedAt time.Time  `json:"requestedAt" gorm:"column:requested_at"`
+	DecidedAt   *time.Time `json:"decidedAt" gorm:"column:decided_at"`
+
+	Decisions []ApprovalDecision `json:"decisions,omitempty" gorm:"foreignKey:ApprovalID"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+type ApprovalDecision struct {
+	ID         string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	ApprovalID string `json:"approvalId" gorm:"column:approval_id;type:uuid;not null;index"`
+	Step       int    `json:"step" gorm:"not null"`
+
+	ApproverID string `json:"approverId" gorm:"column:approver_id;type:uuid;not null"`
+	Approver   *User  `json:"approver,omitempty" gorm:"foreignKey:ApproverID"`
+
+	Decision  string    `json:"decision" gorm:"not null"` // approved, rejected
+	Comment   *string   `json:"comment" gorm:"type:text"`
+	DecidedAt time.Time `json:"decidedAt" gorm:"column:decided_at"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+}
+
+// ============================================================================
+// CONTRACTS
+// ============================================================================
+
+// Contract is a subscription sold to a company, normally from a closed-won
+// deal. ARR is the annualized recurring amount and MRR a twelfth of it.
+type Contract struct {
+	ID   string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Name string `json:"name" gorm:"not null"`
+
+	CompanyID string   `json:"companyId" gorm:"column:company_id;type:uuid;not null;index"`
+	Company   *Company `json:"company,omitempty" gorm:"foreignKey:CompanyID"`
+
+	// Closed-won deal the contract was sold in; empty for automatic renewals
+	DealID *string `json:"dealId" gorm:"column:deal_id;type:uuid"`
+	Deal   *Deal   `json:"deal,omitempty" gorm:"foreignKey:DealID"`
+
+	// Contract this one renews
+	RenewedFromID *string `json:"renewedFromId" gorm:"column:renewed_from_id;type:uuid;index"`
+
+	StartDate        time.Time `json:"startDate" gorm:"column:start_date;type:date;not null"`
+	EndDate          time.Time `json:"endDate" gorm:"column:end_date;type:date;not null"`
+	BillingFrequency string    `json:"billingFrequency" gorm:"column:billing_frequency;not null;default:'annual'"` // monthly, quarterly, annual
+	Currency         string    `json:"currency" gorm:"not null"`
+	ARR              float64   `json:"arr" gorm:"column:arr;not null"`
+	MRR              float64   `json:"mrr" gorm:"column:mrr;not null"`
+	AutoRenew        bool      `json:"autoRenew" gorm:"column:auto_renew;default:false"`
+
+	Status      string     `json:"status" gorm:"not null;default:'active'"` // active, renewed, expired, cancelled
+	CancelledAt *time.Time `json:"cancelledAt" gorm:"column:cancelled_at;type:date"`
+	Notes       *string    `json:"notes" gorm:"type:text"`
+
+	// Renewal opportunity opened ahead of the end date
+	RenewalDealID *string `json:"renewalDealId" gorm:"column:renewal_deal_id;type:uuid"`
+	RenewalDeal   *Deal   `json:"renewalDeal,omitempty" gorm:"foreignKey:RenewalDealID"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time  `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time  `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+	CreatedBy *string    `json:"createdBy" gorm:"column:created_by;type:uuid"`
+	IsDeleted bool       `json:"isDeleted" gorm:"column:is_deleted;default:false"`
+	DeletedAt *time.Time `json:"deletedAt" gorm:"column:deleted_at"`
+}
+
+// ============================================================================
+// LEAD SCORING
+// ============================================================================
+
+// LeadScoringRule adds Points to the score of leads matching it. Attribute
+// rules match when the lead's value is one of Values: source, title_keyword
+// (title contains a keyword), industry and company_size (IDs, through the
+// lead's company) and email_domain_type ("free" or "business"). Activity
+// rules (communications_received, asset_clicks, asset_conversions) match
+// when the lead has at least MinCount events within WithinDays.
+type LeadScoringRule struct {
+	ID        string   `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Name      string   `json:"name" gorm:"not null"`
+	Attribute string   `json:"attribute" gorm:"not null"`
+	Values    []string `json:"values" gorm:"type:jsonb;serializer:json"`
+	MinCount  int      `json:"minCount" gorm:"column:min_count;default:1"`
+	// Activity window in days; nil counts all activity
+	WithinDays *int `json:"withinDays" gorm:"column:within_days"`
+	Points     int  `json:"points" gorm:"not null"`
+	Order      int  `json:"order" gorm:"default:0"`
+	IsActive   bool `json:"isActive" gorm:"column:is_active;default:true"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+// LeadScoreThreshold sets the temperature of leads scoring at least MinScore
+// (and below the next threshold)
+type LeadScoreThreshold struct {
+	ID       string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	MinScore int    `json:"minScore" gorm:"column:min_score;not null"`
+
+	TemperatureID string           `json:"temperatureId" gorm:"column:temperature_id;type:uuid;not null"`
+	Temperature   *LeadTemperature `json:"temperature,omitempty" gorm:"foreignKey:TemperatureID"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+// ============================================================================
+// ASSIGNMENT
+// ============================================================================
+
+// AssignmentCondition compares a lead or company attribute with Values.
+// Operators are in, not_in and contains (any value is a substring).
+type AssignmentCondition struct {
+	Field    string   `json:"field"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values"`
+}
+
+// AssignmentRule assigns leads matching all of its Conditions to UserID
+// (method user), to a member of GroupID by weighted round-robin (method
+// round_robin) or to the owner of TerritoryID (method territory; without
+// TerritoryID, the territory of the lead's company). Users with MaxOpenLeads
+// or more open leads are skipped.
+type AssignmentRule struct {
+	ID         string                `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Name       string                `json:"name" gorm:"not null"`
+	Order      int                   `json:"order" gorm:"default:0"`
+	IsActive   bool                  `json:"isActive" gorm:"column:is_active;default:true"`
+	Conditions []AssignmentCondition `json:"conditions" gorm:"type:jsonb;serializer:json"`
+	Method     string                `json:"method" gorm:"not null"`
+
+	UserID *string `json:"userId" gorm:"column:user_id;type:uuid"`
+	User   *User   `json:"user,omitempty" gorm:"foreignKey:UserID"`
+
+	GroupID *string    `json:"groupId" gorm:"column:group_id;type:uuid"`
+	Group   *UserGroup `json:"group,omitempty" gorm:"foreignKey:GroupID"`
+
+	TerritoryID *string    `json:"territoryId" gorm:"column:territory_id;type:uuid"`
+	Territory   *Territory `json:"territory,omitempty" gorm:"foreignKey:TerritoryID"`
+
+	MaxOpenLeads *int `json:"maxOpenLeads" gorm:"column:max_open_leads"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+// UserGroup is a set of users that round-robin rules share leads between
+type UserGroup struct {
+	ID          string  `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Name        string  `json:"name" gorm:"not null"`
+	Description *string `json:"description"`
+	IsActive    bool    `json:"isActive" gorm:"column:is_active;default:true"`
+
+	Members []UserGroupMember `json:"members,omitempty" gorm:"foreignKey:GroupID"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+// UserGroupMember is a user in a group. Round-robin gives members leads in
+// proportion to Weight; AssignedCount is the number they have been given.
+// MaxOpenLeads overrides the rule's capacity for the member.
+type UserGroupMember struct {
+	ID      string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	GroupID string `json:"groupId" gorm:"column:group_id;type:uuid;not null;index"`
+
+	UserID string `json:"userId" gorm:"column:user_id;type:uuid;not null"`
+	User   *User  `json:"user,omitempty" gorm:"foreignKey:UserID"`
+
+	Weight        int  `json:"weight" gorm:"default:1"`
+	MaxOpenLeads  *int `json:"maxOpenLeads" gorm:"column:max_open_leads"`
+	AssignedCount int  `json:"assignedCount" gorm:"column:assigned_count;default:0"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+// AssignmentLog records one assignment decision for a lead: the rule that
+// fired and the user it chose, or why the lead was left unassigned
+type AssignmentLog struct {
+	ID     string  `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	LeadID string  `json:"leadId" gorm:"column:lead_id;type:uuid;not null;index"`
+	RuleID *string `json:"ruleId" gorm:"column:rule_id;type:uuid"`
+	UserID *string `json:"userId" gorm:"column:user_id;type:uuid"`
+
+	Trigger string  `json:"trigger" gorm:"not null"` // create, import, web_form
+	Outcome string  `json:"outcome" gorm:"not null"` // assigned, no_match, unavailable
+	Detail  *string `json:"detail"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+}
+
+// ============================================================================
+// DUPLICATES
+// ============================================================================
+
+// DuplicateRule finds existing records of EntityType (contact, lead,
+// company) a new or changed record may duplicate: by exact email, by
+// normalized phone, by name similarity at the same company (or company name
+// similarity) of at least Threshold, or by company domain. Block rules
+// prevent the save; warn rules only report the matches.
+type DuplicateRule struct {
+	ID         string   `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Name       string   `json:"name" gorm:"not null"`
+	EntityType string   `json:"entityType" gorm:"column:entity_type;not null"`
+	Match      string   `json:"match" gorm:"not null"`  // email, phone, name_company, domain
+	Action     string   `json:"action" gorm:"not null"` // warn, block
+	Threshold  *float64 `json:"threshold"`
+	IsActive   bool     `json:"isActive" gorm:"column:is_active;default:true"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+// RecordMerge records the merge of one or more loser records into a master
+// of the same EntityType (contact, company). Fields and CustomFields name
+// the loser each value the master took came from, and Reparented counts the
+// related rows moved to the master.
+type RecordMerge struct {
+	ID           string            `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	EntityType   string            `json:"entityType" gorm:"column:entity_type;not null"`
+	MasterID     string            `json:"masterId" gorm:"column:master_id;type:uuid;not null;index"`
+	LoserIDs     []string          `json:"loserIds" gorm:"column:loser_ids;type:jsonb;serializer:json"`
+	Fields       map[string]string `json:"fields" gorm:"type:jsonb;serializer:json"`
+	CustomFields map[string]string `json:"customFields" gorm:"column:custom_fields;type:jsonb;serializer:json"`
+	Reparented   map[string]int    `json:"reparented" gorm:"type:jsonb;serializer:json"`
+
+	MergedBy *string `json:"mergedBy" gorm:"column:merged_by;type:uuid"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+}
+
 // ============================================================================
 // MARKETING AND COMMUNICATIONS
 // ============================================================================
@@ -409,6 +1019,74 @@ type MarketingAsset struct {
 	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
 }
 
+// MarketingInteraction is one view, click or conversion of a marketing asset
+// by a known lead or contact
+type MarketingInteraction struct {
+	ID      string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	AssetID string `json:"assetId" gorm:"column:asset_id;type:uuid;not null;index"`
+	Type    string `json:"type" gorm:"not null"` // view, click, conversion
+
+	LeadID    *string `json:"leadId" gorm:"column:lead_id;type:uuid;index"`
+	ContactID *string `json:"contactId" gorm:"column:contact_id;type:uuid;index"`
+
+	OccurredAt time.Time `json:"occurredAt" gorm:"column:occurred_at;not null"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+}
+
+// WebFormField maps a field posted by a web form to a lead attribute:
+// firstName, lastName, title, email or phone
+type WebFormField struct {
+	Name     string `json:"name"`
+	Field    string `json:"field"`
+	Required bool   `json:"required"`
+}
+
+// WebForm lets a website create leads without authentication. Submissions
+// are posted with the form's Token; a value in HoneypotField marks them as
+// spam, and each IP address may submit RateLimit times an hour. An empty
+// AllowedOrigins list accepts any origin.
+type WebForm struct {
+	ID             string         `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Name           string         `json:"name" gorm:"not null"`
+	Token          string         `json:"token" gorm:"not null;uniqueIndex"`
+	Fields         []WebFormField `json:"fields" gorm:"type:jsonb;serializer:json"`
+	AllowedOrigins []string       `json:"allowedOrigins" gorm:"column:allowed_origins;type:jsonb;serializer:json"`
+	HoneypotField  string         `json:"honeypotField" gorm:"column:honeypot_field;not null"`
+	RateLimit      int            `json:"rateLimit" gorm:"column:rate_limit;not null"`
+
+	// Applied to every lead the form creates
+	Source   *string `json:"source"`
+	StatusID *string `json:"statusId" gorm:"column:status_id;type:uuid"`
+
+	IsActive bool `json:"isActive" gorm:"column:is_active;default:true"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+	CreatedBy *string   `json:"createdBy" gorm:"column:created_by;type:uuid"`
+}
+
+// WebFormSubmission records a post to a web form and what became of it
+type WebFormSubmission struct {
+	ID        string  `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	FormID    string  `json:"formId" gorm:"column:form_id;type:uuid;not null;index:idx_web_form_submission_ip"`
+	IPAddress string  `json:"ipAddress" gorm:"column:ip_address;not null;index:idx_web_form_submission_ip"`
+	Origin    *string `json:"origin"`
+	Outcome   string  `json:"outcome" gorm:"not null"` // created, spam, rejected, duplicate, failed
+	Detail    *string `json:"detail"`
+	LeadID    *string `json:"leadId" gorm:"column:lead_id;type:uuid"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP;index:idx_web_form_submission_ip"`
+}
+
 type CommunicationType struct {
 	ID          string  `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
 	Name        string  `json:"name" gorm:"not null"`
@@ -588,6 +1266,13 @@ func (cs *CompanySize) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+func (er *ExchangeRate) BeforeCreate(tx *gorm.DB) error {
+	if er.ID == "" {
+		er.ID = uuid.New().String()
+	}
+	return nil
+}
+
 func (c *Company) BeforeCreate(tx *gorm.DB) error {
 	if c.ID == "" {
 		c.ID = uuid.New().String()
@@ -630,6 +1315,188 @@ func (s *Stage) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+func (st *StageTransition) BeforeCreate(tx *gorm.DB) error {
+	if st.ID == "" {
+		st.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (dcr *DealCloseReason) BeforeCreate(tx *gorm.DB) error {
+	if dcr.ID == "" {
+		dcr.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (ds *DealSplit) BeforeCreate(tx *gorm.DB) error {
+	if ds.ID == "" {
+		ds.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (p *Product) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == "" {
+		p.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (pb *PriceBook) BeforeCreate(tx *gorm.DB) error {
+	if pb.ID == "" {
+		pb.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (pbe *PriceBookEntry) BeforeCreate(tx *gorm.DB) error {
+	if pbe.ID == "" {
+		pbe.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (dli *DealLineItem) BeforeCreate(tx *gorm.DB) error {
+	if dli.ID == "" {
+		dli.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (qt *QuoteTemplate) BeforeCreate(tx *gorm.DB) error {
+	if qt.ID == "" {
+		qt.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (q *Quote) BeforeCreate(tx *gorm.DB) error {
+	if q.ID == "" {
+		q.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (qli *QuoteLineItem) BeforeCreate(tx *gorm.DB) error {
+	if qli.ID == "" {
+		qli.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (da *DealAttachment) BeforeCreate(tx *gorm.DB) error {
+	if da.ID == "" {
+		da.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (ar *ApprovalRule) BeforeCreate(tx *gorm.DB) error {
+	if ar.ID == "" {
+		ar.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (da *DealApproval) BeforeCreate(tx *gorm.DB) error {
+	if da.ID == "" {
+		da.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (ad *ApprovalDecision) BeforeCreate(tx *gorm.DB) error {
+	if ad.ID == "" {
+		ad.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (c *Contract) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (r *LeadScoringRule) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (t *LeadScoreThreshold) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == "" {
+		t.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (mi *MarketingInteraction) BeforeCreate(tx *gorm.DB) error {
+	if mi.ID == "" {
+		mi.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (wf *WebForm) BeforeCreate(tx *gorm.DB) error {
+	if wf.ID == "" {
+		wf.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (wfs *WebFormSubmission) BeforeCreate(tx *gorm.DB) error {
+	if wfs.ID == "" {
+		wfs.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (ar *AssignmentRule) BeforeCreate(tx *gorm.DB) error {
+	if ar.ID == "" {
+		ar.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (ug *UserGroup) BeforeCreate(tx *gorm.DB) error {
+	if ug.ID == "" {
+		ug.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (ugm *UserGroupMember) BeforeCreate(tx *gorm.DB) error {
+	if ugm.ID == "" {
+		ugm.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (al *AssignmentLog) BeforeCreate(tx *gorm.DB) error {
+	if al.ID == "" {
+		al.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (dr *DuplicateRule) BeforeCreate(tx *gorm.DB) error {
+	if dr.ID == "" {
+		dr.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (rm *RecordMerge) BeforeCreate(tx *gorm.DB) error {
+	if rm.ID == "" {
+		rm.ID = uuid.New().String()
+	}
+	return nil
+}
+
 func (t *Task) BeforeCreate(tx *gorm.DB) error {
 	if t.ID == "" {
 		t.ID = uuid.New().String()
@@ -700,6 +1567,34 @@ func (t *Territory) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+func (ct *CompanyTerritory) BeforeCreate(tx *gorm.DB) error {
+	if ct.ID == "" {
+		ct.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (tm *TerritoryMember) BeforeCreate(tx *gorm.DB) error {
+	if tm.ID == "" {
+		tm.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (tr *TerritoryRealignment) BeforeCreate(tx *gorm.DB) error {
+	if tr.ID == "" {
+		tr.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (trc *TerritoryRealignmentChange) BeforeCreate(tx *gorm.DB) error {
+	if trc.ID == "" {
+		trc.ID = uuid.New().String()
+	}
+	return nil
+}
+
 func (cf *CustomField) BeforeCreate(tx *gorm.DB) error {
 	if cf.ID == "" {
 		cf.ID = uuid.New().String()
@@ -735,6 +1630,13 @@ func (dsh *DealStageHistory) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+func (fs *ForecastSnapshot) BeforeCreate(tx *gorm.DB) error {
+	if fs.ID == "" {
+		fs.ID = uuid.New().String()
+	}
+	return nil
+}
+
 func (pnt *PhoneNumberType) BeforeCreate(tx *gorm.DB) error {
 	if pnt.ID == "" {
 		pnt.ID = uuid.New().String()
@@ -816,16 +1718,45 @@ type TerritoryType struct {
 	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
 }
 
+// Territory covers the companies meeting all of its non-empty criteria and
+// those of its ancestors. Location criteria match the company's primary
+// address; PostalCodes entries are exact codes, prefixes ("941*") or ranges
+// ("10000-14999"). Industries and CompanySize hold picklist IDs or codes.
 type Territory struct {
 	ID          string   `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
 	Name        string   `json:"name" gorm:"not null"`
 	TypeID      *string  `json:"typeId" gorm:"column:type_id;type:uuid"`
-	Countries   []string `json:"countries" gorm:"type:jsonb"`
-	States      []string `json:"states" gorm:"type:jsonb"`
-	Cities      []string `json:"cities" gorm:"type:jsonb"`
-	PostalCodes []string `json:"postalCodes" gorm:"column:postal_codes;type:jsonb"`
-	Industries  []string `json:"industries" gorm:"type:jsonb"`
-	CompanySize []string `json:"companySize" gorm:"column:company_size;type:jsonb"`
+	ParentID    *string  `json:"parentId" gorm:"column:parent_id;type:uuid;index"`
+	Countries   []string `json:"countries" gorm:"type:jsonb;serializer:json"`
+	States      []string `json:"states" gorm:"type:jsonb;serializer:json"`
+	Cities      []string `json:"cities" gorm:"type:jsonb;serializer:json"`
+	PostalCodes []string `json:"postalCodes" gorm:"column:postal_codes;type:jsonb;serializer:json"`
+	Industries  []string `json:"industries" gorm:"type:jsonb;serializer:json"`
+	CompanySize []string `json:"companySize" gorm:"column:company_size;type:jsonb;serializer:json"`
+
+	// Owner receives leads routed to the territory by assignment rules and
+	// owns its companies after a realignment. Child territories without an
+	// owner inherit their parent's.
+	OwnerID *string `json:"ownerId" gorm:"column:owner_id;type:uuid"`
+	Owner   *User   `json:"owner,omitempty" gorm:"foreignKey:OwnerID"`
+
+	Members []TerritoryMember `json:"members,omitempty" gorm:"foreignKey:TerritoryID"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+}
+
+// TerritoryMember is a user working a territory in a role other than owner:
+// manager, sales_rep, overlay or support
+type TerritoryMember struct {
+	ID          string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	TerritoryID string `json:"territoryId" gorm:"column:territory_id;type:uuid;not null;uniqueIndex:idx_territory_member"`
+	UserID      string `json:"userId" gorm:"column:user_id;type:uuid;not null;uniqueIndex:idx_territory_member"`
+	User        *User  `json:"user,omitempty" gorm:"foreignKey:UserID"`
+	Role        string `json:"role" gorm:"not null"`
 
 	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
 	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
@@ -834,6 +1765,98 @@ type Territory struct {
 	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
 }
 
+// CompanyTerritory records that a company meets a territory's criteria. The
+// rows are recomputed when the company, its address or the territory change.
+type CompanyTerritory struct {
+	ID          string     `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	CompanyID   string     `json:"companyId" gorm:"column:company_id;type:uuid;not null;uniqueIndex:idx_company_territory"`
+	TerritoryID string     `json:"territoryId" gorm:"column:territory_id;type:uuid;not null;uniqueIndex:idx_company_territory;index"`
+	Territory   *Territory `json:"territory,omitempty" gorm:"foreignKey:TerritoryID"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+}
+
+// TerritoryDefinition is a territory as a realignment proposes it, or as it
+// was before the realignment was applied. New territories carry the ID they
+// are created with. Delete removes an existing territory; Created marks
+// snapshots of territories the realignment created. Snapshots of territories
+// the realignment deleted keep their Members to restore them.
+type TerritoryDefinition struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	TypeID      *string  `json:"typeId"`
+	ParentID    *string  `json:"parentId"`
+	Countries   []string `json:"countries"`
+	States      []string `json:"states"`
+	Cities      []string `json:"cities"`
+	PostalCodes []string `json:"postalCodes"`
+	Industries  []string `json:"industries"`
+	CompanySize []string `json:"companySize"`
+	OwnerID     *string  `json:"ownerId"`
+	Delete      bool     `json:"delete,omitempty"`
+	Created     bool     `json:"created,omitempty"`
+
+	Members []TerritoryMemberDefinition `json:"members,omitempty"`
+}
+
+// TerritoryMemberDefinition is a territory member in a TerritoryDefinition
+type TerritoryMemberDefinition struct {
+	UserID string `json:"userId"`
+	Role   string `json:"role"`
+}
+
+// TerritoryRealignment is a proposed set of territory definitions and the
+// ownership changes they cause. Applying it saves the definitions and moves
+// the owners; until UndoUntil it can be undone.
+type TerritoryRealignment struct {
+	ID          string                `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Name        string                `json:"name" gorm:"not null"`
+	Status      string                `json:"status" gorm:"not null;default:preview"` // preview, applied, undone
+	Definitions []TerritoryDefinition `json:"definitions" gorm:"type:jsonb;serializer:json"`
+	Previous    []TerritoryDefinition `json:"previous,omitempty" gorm:"type:jsonb;serializer:json"`
+
+	CompanyCount int `json:"companyCount" gorm:"column:company_count;default:0"`
+	LeadCount    int `json:"leadCount" gorm:"column:lead_count;default:0"`
+	DealCount    int `json:"dealCount" gorm:"column:deal_count;default:0"`
+
+	Changes []TerritoryRealignmentChange `json:"changes,omitempty" gorm:"foreignKey:RealignmentID"`
+
+	AppliedAt *time.Time `json:"appliedAt" gorm:"column:applied_at"`
+	AppliedBy *string    `json:"appliedBy" gorm:"column:applied_by;type:uuid"`
+	UndoUntil *time.Time `json:"undoUntil" gorm:"column:undo_until"`
+	UndoneAt  *time.Time `json:"undoneAt" gorm:"column:undone_at"`
+	UndoneBy  *string    `json:"undoneBy" gorm:"column:undone_by;type:uuid"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+	CreatedBy *string   `json:"createdBy" gorm:"column:created_by;type:uuid"`
+}
+
+// TerritoryRealignmentChange is one company, lead or deal a realignment
+// moves from one owner to another
+type TerritoryRealignmentChange struct {
+	ID            string `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	RealignmentID string `json:"realignmentId" gorm:"column:realignment_id;type:uuid;not null;index"`
+
+	EntityType string `json:"entityType" gorm:"column:entity_type;not null"` // company, lead, deal
+	EntityID   string `json:"entityId" gorm:"column:entity_id;type:uuid;not null"`
+	EntityName string `json:"entityName" gorm:"column:entity_name"`
+	CompanyID  string `json:"companyId" gorm:"column:company_id;type:uuid;not null"`
+
+	FromUserID      *string `json:"fromUserId" gorm:"column:from_user_id;type:uuid"`
+	ToUserID        string  `json:"toUserId" gorm:"column:to_user_id;type:uuid;not null"`
+	FromTerritoryID *string `json:"fromTerritoryId" gorm:"column:from_territory_id;type:uuid"`
+	ToTerritoryID   string  `json:"toTerritoryId" gorm:"column:to_territory_id;type:uuid;not null"`
+
+	Status string `json:"status" gorm:"not null;default:pending"` // pending, applied, skipped, reverted
+}
+
 type CustomField struct {
 	ID           string      `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
 	Name         string      `json:"name" gorm:"not null"`
@@ -885,24 +1908,31 @@ type CustomObject struct {
 
 type ActivityLog struct {
 	ID         string  `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
-	EntityType string  `json:"entityType" gorm:"column:entity_type;not null"`
-	EntityID   string  `json:"entityId" gorm:"column:entity_id;not null"`
+	EntityType string  `json:"entityType" gorm:"column:entity_type;not null;index:idx_activity_logs_entity"`
+	EntityID   string  `json:"entityId" gorm:"column:entity_id;not null;index:idx_activity_logs_entity"`
 	Action     string  `json:"action" gorm:"not null"`
 	FieldName  *string `json:"fieldName" gorm:"column:field_name"`
 	OldValue   *string `json:"oldValue" gorm:"column:old_value"`
 	NewValue   *string `json:"newValue" gorm:"column:new_value"`
-	UserID     *string `json:"userId" gorm:"column:user_id;type:uuid"`
+	UserID     *string `json:"userId" gorm:"column:user_id;type:uuid;index"`
 	IPAddress  *string `json:"ipAddress" gorm:"column:ip_address"`
 	UserAgent  *string `json:"userAgent" gorm:"column:user_agent"`
 
-	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	// Set on entries moved here from a record merged into this one
+	MergedFromID *string `json:"mergedFromId" gorm:"column:merged_from_id"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null;index"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP;index"`
 }
 
 type DealStageHistory struct {
 	ID                    string     `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
-	DealID                string     `json:"dealId" gorm:"column:deal_id;type:uuid;not null"`
+	DealID                string     `json:"dealId" gorm:"column:deal_id;type:uuid;not null;index"`
 	FromStageID           *string    `json:"fromStageId" gorm:"column:from_stage_id;type:uuid"`
+	FromStage             *Stage     `json:"fromStage,omitempty" gorm:"foreignKey:FromStageID"`
 	ToStageID             string     `json:"toStageId" gorm:"column:to_stage_id;type:uuid;not null"`
+	ToStage               *Stage     `json:"toStage,omitempty" gorm:"foreignKey:ToStageID"`
 	FromAmount            *float64   `json:"fromAmount" gorm:"column:from_amount"`
 	ToAmount              *float64   `json:"toAmount" gorm:"column:to_amount"`
 	FromProbability       *int       `json:"fromProbability" gorm:"column:from_probability"`
@@ -917,6 +1947,31 @@ type DealStageHistory struct {
 	MovedBy               *string    `json:"movedBy" gorm:"column:moved_by;type:uuid"`
 }
 
+// ForecastSnapshot stores the forecast for one period, owner and pipeline as
+// it stood on SnapshotDate, so that forecast movement can be compared over time
+type ForecastSnapshot struct {
+	ID           string    `json:"id" gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	SnapshotDate time.Time `json:"snapshotDate" gorm:"column:snapshot_date;type:date;not null;index"`
+	Period       string    `json:"period" gorm:"not null"` // e.g. 2026-10
+	PeriodStart  time.Time `json:"periodStart" gorm:"column:period_start;type:date;not null"`
+
+	OwnerID    *string `json:"ownerId" gorm:"column:owner_id;type:uuid"`
+	PipelineID *string `json:"pipelineId" gorm:"column:pipeline_id;type:uuid"`
+
+	ClosedWon        float64 `json:"closedWon" gorm:"column:closed_won"`
+	Commit           float64 `json:"commit"`
+	BestCase         float64 `json:"bestCase" gorm:"column:best_case"`
+	Pipeline         float64 `json:"pipeline"`
+	WeightedPipeline float64 `json:"weightedPipeline" gorm:"column:weighted_pipeline"`
+	DealCount        int     `json:"dealCount" gorm:"column:deal_count"`
+	Currency         string  `json:"currency"`
+
+	TenantID string `json:"tenantId" gorm:"column:tenant_id;type:uuid;not null;index"`
+	Tenant   Tenant `json:"tenant,omitempty" gorm:"foreignKey:TenantID"`
+
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+}
+
 // ============================================================================
 // CONTACT INFORMATION
 // ============================================================================
@@ -0,0 +1,165 @@
+// Package pdf writes simple text documents as PDF without external tools.
+// Text is set in the standard Courier fonts so fixed-width layouts produced
+// by templates line up as written.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A4 page size and layout in points
+const (
+	pageWidth    = 595.0
+	pageHeight   = 842.0
+	margin       = 50.0
+	bodySize     = 10.0
+	headingSize  = 14.0
+	lineSpacing  = 1.3
+	charWidthEms = 0.6 // every Courier glyph is 600/1000 em wide
+)
+
+// Document is a PDF being built line by line
+type Document struct {
+	pages   []*bytes.Buffer
+	current *bytes.Buffer
+	y       float64
+}
+
+// New returns an empty document
+func New() *Document {
+	return &Document{}
+}
+
+// Text adds a line of body text, wrapping it at the right margin
+func (d *Document) Text(line string) {
+	d.write(line, "F1", bodySize)
+}
+
+// Heading adds a line of bold, larger text
+func (d *Document) Heading(line string) {
+	d.write(line, "F2", headingSize)
+}
+
+// Rule draws a horizontal line across the page
+func (d *Document) Rule() {
+	d.ensureSpace(bodySize * lineSpacing)
+	y := d.y - bodySize*lineSpacing/2
+	fmt.Fprintf(d.current, "%.2f %.2f m %.2f %.2f l S\n", margin, y, pageWidth-margin, y)
+	d.y -= bodySize * lineSpacing
+}
+
+// PageBreak starts a new page
+func (d *Document) PageBreak() {
+	d.newPage()
+}
+
+func (d *Document) write(line, font string, size float64) {
+	maxChars := int((pageWidth - 2*margin) / (size * charWidthEms))
+	for _, part := range wrap(line, maxChars) {
+		d.ensureSpace(size * lineSpacing)
+		d.y -= size * lineSpacing
+		fmt.Fprintf(d.current, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, margin, d.y, escape(part))
+	}
+}
+
+func (d *Document) ensureSpace(height float64) {
+	if d.current == nil || d.y-height < margin {
+		d.newPage()
+	}
+}
+
+func (d *Document) newPage() {
+	d.current = &bytes.Buffer{}
+	d.pages = append(d.pages, d.current)
+	d.y = pageHeight - margin
+}
+
+// Bytes renders the document
+func (d *Document) Bytes() []byte {
+	if len(d.pages) == 0 {
+		d.newPage()
+	}
+
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1-4 are the catalog, page tree and fonts; each page then takes
+	// a page object followed by its content stream
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+i*2)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 6+i*2))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// wrap splits a line into parts of at most width characters, breaking at
+// spaces where possible
+func wrap(line string, width int) []string {
+	runes := []rune(strings.TrimRight(line, " \t\r"))
+	if len(runes) <= width {
+		return []string{string(runes)}
+	}
+	var parts []string
+	for len(runes) > width {
+		cut := width
+		for i := width; i > width/2; i-- {
+			if runes[i] == ' ' {
+				cut = i
+				break
+			}
+		}
+		parts = append(parts, string(runes[:cut]))
+		runes = []rune(strings.TrimLeft(string(runes[cut:]), " "))
+	}
+	return append(parts, string(runes))
+}
+
+// escape encodes text as a PDF string in WinAnsiEncoding. Characters outside
+// Latin-1 are replaced with '?'.
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteString("    ")
+		case r < 32:
+			b.WriteByte(' ')
+		case r < 128:
+			b.WriteRune(r)
+		case r == '€':
+			b.WriteString("\\200")
+		case r < 256:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
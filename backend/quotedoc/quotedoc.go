@@ -0,0 +1,151 @@
+// Package quotedoc renders quotes to PDF from tenant templates
+package quotedoc
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"finhub-backend/models"
+	"finhub-backend/pdf"
+)
+
+// DefaultTemplate is used when the tenant has no quote template
+const DefaultTemplate = `# {{.Tenant}}
+# Quote {{.Quote.Number}} v{{.Quote.Version}}
+---
+Date:         {{date .Quote.CreatedAt}}
+Valid until:  {{date .Quote.ExpiresAt}}
+Deal:         {{.Deal.Name}}
+{{- with .Deal.Company}}
+Customer:     {{.Name}}
+{{- end}}
+{{- with .Deal.Contact}}
+Contact:      {{.FirstName}} {{.LastName}}
+{{- end}}
+
+{{pad 12 "SKU"}} {{pad 27 "Product"}} {{lpad 7 "Qty"}} {{lpad 12 "Unit price"}} {{lpad 6 "Disc%"}} {{lpad 13 "Total"}}
+---
+{{- range .Quote.Lines}}
+{{pad 12 .SKU}} {{pad 27 .Name}} {{lpad 7 (number .Quantity)}} {{lpad 12 (money .UnitPrice)}} {{lpad 6 (number .DiscountPercent)}} {{lpad 13 (money .TotalPrice)}}
+{{- if .TermMonths}}
+{{pad 12 ""}} Term: {{.TermMonths}} months
+{{- end}}
+{{- end}}
+---
+{{lpad 66 "Subtotal"}} {{lpad 13 (money .Quote.Subtotal)}}
+{{lpad 66 "Discount"}} {{lpad 13 (money .Quote.DiscountTotal)}}
+{{lpad 66 (print "Total " .Quote.Currency)}} {{lpad 13 (money .Quote.Total)}}
+{{- with .Quote.Terms}}
+
+# Terms
+{{.}}
+{{- end}}
+`
+
+// Data is passed to quote templates
+type Data struct {
+	Tenant string
+	Quote  models.Quote
+	Deal   models.Deal
+}
+
+var funcs = template.FuncMap{
+	"money":  money,
+	"number": number,
+	"date":   date,
+	"pad": func(width int, value interface{}) string {
+		return fit(fmt.Sprint(value), width, false)
+	},
+	"lpad": func(width int, value interface{}) string {
+		return fit(fmt.Sprint(value), width, true)
+	},
+	"upper": strings.ToUpper,
+}
+
+// Parse checks a template body for syntax errors
+func Parse(body string) (*template.Template, error) {
+	return template.New("quote").Funcs(funcs).Option("missingkey=zero").Parse(body)
+}
+
+// Render executes the template and lays the output out as a PDF
+func Render(body string, data Data) ([]byte, error) {
+	if strings.TrimSpace(body) == "" {
+		body = DefaultTemplate
+	}
+	tmpl, err := Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return nil, err
+	}
+
+	doc := pdf.New()
+	for _, line := range strings.Split(out.String(), "\n") {
+		switch {
+		case strings.HasPrefix(line, "# "):
+			doc.Heading(strings.TrimPrefix(line, "# "))
+		case strings.TrimSpace(line) == "---":
+			doc.Rule()
+		case strings.TrimSpace(line) == "\f":
+			doc.PageBreak()
+		default:
+			doc.Text(line)
+		}
+	}
+	return doc.Bytes(), nil
+}
+
+// money formats an amount with thousands separators and two decimals
+func money(amount float64) string {
+	text := fmt.Sprintf("%.2f", amount)
+	sign := ""
+	if strings.HasPrefix(text, "-") {
+		sign, text = "-", text[1:]
+	}
+	whole, cents := text[:len(text)-3], text[len(text)-3:]
+	var b strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(digit)
+	}
+	return sign + b.String() + cents
+}
+
+// number formats a quantity or percentage without trailing zeros
+func number(value float64) string {
+	text := fmt.Sprintf("%.2f", value)
+	text = strings.TrimRight(text, "0")
+	return strings.TrimSuffix(text, ".")
+}
+
+func date(value interface{}) string {
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format("2006-01-02")
+	case *time.Time:
+		if v != nil {
+			return v.Format("2006-01-02")
+		}
+	}
+	return ""
+}
+
+// fit pads or truncates text to exactly width characters
+func fit(text string, width int, right bool) string {
+	runes := []rune(text)
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+	padding := strings.Repeat(" ", width-len(runes))
+	if right {
+		return padding + text
+	}
+	return text + padding
+}
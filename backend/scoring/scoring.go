@@ -0,0 +1,347 @@
+// Package scoring computes lead scores from tenant scoring rules and sets
+// lead temperatures from score thresholds
+package scoring
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"finhub-backend/audit"
+	"finhub-backend/domains"
+	"finhub-backend/models"
+)
+
+// Rule attributes
+const (
+	AttributeSource                 = "source"
+	AttributeTitleKeyword           = "title_keyword"
+	AttributeIndustry               = "industry"
+	AttributeCompanySize            = "company_size"
+	AttributeEmailDomainType        = "email_domain_type"
+	AttributeCommunicationsReceived = "communications_received"
+	AttributeAssetClicks            = "asset_clicks"
+	AttributeAssetConversions       = "asset_conversions"
+)
+
+// Attributes lists every rule attribute
+var Attributes = []string{
+	AttributeSource, AttributeTitleKeyword, AttributeIndustry, AttributeCompanySize,
+	AttributeEmailDomainType, AttributeCommunicationsReceived, AttributeAssetClicks, AttributeAssetConversions,
+}
+
+// IsActivity reports whether an attribute counts activity events
+func IsActivity(attribute string) bool {
+	switch attribute {
+	case AttributeCommunicationsReceived, AttributeAssetClicks, AttributeAssetConversions:
+		return true
+	}
+	return false
+}
+
+// Contribution is a rule that matched a lead and the points it added
+type Contribution struct {
+	RuleID    string `json:"ruleId"`
+	Name      string `json:"name"`
+	Attribute string `json:"attribute"`
+	Points    int    `json:"points"`
+	Detail    string `json:"detail"`
+}
+
+// Result is a lead's computed score and the rules behind it. TemperatureID
+// is nil when the tenant has no threshold the score reaches.
+type Result struct {
+	Score         int            `json:"score"`
+	Contributions []Contribution `json:"contributions"`
+	TemperatureID *string        `json:"temperatureId"`
+}
+
+// signals are the lead values rules are evaluated against
+type signals struct {
+	source     string
+	title      string
+	industryID string
+	sizeID     string
+	emails     []string
+	events     map[string][]time.Time
+}
+
+// Evaluate scores a lead against the tenant's active rules and thresholds
+func Evaluate(db *gorm.DB, lead *models.Lead, now time.Time) (Result, error) {
+	var rules []models.LeadScoringRule
+	if err := db.Where("tenant_id = ? AND is_active = ?", lead.TenantID, true).
+		Order(`"order" ASC, created_at ASC`).
+		Find(&rules).Error; err != nil {
+		return Result{}, err
+	}
+	var thresholds []models.LeadScoreThreshold
+	if err := db.Where("tenant_id = ?", lead.TenantID).Order("min_score DESC").Find(&thresholds).Error; err != nil {
+		return Result{}, err
+	}
+
+	s, err := loadSignals(db, lead)
+	if err != nil {
+		return Result{}, err
+	}
+
+	result := Result{Contributions: []Contribution{}}
+	for _, rule := range rules {
+		detail, ok := match(rule, s, now)
+		if !ok {
+			continue
+		}
+		result.Score += rule.Points
+		result.Contributions = append(result.Contributions, Contribution{
+			RuleID:    rule.ID,
+			Name:      rule.Name,
+			Attribute: rule.Attribute,
+			Points:    rule.Points,
+			Detail:    detail,
+		})
+	}
+	for _, threshold := range thresholds {
+		if result.Score >= threshold.MinScore {
+			result.TemperatureID = &threshold.TemperatureID
+			break
+		}
+	}
+	return result, nil
+}
+
+// ScoreLead recomputes a lead's score and stores it, with the temperature
+// its thresholds give. Converted and deleted leads keep their score, and
+// leads of tenants without scoring rules keep their manually set score.
+func ScoreLead(db *gorm.DB, leadID string) error {
+	var lead models.Lead
+	if err := db.First(&lead, "id = ?", leadID).Error; err != nil {
+		return err
+	}
+	return scoreLead(db, &lead, time.Now())
+}
+
+func scoreLead(db *gorm.DB, lead *models.Lead, now time.Time) error {
+	if lead.ConvertedAt != nil || lead.IsDeleted {
+		return nil
+	}
+	var rules int64
+	if err := db.Model(&models.LeadScoringRule{}).Where("tenant_id = ? AND is_active = ?", lead.TenantID, true).Count(&rules).Error; err != nil {
+		return err
+	}
+	if rules == 0 {
+		return nil
+	}
+
+	result, err := Evaluate(db, lead, now)
+	if err != nil {
+		return err
+	}
+	// Only real score and temperature changes are audited; scored_at moves
+	// on every run
+	updates := map[string]interface{}{}
+	if result.Score != lead.Score {
+		updates["score"] = result.Score
+	}
+	if result.TemperatureID != nil && (lead.TemperatureID == nil || *lead.TemperatureID != *result.TemperatureID) {
+		updates["temperature_id"] = *result.TemperatureID
+	}
+	if len(updates) > 0 {
+		if err := db.Model(lead).Updates(updates).Error; err != nil {
+			return err
+		}
+	}
+	return audit.Skip(db).Model(lead).UpdateColumn("scored_at", now).Error
+}
+
+// ScoreCompanyLeads rescores the leads of a company, e.g. after its industry
+// or size changed
+func ScoreCompanyLeads(db *gorm.DB, companyID string) error {
+	var leads []models.Lead
+	if err := db.Where("company_id = ? AND is_deleted = ? AND converted_at IS NULL", companyID, false).Find(&leads).Error; err != nil {
+		return err
+	}
+	now := time.Now()
+	var errs []error
+	for i := range leads {
+		if err := scoreLead(db, &leads[i], now); err != nil {
+			errs = append(errs, fmt.Errorf("lead %s: %w", leads[i].ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ScoreTenant rescores every open lead of a tenant. A lead that fails to
+// score does not stop the others; the failures are returned together.
+func ScoreTenant(db *gorm.DB, tenantID string) error {
+	now := time.Now()
+	var errs []error
+	var leads []models.Lead
+	if err := db.Where("tenant_id = ? AND is_deleted = ? AND converted_at IS NULL", tenantID, false).
+		FindInBatches(&leads, 200, func(tx *gorm.DB, batch int) error {
+			for i := range leads {
+				if err := scoreLead(db, &leads[i], now); err != nil {
+					errs = append(errs, fmt.Errorf("lead %s: %w", leads[i].ID, err))
+				}
+			}
+			return nil
+		}).Error; err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// ScoreAll rescores the open leads of every active tenant with scoring
+// rules, so that activity falling out of rule windows lowers scores. Every
+// tenant is scored even when some fail.
+func ScoreAll(db *gorm.DB) error {
+	var tenantIDs []string
+	if err := db.Model(&models.LeadScoringRule{}).
+		Joins("JOIN tenants ON tenants.id = lead_scoring_rules.tenant_id").
+		Where("lead_scoring_rules.is_active = ? AND tenants.is_active = ?", true, true).
+		Distinct().
+		Pluck("lead_scoring_rules.tenant_id", &tenantIDs).Error; err != nil {
+		return err
+	}
+	var errs []error
+	for _, tenantID := range tenantIDs {
+		if err := ScoreTenant(db, tenantID); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %s: %w", tenantID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func loadSignals(db *gorm.DB, lead *models.Lead) (signals, error) {
+	s := signals{events: map[string][]time.Time{}}
+	if lead.Source != nil {
+		s.source = *lead.Source
+	}
+	if lead.Title != nil {
+		s.title = *lead.Title
+	}
+
+	if lead.CompanyID != nil {
+		var company models.Company
+		if err := db.Select("industry_id", "size_id").First(&company, "id = ?", *lead.CompanyID).Error; err != nil && err != gorm.ErrRecordNotFound {
+			return s, err
+		}
+		if company.IndustryID != nil {
+			s.industryID = *company.IndustryID
+		}
+		if company.SizeID != nil {
+			s.sizeID = *company.SizeID
+		}
+	}
+
+	if err := db.Model(&models.EmailAddress{}).
+		Where("entity_type = ? AND entity_id = ?", "lead", lead.ID).
+		Pluck("email", &s.emails).Error; err != nil {
+		return s, err
+	}
+
+	// Activity of the lead, and of its contact once it has one
+	communications := db.Model(&models.Communication{}).
+		Where("direction = ?", models.CommunicationDirectionInbound)
+	interactions := db.Model(&models.MarketingInteraction{})
+	if lead.ContactID != nil {
+		communications = communications.Where("lead_id = ? OR contact_id = ?", lead.ID, *lead.ContactID)
+		interactions = interactions.Where("lead_id = ? OR contact_id = ?", lead.ID, *lead.ContactID)
+	} else {
+		communications = communications.Where("lead_id = ?", lead.ID)
+		interactions = interactions.Where("lead_id = ?", lead.ID)
+	}
+	var received []time.Time
+	if err := communications.Pluck("COALESCE(received_at, created_at)", &received).Error; err != nil {
+		return s, err
+	}
+	s.events[AttributeCommunicationsReceived] = received
+
+	var rows []struct {
+		Type       string
+		OccurredAt time.Time
+	}
+	if err := interactions.Select("type", "occurred_at").Where("type IN ?", []string{"click", "conversion"}).Scan(&rows).Error; err != nil {
+		return s, err
+	}
+	for _, row := range rows {
+		attribute := AttributeAssetClicks
+		if row.Type == "conversion" {
+			attribute = AttributeAssetConversions
+		}
+		s.events[attribute] = append(s.events[attribute], row.OccurredAt)
+	}
+	return s, nil
+}
+
+// match reports whether a rule matches and describes why
+func match(rule models.LeadScoringRule, s signals, now time.Time) (string, bool) {
+	switch rule.Attribute {
+	case AttributeSource:
+		for _, value := range rule.Values {
+			if s.source != "" && strings.EqualFold(value, s.source) {
+				return "source is " + s.source, true
+			}
+		}
+	case AttributeTitleKeyword:
+		title := strings.ToLower(s.title)
+		for _, keyword := range rule.Values {
+			if keyword != "" && strings.Contains(title, strings.ToLower(keyword)) {
+				return fmt.Sprintf("title %q contains %q", s.title, keyword), true
+			}
+		}
+	case AttributeIndustry:
+		if contains(rule.Values, s.industryID) {
+			return "company industry matches", true
+		}
+	case AttributeCompanySize:
+		if contains(rule.Values, s.sizeID) {
+			return "company size matches", true
+		}
+	case AttributeEmailDomainType:
+		for _, email := range s.emails {
+			domainType := "business"
+			if domains.IsFreeMail(email) {
+				domainType = "free"
+			}
+			if contains(rule.Values, domainType) {
+				return fmt.Sprintf("%s is a %s email address", email, domainType), true
+			}
+		}
+	default:
+		if !IsActivity(rule.Attribute) {
+			return "", false
+		}
+		count := 0
+		for _, at := range s.events[rule.Attribute] {
+			if rule.WithinDays == nil || !at.Before(now.AddDate(0, 0, -*rule.WithinDays)) {
+				count++
+			}
+		}
+		minCount := rule.MinCount
+		if minCount < 1 {
+			minCount = 1
+		}
+		if count >= minCount {
+			detail := fmt.Sprintf("%d %s", count, strings.ReplaceAll(rule.Attribute, "_", " "))
+			if rule.WithinDays != nil {
+				detail += fmt.Sprintf(" in the last %d days", *rule.WithinDays)
+			}
+			return detail, true
+		}
+	}
+	return "", false
+}
+
+func contains(values []string, value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,361 @@
+package territories
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"finhub-backend/audit"
+	"finhub-backend/models"
+)
+
+// ActionRealign is the audit action recorded for owner changes made by a
+// realignment
+const ActionRealign = "realign"
+
+// Realignment and change statuses
+const (
+	StatusPreview  = "preview"
+	StatusApplied  = "applied"
+	StatusUndone   = "undone"
+	StatusPending  = "pending"
+	StatusSkipped  = "skipped"
+	StatusReverted = "reverted"
+)
+
+// UndoWindow is how long an applied realignment can be undone
+const UndoWindow = 7 * 24 * time.Hour
+
+// batchSize bounds the IN lists of bulk queries
+const batchSize = 1000
+
+// Propose returns a tenant's territories as they would be after applying
+// definitions: matching IDs are replaced or removed and the rest added
+func Propose(current []models.Territory, defs []models.TerritoryDefinition) []models.Territory {
+	byID := map[string]models.TerritoryDefinition{}
+	for _, def := range defs {
+		byID[def.ID] = def
+	}
+	proposed := make([]models.Territory, 0, len(current)+len(defs))
+	for _, territory := range current {
+		def, ok := byID[territory.ID]
+		if !ok {
+			proposed = append(proposed, territory)
+			continue
+		}
+		delete(byID, territory.ID)
+		if !def.Delete {
+			proposed = append(proposed, fromDefinition(def, territory.TenantID))
+		}
+	}
+	// Keep the request order for new territories
+	for _, def := range defs {
+		if _, ok := byID[def.ID]; ok && !def.Delete {
+			proposed = append(proposed, fromDefinition(def, ""))
+		}
+	}
+	return proposed
+}
+
+func fromDefinition(def models.TerritoryDefinition, tenantID string) models.Territory {
+	return models.Territory{
+		ID:          def.ID,
+		Name:        def.Name,
+		TypeID:      def.TypeID,
+		ParentID:    def.ParentID,
+		Countries:   def.Countries,
+		States:      def.States,
+		Cities:      def.Cities,
+		PostalCodes: def.PostalCodes,
+		Industries:  def.Industries,
+		CompanySize: def.CompanySize,
+		OwnerID:     def.OwnerID,
+		TenantID:    tenantID,
+	}
+}
+
+func toDefinition(t models.Territory) models.TerritoryDefinition {
+	return models.TerritoryDefinition{
+		ID:          t.ID,
+		Name:        t.Name,
+		TypeID:      t.TypeID,
+		ParentID:    t.ParentID,
+		Countries:   t.Countries,
+		States:      t.States,
+		Cities:      t.Cities,
+		PostalCodes: t.PostalCodes,
+		Industries:  t.Industries,
+		CompanySize: t.CompanySize,
+		OwnerID:     t.OwnerID,
+	}
+}
+
+// Preview works out which companies, leads and deals would change owner if
+// defs were applied. A company moves when its proposed territory owner
+// differs from its assigned user. Its open leads and deals move with it
+// when they are unassigned or held by the company's current owner.
+func Preview(db *gorm.DB, tenantID string, defs []models.TerritoryDefinition) ([]models.TerritoryRealignmentChange, error) {
+	var current []models.Territory
+	if err := db.Where("tenant_id = ?", tenantID).Find(&current).Error; err != nil {
+		return nil, err
+	}
+	before, after := newTree(current), newTree(Propose(current, defs))
+
+	var changes, companyChanges []models.TerritoryRealignmentChange
+	var companies []models.Company
+	err := db.Where("tenant_id = ? AND is_deleted = ?", tenantID, false).
+		FindInBatches(&companies, 200, func(batch *gorm.DB, _ int) error {
+			profiles, err := loadProfiles(db, companies)
+			if err != nil {
+				return err
+			}
+			for _, company := range companies {
+				profile := profiles[company.ID]
+				to := after.owner(after.match(profile))
+				if to == nil || equalStringPtr(company.AssignedUserID, to.OwnerID) {
+					continue
+				}
+				change := models.TerritoryRealignmentChange{
+					EntityType:    "company",
+					EntityID:      company.ID,
+					EntityName:    company.Name,
+					CompanyID:     company.ID,
+					FromUserID:    company.AssignedUserID,
+					ToUserID:      *to.OwnerID,
+					ToTerritoryID: to.ID,
+					Status:        StatusPending,
+				}
+				if from := before.owner(before.match(profile)); from != nil {
+					change.FromTerritoryID = &from.ID
+				}
+				companyChanges = append(companyChanges, change)
+			}
+			return nil
+		}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(companyChanges); start += batchSize {
+		chunk := companyChanges[start:min(start+batchSize, len(companyChanges))]
+		ids := make([]string, len(chunk))
+		byCompany := make(map[string]models.TerritoryRealignmentChange, len(chunk))
+		for i, change := range chunk {
+			ids[i] = change.CompanyID
+			byCompany[change.CompanyID] = change
+		}
+
+		var leads []models.Lead
+		if err := db.Where("company_id IN ? AND converted_at IS NULL AND is_deleted = ?", ids, false).
+			Find(&leads).Error; err != nil {
+			return nil, err
+		}
+		var deals []models.Deal
+		if err := db.Joins("JOIN stages ON stages.id = deals.stage_id").
+			Where("deals.company_id IN ? AND deals.is_deleted = ?", ids, false).
+			Where("stages.is_closed_won = ? AND stages.is_closed_lost = ?", false, false).
+			Find(&deals).Error; err != nil {
+			return nil, err
+		}
+
+		followers := map[string][]models.TerritoryRealignmentChange{}
+		for _, lead := range leads {
+			company := byCompany[*lead.CompanyID]
+			if follows(lead.AssignedUserID, company) {
+				name := strings.TrimSpace(deref(lead.FirstName) + " " + deref(lead.LastName))
+				followers[company.CompanyID] = append(followers[company.CompanyID], follower(company, "lead", lead.ID, name, lead.AssignedUserID))
+			}
+		}
+		for _, deal := range deals {
+			company := byCompany[*deal.CompanyID]
+			if follows(deal.AssignedUserID, company) {
+				followers[company.CompanyID] = append(followers[company.CompanyID], follower(company, "deal", deal.ID, deal.Name, deal.AssignedUserID))
+			}
+		}
+		for _, company := range chunk {
+			changes = append(changes, company)
+			changes = append(changes, followers[company.CompanyID]...)
+		}
+	}
+	return changes, nil
+}
+
+// follows reports whether a record held by userID moves with its company
+func follows(userID *string, company models.TerritoryRealignmentChange) bool {
+	if userID != nil && *userID == company.ToUserID {
+		return false
+	}
+	return userID == nil || equalStringPtr(userID, company.FromUserID)
+}
+
+func follower(company models.TerritoryRealignmentChange, entityType, id, name string, from *string) models.TerritoryRealignmentChange {
+	return models.TerritoryRealignmentChange{
+		EntityType:      entityType,
+		EntityID:        id,
+		EntityName:      name,
+		CompanyID:       company.CompanyID,
+		FromUserID:      from,
+		ToUserID:        company.ToUserID,
+		FromTerritoryID: company.FromTerritoryID,
+		ToTerritoryID:   company.ToTerritoryID,
+		Status:          StatusPending,
+	}
+}
+
+// Apply saves a realignment's definitions, recomputes memberships and moves
+// the owners of its pending changes. A record whose owner changed since the
+// preview is skipped. It returns the definitions as they were, for Undo.
+func Apply(tx *gorm.DB, realignment *models.TerritoryRealignment) ([]models.TerritoryDefinition, error) {
+	previous, err := saveDefinitions(tx, realignment.TenantID, realignment.Definitions)
+	if err != nil {
+		return nil, err
+	}
+	if err := AssignTenant(tx, realignment.TenantID); err != nil {
+		return nil, err
+	}
+	for i := range realignment.Changes {
+		change := &realignment.Changes[i]
+		if change.Status != StatusPending {
+			continue
+		}
+		moved, err := moveOwner(tx.Set(audit.ActionKey, ActionRealign), change.EntityType, change.EntityID, change.FromUserID, &change.ToUserID)
+		if err != nil {
+			return nil, err
+		}
+		change.Status = StatusSkipped
+		if moved {
+			change.Status = StatusApplied
+		}
+		if err := audit.Skip(tx).Model(change).Update("status", change.Status).Error; err != nil {
+			return nil, err
+		}
+	}
+	return previous, nil
+}
+
+// Undo restores the definitions an applied realignment replaced and gives
+// its records back to their previous owners. Records reassigned since the
+// realignment are left alone. Territories it deleted are recreated with
+// their members.
+func Undo(tx *gorm.DB, realignment *models.TerritoryRealignment) error {
+	if _, err := saveDefinitions(tx, realignment.TenantID, realignment.Previous); err != nil {
+		return err
+	}
+	if err := AssignTenant(tx, realignment.TenantID); err != nil {
+		return err
+	}
+	for i := range realignment.Changes {
+		change := &realignment.Changes[i]
+		if change.Status != StatusApplied {
+			continue
+		}
+		moved, err := moveOwner(tx.Set(audit.ActionKey, audit.ActionRestore), change.EntityType, change.EntityID, &change.ToUserID, change.FromUserID)
+		if err != nil {
+			return err
+		}
+		change.Status = StatusSkipped
+		if moved {
+			change.Status = StatusReverted
+		}
+		if err := audit.Skip(tx).Model(change).Update("status", change.Status).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveOwner reassigns a record from one user to another, unless it is no
+// longer held by from
+func moveOwner(tx *gorm.DB, entityType, id string, from, to *string) (bool, error) {
+	var model interface{}
+	switch entityType {
+	case "company":
+		model = &models.Company{ID: id}
+	case "lead":
+		model = &models.Lead{ID: id}
+	case "deal":
+		model = &models.Deal{ID: id}
+	default:
+		return false, fmt.Errorf("unknown entity type %s", entityType)
+	}
+	result := tx.Model(model).
+		Where("assigned_user_id IS NOT DISTINCT FROM ?", from).
+		Update("assigned_user_id", to)
+	return result.RowsAffected > 0, result.Error
+}
+
+// saveDefinitions creates, updates or deletes territories to match defs and
+// returns snapshots to restore them. A definition marked Created deletes the
+// territory it describes; new territories get the definition's members.
+func saveDefinitions(tx *gorm.DB, tenantID string, defs []models.TerritoryDefinition) ([]models.TerritoryDefinition, error) {
+	var previous []models.TerritoryDefinition
+	for _, def := range defs {
+		var existing models.Territory
+		if err := tx.Where("id = ? AND tenant_id = ?", def.ID, tenantID).Limit(1).Find(&existing).Error; err != nil {
+			return nil, err
+		}
+		if existing.ID == "" {
+			previous = append(previous, models.TerritoryDefinition{ID: def.ID, Name: def.Name, Created: true})
+		} else {
+			snapshot := toDefinition(existing)
+			if def.Delete || def.Created {
+				var members []models.TerritoryMember
+				if err := tx.Where("territory_id = ?", existing.ID).Order("created_at ASC").Find(&members).Error; err != nil {
+					return nil, err
+				}
+				for _, member := range members {
+					snapshot.Members = append(snapshot.Members, models.TerritoryMemberDefinition{UserID: member.UserID, Role: member.Role})
+				}
+			}
+			previous = append(previous, snapshot)
+		}
+
+		if def.Delete || def.Created {
+			if existing.ID == "" {
+				continue
+			}
+			if err := deleteTerritory(tx, existing.ID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		territory := fromDefinition(def, tenantID)
+		if existing.ID == "" {
+			if err := tx.Create(&territory).Error; err != nil {
+				return nil, err
+			}
+			for _, member := range def.Members {
+				if err := tx.Create(&models.TerritoryMember{TerritoryID: territory.ID, UserID: member.UserID, Role: member.Role, TenantID: tenantID}).Error; err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		territory.CreatedAt = existing.CreatedAt
+		territory.UpdatedAt = time.Now()
+		if err := tx.Omit("Owner", "Members", "Tenant").Save(&territory).Error; err != nil {
+			return nil, err
+		}
+	}
+	return previous, nil
+}
+
+func deleteTerritory(tx *gorm.DB, id string) error {
+	if err := audit.Skip(tx).Where("territory_id = ?", id).Delete(&models.CompanyTerritory{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("territory_id = ?", id).Delete(&models.TerritoryMember{}).Error; err != nil {
+		return err
+	}
+	return tx.Delete(&models.Territory{ID: id}).Error
+}
+
+func equalStringPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
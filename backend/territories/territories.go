@@ -0,0 +1,479 @@
+// Package territories matches companies to territories and keeps the
+// company_territories membership rows current
+package territories
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"finhub-backend/audit"
+	"finhub-backend/models"
+)
+
+// Profile is what territory criteria are matched against: a company's
+// primary address, industry and size
+type Profile struct {
+	Country    string
+	State      string
+	City       string
+	PostalCode string
+
+	IndustryID   string
+	IndustryCode string
+	SizeID       string
+	SizeCode     string
+}
+
+// loadProfiles reads the profiles of companies, keyed by company ID
+func loadProfiles(db *gorm.DB, companies []models.Company) (map[string]Profile, error) {
+	profiles := make(map[string]Profile, len(companies))
+	if len(companies) == 0 {
+		return profiles, nil
+	}
+	ids := make([]string, len(companies))
+	var industryIDs, sizeIDs []string
+	for i, company := range companies {
+		ids[i] = company.ID
+		if company.IndustryID != nil {
+			industryIDs = append(industryIDs, *company.IndustryID)
+		}
+		if company.SizeID != nil {
+			sizeIDs = append(sizeIDs, *company.SizeID)
+		}
+	}
+
+	var addresses []models.Address
+	if err := db.Where("entity_type = ? AND entity_id IN ?", "company", ids).
+		Order("is_primary DESC, created_at ASC").
+		Find(&addresses).Error; err != nil {
+		return nil, err
+	}
+	primary := map[string]models.Address{}
+	for _, address := range addresses {
+		if _, ok := primary[address.EntityID]; !ok {
+			primary[address.EntityID] = address
+		}
+	}
+
+	codes := map[string]string{}
+	var industries []models.Industry
+	if len(industryIDs) > 0 {
+		if err := db.Select("id", "code").Where("id IN ?", industryIDs).Find(&industries).Error; err != nil {
+			return nil, err
+		}
+	}
+	for _, industry := range industries {
+		codes[industry.ID] = industry.Code
+	}
+	var sizes []models.CompanySize
+	if len(sizeIDs) > 0 {
+		if err := db.Select("id", "code").Where("id IN ?", sizeIDs).Find(&sizes).Error; err != nil {
+			return nil, err
+		}
+	}
+	for _, size := range sizes {
+		codes[size.ID] = size.Code
+	}
+
+	for _, company := range companies {
+		address := primary[company.ID]
+		p := Profile{
+			Country:    deref(address.Country),
+			State:      deref(address.State),
+			City:       deref(address.City),
+			PostalCode: deref(address.PostalCode),
+			IndustryID: deref(company.IndustryID),
+			SizeID:     deref(company.SizeID),
+		}
+		p.IndustryCode, p.SizeCode = codes[p.IndustryID], codes[p.SizeID]
+		profiles[company.ID] = p
+	}
+	return profiles, nil
+}
+
+// Match reports whether a profile meets every non-empty criterion of a
+// territory itself, ignoring its ancestors. A territory without criteria
+// matches nothing.
+func Match(t *models.Territory, p Profile) bool {
+	ok, criteria := matchOwn(t, p)
+	return ok && criteria > 0
+}
+
+// matchOwn reports whether a profile meets a territory's own criteria, and
+// how many criteria the territory has
+func matchOwn(t *models.Territory, p Profile) (bool, int) {
+	criteria := 0
+	check := func(values []string, ok func(string) bool) bool {
+		if len(values) == 0 {
+			return true
+		}
+		criteria++
+		for _, value := range values {
+			if ok(value) {
+				return true
+			}
+		}
+		return false
+	}
+	equal := func(actual ...string) func(string) bool {
+		return func(value string) bool {
+			for _, a := range actual {
+				if a != "" && strings.EqualFold(strings.TrimSpace(value), a) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	ok := check(t.Countries, equal(p.Country)) &&
+		check(t.States, equal(p.State)) &&
+		check(t.Cities, equal(p.City)) &&
+		check(t.PostalCodes, func(pattern string) bool { return MatchPostalCode(pattern, p.PostalCode) }) &&
+		check(t.Industries, equal(p.IndustryID, p.IndustryCode)) &&
+		check(t.CompanySize, equal(p.SizeID, p.SizeCode))
+	return ok, criteria
+}
+
+// MatchPostalCode reports whether a postal code matches a pattern: an exact
+// code, a prefix ending in "*" or an inclusive range "low-high" of equal
+// length bounds, compared with the code's leading characters. Spaces and
+// case are ignored.
+func MatchPostalCode(pattern, code string) bool {
+	pattern, code = normalizePostalCode(pattern), normalizePostalCode(code)
+	if pattern == "" || code == "" {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(code, prefix)
+	}
+	if low, high, ok := PostalRange(pattern); ok {
+		if len(code) < len(low) {
+			return false
+		}
+		head := code[:len(low)]
+		return head >= low && head <= high
+	}
+	return code == pattern
+}
+
+// PostalRange splits a "low-high" range pattern. Bounds must have the same
+// length and be in order.
+func PostalRange(pattern string) (string, string, bool) {
+	low, high, ok := strings.Cut(normalizePostalCode(pattern), "-")
+	if !ok || low == "" || len(low) != len(high) || low > high {
+		return "", "", false
+	}
+	return low, high, true
+}
+
+// ValidPostalPattern reports whether a PostalCodes entry is well formed
+func ValidPostalPattern(pattern string) bool {
+	pattern = normalizePostalCode(pattern)
+	if pattern == "" || pattern == "*" {
+		return false
+	}
+	if strings.Contains(pattern, "-") && !strings.HasSuffix(pattern, "*") {
+		_, _, ok := PostalRange(pattern)
+		return ok
+	}
+	return true
+}
+
+func normalizePostalCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+}
+
+// tree indexes a tenant's territories by ID to follow parent links
+type tree map[string]*models.Territory
+
+func newTree(list []models.Territory) tree {
+	t := make(tree, len(list))
+	for i := range list {
+		t[list[i].ID] = &list[i]
+	}
+	return t
+}
+
+// chain returns a territory and its ancestors, nearest first
+func (t tree) chain(territory *models.Territory) []*models.Territory {
+	chain := []*models.Territory{territory}
+	seen := map[string]bool{territory.ID: true}
+	for territory.ParentID != nil {
+		parent, ok := t[*territory.ParentID]
+		if !ok || seen[parent.ID] {
+			break
+		}
+		seen[parent.ID] = true
+		chain = append(chain, parent)
+		territory = parent
+	}
+	return chain
+}
+
+// matches reports whether a profile meets the criteria of a territory and
+// all of its ancestors, at least one of which must have criteria
+func (t tree) matches(territory *models.Territory, p Profile) bool {
+	criteria := 0
+	for _, node := range t.chain(territory) {
+		ok, n := matchOwn(node, p)
+		if !ok {
+			return false
+		}
+		criteria += n
+	}
+	return criteria > 0
+}
+
+// match returns the IDs of the territories a profile falls in
+func (t tree) match(p Profile) []string {
+	var matched []string
+	for id, territory := range t {
+		if t.matches(territory, p) {
+			matched = append(matched, id)
+		}
+	}
+	sort.Strings(matched)
+	return matched
+}
+
+// owner returns the territory a company in the matched territories is owned
+// through: the nearest territory with an owner, starting from the deepest
+// matched one. Among equally deep territories the one with the most
+// criteria wins, then the first by name.
+func (t tree) owner(matched []string) *models.Territory {
+	var candidates []*models.Territory
+	for _, id := range matched {
+		if territory, ok := t[id]; ok {
+			candidates = append(candidates, territory)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if da, db := len(t.chain(a)), len(t.chain(b)); da != db {
+			return da > db
+		}
+		if sa, sb := specificity(a), specificity(b); sa != sb {
+			return sa > sb
+		}
+		return a.Name < b.Name
+	})
+	for _, candidate := range candidates {
+		for _, node := range t.chain(candidate) {
+			if node.OwnerID != nil {
+				return node
+			}
+		}
+	}
+	return nil
+}
+
+func specificity(t *models.Territory) int {
+	n := 0
+	for _, values := range [][]string{t.Countries, t.States, t.Cities, t.PostalCodes, t.Industries, t.CompanySize} {
+		if len(values) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// ValidateHierarchy checks that every parent of a set of territories is in
+// the set and that no territory is its own ancestor
+func ValidateHierarchy(list []models.Territory) error {
+	t := newTree(list)
+	for _, territory := range t {
+		if territory.ParentID == nil {
+			continue
+		}
+		if _, ok := t[*territory.ParentID]; !ok {
+			return fmt.Errorf("parent of %s not found", territory.Name)
+		}
+		chain := t.chain(territory)
+		if last := chain[len(chain)-1]; last.ParentID != nil {
+			return fmt.Errorf("%s is its own ancestor", territory.Name)
+		}
+	}
+	return nil
+}
+
+// AssignCompany recomputes a company's territory memberships and returns
+// the IDs of the territories it belongs to
+func AssignCompany(db *gorm.DB, companyID string) ([]string, error) {
+	var company models.Company
+	if err := db.Where("id = ?", companyID).Limit(1).Find(&company).Error; err != nil {
+		return nil, err
+	}
+	if company.ID == "" {
+		return nil, nil
+	}
+	var list []models.Territory
+	if err := db.Where("tenant_id = ?", company.TenantID).Find(&list).Error; err != nil {
+		return nil, err
+	}
+	companies := []models.Company{company}
+	profiles, err := loadProfiles(db, companies)
+	if err != nil {
+		return nil, err
+	}
+	return assign(db, &company, newTree(list), profiles[company.ID])
+}
+
+func assign(db *gorm.DB, company *models.Company, t tree, profile Profile) ([]string, error) {
+	var matched []string
+	if !company.IsDeleted {
+		matched = t.match(profile)
+	}
+
+	var current []string
+	if err := db.Model(&models.CompanyTerritory{}).Where("company_id = ?", company.ID).Pluck("territory_id", &current).Error; err != nil {
+		return nil, err
+	}
+	if equalSets(current, matched) {
+		return matched, nil
+	}
+
+	// Memberships are derived data, so they are not audited
+	tx := audit.Skip(db)
+	if err := tx.Where("company_id = ?", company.ID).Delete(&models.CompanyTerritory{}).Error; err != nil {
+		return nil, err
+	}
+	for _, territoryID := range matched {
+		if err := tx.Create(&models.CompanyTerritory{
+			CompanyID:   company.ID,
+			TerritoryID: territoryID,
+			TenantID:    company.TenantID,
+		}).Error; err != nil {
+			return nil, err
+		}
+	}
+	return matched, nil
+}
+
+// AssignTenant recomputes the territory memberships of all of a tenant's
+// companies, e.g. after territory criteria changed
+func AssignTenant(db *gorm.DB, tenantID string) error {
+	var list []models.Territory
+	if err := db.Where("tenant_id = ?", tenantID).Find(&list).Error; err != nil {
+		return err
+	}
+	t := newTree(list)
+	var companies []models.Company
+	return db.Where("tenant_id = ?", tenantID).
+		FindInBatches(&companies, 200, func(batch *gorm.DB, _ int) error {
+			profiles, err := loadProfiles(db, companies)
+			if err != nil {
+				return err
+			}
+			for i := range companies {
+				if _, err := assign(db, &companies[i], t, profiles[companies[i].ID]); err != nil {
+					return fmt.Errorf("company %s: %w", companies[i].ID, err)
+				}
+			}
+			return nil
+		}).Error
+}
+
+// Primary returns the territory that owns a company: the deepest territory
+// it is in, or that territory's nearest ancestor with an owner. It returns
+// nil when none of the company's territories has an owner.
+func Primary(db *gorm.DB, companyID string) (*models.Territory, error) {
+	var list []models.Territory
+	if err := db.Where("tenant_id = (SELECT tenant_id FROM companies WHERE id = ?)", companyID).
+		Find(&list).Error; err != nil {
+		return nil, err
+	}
+	var matched []string
+	if err := db.Model(&models.CompanyTerritory{}).Where("company_id = ?", companyID).
+		Pluck("territory_id", &matched).Error; err != nil {
+		return nil, err
+	}
+	return newTree(list).owner(matched), nil
+}
+
+// Register installs callbacks that recompute a company's memberships when
+// the company or one of its addresses is created, updated or deleted
+func Register(db *gorm.DB) error {
+	if err := db.Callback().Create().After("gorm:create").Register("territories:after_create", afterWrite); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After("gorm:update").Register("territories:after_update", afterWrite); err != nil {
+		return err
+	}
+	return db.Callback().Delete().After("gorm:delete").Register("territories:after_delete", afterWrite)
+}
+
+var (
+	companyType = reflect.TypeOf(models.Company{})
+	addressType = reflect.TypeOf(models.Address{})
+)
+
+func afterWrite(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+	var companyIDs []string
+	switch db.Statement.Schema.ModelType {
+	case companyType:
+		eachRecord(db.Statement.ReflectValue, func(record reflect.Value) {
+			if company, ok := record.Interface().(models.Company); ok && company.ID != "" {
+				companyIDs = append(companyIDs, company.ID)
+			}
+		})
+	case addressType:
+		eachRecord(db.Statement.ReflectValue, func(record reflect.Value) {
+			if address, ok := record.Interface().(models.Address); ok && address.EntityType == "company" && address.EntityID != "" {
+				companyIDs = append(companyIDs, address.EntityID)
+			}
+		})
+	default:
+		return
+	}
+
+	session := db.Session(&gorm.Session{NewDB: true})
+	for _, companyID := range companyIDs {
+		if _, err := AssignCompany(session, companyID); err != nil {
+			db.AddError(err)
+			return
+		}
+	}
+}
+
+func eachRecord(value reflect.Value, fn func(record reflect.Value)) {
+	value = reflect.Indirect(value)
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			fn(reflect.Indirect(value.Index(i)))
+		}
+	case reflect.Struct:
+		fn(value)
+	}
+}
+
+func equalSets(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[string]bool{}
+	for _, v := range a {
+		seen[v] = true
+	}
+	for _, v := range b {
+		if !seen[v] {
+			return false
+		}
+	}
+	return true
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
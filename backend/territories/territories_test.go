@@ -0,0 +1,74 @@
+package territories
+
+import "testing"
+
+func TestMatchPostalCode(t *testing.T) {
+	tests := []struct {
+		pattern, code string
+		want          bool
+	}{
+		{"94105", "94105", true},
+		{"94105", "94106", false},
+		{"sw1a 1aa", "SW1A1AA", true},
+		{"941*", "94105", true},
+		{"941*", "9410", true},
+		{"941*", "95105", false},
+		{"sw1*", "SW1A 1AA", true},
+		{"10000-14999", "10000", true},
+		{"10000-14999", "14999", true},
+		{"10000-14999", "12345-6789", true},
+		{"10000-14999", "15000", false},
+		{"10000-14999", "9999", false},
+		{"10000-14999", "", false},
+		// Unequal bounds are not a range and only match literally
+		{"100-14999", "12000", false},
+		{"100-14999", "100-14999", true},
+		{"", "94105", false},
+	}
+	for _, tt := range tests {
+		if got := MatchPostalCode(tt.pattern, tt.code); got != tt.want {
+			t.Errorf("MatchPostalCode(%q, %q) = %v, want %v", tt.pattern, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestPostalRange(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		low, high string
+		ok        bool
+	}{
+		{"10000-14999", "10000", "14999", true},
+		{" 10000 - 14999 ", "10000", "14999", true},
+		{"a0a-z9z", "A0A", "Z9Z", true},
+		{"100-14999", "", "", false},
+		{"14999-10000", "", "", false},
+		{"-14999", "", "", false},
+		{"10000", "", "", false},
+	}
+	for _, tt := range tests {
+		low, high, ok := PostalRange(tt.pattern)
+		if low != tt.low || high != tt.high || ok != tt.ok {
+			t.Errorf("PostalRange(%q) = %q, %q, %v, want %q, %q, %v", tt.pattern, low, high, ok, tt.low, tt.high, tt.ok)
+		}
+	}
+}
+
+func TestValidPostalPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{"94105", true},
+		{"941*", true},
+		{"10000-14999", true},
+		{"100-14999", false},
+		{"*", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ValidPostalPattern(tt.pattern); got != tt.want {
+			t.Errorf("ValidPostalPattern(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}